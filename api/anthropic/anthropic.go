@@ -12,4 +12,5 @@ import (
 
 type IAnthropicV1 interface {
 	Completions(ctx context.Context, req *v1.CompletionsReq) (res *v1.CompletionsRes, err error)
+	CountTokens(ctx context.Context, req *v1.CountTokensReq) (res *v1.CountTokensRes, err error)
 }
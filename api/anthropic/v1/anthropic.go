@@ -15,3 +15,15 @@ type CompletionsReq struct {
 type CompletionsRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// CountTokens接口请求参数
+type CountTokensReq struct {
+	g.Meta `path:"/messages/count_tokens" tags:"anthropic" method:"post" summary:"CountTokens接口"`
+	Model  string `json:"model"`
+}
+
+// CountTokens接口响应参数
+type CountTokensRes struct {
+	g.Meta      `mime:"application/json" example:"json"`
+	InputTokens int `json:"input_tokens"`
+}
@@ -0,0 +1,39 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// Create接口请求参数
+type CreateReq struct {
+	g.Meta `path:"/" tags:"app_key" method:"post" summary:"签发子密钥接口"`
+	model.SubKeyCreateReq
+}
+
+// Create接口响应参数
+type CreateRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// List接口请求参数
+type ListReq struct {
+	g.Meta `path:"/" tags:"app_key" method:"get" summary:"子密钥列表接口"`
+	model.SubKeyListReq
+}
+
+// List接口响应参数
+type ListRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// Revoke接口请求参数
+type RevokeReq struct {
+	g.Meta `path:"/{key_id}" tags:"app_key" method:"delete" summary:"撤销子密钥接口"`
+	KeyId  string `json:"key_id"`
+}
+
+// Revoke接口响应参数
+type RevokeRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
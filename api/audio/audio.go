@@ -13,4 +13,5 @@ import (
 type IAudioV1 interface {
 	Speech(ctx context.Context, req *v1.SpeechReq) (res *v1.SpeechRes, err error)
 	Transcriptions(ctx context.Context, req *v1.TranscriptionsReq) (res *v1.TranscriptionsRes, err error)
+	Translations(ctx context.Context, req *v1.TranslationsReq) (res *v1.TranslationsRes, err error)
 }
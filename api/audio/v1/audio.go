@@ -27,3 +27,15 @@ type TranscriptionsReq struct {
 type TranscriptionsRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// Translations接口请求参数
+type TranslationsReq struct {
+	g.Meta `path:"/translations" tags:"audio" method:"post" summary:"Translations接口"`
+	smodel.AudioRequest
+	Duration float64 `json:"duration"`
+}
+
+// Translations接口响应参数
+type TranslationsRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
@@ -3,13 +3,15 @@ package v1
 import (
 	"github.com/gogf/gf/v2/frame/g"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/model/common"
 )
 
 // ChatCompletions接口请求参数
 type CompletionsReq struct {
 	g.Meta `path:"/completions" tags:"chat" method:"post" summary:"ChatCompletions接口"`
 	smodel.ChatCompletionRequest
-	IsToResponses bool `json:"is_to_responses"`
+	IsToResponses bool           `json:"is_to_responses"`
+	Prompt        *common.Prompt `json:"prompt,omitempty"` // 提示词模板
 }
 
 // ChatCompletions接口响应参数
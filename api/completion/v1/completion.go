@@ -0,0 +1,17 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// Completions接口请求参数
+type CompletionsReq struct {
+	g.Meta `path:"/completions" tags:"completion" method:"post" summary:"Completions接口"`
+	model.CompletionRequest
+}
+
+// Completions接口响应参数
+type CompletionsRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
@@ -13,5 +13,8 @@ import (
 type IDashboardV1 interface {
 	Subscription(ctx context.Context, req *v1.SubscriptionReq) (res *v1.SubscriptionRes, err error)
 	Usage(ctx context.Context, req *v1.UsageReq) (res *v1.UsageRes, err error)
+	UsageDetail(ctx context.Context, req *v1.UsageDetailReq) (res *v1.UsageDetailRes, err error)
+	Costs(ctx context.Context, req *v1.CostsReq) (res *v1.CostsRes, err error)
 	Models(ctx context.Context, req *v1.ModelsReq) (res *v1.ModelsRes, err error)
+	Model(ctx context.Context, req *v1.ModelReq) (res *v1.ModelRes, err error)
 }
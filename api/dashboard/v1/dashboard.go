@@ -27,6 +27,30 @@ type UsageRes struct {
 	*model.DashboardUsageRes
 }
 
+// Usage明细接口请求参数
+type UsageDetailReq struct {
+	g.Meta `path:"/usage" tags:"dashboard" method:"get" summary:"Usage明细接口"`
+	model.DashboardReportReq
+}
+
+// Usage明细接口响应参数
+type UsageDetailRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+	*model.DashboardReportRes
+}
+
+// Costs接口请求参数
+type CostsReq struct {
+	g.Meta `path:"/costs" tags:"dashboard" method:"get" summary:"Costs接口"`
+	model.DashboardReportReq
+}
+
+// Costs接口响应参数
+type CostsRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+	*model.DashboardReportRes
+}
+
 // Models接口请求参数
 type ModelsReq struct {
 	g.Meta    `path:"/models" tags:"dashboard" method:"get,post" summary:"Models接口"`
@@ -37,3 +61,15 @@ type ModelsReq struct {
 type ModelsRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// Model接口请求参数
+type ModelReq struct {
+	g.Meta    `path:"/models/*model" tags:"dashboard" method:"get" summary:"Model接口"`
+	Model     string `json:"model"`
+	IsFastAPI bool   `json:"is_fastapi"`
+}
+
+// Model接口响应参数
+type ModelRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
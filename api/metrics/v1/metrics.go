@@ -0,0 +1,15 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 监控指标接口请求参数
+type MetricsReq struct {
+	g.Meta `path:"/metrics" tags:"metrics" method:"get" summary:"监控指标接口"`
+}
+
+// 监控指标接口响应参数
+type MetricsRes struct {
+	g.Meta `mime:"text/plain" example:"string"`
+}
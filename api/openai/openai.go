@@ -14,4 +14,7 @@ type IOpenaiV1 interface {
 	Responses(ctx context.Context, req *v1.ResponsesReq) (res *v1.ResponsesRes, err error)
 	ResponsesChatCompletions(ctx context.Context, req *v1.ResponsesChatCompletionsReq) (res *v1.ResponsesChatCompletionsRes, err error)
 	ResponsesCompact(ctx context.Context, req *v1.ResponsesCompactReq) (res *v1.ResponsesCompactRes, err error)
+	ResponsesRetrieve(ctx context.Context, req *v1.ResponsesRetrieveReq) (res *v1.ResponsesRetrieveRes, err error)
+	ResponsesDelete(ctx context.Context, req *v1.ResponsesDeleteReq) (res *v1.ResponsesDeleteRes, err error)
+	ResponsesInputItems(ctx context.Context, req *v1.ResponsesInputItemsReq) (res *v1.ResponsesInputItemsRes, err error)
 }
@@ -38,3 +38,39 @@ type ResponsesCompactReq struct {
 type ResponsesCompactRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// ResponsesRetrieve接口请求参数
+type ResponsesRetrieveReq struct {
+	g.Meta     `path:"/responses/{response_id}" tags:"openai" method:"get" summary:"ResponsesRetrieve接口"`
+	ResponseId string `json:"response_id"`
+}
+
+// ResponsesRetrieve接口响应参数
+type ResponsesRetrieveRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// ResponsesDelete接口请求参数
+type ResponsesDeleteReq struct {
+	g.Meta     `path:"/responses/{response_id}" tags:"openai" method:"delete" summary:"ResponsesDelete接口"`
+	ResponseId string `json:"response_id"`
+}
+
+// ResponsesDelete接口响应参数
+type ResponsesDeleteRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// ResponsesInputItems接口请求参数
+type ResponsesInputItemsReq struct {
+	g.Meta     `path:"/responses/{response_id}/input_items" tags:"openai" method:"get" summary:"ResponsesInputItems接口"`
+	ResponseId string `json:"response_id"`
+	After      string `json:"after"`
+	Limit      int    `json:"limit"`
+	Order      string `json:"order"`
+}
+
+// ResponsesInputItems接口响应参数
+type ResponsesInputItemsRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
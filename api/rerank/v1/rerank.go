@@ -0,0 +1,17 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// Rerank接口请求参数
+type RerankReq struct {
+	g.Meta `path:"/rerank" tags:"rerank" method:"post" summary:"Rerank接口"`
+	model.RerankRequest
+}
+
+// Rerank接口响应参数
+type RerankRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
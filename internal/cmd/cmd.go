@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
@@ -18,9 +19,11 @@ import (
 	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/consts"
 	"github.com/iimeta/fastapi/v2/internal/controller/anthropic"
+	"github.com/iimeta/fastapi/v2/internal/controller/app_key"
 	"github.com/iimeta/fastapi/v2/internal/controller/audio"
 	"github.com/iimeta/fastapi/v2/internal/controller/batch"
 	"github.com/iimeta/fastapi/v2/internal/controller/chat"
+	"github.com/iimeta/fastapi/v2/internal/controller/completion"
 	"github.com/iimeta/fastapi/v2/internal/controller/dashboard"
 	"github.com/iimeta/fastapi/v2/internal/controller/embedding"
 	"github.com/iimeta/fastapi/v2/internal/controller/file"
@@ -28,15 +31,19 @@ import (
 	"github.com/iimeta/fastapi/v2/internal/controller/google"
 	"github.com/iimeta/fastapi/v2/internal/controller/health"
 	"github.com/iimeta/fastapi/v2/internal/controller/image"
+	"github.com/iimeta/fastapi/v2/internal/controller/metrics"
 	"github.com/iimeta/fastapi/v2/internal/controller/moderation"
 	"github.com/iimeta/fastapi/v2/internal/controller/openai"
+	"github.com/iimeta/fastapi/v2/internal/controller/rerank"
 	"github.com/iimeta/fastapi/v2/internal/controller/video"
 	"github.com/iimeta/fastapi/v2/internal/controller/volcengine"
 	"github.com/iimeta/fastapi/v2/internal/errors"
 	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
 	"github.com/iimeta/fastapi/v2/utility/redis"
+	"github.com/iimeta/fastapi/v2/utility/tracing"
 	"github.com/iimeta/fastapi/v2/utility/util"
 )
 
@@ -49,6 +56,19 @@ var (
 
 			s := g.Server()
 
+			if config.Cfg.Trace.Open {
+				shutdown, err := tracing.Init(config.Cfg.Trace)
+				if err != nil {
+					logger.Error(ctx, err)
+					return err
+				}
+				defer func() {
+					if err := shutdown(ctx); err != nil {
+						logger.Error(ctx, err)
+					}
+				}()
+			}
+
 			if config.Cfg.Debug.Open {
 				runtime.SetMutexProfileFraction(1) // (非必需)开启对锁调用的跟踪
 				runtime.SetBlockProfileRate(1)     // (非必需)开启对阻塞操作的跟踪
@@ -65,6 +85,7 @@ var (
 				g.Middleware(middlewareHandlerResponse)
 				g.Bind(
 					health.NewV1(),
+					metrics.NewV1(),
 				)
 			})
 
@@ -91,8 +112,10 @@ var (
 						openai.NewV1(),
 						anthropic.NewV1(),
 						google.NewV1(),
+						completion.NewV1(),
 						embedding.NewV1(),
 						moderation.NewV1(),
+						rerank.NewV1(),
 						general.NewV1(),
 					)
 				})
@@ -139,6 +162,12 @@ var (
 					)
 				})
 
+				v1.Group("/keys", func(g *ghttp.RouterGroup) {
+					g.Bind(
+						app_key.NewV1(),
+					)
+				})
+
 				v1.Group("/contents/generations", func(g *ghttp.RouterGroup) {
 					g.Bind(
 						volcengine.NewV1(),
@@ -192,6 +221,13 @@ var (
 
 			s.Run()
 
+			// 关闭日志写入和用量事件导出, 队列中的数据写入数据库/导出目标或本地缓冲文件
+			closeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+
+			service.Log().Close(closeCtx)
+			service.UsageExport().Close(closeCtx)
+
 			return nil
 		},
 	}
@@ -227,6 +263,11 @@ func middleware(r *ghttp.Request) {
 
 	secretKey := strings.TrimPrefix(r.GetHeader("Authorization"), "Bearer ")
 
+	// 网关批处理请求在节点内执行, 使用批处理创建者的密钥
+	if taskBatch, ok := r.GetCtx().Value(consts.BATCH_TASK_KEY).(*entity.TaskBatch); ok {
+		secretKey = taskBatch.Creator
+	}
+
 	if secretKey == "" {
 		if key := r.Get("key"); key != nil {
 			secretKey = key.String()
@@ -298,6 +339,8 @@ func middleware(r *ghttp.Request) {
 		r.SetCtx(gctx.NeverDone(r.GetCtx()))
 	}
 
+	defer service.RateLimit().Release(r.GetCtx())
+
 	r.Middleware.Next()
 }
 
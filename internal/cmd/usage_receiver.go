@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/utility/crypto"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 本地用量事件接收服务, 替代下游 webhook 用于离线联调, 校验签名, 按事件ID去重后追加写入 JSONL 文件
+var UsageReceiver = gcmd.Command{
+	Name:  "usage-receiver",
+	Usage: "usage-receiver [-addr=127.0.0.1:8090] [-path=/usage/events] [-secret=xxx] [-output=./usage/received.jsonl]",
+	Brief: "start a local usage event webhook receiver",
+	Arguments: []gcmd.Argument{
+		{Name: "addr", Default: "127.0.0.1:8090", Brief: "listen address"},
+		{Name: "path", Default: "/usage/events", Brief: "webhook path"},
+		{Name: "secret", Brief: "signature secret, skip verification if empty"},
+		{Name: "output", Default: "./usage/received.jsonl", Brief: "output JSONL file"},
+	},
+	Func: func(ctx context.Context, parser *gcmd.Parser) (err error) {
+
+		var (
+			addr   = parser.GetOpt("addr", "127.0.0.1:8090").String()
+			path   = parser.GetOpt("path", "/usage/events").String()
+			secret = parser.GetOpt("secret").String()
+			output = parser.GetOpt("output", "./usage/received.jsonl").String()
+			seen   = make(map[string]bool)
+			mutex  sync.Mutex
+		)
+
+		if err = os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = file.Close()
+		}()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if secret != "" {
+
+				timestamp := r.Header.Get(consts.USAGE_TIMESTAMP_HEADER)
+				signature := "sha256=" + crypto.HmacSHA256(secret, append([]byte(timestamp+"."), body...))
+
+				if !hmac.Equal([]byte(signature), []byte(r.Header.Get(consts.USAGE_SIGNATURE_HEADER))) {
+					logger.Errorf(r.Context(), "usage receiver invalid signature, timestamp: %s", timestamp)
+					http.Error(w, "invalid signature", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			payload := new(struct {
+				SchemaVersion string              `json:"schema_version"`
+				Events        []*model.UsageEvent `json:"events"`
+			})
+
+			if err = json.Unmarshal(body, payload); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			duplicates := 0
+			for _, event := range payload.Events {
+
+				if seen[event.EventId] {
+					duplicates++
+					continue
+				}
+
+				data, err := json.Marshal(event)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				if _, err = file.Write(append(data, '\n')); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				seen[event.EventId] = true
+			}
+
+			logger.Infof(r.Context(), "usage receiver schema_version: %s, events: %d, duplicates: %d", payload.SchemaVersion, len(payload.Events), duplicates)
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		logger.Infof(ctx, "usage receiver listen on: http://%s%s, output: %s", addr, path, output)
+
+		return http.ListenAndServe(addr, mux)
+	},
+}
+
+func init() {
+	if err := Main.AddCommand(&UsageReceiver); err != nil {
+		panic(err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gogf/gf/v2/os/gfsnotify"
 	"github.com/iimeta/fastapi/v2/internal/model/entity"
 	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/tracing"
 )
 
 var Cfg *Config
@@ -42,8 +43,12 @@ func init() {
 
 // 配置信息
 type Config struct {
-	ApiServerAddress string `json:"api_server_address"`
-	Local            Local  `json:"local"`
+	ApiServerAddress string         `json:"api_server_address"`
+	Local            Local          `json:"local"`
+	Trace            tracing.Config `json:"trace"`
+	LogWriter        LogWriter      `json:"log_writer"`
+	UsageExport      UsageExport    `json:"usage_export"`
+	SubKey           SubKey         `json:"sub_key"`
 	*entity.SysConfig
 }
 
@@ -51,6 +56,48 @@ type Local struct {
 	PublicIp []string `json:"public_ip"`
 }
 
+// 日志写入配置, 启动时生效
+type LogWriter struct {
+	QueueSize     int    `json:"queue_size"`     // 队列长度, 默认: 10000, 队列已满时写入本地缓冲文件
+	BatchSize     int    `json:"batch_size"`     // 每批写入条数, 默认: 200
+	FlushInterval int    `json:"flush_interval"` // 刷新间隔, 单位: 毫秒, 默认: 1000
+	SpoolPath     string `json:"spool_path"`     // 本地缓冲文件路径, 默认: ./spool/log.spool
+}
+
+// 用量事件导出配置, 启动时生效
+type UsageExport struct {
+	Open          bool              `json:"open"`           // 开关
+	QueueSize     int               `json:"queue_size"`     // 每个导出目标的队列长度, 默认: 10000, 队列已满时写入本地积压文件
+	BatchSize     int               `json:"batch_size"`     // 每批导出条数, 默认: 100
+	FlushInterval int               `json:"flush_interval"` // 刷新间隔, 单位: 毫秒, 默认: 1000
+	MaxRetries    int               `json:"max_retries"`    // 导出失败重试次数, 默认: 3, 仍失败时写入本地积压文件, 下次刷新时重放
+	RetryInterval int               `json:"retry_interval"` // 首次重试间隔, 单位: 毫秒, 默认: 500, 之后每次翻倍
+	SpoolDir      string            `json:"spool_dir"`      // 本地积压文件目录, 默认: ./spool
+	Sinks         []UsageExportSink `json:"sinks"`          // 导出目标
+}
+
+// 子密钥配置, 应用密钥通过 /v1/keys 自助签发短期子密钥
+type SubKey struct {
+	Open         bool  `json:"open"`           // 开关
+	MaxExpiresIn int64 `json:"max_expires_in"` // 最长有效期, 单位: 秒, 默认: 2592000
+	MaxKeys      int   `json:"max_keys"`       // 每个父密钥可同时生效的子密钥数, 默认: 100
+}
+
+// 用量事件导出目标
+type UsageExportSink struct {
+	Name       string            `json:"name"`        // 名称, 用于区分本地积压文件, 默认: 类型_序号
+	Type       string            `json:"type"`        // 类型[webhook, file, kafka]
+	Url        string            `json:"url"`         // webhook: 推送地址
+	Secret     string            `json:"secret"`      // webhook: 签名密钥, HMAC-SHA256
+	Headers    map[string]string `json:"headers"`     // webhook: 请求头
+	Timeout    int               `json:"timeout"`     // webhook/kafka: 超时时间, 单位: 秒, 默认: 10
+	Path       string            `json:"path"`        // file: 文件路径, 默认: ./usage/usage.jsonl
+	MaxSize    int               `json:"max_size"`    // file: 单个文件大小, 单位: MB, 默认: 100, 超过后轮转
+	MaxBackups int               `json:"max_backups"` // file: 保留的轮转文件数, 默认: 0, 不限制
+	Brokers    []string          `json:"brokers"`     // kafka: 服务地址
+	Topic      string            `json:"topic"`       // kafka: 主题
+}
+
 func Reload(ctx context.Context, sysConfig *entity.SysConfig) {
 
 	if sysConfig.Core.ChannelPrefix == "" && Cfg.SysConfig != nil && Cfg.SysConfig.Core != nil {
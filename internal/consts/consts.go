@@ -9,6 +9,11 @@ const (
 	SECRET_KEY             = "sk"
 	APP_IS_LIMIT_QUOTA_KEY = "app_is_limit_quota"
 	KEY_IS_LIMIT_QUOTA_KEY = "key_is_limit_quota"
+	BATCH_TASK_KEY         = "batch_task" // 网关批处理任务, 仅由节点内执行批处理请求时设置
+)
+
+const (
+	SUB_KEY_PREFIX = "sk-FastAPI" // 子密钥前缀
 )
 
 const (
@@ -22,6 +27,10 @@ const (
 	SESSION_KEEP_PREFERRED_KEY         = "session_keep_preferred_key"
 	SESSION_KEEP_HIT                   = "session_keep_hit"
 	SESSION_ENDPOINT                   = "session_endpoint"
+	SESSION_RATE_LIMIT                 = "session_rate_limit"
+	SESSION_QUOTA_HOLD                 = "session_quota_hold"
+	SESSION_FALLBACK_STATE             = "session_fallback_state"
+	SESSION_PROMPT                     = "session_prompt"
 )
 
 // 会话保持Redis Key — fastapi-admin内对应常量: internal/consts/consts.go SESSION_KEEP_*
@@ -46,17 +55,25 @@ const (
 	MODEL_AGENT_HEADER  = "X-Model-Agent"
 )
 
+// 用量事件导出
 const (
-	CHANGE_CHANNEL_CONFIG   = "admin:change:channel:config"
-	CHANGE_CHANNEL_RESELLER = "admin:change:channel:reseller"
-	CHANGE_CHANNEL_USER     = "admin:change:channel:user"
-	CHANGE_CHANNEL_APP      = "admin:change:channel:app"
-	CHANGE_CHANNEL_APP_KEY  = "admin:change:channel:app:key"
-	CHANGE_CHANNEL_PROVIDER = "admin:change:channel:provider"
-	CHANGE_CHANNEL_MODEL    = "admin:change:channel:model"
-	CHANGE_CHANNEL_KEY      = "admin:change:channel:key"
-	CHANGE_CHANNEL_AGENT    = "admin:change:channel:agent"
-	CHANGE_CHANNEL_GROUP    = "admin:change:channel:group"
+	USAGE_EVENT_SCHEMA_VERSION = "1" // 用量事件结构版本, 字段只增不改, 不兼容变更时升级
+	USAGE_TIMESTAMP_HEADER     = "X-Fastapi-Timestamp"
+	USAGE_SIGNATURE_HEADER     = "X-Fastapi-Signature" // sha256=HMAC-SHA256(secret, 时间戳 + "." + 请求体)
+)
+
+const (
+	CHANGE_CHANNEL_CONFIG          = "admin:change:channel:config"
+	CHANGE_CHANNEL_RESELLER        = "admin:change:channel:reseller"
+	CHANGE_CHANNEL_USER            = "admin:change:channel:user"
+	CHANGE_CHANNEL_APP             = "admin:change:channel:app"
+	CHANGE_CHANNEL_APP_KEY         = "admin:change:channel:app:key"
+	CHANGE_CHANNEL_PROVIDER        = "admin:change:channel:provider"
+	CHANGE_CHANNEL_MODEL           = "admin:change:channel:model"
+	CHANGE_CHANNEL_KEY             = "admin:change:channel:key"
+	CHANGE_CHANNEL_AGENT           = "admin:change:channel:agent"
+	CHANGE_CHANNEL_GROUP           = "admin:change:channel:group"
+	CHANGE_CHANNEL_PROMPT_TEMPLATE = "admin:change:channel:prompt:template"
 )
 
 const (
@@ -94,12 +111,25 @@ const (
 	ACTION_MODERATIONS    = "moderations"
 	ACTION_SPEECH         = "speech"
 	ACTION_TRANSCRIPTIONS = "transcriptions"
+	ACTION_TRANSLATIONS   = "translations"
 	ACTION_REALTIME       = "realtime"
+	ACTION_RERANK         = "rerank"
+)
+
+// 后备触发的错误类型
+const (
+	ERR_CLASS_RATE_LIMIT              = "rate_limit"
+	ERR_CLASS_TIMEOUT                 = "timeout"
+	ERR_CLASS_CONTEXT_LENGTH_EXCEEDED = "context_length_exceeded"
+	ERR_CLASS_CONTENT_FILTER          = "content_filter"
+	ERR_CLASS_SERVER_ERROR            = "server_error"
+	ERR_CLASS_NO_AVAILABLE            = "no_available"
 )
 
 // 支持的端点[OpenAI风格用规范化路径, Google用action, general用请求路径]
 const (
 	ENDPOINT_CHAT_COMPLETIONS     = "/v1/chat/completions"
+	ENDPOINT_COMPLETIONS          = "/v1/completions"
 	ENDPOINT_RESPONSES            = "/v1/responses"
 	ENDPOINT_RESPONSES_COMPACT    = "/v1/responses/compact"
 	ENDPOINT_MESSAGES             = "/v1/messages"
@@ -107,10 +137,12 @@ const (
 	ENDPOINT_IMAGE_EDITS          = "/v1/images/edits"
 	ENDPOINT_AUDIO_SPEECH         = "/v1/audio/speech"
 	ENDPOINT_AUDIO_TRANSCRIPTIONS = "/v1/audio/transcriptions"
+	ENDPOINT_AUDIO_TRANSLATIONS   = "/v1/audio/translations"
 	ENDPOINT_VIDEO_GENERATIONS    = "/v1/videos"
 	ENDPOINT_EMBEDDINGS           = "/v1/embeddings"
 	ENDPOINT_MODERATIONS          = "/v1/moderations"
 	ENDPOINT_REALTIME             = "/v1/realtime"
+	ENDPOINT_RERANK               = "/v1/rerank"
 )
 
 var RESOLUTION_ASPECT_RATIO = map[string]string{
@@ -16,10 +16,38 @@ const (
 
 	ACCESS_TOKEN_KEY = "api:baidu:access_token:%s"
 	GCP_TOKEN_KEY    = "api:gcp:token:%s"
+
+	RATE_LIMIT_RPM_KEY         = "api:rate_limit:{%s}:rpm:%d" // 限流对象, 窗口
+	RATE_LIMIT_TPM_KEY         = "api:rate_limit:{%s}:tpm:%d" // 限流对象, 窗口
+	RATE_LIMIT_CONCURRENCY_KEY = "api:rate_limit:{%s}:concurrency"
+
+	UPSTREAM_LIMIT_USAGE_KEY     = "api:upstream_limit:{%s}:usage:%d"  // 类型[agent, key], 分钟
+	UPSTREAM_LIMIT_SPEND_KEY     = "api:upstream_limit:{%s}:spend:%s"  // 类型[agent, key], 日期
+	UPSTREAM_LIMIT_EXHAUSTED_KEY = "api:upstream_limit:{%s}:exhausted" // 类型[agent, key]
+
+	UPSTREAM_LIMIT_RPM_FIELD = "%s.rpm" // ID
+	UPSTREAM_LIMIT_TPM_FIELD = "%s.tpm" // ID
+
+	CIRCUIT_BREAKER_KEY = "api:circuit_breaker:{%s}"      // 类型[agent, key]
+	LB_STATS_KEY        = "api:lb_stats:{%s}"             // 类型[agent, key]
+	LB_INFLIGHT_KEY     = "api:lb_stats:{%s}:inflight:%s" // 类型[agent, key], ID
+
+	CIRCUIT_BREAKER_OPEN_UNTIL_FIELD  = "%s.open_until"  // ID
+	CIRCUIT_BREAKER_PROBE_UNTIL_FIELD = "%s.probe_until" // ID
+	LB_LATENCY_FIELD                  = "%s.latency"     // ID
+	LB_ERROR_RATE_FIELD               = "%s.error_rate"  // ID
+
+	QUOTA_HOLD_KEY        = "{%s}:quota_hold:%s"        // 额度所在的Key, 额度所在的字段
+	QUOTA_HOLD_AMOUNT_KEY = "{%s}:quota_hold:%s:amount" // 额度所在的Key, 额度所在的字段
+
+	RESPONSE_CACHE_KEY = "api:response_cache:%d:%s" // 用户ID, 请求哈希
 )
 
 const (
 	LOCK_USER_KEY = "api:lock:user:%d"
 	LOCK_APP_KEY  = "api:lock:app:%d"
 	LOCK_SK_KEY   = "api:lock:sk:%s"
+
+	LOCK_BATCH_TASK_KEY = "api:lock:batch_task:%s"
+	LOCK_SUB_KEY_KEY    = "api:lock:sub_key:%s" // 父密钥ID
 )
@@ -0,0 +1,28 @@
+package anthropic
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/api/anthropic/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+func (c *ControllerV1) CountTokens(ctx context.Context, req *v1.CountTokensReq) (res *v1.CountTokensRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller Anthropic CountTokens time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	inputTokens, err := service.Anthropic().CountTokens(ctx, g.RequestFromCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(g.Map{"input_tokens": inputTokens})
+
+	return
+}
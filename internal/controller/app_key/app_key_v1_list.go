@@ -0,0 +1,21 @@
+package app_key
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/api/app_key/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+func (c *ControllerV1) List(ctx context.Context, req *v1.ListReq) (res *v1.ListRes, err error) {
+
+	response, err := service.AppKey().ListSubKey(ctx, req.SubKeyListReq)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(response)
+
+	return
+}
@@ -20,6 +20,12 @@ func (c *ControllerV1) Completions(ctx context.Context, req *v1.CompletionsReq)
 	}()
 
 	if !req.IsToResponses {
+
+		// 渲染提示词模板
+		if req.Messages, err = service.PromptTemplate().Render(ctx, req.Prompt, req.Messages); err != nil {
+			return nil, err
+		}
+
 		if req.Stream {
 
 			if err = service.Chat().CompletionsStream(ctx, req.ChatCompletionRequest, nil, nil); err != nil {
@@ -0,0 +1,15 @@
+// =================================================================================
+// This is auto-generated by GoFrame CLI tool only once. Fill this file as you wish.
+// =================================================================================
+
+package completion
+
+import (
+	"github.com/iimeta/fastapi/v2/api/completion"
+)
+
+type ControllerV1 struct{}
+
+func NewV1() completion.ICompletionV1 {
+	return &ControllerV1{}
+}
@@ -0,0 +1,45 @@
+package completion
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/api/completion/v1"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+func (c *ControllerV1) Completions(ctx context.Context, req *v1.CompletionsReq) (res *v1.CompletionsRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller Completions time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if req.Stream {
+
+		if err = service.Completion().CompletionsStream(ctx, g.RequestFromCtx(ctx).GetBody(), nil, nil); err != nil {
+			return nil, err
+		}
+
+		g.RequestFromCtx(ctx).SetCtxVar("stream", req.Stream)
+
+	} else {
+
+		response, err := service.Completion().Completions(ctx, g.RequestFromCtx(ctx).GetBody(), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// 响应头透传
+		passthrough, _ := g.RequestFromCtx(ctx).GetCtxVar("passthrough").Val().(*common.EffectivePassthrough)
+		common.WritePassthroughHeaders(ctx, passthrough, response.ResponseHeaders)
+
+		// 旧版响应格式与对话不同, 直接返回上游响应
+		g.RequestFromCtx(ctx).Response.WriteJson(response.ResponseBytes)
+	}
+
+	return
+}
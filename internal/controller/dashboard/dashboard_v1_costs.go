@@ -0,0 +1,21 @@
+package dashboard
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/api/dashboard/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+func (c *ControllerV1) Costs(ctx context.Context, req *v1.CostsReq) (res *v1.CostsRes, err error) {
+
+	report, err := service.Dashboard().Costs(ctx, req.DashboardReportReq)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(report)
+
+	return
+}
@@ -0,0 +1,34 @@
+package dashboard
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/api/dashboard/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+func (c *ControllerV1) Model(ctx context.Context, req *v1.ModelReq) (res *v1.ModelRes, err error) {
+
+	// Anthropic 客户端
+	if g.RequestFromCtx(ctx).Header.Get("anthropic-version") != "" {
+
+		m, err := service.Dashboard().AnthropicModel(ctx, req.Model)
+		if err != nil {
+			return nil, err
+		}
+
+		g.RequestFromCtx(ctx).Response.WriteJson(m)
+
+		return nil, nil
+	}
+
+	m, err := service.Dashboard().Model(ctx, req.Model, req.IsFastAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(m)
+
+	return
+}
@@ -0,0 +1,21 @@
+package dashboard
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/api/dashboard/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+func (c *ControllerV1) UsageDetail(ctx context.Context, req *v1.UsageDetailReq) (res *v1.UsageDetailRes, err error) {
+
+	report, err := service.Dashboard().UsageDetail(ctx, req.DashboardReportReq)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(report)
+
+	return
+}
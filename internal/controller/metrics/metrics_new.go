@@ -0,0 +1,15 @@
+// =================================================================================
+// This is auto-generated by GoFrame CLI tool only once. Fill this file as you wish.
+// =================================================================================
+
+package metrics
+
+import (
+	"github.com/iimeta/fastapi/v2/api/metrics"
+)
+
+type ControllerV1 struct{}
+
+func NewV1() metrics.IMetricsV1 {
+	return &ControllerV1{}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/api/metrics/v1"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+func (c *ControllerV1) Metrics(ctx context.Context, req *v1.MetricsReq) (res *v1.MetricsRes, err error) {
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return
+	}
+
+	if config.Cfg.Metrics == nil || !config.Cfg.Metrics.Open || (len(config.Cfg.Metrics.IpWhitelist) > 0 && !slices.Contains(config.Cfg.Metrics.IpWhitelist, r.GetClientIp())) {
+		return nil, errors.ERR_NOT_FOUND
+	}
+
+	r.Response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Response.Write(service.Metrics().Expose(ctx))
+
+	return
+}
@@ -0,0 +1,28 @@
+package openai
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/api/openai/v1"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+func (c *ControllerV1) ResponsesInputItems(ctx context.Context, req *v1.ResponsesInputItemsReq) (res *v1.ResponsesInputItemsRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller OpenAI ResponsesInputItems time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	response, err := service.OpenAI().ResponsesInputItems(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(response)
+
+	return
+}
@@ -0,0 +1,15 @@
+// =================================================================================
+// This is auto-generated by GoFrame CLI tool only once. Fill this file as you wish.
+// =================================================================================
+
+package rerank
+
+import (
+	"github.com/iimeta/fastapi/v2/api/rerank"
+)
+
+type ControllerV1 struct{}
+
+func NewV1() rerank.IRerankV1 {
+	return &ControllerV1{}
+}
@@ -0,0 +1,33 @@
+package rerank
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/api/rerank/v1"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+func (c *ControllerV1) Rerank(ctx context.Context, req *v1.RerankReq) (res *v1.RerankRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller Rerank time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	response, err := service.Rerank().Rerank(ctx, req.RerankRequest, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// 响应头透传
+	passthrough, _ := g.RequestFromCtx(ctx).GetCtxVar("passthrough").Val().(*common.EffectivePassthrough)
+	common.WritePassthroughHeaders(ctx, passthrough, response.ResponseHeaders)
+
+	g.RequestFromCtx(ctx).Response.WriteJson(response)
+
+	return
+}
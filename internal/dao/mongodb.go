@@ -188,38 +188,11 @@ func (m *MongoDB[T]) Insert(ctx context.Context, document any) (string, error) {
 
 func insert(ctx context.Context, database string, document any) (string, error) {
 
-	collection := gmeta.Get(document, "collection").String()
-	if collection == "" {
-		return "", errors.New("collection meta undefined")
-	}
-
-	bytes, err := bson.Marshal(document)
+	collection, value, err := Document(ctx, document)
 	if err != nil {
 		return "", err
 	}
 
-	value := bson.M{}
-	if err = bson.Unmarshal(bytes, &value); err != nil {
-		return "", err
-	}
-
-	// 统一主键成int类型的string格式, 雪花ID
-	if value["_id"] == nil || value["_id"] == "" {
-		value["_id"] = util.GenerateId()
-	}
-
-	if value["creator"] == nil || value["creator"] == "" {
-		value["creator"] = service.Session().GetSecretKey(ctx)
-	}
-
-	if value["created_at"] == nil || gconv.Int(value["created_at"]) == 0 {
-		value["created_at"] = gtime.TimestampMilli()
-	}
-
-	if value["updated_at"] == nil || gconv.Int(value["updated_at"]) == 0 {
-		value["updated_at"] = gtime.TimestampMilli()
-	}
-
 	m := &db.MongoDB{
 		Database:   database,
 		Collection: collection,
@@ -247,33 +220,11 @@ func inserts(ctx context.Context, database string, documents []any) ([]string, e
 	values := make([]any, 0)
 	for _, document := range documents {
 
-		bytes, err := bson.Marshal(document)
+		_, value, err := Document(ctx, document)
 		if err != nil {
 			return nil, err
 		}
 
-		value := bson.M{}
-		if err = bson.Unmarshal(bytes, &value); err != nil {
-			return nil, err
-		}
-
-		// 统一主键成int类型的string格式, 雪花ID
-		if value["_id"] == nil || value["_id"] == "" {
-			value["_id"] = util.GenerateId()
-		}
-
-		if value["creator"] == nil || value["creator"] == "" {
-			value["creator"] = service.Session().GetSecretKey(ctx)
-		}
-
-		if value["created_at"] == nil || gconv.Int(value["created_at"]) == 0 {
-			value["created_at"] = gtime.TimestampMilli()
-		}
-
-		if value["updated_at"] == nil || gconv.Int(value["updated_at"]) == 0 {
-			value["updated_at"] = gtime.TimestampMilli()
-		}
-
 		values = append(values, value)
 	}
 
@@ -290,6 +241,44 @@ func inserts(ctx context.Context, database string, documents []any) ([]string, e
 	return gconv.Strings(ids), nil
 }
 
+// 转换为待写入的文档, 返回集合名称, 补全主键、创建人和时间
+func Document(ctx context.Context, document any) (string, bson.M, error) {
+
+	collection := gmeta.Get(document, "collection").String()
+	if collection == "" {
+		return "", nil, errors.New("collection meta undefined")
+	}
+
+	bytes, err := bson.Marshal(document)
+	if err != nil {
+		return "", nil, err
+	}
+
+	value := bson.M{}
+	if err = bson.Unmarshal(bytes, &value); err != nil {
+		return "", nil, err
+	}
+
+	// 统一主键成int类型的string格式, 雪花ID
+	if value["_id"] == nil || value["_id"] == "" {
+		value["_id"] = util.GenerateId()
+	}
+
+	if value["creator"] == nil || value["creator"] == "" {
+		value["creator"] = service.Session().GetSecretKey(ctx)
+	}
+
+	if value["created_at"] == nil || gconv.Int(value["created_at"]) == 0 {
+		value["created_at"] = gtime.TimestampMilli()
+	}
+
+	if value["updated_at"] == nil || gconv.Int(value["updated_at"]) == 0 {
+		value["updated_at"] = gtime.TimestampMilli()
+	}
+
+	return collection, value, nil
+}
+
 func (m *MongoDB[T]) UpdateById(ctx context.Context, id, update any, isUpsert ...bool) error {
 	return m.UpdateOne(ctx, bson.M{"_id": id}, update, isUpsert...)
 }
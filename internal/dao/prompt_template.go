@@ -0,0 +1,23 @@
+package dao
+
+import (
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/utility/db"
+)
+
+var PromptTemplate = NewPromptTemplateDao()
+
+type PromptTemplateDao struct {
+	*MongoDB[entity.PromptTemplate]
+}
+
+func NewPromptTemplateDao(database ...string) *PromptTemplateDao {
+
+	if len(database) == 0 {
+		database = append(database, db.DefaultDatabase)
+	}
+
+	return &PromptTemplateDao{
+		MongoDB: NewMongoDB[entity.PromptTemplate](database[0], PROMPT_TEMPLATE),
+	}
+}
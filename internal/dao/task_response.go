@@ -0,0 +1,23 @@
+package dao
+
+import (
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/utility/db"
+)
+
+var TaskResponse = NewTaskResponseDao()
+
+type TaskResponseDao struct {
+	*MongoDB[entity.TaskResponse]
+}
+
+func NewTaskResponseDao(database ...string) *TaskResponseDao {
+
+	if len(database) == 0 {
+		database = append(database, db.DefaultDatabase)
+	}
+
+	return &TaskResponseDao{
+		MongoDB: NewMongoDB[entity.TaskResponse](database[0], TASK_RESPONSE),
+	}
+}
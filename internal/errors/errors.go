@@ -49,6 +49,7 @@ var (
 	ERR_NOT_API_KEY                       = NewError(401, "invalid_request_error", "You didn't provide an API key.", "fastapi_request_error", nil)
 	ERR_INVALID_API_KEY                   = NewError(401, "invalid_api_key", "Incorrect API key provided or has been disabled.", "fastapi_request_error", nil)
 	ERR_API_KEY_DISABLED                  = NewError(401, "api_key_disabled", "Key has been disabled.", "fastapi_request_error", nil)
+	ERR_API_KEY_EXPIRED                   = NewError(401, "api_key_expired", "Key has expired.", "fastapi_request_error", nil)
 	ERR_INVALID_RESELLER                  = NewError(401, "invalid_reseller", "Reseller does not exist or has been disabled.", "fastapi_request_error", nil)
 	ERR_RESELLER_DISABLED                 = NewError(401, "reseller_disabled", "Reseller has been disabled.", "fastapi_request_error", nil)
 	ERR_INVALID_USER                      = NewError(401, "invalid_user", "User does not exist or has been disabled.", "fastapi_request_error", nil)
@@ -62,6 +63,7 @@ var (
 	ERR_NOT_AUTHORIZED                    = NewError(403, "not_authorized", "Not Authorized.", "fastapi_request_error", nil)
 	ERR_NOT_FOUND                         = NewError(404, "unknown_url", "Unknown request URL.", "fastapi_request_error", nil)
 	ERR_MODEL_NOT_FOUND                   = NewError(404, "model_not_found", "The model does not exist or you do not have access to it.", "fastapi_request_error", nil)
+	ERR_API_KEY_NOT_FOUND                 = NewError(404, "api_key_not_found", "The key does not exist or you do not have access to it.", "fastapi_request_error", nil)
 	ERR_PATH_NOT_FOUND                    = NewError(404, "path_not_found", "The path does not exist or you do not have access to it.", "fastapi_request_error", nil)
 	ERR_GROUP_NOT_FOUND                   = NewError(404, "group_not_found", "The group does not exist or you do not have access to it.", "fastapi_request_error", nil)
 	ERR_RESELLER_INSUFFICIENT_QUOTA       = NewError(429, "reseller_insufficient_quota", "You reseller exceeded current quota.", "fastapi_request_error", nil)
@@ -71,6 +73,20 @@ var (
 	ERR_APP_QUOTA_EXPIRED                 = NewError(429, "app_quota_expired", "You app quota has expired.", "fastapi_request_error", nil)
 	ERR_KEY_QUOTA_EXPIRED                 = NewError(429, "key_quota_expired", "You key quota has expired.", "fastapi_request_error", nil)
 	ERR_GROUP_INSUFFICIENT_QUOTA          = NewError(429, "group_insufficient_quota", "Group exceeded current quota.", "fastapi_request_error", nil)
+	ERR_RATE_LIMIT_REQUESTS               = NewError(429, "rate_limit_exceeded", "Rate limit reached on requests per min (RPM), please try again later.", "requests", nil)
+	ERR_RATE_LIMIT_TOKENS                 = NewError(429, "rate_limit_exceeded", "Rate limit reached on tokens per min (TPM), please try again later.", "tokens", nil)
+	ERR_RATE_LIMIT_CONCURRENCY            = NewError(429, "rate_limit_exceeded", "Rate limit reached on concurrent requests, please try again later.", "requests", nil)
+	ERR_UPSTREAM_RATE_LIMITED             = NewError(429, "upstream_rate_limit_exceeded", "Upstream rate limit reached, please try again later.", "fastapi_error", nil)
+	ERR_CIRCUIT_BREAKER_OPEN              = NewError(503, "circuit_breaker_open", "Upstream is temporarily unavailable, please try again later.", "fastapi_error", nil)
+	ERR_FIRST_TOKEN_TIMEOUT               = NewError(504, "first_token_timeout", "Upstream did not return the first token in time.", "fastapi_error", nil)
+	ERR_STREAM_IDLE_TIMEOUT               = NewError(504, "stream_idle_timeout", "Upstream stream stalled, the response is incomplete.", "fastapi_error", nil)
+	ERR_UPSTREAM_CLOSED                   = NewError(502, "upstream_closed", "Upstream closed the stream before returning any data.", "fastapi_error", nil)
+	ERR_GUARDRAIL_BLOCKED                 = NewError(400, "content_policy_violation", "Your request was rejected as a result of our content policy.", "fastapi_request_error", nil)
+	ERR_GUARDRAIL_PII_DETECTED            = NewError(400, "pii_detected", "Your request contains personal information that is not allowed.", "fastapi_request_error", nil)
+	ERR_GUARDRAIL_MODERATION_FLAGGED      = NewError(400, "moderation_flagged", "Your request was flagged by the moderation model.", "fastapi_request_error", nil)
+	ERR_GUARDRAIL_OUTPUT_BLOCKED          = NewError(400, "content_policy_violation", "The response was blocked as a result of our content policy.", "fastapi_request_error", nil)
+	ERR_PROMPT_TEMPLATE_NOT_FOUND         = NewError(404, "prompt_not_found", "The prompt template does not exist or you do not have access to it.", "fastapi_request_error", "prompt.id")
+	ERR_PROMPT_VERSION_NOT_FOUND          = NewError(404, "prompt_version_not_found", "The prompt template version does not exist.", "fastapi_request_error", "prompt.version")
 )
 
 func NewError(status int, code any, message, typ string, param any) error {
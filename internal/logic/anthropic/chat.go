@@ -5,13 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
@@ -47,223 +44,133 @@ func (s *sAnthropic) Completions(ctx context.Context, request *ghttp.Request, fa
 	var (
 		converter = common.NewConverter(ctx, sconsts.PROVIDER_ANTHROPIC)
 		params    = convToChatCompletionRequest(request)
-		mak       = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_MESSAGES,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
+		reqModel  = params.Model
+		protocol  string
+		body      []byte
+		res       any
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
-			}
-
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: response,
-					Action:            consts.ACTION_MESSAGES,
-					Usage:             response.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          response.ConnTime,
-					Duration:          response.Duration,
-					TotalTime:         response.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name:        "sAnthropic Completions",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_MESSAGES,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
 			}
-		}
-	}()
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+			protocol = upstreamProtocol(ctx, attempt.Mak)
+			body, err = attempt.TransformBody(ctx, request.GetBody(), reqModel, &params, requestConverter(protocol))
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
+			switch protocol {
+			case protocolChatCompletions:
 
-	//request := *params
-	//
-	//if !gstr.Contains(mak.RealModel.Model, "*") {
-	//	request.Model = mak.RealModel.Model
-	//}
-	//
-	//// 预设配置
-	//if mak.RealModel.IsEnablePresetConfig {
-	//
-	//	// 替换预设提示词
-	//	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-	//		if request.Messages[0].Role == consts.ROLE_SYSTEM {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages[1:]...)
-	//		} else {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages...)
-	//		}
-	//	}
-	//
-	//	// 检查MaxTokens取值范围
-	//	if request.MaxTokens != 0 {
-	//		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-	//		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-	//		}
-	//	}
-	//}
-
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sAnthropic Completions params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
-
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
+				chatCompletionRequest := smodel.ChatCompletionRequest{}
+				if err = json.Unmarshal(body, &chatCompletionRequest); err != nil {
 					logger.Error(ctx, err)
 					return response, err
 				}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
-				}
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+				res, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, chatCompletionRequest)
+				common.EndSpan(upstreamSpan, err)
 
-				body = gjson.MustEncode(data)
+			case protocolResponses:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "Responses")
+				res, err = common.NewAdapterOpenAI(upstreamCtx, attempt.Mak, false).Responses(upstreamCtx, body)
+				common.EndSpan(upstreamSpan, err)
 
-				break
+			default:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsOfficial")
+				res, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, false).ChatCompletionsOfficial(upstreamCtx, body)
+				common.EndSpan(upstreamSpan, err)
 			}
-		}
-	}
 
-	res, err := common.NewAdapterOfficial(ctx, mak, false).ChatCompletionsOfficial(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ChatCompletionResponse) (smodel.ChatCompletionResponse, error) {
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			var err error
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			if r, ok := res.(smodel.ChatCompletionResponse); ok {
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				// 转换为 Anthropic Messages 响应
+				response = r
+				response.ResponseBytes = gjson.MustEncode(convChatCompletionsToMessages(gjson.New(gjson.MustEncode(r)).Map(), reqModel))
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			} else if r, ok := res.(smodel.OpenAIResponsesRes); ok {
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+				// 转换为 Anthropic Messages 响应
+				response = common.ConvResponsesToChatCompletionsResponse(ctx, r)
+				response.ResponseBytes = gjson.MustEncode(convResponsesToMessages(gjson.New(r.ResponseBytes).Map(), reqModel))
 
-		if isRetry {
+			} else if r, ok := res.(*smodel.AnthropicChatCompletionRes); ok {
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
+				if r.Err == nil && r.ResponseBytes != nil {
+					if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
+						logger.Error(ctx, err)
+						return response, err
+					}
 				} else {
+					response.ResponseBytes = r.ResponseBytes
+					response.Error = r.Err
+				}
 
-					if mak.RealModel.IsEnableFallback {
+				response.TotalTime = r.TotalTime
+				response.ResponseHeaders = r.ResponseHeaders
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-							}
-						}
+			} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-							}
-						}
+				if r.Error == nil && r.ResponseBytes != nil {
+					if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
+						logger.Error(ctx, err)
+						return response, err
 					}
+				} else {
+					response.ResponseBytes = r.ResponseBytes
+					response.Error = r.Error
+				}
 
+				response.TotalTime = r.TotalTime
+				response.ResponseHeaders = r.ResponseHeaders
+
+			} else {
+				if err := json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
+					logger.Error(ctx, err)
 					return response, err
 				}
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
-	}
-
-	if r, ok := res.(*smodel.AnthropicChatCompletionRes); ok {
-
-		if r.Err == nil && r.ResponseBytes != nil {
-			if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
-				logger.Error(ctx, err)
-				return response, err
+			return response, nil
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *smodel.ChatCompletionResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
 			}
-		} else {
-			response.ResponseBytes = r.ResponseBytes
-			response.Error = r.Err
-		}
-
-		response.TotalTime = r.TotalTime
-		response.ResponseHeaders = r.ResponseHeaders
-
-	} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
-
-		if r.Error == nil && r.ResponseBytes != nil {
-			if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
-				logger.Error(ctx, err)
-				return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				ChatCompletionRes: res,
+				Action:            consts.ACTION_MESSAGES,
+				Usage:             res.Usage,
+				ConnTime:          res.ConnTime,
+				Duration:          res.Duration,
+				TotalTime:         res.TotalTime,
 			}
-		} else {
-			response.ResponseBytes = r.ResponseBytes
-			response.Error = r.Error
-		}
-
-		response.TotalTime = r.TotalTime
-		response.ResponseHeaders = r.ResponseHeaders
-
-	} else {
-		if err = json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
-			logger.Error(ctx, err)
-			return response, err
-		}
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // CompletionsStream
@@ -275,368 +182,511 @@ func (s *sAnthropic) CompletionsStream(ctx context.Context, request *ghttp.Reque
 	}()
 
 	var (
-		converter = common.NewConverter(ctx, sconsts.PROVIDER_ANTHROPIC)
-		params    = convToChatCompletionRequest(request)
-		mak       = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_MESSAGES,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		completion string
-		connTime   int64
-		duration   int64
-		totalTime  int64
-		usage      *smodel.Usage
-		retryInfo  *mcommon.Retry
+		converter           = common.NewConverter(ctx, sconsts.PROVIDER_ANTHROPIC)
+		params              = convToChatCompletionRequest(request)
+		reqModel            = params.Model
+		protocol            string
+		body                []byte
+		completion          string
+		connTime            int64
+		duration            int64
+		totalTime           int64
+		usage               *smodel.Usage
+		cancel              context.CancelFunc
+		chatCompletionsChan chan *smodel.ChatCompletionResponse
+		responsesChan       chan *smodel.OpenAIResponsesStreamRes
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					Completion:        completion,
-					Action:            consts.ACTION_MESSAGES,
-					Usage:             usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	params.Stream = true
+
+	pipeline := &common.Pipeline[chan any]{
+		Name:        "sAnthropic CompletionsStream",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_MESSAGES,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
 			}
-		}
-	}()
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+			protocol = upstreamProtocol(ctx, attempt.Mak)
+			body, err = attempt.TransformBody(ctx, request.GetBody(), reqModel, &params, requestConverter(protocol))
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (responseChan chan any, err error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			switch protocol {
+			case protocolChatCompletions:
 
-	//request := *params
-	//
-	//if !gstr.Contains(mak.RealModel.Model, "*") {
-	//	request.Model = mak.RealModel.Model
-	//}
-	//
-	//// 预设配置
-	//if mak.RealModel.IsEnablePresetConfig {
-	//
-	//	// 替换预设提示词
-	//	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-	//		if request.Messages[0].Role == consts.ROLE_SYSTEM {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages[1:]...)
-	//		} else {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages...)
-	//		}
-	//	}
-	//
-	//	// 检查MaxTokens取值范围
-	//	if request.MaxTokens != 0 {
-	//		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-	//		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-	//		}
-	//	}
-	//}
-
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sAnthropic CompletionsStream params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
-
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
+				chatCompletionRequest := smodel.ChatCompletionRequest{}
+				if err = json.Unmarshal(body, &chatCompletionRequest); err != nil {
 					logger.Error(ctx, err)
-					return err
+					return nil, err
 				}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
-				}
-
-				body = gjson.MustEncode(data)
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStream")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				chatCompletionsChan, err = common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, chatCompletionRequest)
+				attempt.EndStreamSpan(upstreamSpan, err)
+
+			case protocolResponses:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ResponsesStream")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				responsesChan, err = common.NewAdapterOpenAI(upstreamCtx, attempt.Mak, true).ResponsesStream(upstreamCtx, body)
+				attempt.EndStreamSpan(upstreamSpan, err)
+
+			default:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStreamOfficial")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				responseChan, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, true).ChatCompletionsStreamOfficial(upstreamCtx, body)
+				attempt.EndStreamSpan(upstreamSpan, err)
+			}
 
-				break
+			if err != nil {
+				cancel()
 			}
-		}
-	}
 
-	response, err := common.NewAdapterOfficial(ctx, mak, true).ChatCompletionsStreamOfficial(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan any) (chan any, error) {
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			var (
+				mak = attempt.Mak
+				err error
+			)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			streamTimeout := common.NewStreamTimeout(mak)
+			guardrail := attempt.Guardrail.Stream()
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			// 转换为 Anthropic Messages 事件
+			switch protocol {
+			case protocolChatCompletions:
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				defer func() {
+					cancel()
+					close(chatCompletionsChan)
+				}()
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+				messageStream := newMessageStream(reqModel)
 
-		if isRetry {
+				for {
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+					response, err := common.StreamRecv(ctx, streamTimeout, chatCompletionsChan)
+					if err != nil {
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+						// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+						if common.IsStreamStalled(err) {
+							common.StreamErrorEvent(ctx, err, "error")
+							return responseChan, err
+						}
+
+						// 未向客户端输出, 转入重试
+						return responseChan, attempt.UpstreamError(err)
+					}
+
+					connTime = response.ConnTime
+					duration = response.Duration
+					totalTime = response.TotalTime
+
+					if response.Error != nil {
 
-					if mak.RealModel.IsEnableFallback {
+						if errors.Is(response.Error, io.EOF) {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
+							// 补发护栏暂缓的内容
+							if flush := guardrail.Flush(ctx); flush != nil {
+								if err := messageStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+									logger.Error(ctx, err)
+									return responseChan, err
 								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
 							}
-						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
+							if err := messageStream.stop(ctx); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
 							}
+
+							return responseChan, nil
 						}
-					}
 
-					return err
-				}
-			}
+						return responseChan, attempt.UpstreamError(response.Error)
+					}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
+					if response.ResponseHeaders != nil {
+						service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+					}
 
-			return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+					if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
 
-		return err
-	}
+						if response.Choices[0].Delta.ReasoningContent != nil {
+							completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+						}
 
-	defer close(response)
+						completion += response.Choices[0].Delta.Content
 
-	for {
+						if response.Choices[0].Delta.ToolCalls != nil {
+							completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+						}
+					}
 
-		res := <-response
+					// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+					flush, err := guardrail.Chunk(ctx, response)
+					if err != nil {
+						common.StreamErrorEvent(ctx, err, "error")
+						return responseChan, err
+					}
 
-		var response smodel.ChatCompletionResponse
+					if flush != nil {
+						if err := messageStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					}
 
-		if r, ok := res.(*smodel.AnthropicChatCompletionRes); ok {
+					if response.Usage != nil {
+						logger.Infof(ctx, "sAnthropic CompletionsStream Usage: %s", gjson.MustEncodeString(response.Usage))
+						usage = common.MergeUsage(usage, response.Usage)
+					}
 
-			if r.Err == nil && r.ResponseBytes != nil {
-				if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
-					logger.Error(ctx, err)
-					return err
+					if err := messageStream.chatCompletionsChunk(ctx, gjson.MustEncode(response)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-			} else {
-				response.ResponseBytes = r.ResponseBytes
-				response.Error = r.Err
-			}
 
-			response.SSEEvent = r.SSEEvent
-			response.ConnTime = r.ConnTime
-			response.Duration = r.Duration
-			response.TotalTime = r.TotalTime
-			response.ResponseHeaders = r.ResponseHeaders
+			case protocolResponses:
 
-		} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
+				defer func() {
+					cancel()
+					close(responsesChan)
+				}()
 
-			if r.Error == nil && r.ResponseBytes != nil {
-				if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
-			} else {
-				response.ResponseBytes = r.ResponseBytes
-				response.Error = r.Error
-			}
+				messageStream := newMessageStream(reqModel)
 
-			response.SSEEvent = r.SSEEvent
-			response.ConnTime = r.ConnTime
-			response.Duration = r.Duration
-			response.TotalTime = r.TotalTime
-			response.ResponseHeaders = r.ResponseHeaders
+				for {
 
-		} else {
-			if err = json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
-				logger.Error(ctx, err)
-				return err
-			}
-		}
+					res, err := common.StreamRecv(ctx, streamTimeout, responsesChan)
+					if err != nil {
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+						// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+						if common.IsStreamStalled(err) {
+							common.StreamErrorEvent(ctx, err, "error")
+							return responseChan, err
+						}
 
-		if response.Error != nil {
+						// 未向客户端输出, 转入重试
+						return responseChan, attempt.UpstreamError(err)
+					}
 
-			if errors.Is(response.Error, io.EOF) {
-				return nil
-			}
+					response := common.ConvResponsesStreamToChatCompletionsResponse(ctx, *res)
 
-			err = response.Error
+					connTime = response.ConnTime
+					duration = response.Duration
+					totalTime = response.TotalTime
 
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+					if response.Error != nil {
 
-			isRetry, isDisabled := common.IsNeedRetry(err)
+						if errors.Is(response.Error, io.EOF) {
 
-			if isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+							// 补发护栏暂缓的内容
+							if flush := guardrail.Flush(ctx); flush != nil {
+								if err := messageStream.responsesEvent(ctx, flush.ResponseBytes); err != nil {
+									logger.Error(ctx, err)
+									return responseChan, err
+								}
+							}
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+							if err := messageStream.stop(ctx); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
+							}
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
+							return responseChan, nil
+						}
+
+						return responseChan, attempt.UpstreamError(response.Error)
+					}
+
+					if res.ResponseHeaders != nil {
+						service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, res.ResponseHeaders)
+					}
+
+					if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+						completion += response.Choices[0].Delta.Content
+					}
+
+					// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+					flush, err := guardrail.Chunk(ctx, &response)
+					if err != nil {
+						common.StreamErrorEvent(ctx, err, "error")
+						return responseChan, err
+					}
+
+					if flush != nil {
+						if err := messageStream.responsesEvent(ctx, flush.ResponseBytes); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					}
+
+					if response.Usage != nil {
+						logger.Infof(ctx, "sAnthropic CompletionsStream Usage: %s", response.ResponseBytes)
+						usage = common.MergeUsage(usage, response.Usage)
+					}
+
+					if err := messageStream.responsesEvent(ctx, response.ResponseBytes); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
 			}
 
-			if isRetry {
+			defer func() {
+				cancel()
+				close(responseChan)
+			}()
+
+			for {
+
+				var res any
+				if res, err = common.StreamRecv(ctx, streamTimeout, responseChan); err != nil {
+
+					// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+					if common.IsStreamStalled(err) {
+						common.StreamErrorEvent(ctx, err, "error")
+						return responseChan, err
+					}
+
+					// 未向客户端输出, 转入重试
+					return responseChan, attempt.UpstreamError(err)
+				}
+
+				var response smodel.ChatCompletionResponse
 
-				if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+				if r, ok := res.(*smodel.AnthropicChatCompletionRes); ok {
 
-					if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-						service.Session().SaveModelAgentBillingMethod(ctx, 1)
-						retry = []int{}
+					if r.Err == nil && r.ResponseBytes != nil {
+						if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
 					} else {
+						response.ResponseBytes = r.ResponseBytes
+						response.Error = r.Err
+					}
 
-						if mak.RealModel.IsEnableFallback {
+					response.SSEEvent = r.SSEEvent
+					response.ConnTime = r.ConnTime
+					response.Duration = r.Duration
+					response.TotalTime = r.TotalTime
+					response.ResponseHeaders = r.ResponseHeaders
 
-							if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-								if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-								}
-							}
+				} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
 
-							if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-								if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-								}
-							}
+					if r.Error == nil && r.ResponseBytes != nil {
+						if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
 						}
+					} else {
+						response.ResponseBytes = r.ResponseBytes
+						response.Error = r.Error
+					}
+
+					response.SSEEvent = r.SSEEvent
+					response.ConnTime = r.ConnTime
+					response.Duration = r.Duration
+					response.TotalTime = r.TotalTime
+					response.ResponseHeaders = r.ResponseHeaders
 
-						return err
+				} else {
+					if err := json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
 					}
 				}
 
-				retryInfo = &mcommon.Retry{
-					IsRetry:    true,
-					RetryCount: len(retry),
-					ErrMsg:     err.Error(),
-				}
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-				return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-			}
+				if response.Error != nil {
 
-			return err
-		}
+					if errors.Is(response.Error, io.EOF) {
 
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+						// 补发护栏暂缓的内容
+						if flush := guardrail.Flush(ctx); flush != nil {
+							if err := util.SSEServer(ctx, string(flush.ResponseBytes), flush.SSEEvent); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
+							}
+						}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-			if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
-				completion += response.Choices[0].Delta.Audio.Transcript
-			} else {
-				if len(response.Choices) > 1 {
-					for i, choice := range response.Choices {
-						completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+						return responseChan, nil
 					}
-				} else {
-					completion += response.Choices[0].Delta.Content
+
+					return responseChan, attempt.UpstreamError(response.Error)
 				}
-			}
-		}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
-			completion += gconv.String(response.Choices[0].Delta.ToolCalls)
-		}
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+				}
 
-		if response.Usage != nil {
-			logger.Infof(ctx, "sAnthropic CompletionsStream Usage: %s", response.ResponseBytes)
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+					if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
+						completion += response.Choices[0].Delta.Audio.Transcript
+					} else {
+						if len(response.Choices) > 1 {
+							for i, choice := range response.Choices {
+								completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+							}
+						} else {
+							completion += response.Choices[0].Delta.Content
+						}
+					}
+				}
 
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
+					completion += gconv.String(response.Choices[0].Delta.ToolCalls)
 				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
+
+				// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+				flush, err := guardrail.Chunk(ctx, &response)
+				if err != nil {
+					common.StreamErrorEvent(ctx, err, "error")
+					return responseChan, err
 				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+				if flush != nil {
+					if err := util.SSEServer(ctx, string(flush.ResponseBytes), flush.SSEEvent); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-				if response.Usage.CacheCreationInputTokens != 0 {
-					usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+
+				if response.Usage != nil {
+					logger.Infof(ctx, "sAnthropic CompletionsStream Usage: %s", response.ResponseBytes)
+					usage = common.MergeUsage(usage, response.Usage)
 				}
-				if response.Usage.CacheReadInputTokens != 0 {
-					usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+
+				if err := util.SSEServer(ctx, string(response.ResponseBytes), response.SSEEvent); err != nil {
+					logger.Error(ctx, err)
+					return responseChan, err
 				}
 			}
-		}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan any, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				Action:            consts.ACTION_MESSAGES,
+				Completion:        completion,
+				Usage:             usage,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
+			}
+		},
+	}
+
+	// 流中断或输出被拦截已以错误事件结束流
+	if _, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); common.IsStreamStalled(err) || common.IsOutputBlocked(err) {
+		return nil
+	}
+
+	return err
+}
+
+// CountTokens
+func (s *sAnthropic) CountTokens(ctx context.Context, request *ghttp.Request) (inputTokens int, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAnthropic CountTokens time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	var (
+		params   = convToChatCompletionRequest(request)
+		reqModel = params.Model
+		body     []byte
+	)
+
+	if params.Model == "" {
+		return 0, errors.NewError(400, "invalid_request_error", "model: Field required", "invalid_request_error", "model")
+	}
+
+	pipeline := &common.Pipeline[int]{
+		Name: "sAnthropic CountTokens",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_MESSAGES,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) (inputTokens int, done bool, err error) {
+
+			// Anthropic 上游转发到 count_tokens 接口
+			if common.GetProviderCode(ctx, attempt.Mak.Provider) == sconsts.PROVIDER_ANTHROPIC {
+				return 0, false, nil
+			}
 
-		if err = util.SSEServer(ctx, string(response.ResponseBytes), response.SSEEvent); err != nil {
-			logger.Error(ctx, err)
+			// 其他上游没有对应接口, 本地估算
+			return countTokens(ctx, params), true, nil
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+			body, err = attempt.TransformBody(ctx, request.GetBody(), reqModel, &params, nil)
 			return err
-		}
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (inputTokens int, err error) {
+
+			// 与 Messages 接口同级
+			if attempt.Mak.Path == "" {
+				attempt.Mak.Path = "/messages"
+			}
+
+			attempt.Mak.Path += "/count_tokens"
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "CountTokens")
+			res, err := common.NewAdapterOfficial(upstreamCtx, attempt.Mak, false).ChatCompletionsOfficial(upstreamCtx, body)
+			common.EndSpan(upstreamSpan, err)
+
+			if err != nil {
+				return 0, err
+			}
+
+			r, ok := res.(*smodel.AnthropicChatCompletionRes)
+			if !ok {
+				return 0, errors.ERR_INTERNAL_ERROR
+			}
+
+			if r.Err != nil {
+				return 0, r.Err
+			}
+
+			return gjson.New(r.ResponseBytes).Get("input_tokens").Int(), nil
+		},
 	}
+
+	return pipeline.Execute(ctx, nil, nil)
+}
+
+// 本地估算输入令牌数
+func countTokens(ctx context.Context, params smodel.ChatCompletionRequest) (inputTokens int) {
+
+	inputTokens = common.TokensFromMessages(ctx, params.Model, params.Messages)
+
+	if params.Tools != nil {
+		inputTokens += common.TokensFromString(ctx, params.Model, gjson.MustEncodeString(params.Tools))
+	}
+
+	return inputTokens
 }
 
 func convToChatCompletionRequest(request *ghttp.Request) smodel.ChatCompletionRequest {
@@ -669,7 +719,7 @@ func convToChatCompletionRequest(request *ghttp.Request) smodel.ChatCompletionRe
 			}
 
 			messages = append(messages, smodel.ChatCompletionMessage{
-				Role:    sconsts.ROLE_USER,
+				Role:    message.Role,
 				Content: contents,
 			})
 
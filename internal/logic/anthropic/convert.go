@@ -0,0 +1,602 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+)
+
+// 上游协议
+const (
+	protocolMessages        = "messages"         // Anthropic Messages, 原样转发
+	protocolChatCompletions = "chat_completions" // Chat Completions
+	protocolResponses       = "responses"        // Responses
+)
+
+// 按模型代理的提供商选择上游协议
+func upstreamProtocol(ctx context.Context, mak *common.MAK) string {
+
+	provider := common.GetProviderCode(ctx, mak.Provider)
+
+	// 含 Claude 的云厂商渠道也使用 Messages 协议
+	if provider == sconsts.PROVIDER_ANTHROPIC || provider == sconsts.PROVIDER_GCP_CLAUDE || gstr.ContainsI(provider, "claude") {
+		return protocolMessages
+	}
+
+	if provider == sconsts.PROVIDER_OPENAI {
+		return protocolResponses
+	}
+
+	return protocolChatCompletions
+}
+
+// 按上游协议选择请求转换, Messages 协议原样转发
+func requestConverter(protocol string) func(body []byte) any {
+
+	switch protocol {
+	case protocolChatCompletions:
+		return func(body []byte) any {
+			return convMessagesToChatCompletions(body)
+		}
+	case protocolResponses:
+		return func(body []byte) any {
+			return convMessagesToResponses(body)
+		}
+	}
+
+	return nil
+}
+
+// 思考预算转换为推理强度
+func reasoningEffort(thinking map[string]any) string {
+
+	if thinking == nil || thinking["type"] != "enabled" {
+		return ""
+	}
+
+	budgetTokens := gconv.Int(thinking["budget_tokens"])
+
+	if budgetTokens <= 4096 {
+		return "low"
+	}
+
+	if budgetTokens <= 16384 {
+		return "medium"
+	}
+
+	return "high"
+}
+
+// 系统提示, 文本块按顺序拼接
+func systemText(system any) string {
+
+	if text, ok := system.(string); ok {
+		return text
+	}
+
+	texts := make([]string, 0)
+	for _, block := range gconv.Maps(system) {
+		if block["type"] == "text" {
+			texts = append(texts, gconv.String(block["text"]))
+		}
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+// 消息内容统一为内容块
+func contentBlocks(content any) []map[string]any {
+
+	if text, ok := content.(string); ok {
+		return []map[string]any{{"type": "text", "text": text}}
+	}
+
+	return gconv.Maps(content)
+}
+
+// 图片地址, base64 图片转换为 data URL
+func imageUrl(block map[string]any) string {
+
+	source := gconv.Map(block["source"])
+
+	if source["type"] == "url" {
+		return gconv.String(source["url"])
+	}
+
+	return "data:" + gconv.String(source["media_type"]) + ";base64," + gconv.String(source["data"])
+}
+
+// 工具结果内容转换为文本
+func toolResultText(content any) string {
+
+	if text, ok := content.(string); ok {
+		return text
+	}
+
+	texts := make([]string, 0)
+	for _, block := range gconv.Maps(content) {
+		if block["type"] == "text" {
+			texts = append(texts, gconv.String(block["text"]))
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// 自定义工具, 服务端工具上游无法执行, 不转换
+func customTools(tools any) []map[string]any {
+
+	customTools := make([]map[string]any, 0)
+	for _, tool := range gconv.Maps(tools) {
+		if typ := gconv.String(tool["type"]); typ == "" || typ == "custom" {
+			customTools = append(customTools, tool)
+		}
+	}
+
+	return customTools
+}
+
+// Anthropic Messages 请求转换为 Chat Completions 请求
+func convMessagesToChatCompletions(body []byte) map[string]any {
+
+	request := gjson.New(body).Map()
+
+	messages := make([]any, 0)
+
+	if system := systemText(request["system"]); system != "" {
+		messages = append(messages, g.Map{"role": sconsts.ROLE_SYSTEM, "content": system})
+	}
+
+	for _, message := range gconv.Maps(request["messages"]) {
+
+		blocks := contentBlocks(message["content"])
+
+		if message["role"] == sconsts.ROLE_ASSISTANT {
+
+			var (
+				text      string
+				toolCalls = make([]any, 0)
+			)
+
+			for _, block := range blocks {
+				switch block["type"] {
+				case "text":
+					text += gconv.String(block["text"])
+				case "tool_use":
+					toolCalls = append(toolCalls, g.Map{
+						"id":   block["id"],
+						"type": "function",
+						"function": g.Map{
+							"name":      block["name"],
+							"arguments": gjson.MustEncodeString(block["input"]),
+						},
+					})
+				}
+			}
+
+			assistant := g.Map{"role": sconsts.ROLE_ASSISTANT, "content": text}
+			if len(toolCalls) > 0 {
+				assistant["tool_calls"] = toolCalls
+			}
+
+			messages = append(messages, assistant)
+
+			continue
+		}
+
+		contents := make([]any, 0)
+
+		for _, block := range blocks {
+			switch block["type"] {
+			case "text":
+				contents = append(contents, g.Map{"type": "text", "text": block["text"]})
+			case "image":
+				contents = append(contents, g.Map{"type": "image_url", "image_url": g.Map{"url": imageUrl(block)}})
+			case "tool_result":
+				// 工具结果需紧跟在助手的工具调用之后
+				messages = append(messages, g.Map{
+					"role":         sconsts.ROLE_TOOL,
+					"tool_call_id": block["tool_use_id"],
+					"content":      toolResultText(block["content"]),
+				})
+			}
+		}
+
+		if len(contents) > 0 {
+			messages = append(messages, g.Map{"role": sconsts.ROLE_USER, "content": contents})
+		}
+	}
+
+	chatCompletionRequest := g.Map{
+		"model":    request["model"],
+		"messages": messages,
+	}
+
+	if request["max_tokens"] != nil {
+		chatCompletionRequest["max_tokens"] = request["max_tokens"]
+	}
+
+	if request["temperature"] != nil {
+		chatCompletionRequest["temperature"] = request["temperature"]
+	}
+
+	if request["top_p"] != nil {
+		chatCompletionRequest["top_p"] = request["top_p"]
+	}
+
+	if request["top_k"] != nil {
+		chatCompletionRequest["top_k"] = request["top_k"]
+	}
+
+	if request["stop_sequences"] != nil {
+		chatCompletionRequest["stop"] = request["stop_sequences"]
+	}
+
+	if gconv.Bool(request["stream"]) {
+		chatCompletionRequest["stream"] = true
+		chatCompletionRequest["stream_options"] = g.Map{"include_usage": true}
+	}
+
+	if metadata := gconv.Map(request["metadata"]); metadata["user_id"] != nil {
+		chatCompletionRequest["user"] = metadata["user_id"]
+	}
+
+	if effort := reasoningEffort(gconv.Map(request["thinking"])); effort != "" {
+		chatCompletionRequest["reasoning_effort"] = effort
+	}
+
+	if tools := customTools(request["tools"]); len(tools) > 0 {
+
+		chatCompletionTools := make([]any, 0)
+		for _, tool := range tools {
+			chatCompletionTools = append(chatCompletionTools, g.Map{
+				"type": "function",
+				"function": g.Map{
+					"name":        tool["name"],
+					"description": tool["description"],
+					"parameters":  tool["input_schema"],
+				},
+			})
+		}
+
+		chatCompletionRequest["tools"] = chatCompletionTools
+	}
+
+	if toolChoice := gconv.Map(request["tool_choice"]); toolChoice != nil {
+
+		switch toolChoice["type"] {
+		case "auto":
+			chatCompletionRequest["tool_choice"] = "auto"
+		case "any":
+			chatCompletionRequest["tool_choice"] = "required"
+		case "none":
+			chatCompletionRequest["tool_choice"] = "none"
+		case "tool":
+			chatCompletionRequest["tool_choice"] = g.Map{"type": "function", "function": g.Map{"name": toolChoice["name"]}}
+		}
+
+		if gconv.Bool(toolChoice["disable_parallel_tool_use"]) {
+			chatCompletionRequest["parallel_tool_calls"] = false
+		}
+	}
+
+	return chatCompletionRequest
+}
+
+// Anthropic Messages 请求转换为 Responses 请求
+func convMessagesToResponses(body []byte) map[string]any {
+
+	request := gjson.New(body).Map()
+
+	input := make([]any, 0)
+
+	for _, message := range gconv.Maps(request["messages"]) {
+
+		blocks := contentBlocks(message["content"])
+
+		if message["role"] == sconsts.ROLE_ASSISTANT {
+
+			for _, block := range blocks {
+				switch block["type"] {
+				case "text":
+					input = append(input, g.Map{
+						"role":    sconsts.ROLE_ASSISTANT,
+						"content": []any{g.Map{"type": "output_text", "text": block["text"]}},
+					})
+				case "tool_use":
+					input = append(input, g.Map{
+						"type":      "function_call",
+						"call_id":   block["id"],
+						"name":      block["name"],
+						"arguments": gjson.MustEncodeString(block["input"]),
+					})
+				}
+			}
+
+			continue
+		}
+
+		contents := make([]any, 0)
+
+		for _, block := range blocks {
+			switch block["type"] {
+			case "text":
+				contents = append(contents, g.Map{"type": "input_text", "text": block["text"]})
+			case "image":
+				contents = append(contents, g.Map{"type": "input_image", "image_url": imageUrl(block)})
+			case "tool_result":
+				input = append(input, g.Map{
+					"type":    "function_call_output",
+					"call_id": block["tool_use_id"],
+					"output":  toolResultText(block["content"]),
+				})
+			}
+		}
+
+		if len(contents) > 0 {
+			input = append(input, g.Map{"role": sconsts.ROLE_USER, "content": contents})
+		}
+	}
+
+	responsesRequest := g.Map{
+		"model": request["model"],
+		"input": input,
+		"store": false,
+	}
+
+	if system := systemText(request["system"]); system != "" {
+		responsesRequest["instructions"] = system
+	}
+
+	if request["max_tokens"] != nil {
+		responsesRequest["max_output_tokens"] = request["max_tokens"]
+	}
+
+	if request["temperature"] != nil {
+		responsesRequest["temperature"] = request["temperature"]
+	}
+
+	if request["top_p"] != nil {
+		responsesRequest["top_p"] = request["top_p"]
+	}
+
+	if gconv.Bool(request["stream"]) {
+		responsesRequest["stream"] = true
+	}
+
+	if metadata := gconv.Map(request["metadata"]); metadata["user_id"] != nil {
+		responsesRequest["user"] = metadata["user_id"]
+	}
+
+	if effort := reasoningEffort(gconv.Map(request["thinking"])); effort != "" {
+		responsesRequest["reasoning"] = g.Map{"effort": effort, "summary": "auto"}
+	}
+
+	if tools := customTools(request["tools"]); len(tools) > 0 {
+
+		responsesTools := make([]any, 0)
+		for _, tool := range tools {
+			responsesTools = append(responsesTools, g.Map{
+				"type":        "function",
+				"name":        tool["name"],
+				"description": tool["description"],
+				"parameters":  tool["input_schema"],
+			})
+		}
+
+		responsesRequest["tools"] = responsesTools
+	}
+
+	if toolChoice := gconv.Map(request["tool_choice"]); toolChoice != nil {
+
+		switch toolChoice["type"] {
+		case "auto":
+			responsesRequest["tool_choice"] = "auto"
+		case "any":
+			responsesRequest["tool_choice"] = "required"
+		case "none":
+			responsesRequest["tool_choice"] = "none"
+		case "tool":
+			responsesRequest["tool_choice"] = g.Map{"type": "function", "name": toolChoice["name"]}
+		}
+
+		if gconv.Bool(toolChoice["disable_parallel_tool_use"]) {
+			responsesRequest["parallel_tool_calls"] = false
+		}
+	}
+
+	return responsesRequest
+}
+
+// Chat Completions 结束原因转换为 Anthropic 停止原因
+func stopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// 工具调用参数解析为对象
+func toolInput(arguments string) any {
+
+	input := make(map[string]any)
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+			return map[string]any{}
+		}
+	}
+
+	return input
+}
+
+// Chat Completions 用量转换为 Anthropic 用量, 输入令牌数不含缓存令牌数
+func convChatCompletionsUsage(usage map[string]any) map[string]any {
+
+	promptTokensDetails := gconv.Map(usage["prompt_tokens_details"])
+
+	cacheReadTokens := gconv.Int(promptTokensDetails["cached_tokens"])
+	if cacheReadTokens == 0 {
+		// DeepSeek
+		cacheReadTokens = gconv.Int(usage["prompt_cache_hit_tokens"])
+	}
+
+	cacheWriteTokens := gconv.Int(promptTokensDetails["cache_write_tokens"])
+
+	return g.Map{
+		"input_tokens":                max(gconv.Int(usage["prompt_tokens"])-cacheReadTokens-cacheWriteTokens, 0),
+		"output_tokens":               gconv.Int(usage["completion_tokens"]),
+		"cache_creation_input_tokens": cacheWriteTokens,
+		"cache_read_input_tokens":     cacheReadTokens,
+	}
+}
+
+// Responses 用量转换为 Anthropic 用量, 输入令牌数不含缓存令牌数
+func convResponsesUsage(usage map[string]any) map[string]any {
+
+	inputTokensDetails := gconv.Map(usage["input_tokens_details"])
+
+	cacheReadTokens := gconv.Int(inputTokensDetails["cached_tokens"])
+	cacheWriteTokens := gconv.Int(inputTokensDetails["cache_write_tokens"])
+
+	return g.Map{
+		"input_tokens":                max(gconv.Int(usage["input_tokens"])-cacheReadTokens-cacheWriteTokens, 0),
+		"output_tokens":               gconv.Int(usage["output_tokens"]),
+		"cache_creation_input_tokens": cacheWriteTokens,
+		"cache_read_input_tokens":     cacheReadTokens,
+	}
+}
+
+func messageId(id string) string {
+
+	if id == "" {
+		return "msg_" + grand.S(24)
+	}
+
+	if gstr.HasPrefix(id, "msg_") {
+		return id
+	}
+
+	return "msg_" + id
+}
+
+// Chat Completions 响应转换为 Anthropic Messages 响应
+func convChatCompletionsToMessages(response map[string]any, model string) map[string]any {
+
+	var (
+		content      = make([]any, 0)
+		finishReason string
+	)
+
+	if choices := gconv.Maps(response["choices"]); len(choices) > 0 {
+
+		message := gconv.Map(choices[0]["message"])
+		finishReason = gconv.String(choices[0]["finish_reason"])
+
+		if reasoningContent := gconv.String(message["reasoning_content"]); reasoningContent != "" {
+			content = append(content, g.Map{"type": "thinking", "thinking": reasoningContent, "signature": ""})
+		}
+
+		if text := gconv.String(message["content"]); text != "" {
+			content = append(content, g.Map{"type": "text", "text": text})
+		}
+
+		for _, toolCall := range gconv.Maps(message["tool_calls"]) {
+			function := gconv.Map(toolCall["function"])
+			content = append(content, g.Map{
+				"type":  "tool_use",
+				"id":    toolCall["id"],
+				"name":  function["name"],
+				"input": toolInput(gconv.String(function["arguments"])),
+			})
+		}
+	}
+
+	return g.Map{
+		"id":            messageId(gconv.String(response["id"])),
+		"type":          "message",
+		"role":          sconsts.ROLE_ASSISTANT,
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason(finishReason),
+		"stop_sequence": nil,
+		"usage":         convChatCompletionsUsage(gconv.Map(response["usage"])),
+	}
+}
+
+// Responses 响应转换为 Anthropic Messages 响应
+func convResponsesToMessages(response map[string]any, model string) map[string]any {
+
+	var (
+		content   = make([]any, 0)
+		isToolUse bool
+	)
+
+	for _, output := range gconv.Maps(response["output"]) {
+		switch output["type"] {
+		case "reasoning":
+			for _, summary := range gconv.Maps(output["summary"]) {
+				content = append(content, g.Map{"type": "thinking", "thinking": summary["text"], "signature": ""})
+			}
+		case "message":
+			for _, outputContent := range gconv.Maps(output["content"]) {
+				switch outputContent["type"] {
+				case "output_text":
+					content = append(content, g.Map{"type": "text", "text": outputContent["text"]})
+				case "refusal":
+					content = append(content, g.Map{"type": "text", "text": outputContent["refusal"]})
+				}
+			}
+		case "function_call":
+			isToolUse = true
+			content = append(content, g.Map{
+				"type":  "tool_use",
+				"id":    output["call_id"],
+				"name":  output["name"],
+				"input": toolInput(gconv.String(output["arguments"])),
+			})
+		}
+	}
+
+	return g.Map{
+		"id":            messageId(gconv.String(response["id"])),
+		"type":          "message",
+		"role":          sconsts.ROLE_ASSISTANT,
+		"model":         model,
+		"content":       content,
+		"stop_reason":   responsesStopReason(response, isToolUse),
+		"stop_sequence": nil,
+		"usage":         convResponsesUsage(gconv.Map(response["usage"])),
+	}
+}
+
+// Responses 停止原因
+func responsesStopReason(response map[string]any, isToolUse bool) string {
+
+	if isToolUse {
+		return "tool_use"
+	}
+
+	if response["status"] == "incomplete" {
+		if reason := gconv.Map(response["incomplete_details"])["reason"]; reason == "max_output_tokens" {
+			return "max_tokens"
+		} else if reason == "content_filter" {
+			return "refusal"
+		}
+	}
+
+	return "end_turn"
+}
@@ -0,0 +1,251 @@
+package anthropic
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+// 流式事件转换, 将 Chat Completions/Responses 数据块转换为 Anthropic Messages 事件
+type messageStream struct {
+	model      string
+	isStarted  bool
+	index      int    // 当前内容块索引
+	blockType  string // 当前内容块类型, 空表示无打开的内容块
+	toolIndex  int    // 当前工具调用索引, Chat Completions
+	stopReason string
+	usage      map[string]any
+}
+
+func newMessageStream(model string) *messageStream {
+	return &messageStream{
+		model:     model,
+		index:     -1,
+		toolIndex: -1,
+	}
+}
+
+func (s *messageStream) event(ctx context.Context, event string, data map[string]any) error {
+	data["type"] = event
+	return util.SSEServer(ctx, gjson.MustEncodeString(data), event)
+}
+
+func (s *messageStream) start(ctx context.Context, id string) error {
+
+	if s.isStarted {
+		return nil
+	}
+
+	s.isStarted = true
+
+	return s.event(ctx, "message_start", g.Map{
+		"message": g.Map{
+			"id":            messageId(id),
+			"type":          "message",
+			"role":          sconsts.ROLE_ASSISTANT,
+			"model":         s.model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         g.Map{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+// 打开内容块, 先关闭当前内容块
+func (s *messageStream) openBlock(ctx context.Context, contentBlock map[string]any) error {
+
+	if err := s.closeBlock(ctx); err != nil {
+		return err
+	}
+
+	s.index++
+	s.blockType = gconv.String(contentBlock["type"])
+
+	return s.event(ctx, "content_block_start", g.Map{"index": s.index, "content_block": contentBlock})
+}
+
+func (s *messageStream) closeBlock(ctx context.Context) error {
+
+	if s.blockType == "" {
+		return nil
+	}
+
+	s.blockType = ""
+
+	return s.event(ctx, "content_block_stop", g.Map{"index": s.index})
+}
+
+func (s *messageStream) text(ctx context.Context, text string) error {
+
+	if s.blockType != "text" {
+		if err := s.openBlock(ctx, g.Map{"type": "text", "text": ""}); err != nil {
+			return err
+		}
+	}
+
+	return s.event(ctx, "content_block_delta", g.Map{"index": s.index, "delta": g.Map{"type": "text_delta", "text": text}})
+}
+
+func (s *messageStream) thinking(ctx context.Context, thinking string) error {
+
+	if s.blockType != "thinking" {
+		if err := s.openBlock(ctx, g.Map{"type": "thinking", "thinking": ""}); err != nil {
+			return err
+		}
+	}
+
+	return s.event(ctx, "content_block_delta", g.Map{"index": s.index, "delta": g.Map{"type": "thinking_delta", "thinking": thinking}})
+}
+
+func (s *messageStream) toolUse(ctx context.Context, id, name string) error {
+	return s.openBlock(ctx, g.Map{"type": "tool_use", "id": id, "name": name, "input": g.Map{}})
+}
+
+func (s *messageStream) inputJson(ctx context.Context, partialJson string) error {
+
+	if s.blockType != "tool_use" || partialJson == "" {
+		return nil
+	}
+
+	return s.event(ctx, "content_block_delta", g.Map{"index": s.index, "delta": g.Map{"type": "input_json_delta", "partial_json": partialJson}})
+}
+
+// 结束消息
+func (s *messageStream) stop(ctx context.Context) error {
+
+	if !s.isStarted {
+		if err := s.start(ctx, ""); err != nil {
+			return err
+		}
+	}
+
+	if err := s.closeBlock(ctx); err != nil {
+		return err
+	}
+
+	if s.stopReason == "" {
+		s.stopReason = "end_turn"
+	}
+
+	if s.usage == nil {
+		s.usage = g.Map{"output_tokens": 0}
+	}
+
+	if err := s.event(ctx, "message_delta", g.Map{"delta": g.Map{"stop_reason": s.stopReason, "stop_sequence": nil}, "usage": s.usage}); err != nil {
+		return err
+	}
+
+	return s.event(ctx, "message_stop", g.Map{})
+}
+
+// Chat Completions 数据块
+func (s *messageStream) chatCompletionsChunk(ctx context.Context, data []byte) error {
+
+	chunk := gjson.New(data).Map()
+
+	if err := s.start(ctx, gconv.String(chunk["id"])); err != nil {
+		return err
+	}
+
+	if usage := gconv.Map(chunk["usage"]); usage != nil {
+		s.usage = convChatCompletionsUsage(usage)
+	}
+
+	choices := gconv.Maps(chunk["choices"])
+	if len(choices) == 0 {
+		return nil
+	}
+
+	if finishReason := gconv.String(choices[0]["finish_reason"]); finishReason != "" {
+		s.stopReason = stopReason(finishReason)
+	}
+
+	delta := gconv.Map(choices[0]["delta"])
+
+	if reasoningContent := gconv.String(delta["reasoning_content"]); reasoningContent != "" {
+		if err := s.thinking(ctx, reasoningContent); err != nil {
+			return err
+		}
+	}
+
+	if content := gconv.String(delta["content"]); content != "" {
+		if err := s.text(ctx, content); err != nil {
+			return err
+		}
+	}
+
+	for _, toolCall := range gconv.Maps(delta["tool_calls"]) {
+
+		function := gconv.Map(toolCall["function"])
+
+		// 新的工具调用
+		if index := gconv.Int(toolCall["index"]); index != s.toolIndex || s.blockType != "tool_use" {
+			s.toolIndex = index
+			if err := s.toolUse(ctx, gconv.String(toolCall["id"]), gconv.String(function["name"])); err != nil {
+				return err
+			}
+		}
+
+		if err := s.inputJson(ctx, gconv.String(function["arguments"])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Responses 事件
+func (s *messageStream) responsesEvent(ctx context.Context, data []byte) error {
+
+	event := gjson.New(data).Map()
+
+	switch event["type"] {
+	case "response.created":
+		return s.start(ctx, gconv.String(gconv.Map(event["response"])["id"]))
+
+	case "response.output_item.added":
+
+		if err := s.start(ctx, ""); err != nil {
+			return err
+		}
+
+		if item := gconv.Map(event["item"]); item["type"] == "function_call" {
+			return s.toolUse(ctx, gconv.String(item["call_id"]), gconv.String(item["name"]))
+		}
+
+	case "response.output_text.delta":
+		return s.text(ctx, gconv.String(event["delta"]))
+
+	case "response.refusal.delta":
+		return s.text(ctx, gconv.String(event["delta"]))
+
+	case "response.reasoning_summary_text.delta":
+		return s.thinking(ctx, gconv.String(event["delta"]))
+
+	case "response.function_call_arguments.delta":
+		return s.inputJson(ctx, gconv.String(event["delta"]))
+
+	case "response.output_item.done":
+		return s.closeBlock(ctx)
+
+	case "response.completed", "response.incomplete":
+
+		response := gconv.Map(event["response"])
+
+		s.stopReason = responsesStopReason(response, s.stopReason == "tool_use")
+		s.usage = convResponsesUsage(gconv.Map(response["usage"]))
+
+		for _, output := range gconv.Maps(response["output"]) {
+			if output["type"] == "function_call" {
+				s.stopReason = "tool_use"
+			}
+		}
+	}
+
+	return nil
+}
@@ -60,6 +60,9 @@ func (s *sApp) GetByAppId(ctx context.Context, appId int) (*model.App, error) {
 		Group:          app.Group,
 		IpWhitelist:    app.IpWhitelist,
 		IpBlacklist:    app.IpBlacklist,
+		RateLimit:      app.RateLimit,
+		Guardrail:      app.Guardrail,
+		Prompt:         app.Prompt,
 		Remark:         app.Remark,
 		Status:         app.Status,
 		Rid:            app.Rid,
@@ -98,6 +101,9 @@ func (s *sApp) List(ctx context.Context) ([]*model.App, error) {
 			Group:          result.Group,
 			IpWhitelist:    result.IpWhitelist,
 			IpBlacklist:    result.IpBlacklist,
+			RateLimit:      result.RateLimit,
+			Guardrail:      result.Guardrail,
+			Prompt:         result.Prompt,
 			Remark:         result.Remark,
 			Status:         result.Status,
 			Rid:            result.Rid,
@@ -253,6 +259,9 @@ func (s *sApp) UpdateCache(ctx context.Context, app *entity.App) {
 		Group:          app.Group,
 		IpWhitelist:    app.IpWhitelist,
 		IpBlacklist:    app.IpBlacklist,
+		RateLimit:      app.RateLimit,
+		Guardrail:      app.Guardrail,
+		Prompt:         app.Prompt,
 		Status:         app.Status,
 		Rid:            app.Rid,
 	}); err != nil {
@@ -65,6 +65,13 @@ func (s *sAppKey) GetBySecretKey(ctx context.Context, secretKey string) (*model.
 		Group:               key.Group,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
+		RateLimit:           key.RateLimit,
+		ResponseCache:       key.ResponseCache,
+		Guardrail:           key.Guardrail,
+		Prompt:              key.Prompt,
+		ParentId:            key.ParentId,
+		ExpiresAt:           key.ExpiresAt,
+		Metadata:            key.Metadata,
 		Status:              key.Status,
 	}, nil
 }
@@ -102,6 +109,13 @@ func (s *sAppKey) List(ctx context.Context) ([]*model.AppKey, error) {
 			Group:               result.Group,
 			IpWhitelist:         result.IpWhitelist,
 			IpBlacklist:         result.IpBlacklist,
+			RateLimit:           result.RateLimit,
+			ResponseCache:       result.ResponseCache,
+			Guardrail:           result.Guardrail,
+			Prompt:              result.Prompt,
+			ParentId:            result.ParentId,
+			ExpiresAt:           result.ExpiresAt,
+			Metadata:            result.Metadata,
 			Status:              result.Status,
 			Rid:                 result.Rid,
 		})
@@ -183,6 +197,13 @@ func (s *sAppKey) UpdateCache(ctx context.Context, key *entity.AppKey) {
 		Group:               key.Group,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
+		RateLimit:           key.RateLimit,
+		ResponseCache:       key.ResponseCache,
+		Guardrail:           key.Guardrail,
+		Prompt:              key.Prompt,
+		ParentId:            key.ParentId,
+		ExpiresAt:           key.ExpiresAt,
+		Metadata:            key.Metadata,
 		Status:              key.Status,
 		Rid:                 key.Rid,
 	}); err != nil {
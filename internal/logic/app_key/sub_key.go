@@ -0,0 +1,703 @@
+package app_key
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gogf/gf/v2/container/gset"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/model/do"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/db"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+	"github.com/iimeta/fastapi/v2/utility/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	subKeyMaxMetadata       = 16
+	subKeyMaxMetadataKey    = 64
+	subKeyMaxMetadataValue  = 512
+	subKeyDefaultExpiresIn  = 30 * 24 * 3600
+	subKeyDefaultMaxKeys    = 100
+	subKeyDefaultListLimit  = 20
+	subKeyMaxListLimit      = 100
+	subKeyRandomKeyLength   = 38
+	subKeyStatusActive      = "active"
+	subKeyStatusExpired     = "expired"
+	subKeyStatusRevoked     = "revoked"
+	subKeyObject            = "api_key"
+	subKeyInvalidRequestErr = "invalid_request_error"
+	subKeyLockSeconds       = 30                    // 父密钥锁过期时间, 防止异常退出时无法释放
+	subKeyLockWait          = 5 * time.Second       // 等待父密钥锁的最长时间
+	subKeyLockInterval      = 50 * time.Millisecond // 等待父密钥锁的间隔
+)
+
+// 签发子密钥
+func (s *sAppKey) CreateSubKey(ctx context.Context, params model.SubKeyCreateReq) (*model.SubKey, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAppKey CreateSubKey time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	parent, err := s.subKeyParent(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	maxExpiresIn := config.Cfg.SubKey.MaxExpiresIn
+	if maxExpiresIn <= 0 {
+		maxExpiresIn = subKeyDefaultExpiresIn
+	}
+
+	if params.ExpiresIn < 0 || params.ExpiresIn > maxExpiresIn {
+		err = errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'expires_in': must be between 0 and %d seconds, 0 means the maximum.", subKeyInvalidRequestErr, "expires_in", maxExpiresIn)
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if params.ExpiresIn == 0 {
+		params.ExpiresIn = maxExpiresIn
+	}
+
+	// 子密钥不晚于父密钥过期
+	expiresAt := now + params.ExpiresIn*1000
+	if parent.ExpiresAt != 0 && expiresAt > parent.ExpiresAt {
+		expiresAt = parent.ExpiresAt
+	}
+
+	maxKeys := config.Cfg.SubKey.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = subKeyDefaultMaxKeys
+	}
+
+	// 同一父密钥串行签发, 检查数量上限和写入之间不会有其它签发
+	unlock, err := lockSubKeyParent(ctx, parent.Id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+	defer unlock()
+
+	// 回收已过期子密钥未用完的额度
+	s.reclaimExpiredSubKeys(ctx, parent)
+
+	count, err := dao.AppKey.CountDocuments(ctx, bson.M{
+		"parent_id":  parent.Id,
+		"status":     1,
+		"expires_at": bson.M{"$gt": now},
+	})
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if count >= int64(maxKeys) {
+		err = errors.NewErrorf(400, "sub_key_limit_exceeded", "The number of active sub keys has reached the limit of %d.", subKeyInvalidRequestErr, nil, maxKeys)
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	models, err := s.subKeyModels(ctx, parent, params.Models)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	rateLimit, err := subKeyRateLimit(parent.RateLimit, params.RateLimit)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	ipWhitelist := parent.IpWhitelist
+	if len(params.IpWhitelist) > 0 {
+
+		if len(parent.IpWhitelist) > 0 {
+			for _, ip := range params.IpWhitelist {
+				if !slices.Contains(parent.IpWhitelist, ip) {
+					err = errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'ip_whitelist': %s is not in the parent key whitelist.", subKeyInvalidRequestErr, "ip_whitelist", ip)
+					logger.Error(ctx, err)
+					return nil, err
+				}
+			}
+		}
+
+		ipWhitelist = params.IpWhitelist
+	}
+
+	if err = checkSubKeyMetadata(params.Metadata); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if params.Quota < 0 || (parent.IsLimitQuota && params.Quota == 0) {
+		err = errors.NewError(400, subKeyInvalidRequestErr, "Invalid 'quota': must be a positive integer when the parent key has a quota limit.", subKeyInvalidRequestErr, "quota")
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 从父密钥剩余额度中划出
+	if parent.IsLimitQuota {
+		if err = s.transferQuota(ctx, parent, params.Quota); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+	}
+
+	subKey := &do.AppKey{
+		UserId:         parent.UserId,
+		AppId:          parent.AppId,
+		Key:            consts.SUB_KEY_PREFIX + grand.S(subKeyRandomKeyLength),
+		BillingMethods: parent.BillingMethods,
+		Models:         models,
+		IsLimitQuota:   params.Quota > 0,
+		Quota:          params.Quota,
+		IsBindGroup:    parent.IsBindGroup,
+		Group:          parent.Group,
+		IpWhitelist:    ipWhitelist,
+		IpBlacklist:    parent.IpBlacklist,
+		RateLimit:      rateLimit,
+		ResponseCache:  parent.ResponseCache,
+		Guardrail:      parent.Guardrail,
+		Prompt:         parent.Prompt,
+		ParentId:       parent.Id,
+		ExpiresAt:      expiresAt,
+		Metadata:       params.Metadata,
+		Remark:         params.Remark,
+		Status:         1,
+		Rid:            parent.Rid,
+	}
+
+	id, err := dao.AppKey.Insert(ctx, subKey)
+	if err != nil {
+		logger.Error(ctx, err)
+		if parent.IsLimitQuota {
+			if err := s.transferQuota(ctx, parent, -params.Quota); err != nil {
+				logger.Errorf(ctx, "sAppKey CreateSubKey refund quota: %d to parent: %s, error: %v", params.Quota, parent.Id, err)
+			}
+		}
+		return nil, err
+	}
+
+	if subKey.IsLimitQuota {
+		if _, err = redis.HSet(ctx, fmt.Sprintf(consts.API_USER_USAGE_KEY, subKey.UserId), map[string]any{
+			fmt.Sprintf(consts.APP_KEY_QUOTA_FIELD, subKey.AppId, subKey.Key): subKey.Quota,
+		}); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	newData, err := dao.AppKey.FindById(ctx, id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if _, err = redis.Publish(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
+		Action:  consts.ACTION_CREATE,
+		NewData: newData,
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	logger.Infof(ctx, "sAppKey CreateSubKey parent: %s, id: %s, quota: %d, expiresAt: %d", parent.Id, id, subKey.Quota, expiresAt)
+
+	result := s.subKey(ctx, newData)
+	result.Key = newData.Key
+
+	return result, nil
+}
+
+// 子密钥列表
+func (s *sAppKey) ListSubKey(ctx context.Context, params model.SubKeyListReq) (*model.SubKeyListRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAppKey ListSubKey time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	parent, err := s.subKeyParent(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 回收已过期子密钥未用完的额度, 等待父密钥锁超时时跳过
+	if unlock, err := lockSubKeyParent(ctx, parent.Id); err == nil {
+		s.reclaimExpiredSubKeys(ctx, parent)
+		unlock()
+	}
+
+	limit := params.Limit
+	if limit < 0 || limit > subKeyMaxListLimit {
+		err = errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'limit': must be between 1 and %d.", subKeyInvalidRequestErr, "limit", subKeyMaxListLimit)
+		logger.Error(ctx, err)
+		return nil, err
+	} else if limit == 0 {
+		limit = subKeyDefaultListLimit
+	}
+
+	filter := bson.M{
+		"parent_id": parent.Id,
+	}
+
+	if params.After != "" {
+
+		after, err := dao.AppKey.FindOne(ctx, bson.M{"_id": params.After, "parent_id": parent.Id})
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				err = errors.NewError(404, subKeyInvalidRequestErr, "No such key: "+params.After, subKeyInvalidRequestErr, "after")
+			}
+			logger.Error(ctx, err)
+			return nil, err
+		}
+
+		filter["created_at"] = bson.M{"$lte": after.CreatedAt}
+
+		if params.Order == "asc" {
+			filter["created_at"] = bson.M{"$gte": after.CreatedAt}
+		}
+
+		filter["_id"] = bson.M{"$ne": after.Id}
+	}
+
+	sort := "-created_at"
+	if params.Order == "asc" {
+		sort = "created_at"
+	}
+
+	paging := &db.Paging{
+		Page:     1,
+		PageSize: limit,
+	}
+
+	results, err := dao.AppKey.FindByPage(ctx, paging, filter, &dao.FindOptions{SortFields: []string{sort}})
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	listRes := &model.SubKeyListRes{
+		Object:  "list",
+		Data:    make([]*model.SubKey, 0),
+		HasMore: paging.PageCount > 1,
+	}
+
+	for _, result := range results {
+		listRes.Data = append(listRes.Data, s.subKey(ctx, result))
+	}
+
+	if len(listRes.Data) > 0 {
+		listRes.FirstId = &listRes.Data[0].Id
+		listRes.LastId = &listRes.Data[len(listRes.Data)-1].Id
+	}
+
+	return listRes, nil
+}
+
+// 撤销子密钥, 未用完的额度退回父密钥
+func (s *sAppKey) RevokeSubKey(ctx context.Context, id string) (*model.SubKey, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAppKey RevokeSubKey time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	parent, err := s.subKeyParent(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	oldData, err := dao.AppKey.FindOne(ctx, bson.M{"_id": id, "parent_id": parent.Id})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = errors.ERR_API_KEY_NOT_FOUND
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if oldData.Status != 1 {
+		return s.subKey(ctx, oldData), nil
+	}
+
+	// 与签发和回收额度串行, 避免重复退回额度
+	unlock, err := lockSubKeyParent(ctx, parent.Id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+	defer unlock()
+
+	newData, err := dao.AppKey.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": 1}, bson.M{"status": 2})
+	if err != nil {
+		// 并发撤销时已被其他请求处理
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if newData, err = dao.AppKey.FindById(ctx, id); err == nil {
+				return s.subKey(ctx, newData), nil
+			}
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if _, err = redis.Publish(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
+		Action:  consts.ACTION_STATUS,
+		OldData: oldData,
+		NewData: newData,
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	if parent.IsLimitQuota {
+		newData.Quota -= s.refundSubKeyQuota(ctx, parent, newData)
+	}
+
+	logger.Infof(ctx, "sAppKey RevokeSubKey parent: %s, id: %s", parent.Id, id)
+
+	return s.subKey(ctx, newData), nil
+}
+
+// 回收已过期子密钥未用完的额度, 需持有父密钥锁
+func (s *sAppKey) reclaimExpiredSubKeys(ctx context.Context, parent *model.AppKey) {
+
+	if !parent.IsLimitQuota {
+		return
+	}
+
+	results, err := dao.AppKey.Find(ctx, bson.M{
+		"parent_id":      parent.Id,
+		"status":         1,
+		"is_limit_quota": true,
+		"quota":          bson.M{"$gt": 0},
+		"expires_at":     bson.M{"$gt": 0, "$lte": gtime.TimestampMilli()},
+	})
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	for _, result := range results {
+		if refunded := s.refundSubKeyQuota(ctx, parent, result); refunded > 0 {
+			logger.Infof(ctx, "sAppKey reclaimExpiredSubKeys parent: %s, id: %s, quota: %d", parent.Id, result.Id, refunded)
+		}
+	}
+}
+
+// 子密钥未用完的额度退回父密钥, 返回退回的额度, 需持有父密钥锁
+func (s *sAppKey) refundSubKeyQuota(ctx context.Context, parent *model.AppKey, subKey *entity.AppKey) int {
+
+	if !subKey.IsLimitQuota {
+		return 0
+	}
+
+	usageKey := fmt.Sprintf(consts.API_USER_USAGE_KEY, subKey.UserId)
+	field := fmt.Sprintf(consts.APP_KEY_QUOTA_FIELD, subKey.AppId, subKey.Key)
+
+	remaining, err := redis.HGetInt(ctx, usageKey, field)
+	if err != nil {
+		logger.Error(ctx, err)
+		return 0
+	}
+
+	// 已用完, 不再重复回收
+	if remaining <= 0 {
+		if err = dao.AppKey.UpdateById(ctx, subKey.Id, bson.M{"quota": 0}); err != nil {
+			logger.Error(ctx, err)
+		}
+		return 0
+	}
+
+	if _, err = redis.HIncrBy(ctx, usageKey, field, int64(-remaining)); err != nil {
+		logger.Error(ctx, err)
+		return 0
+	}
+
+	if err = dao.AppKey.UpdateById(ctx, subKey.Id, bson.M{"$inc": bson.M{"quota": -remaining}}); err != nil {
+		logger.Error(ctx, err)
+		return 0
+	}
+
+	if err = s.transferQuota(ctx, parent, -remaining); err != nil {
+		logger.Errorf(ctx, "sAppKey refundSubKeyQuota refund quota: %d to parent: %s, error: %v", remaining, parent.Id, err)
+		return 0
+	}
+
+	return remaining
+}
+
+// 父密钥锁, 同一父密钥的签发、撤销和回收额度串行执行, 等待超时返回错误
+func lockSubKeyParent(ctx context.Context, parentId string) (func(), error) {
+
+	key := fmt.Sprintf(consts.LOCK_SUB_KEY_KEY, parentId)
+	deadline := time.Now().Add(subKeyLockWait)
+
+	for {
+
+		ok, err := redis.SetNX(ctx, key, gctx.CtxId(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+
+			if _, err = redis.Expire(ctx, key, subKeyLockSeconds); err != nil {
+				logger.Error(ctx, err)
+			}
+
+			return func() {
+				if _, err := redis.Del(ctx, key); err != nil {
+					logger.Error(ctx, err)
+				}
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.NewError(409, "conflict", "Another request for this key is in progress, please retry.", "fastapi_request_error", nil)
+		}
+
+		time.Sleep(subKeyLockInterval)
+	}
+}
+
+// 当前调用的密钥作为父密钥, 子密钥不可再签发子密钥
+func (s *sAppKey) subKeyParent(ctx context.Context) (*model.AppKey, error) {
+
+	if !config.Cfg.SubKey.Open {
+		return nil, errors.ERR_NOT_FOUND
+	}
+
+	parent, err := s.GetCache(ctx, service.Session().GetSecretKey(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if parent.ParentId != "" {
+		return nil, errors.NewError(403, "forbidden", "Sub keys cannot manage sub keys.", "fastapi_request_error", nil)
+	}
+
+	return parent, nil
+}
+
+// 父密钥和子密钥之间划转额度, quota为负数时退回父密钥
+func (s *sAppKey) transferQuota(ctx context.Context, parent *model.AppKey, quota int) error {
+
+	usageKey := fmt.Sprintf(consts.API_USER_USAGE_KEY, parent.UserId)
+	field := fmt.Sprintf(consts.APP_KEY_QUOTA_FIELD, parent.AppId, parent.Key)
+
+	currentQuota, err := redis.HIncrBy(ctx, usageKey, field, int64(-quota))
+	if err != nil {
+		return err
+	}
+
+	if quota > 0 && currentQuota < 0 {
+
+		if _, err = redis.HIncrBy(ctx, usageKey, field, int64(quota)); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		return errors.ERR_INSUFFICIENT_QUOTA
+	}
+
+	if err = dao.AppKey.UpdateById(ctx, parent.Id, bson.M{"$inc": bson.M{"quota": -quota}}); err != nil {
+
+		if _, e := redis.HIncrBy(ctx, usageKey, field, int64(quota)); e != nil {
+			logger.Error(ctx, e)
+		}
+
+		return err
+	}
+
+	if err = s.SaveCacheQuota(ctx, parent.Key, int(currentQuota)); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	return nil
+}
+
+// 解析子密钥模型, 须为父密钥可用模型的子集, 返回模型ID
+func (s *sAppKey) subKeyModels(ctx context.Context, parent *model.AppKey, names []string) ([]string, error) {
+
+	if len(names) == 0 {
+		return parent.Models, nil
+	}
+
+	allowed := parent.Models
+	if len(allowed) == 0 {
+
+		app, err := service.App().GetCache(ctx, parent.AppId)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed = app.Models
+	}
+
+	models := make([]*model.Model, 0)
+	if len(allowed) > 0 {
+
+		list, err := service.Model().GetCacheList(ctx, allowed...)
+		if err != nil {
+			return nil, err
+		}
+
+		models = list
+
+	} else {
+
+		results, err := dao.Model.Find(ctx, bson.M{"model": bson.M{"$in": names}, "status": 1})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			models = append(models, &model.Model{Id: result.Id, Model: result.Model, Status: result.Status})
+		}
+	}
+
+	ids := make([]string, 0)
+	for _, name := range names {
+
+		found := false
+		for _, m := range models {
+			if m.Model == name && m.Status == 1 {
+				ids = append(ids, m.Id)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, errors.NewErrorf(400, "model_not_found", "The model `%s` does not exist or you do not have access to it.", subKeyInvalidRequestErr, "models", name)
+		}
+	}
+
+	return ids, nil
+}
+
+// 子密钥速率限制, 各项不超过父密钥, 未设置时继承父密钥
+func subKeyRateLimit(parent, rateLimit *common.RateLimit) (*common.RateLimit, error) {
+
+	if rateLimit == nil {
+		return parent, nil
+	}
+
+	if rateLimit.Rpm < 0 || rateLimit.Tpm < 0 || rateLimit.Concurrency < 0 {
+		return nil, errors.NewError(400, subKeyInvalidRequestErr, "Invalid 'rate_limit': must not be negative.", subKeyInvalidRequestErr, "rate_limit")
+	}
+
+	if parent == nil {
+		return rateLimit, nil
+	}
+
+	limit := func(parent, value int, param string) (int, error) {
+
+		if parent == 0 {
+			return value, nil
+		}
+
+		if value == 0 {
+			return parent, nil
+		}
+
+		if value > parent {
+			return 0, errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'rate_limit.%s': must not exceed the parent key limit of %d.", subKeyInvalidRequestErr, "rate_limit."+param, param, parent)
+		}
+
+		return value, nil
+	}
+
+	var (
+		result = new(common.RateLimit)
+		err    error
+	)
+
+	if result.Rpm, err = limit(parent.Rpm, rateLimit.Rpm, "rpm"); err != nil {
+		return nil, err
+	}
+
+	if result.Tpm, err = limit(parent.Tpm, rateLimit.Tpm, "tpm"); err != nil {
+		return nil, err
+	}
+
+	if result.Concurrency, err = limit(parent.Concurrency, rateLimit.Concurrency, "concurrency"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func checkSubKeyMetadata(metadata map[string]string) error {
+
+	if len(metadata) > subKeyMaxMetadata {
+		return errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'metadata': must have at most %d keys.", subKeyInvalidRequestErr, "metadata", subKeyMaxMetadata)
+	}
+
+	for k, v := range metadata {
+		if k == "" || len(k) > subKeyMaxMetadataKey || len(v) > subKeyMaxMetadataValue {
+			return errors.NewErrorf(400, subKeyInvalidRequestErr, "Invalid 'metadata': keys must be 1-%d characters and values at most %d characters.", subKeyInvalidRequestErr, "metadata", subKeyMaxMetadataKey, subKeyMaxMetadataValue)
+		}
+	}
+
+	return nil
+}
+
+func (s *sAppKey) subKey(ctx context.Context, key *entity.AppKey) *model.SubKey {
+
+	subKey := &model.SubKey{
+		Object:       subKeyObject,
+		Id:           key.Id,
+		MaskedKey:    util.MaskKey(key.Key),
+		Models:       make([]string, 0),
+		IsLimitQuota: key.IsLimitQuota,
+		UsedQuota:    key.UsedQuota,
+		RateLimit:    key.RateLimit,
+		IpWhitelist:  key.IpWhitelist,
+		Metadata:     key.Metadata,
+		Remark:       key.Remark,
+		Status:       subKeyStatusActive,
+		ExpiresAt:    key.ExpiresAt / 1000,
+		CreatedAt:    key.CreatedAt / 1000,
+	}
+
+	if key.IsLimitQuota {
+		subKey.RemainingQuota = key.Quota
+	}
+
+	if key.Status != 1 {
+		subKey.Status = subKeyStatusRevoked
+	} else if key.ExpiresAt != 0 && key.ExpiresAt < gtime.TimestampMilli() {
+		subKey.Status = subKeyStatusExpired
+	}
+
+	if len(key.Models) > 0 {
+		if models, err := service.Model().GetCacheList(ctx, key.Models...); err != nil {
+			logger.Error(ctx, err)
+		} else {
+			names := gset.NewStrSet()
+			for _, m := range models {
+				if names.AddIfNotExist(m.Model) {
+					subKey.Models = append(subKey.Models, m.Model)
+				}
+			}
+		}
+	}
+
+	return subKey
+}
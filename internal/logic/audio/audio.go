@@ -2,12 +2,9 @@ package audio
 
 import (
 	"context"
-	"slices"
 
-	"github.com/gogf/gf/v2/frame/g"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
 	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
 	v1 "github.com/iimeta/fastapi/v2/api/audio/v1"
@@ -44,127 +41,34 @@ func (s *sAudio) Speech(ctx context.Context, data []byte, fallbackModelAgent *mo
 		return response, err
 	}
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_AUDIO_SPEECH,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					AudioInput:   params.Input,
-					Action:       consts.ACTION_SPEECH,
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.SpeechResponse]{
+		Name: "sAudio Speech",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_AUDIO_SPEECH,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := params
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sAudio Speech request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				break
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.SpeechResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "AudioSpeech")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).AudioSpeech(upstreamCtx, data)
+			common.EndSpan(upstreamSpan, err)
+
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.SpeechResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				AudioInput: params.Input,
+				Action:     consts.ACTION_SPEECH,
+				TotalTime:  res.TotalTime,
 			}
-		}
+		},
 	}
 
-	response, err = common.NewAdapter(ctx, mak, false).AudioSpeech(ctx, data)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Speech(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Speech(g.RequestFromCtx(ctx).GetCtx(), data, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
-			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.Speech(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
-	}
-
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // Transcriptions
@@ -175,134 +79,64 @@ func (s *sAudio) Transcriptions(ctx context.Context, params *v1.TranscriptionsRe
 		logger.Debugf(ctx, "sAudio Transcriptions time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_AUDIO_TRANSCRIPTIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					AudioText:    response.Text,
-					Action:       consts.ACTION_TRANSCRIPTIONS,
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				if response.Duration != 0 {
-					afterHandler.AudioMinute = util.Round(response.Duration/60, 2)
-				} else {
-					afterHandler.AudioMinute = util.Round(params.Duration/60, 2)
-				}
+	return s.audioPipeline("sAudio Transcriptions", consts.ENDPOINT_AUDIO_TRANSCRIPTIONS, consts.ACTION_TRANSCRIPTIONS, params.AudioRequest, params.Duration).
+		Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
+}
 
-				common.AfterHandler(ctx, mak, afterHandler)
+// Translations
+func (s *sAudio) Translations(ctx context.Context, params *v1.TranslationsReq, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (response smodel.AudioResponse, err error) {
 
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAudio Translations time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := *params
+	return s.audioPipeline("sAudio Translations", consts.ENDPOINT_AUDIO_TRANSLATIONS, consts.ACTION_TRANSLATIONS, params.AudioRequest, params.Duration).
+		Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
+}
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sAudio Transcriptions request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
+// 转录和翻译的请求和响应格式相同, 仅上游路径不同
+func (s *sAudio) audioPipeline(name, endpoint, action string, params smodel.AudioRequest, duration float64) *common.Pipeline[smodel.AudioResponse] {
+	return &common.Pipeline[smodel.AudioResponse]{
+		Name: name,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           endpoint,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).AudioTranscriptions(ctx, request.AudioRequest)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.AudioResponse, err error) {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			request := params
+			request.Model = attempt.ReplaceModel(ctx, request.Model)
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			if endpoint != consts.ENDPOINT_AUDIO_TRANSCRIPTIONS {
+				attempt.Mak.SetUpstreamPath(endpoint)
 			}
-		}
 
-		if isRetry {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "Audio"+gstr.UcFirst(action))
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).AudioTranscriptions(upstreamCtx, request)
+			common.EndSpan(upstreamSpan, err)
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.AudioResponse, err error) *mcommon.AfterHandler {
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Transcriptions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Transcriptions(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
+			after := &mcommon.AfterHandler{
+				AudioText: res.Text,
+				Action:    action,
+				TotalTime: res.TotalTime,
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			if res.Duration != 0 {
+				after.AudioMinute = util.Round(res.Duration/60, 2)
+			} else {
+				after.AudioMinute = util.Round(duration/60, 2)
 			}
 
-			return s.Transcriptions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+			return after
+		},
 	}
-
-	return response, nil
 }
@@ -2,13 +2,17 @@ package auth
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi/v2/internal/errors"
 	"github.com/iimeta/fastapi/v2/internal/logic/common"
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type sAuth struct{}
@@ -22,19 +26,25 @@ func New() service.IAuth {
 }
 
 // 身份核验
-func (s *sAuth) Authenticator(ctx context.Context, secretKey string) error {
+func (s *sAuth) Authenticator(ctx context.Context, secretKey string) (err error) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(g.RequestFromCtx(ctx).GetCtx(), "sAuth Authenticator time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err := service.Session().Save(ctx, secretKey); err != nil {
+	ctx, span := gtrace.NewSpan(ctx, "sAuth Authenticator")
+	defer func() {
+		common.EndSpan(span, err)
+	}()
+
+	if err = service.Session().Save(ctx, secretKey); err != nil {
 		logger.Error(ctx, err)
 		return err
 	}
 
-	if err := s.VerifySecretKey(g.RequestFromCtx(ctx).GetCtx(), secretKey); err != nil {
+	// 会话数据保存在请求上下文中, 需重新关联当前链路
+	if err = s.VerifySecretKey(trace.ContextWithSpan(g.RequestFromCtx(ctx).GetCtx(), span), secretKey); err != nil {
 		logger.Error(g.RequestFromCtx(ctx).GetCtx(), err)
 		return err
 	}
@@ -51,7 +61,8 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 	}()
 
 	path := g.RequestFromCtx(ctx).URL.Path
-	modelsPath := "/v1/models"
+	// 模型列表和模型详情不校验额度和限流
+	isModelsPath := path == "/v1/models" || (g.RequestFromCtx(ctx).Method == http.MethodGet && gstr.HasPrefix(path, "/v1/models/"))
 
 	key, err := service.AppKey().GetCache(ctx, secretKey)
 	if err != nil || key == nil {
@@ -78,13 +89,19 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		return err
 	}
 
+	if key.ExpiresAt != 0 && key.ExpiresAt < gtime.TimestampMilli() {
+		err = errors.ERR_API_KEY_EXPIRED
+		logger.Error(ctx, err)
+		return err
+	}
+
 	if err = common.CheckIp(ctx, key.IpWhitelist, key.IpBlacklist); err != nil {
 		logger.Errorf(ctx, "sAuth Key CheckIp ClientIp: %s, RemoteIp: %s, error: %v", g.RequestFromCtx(ctx).GetClientIp(), g.RequestFromCtx(ctx).GetRemoteIp(), err)
 		return err
 	}
 
 	if key.IsLimitQuota {
-		if path != modelsPath && service.AppKey().GetCacheQuota(ctx, key.Key) <= 0 {
+		if !isModelsPath && service.AppKey().GetCacheQuota(ctx, key.Key) <= 0 {
 			err = errors.ERR_INSUFFICIENT_QUOTA
 			logger.Error(ctx, err)
 			return err
@@ -122,7 +139,7 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		return err
 	}
 
-	if path != modelsPath && service.User().GetCacheQuota(ctx, user.UserId) <= 0 {
+	if !isModelsPath && service.User().GetCacheQuota(ctx, user.UserId) <= 0 {
 		err = errors.ERR_INSUFFICIENT_QUOTA
 		logger.Error(ctx, err)
 		return err
@@ -209,7 +226,7 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 	}
 
 	if app.IsLimitQuota {
-		if path != modelsPath && service.App().GetCacheQuota(ctx, app.AppId) <= 0 {
+		if !isModelsPath && service.App().GetCacheQuota(ctx, app.AppId) <= 0 {
 			err = errors.ERR_INSUFFICIENT_QUOTA
 			logger.Error(ctx, err)
 			return err
@@ -222,6 +239,13 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		}
 	}
 
+	if !isModelsPath {
+		if err = service.RateLimit().Check(ctx, key, app, user); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+	}
+
 	service.Session().SaveUser(ctx, user)
 	service.Session().SaveIsLimitQuota(ctx, app.IsLimitQuota, key.IsLimitQuota)
 
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"slices"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
@@ -24,6 +23,7 @@ import (
 	"github.com/iimeta/fastapi/v2/internal/logic/common"
 	"github.com/iimeta/fastapi/v2/internal/model"
 	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/db"
 	"github.com/iimeta/fastapi/v2/utility/logger"
@@ -51,128 +51,64 @@ func (s *sBatch) Create(ctx context.Context, params *v1.CreateReq, fallbackModel
 	}()
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
+		inputFile *entity.TaskFile
+		isNative  bool
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					Action:       consts.ACTION_CREATE,
-					IsBatch:      true,
-					BatchId:      response.Id,
-					FileId:       params.InputFileId,
-					RequestData:  util.ConvToMap(params.BatchCreateRequest),
-					ResponseData: util.ConvToMap(response.ResponseBytes),
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.BatchResponse]{
+		Name: "sBatch Create",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
-
-	if mak.Model, err = getFileModel(ctx, params.InputFileId); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	if mak.Model == "" {
-		mak.Model = params.Provider + "-batches"
-	}
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
+		},
+		Route: func(ctx context.Context, attempt *common.Attempt) (err error) {
 
-	response, err = common.NewAdapter(ctx, mak, false).BatchCreate(ctx, params.BatchCreateRequest)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			if inputFile, err = getInputFile(ctx, params.InputFileId); err != nil {
+				return err
+			}
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			// 按输入文件的模型路由
+			if attempt.Mak.Model, err = getFileModel(ctx, inputFile); err != nil {
+				return err
 			}
-		}
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
+			if attempt.Mak.Model == "" {
+				attempt.Mak.Model = params.Provider + "-batches"
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return attempt.Mak.InitMAK(ctx)
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.BatchResponse, err error) {
+
+			// 网关批处理
+			if isNative = inputFile.IsNative || common.IsNativeBatch(ctx, attempt.Mak); isNative {
+				return createNative(ctx, attempt.Mak, params, inputFile)
 			}
 
-			return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "BatchCreate")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).BatchCreate(upstreamCtx, params.BatchCreateRequest)
+			common.EndSpan(upstreamSpan, err)
 
-		return response, err
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.BatchResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				Action:        consts.ACTION_CREATE,
+				IsBatch:       true,
+				IsNativeBatch: isNative,
+				BatchId:       res.Id,
+				FileId:        params.InputFileId,
+				RequestData:   util.ConvToMap(params.BatchCreateRequest),
+				ResponseData:  util.ConvToMap(res.ResponseBytes),
+				TotalTime:     res.TotalTime,
+			}
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // List
@@ -416,6 +352,11 @@ func (s *sBatch) Cancel(ctx context.Context, params *v1.CancelReq) (response smo
 		return response, err
 	}
 
+	// 网关批处理
+	if taskBatch.IsNative {
+		return cancelNative(ctx, taskBatch)
+	}
+
 	logBatch, err := dao.LogBatch.FindOne(ctx, bson.M{"trace_id": taskBatch.TraceId, "status": 1})
 	if err != nil {
 		logger.Error(ctx, err)
@@ -444,7 +385,7 @@ func (s *sBatch) Cancel(ctx context.Context, params *v1.CancelReq) (response smo
 	return response, nil
 }
 
-func getFileModel(ctx context.Context, fileId string) (string, error) {
+func getInputFile(ctx context.Context, fileId string) (*entity.TaskFile, error) {
 
 	taskFile, err := dao.TaskFile.FindOne(ctx, bson.M{"file_id": fileId, "creator": service.Session().GetSecretKey(ctx)})
 	if err != nil {
@@ -452,12 +393,17 @@ func getFileModel(ctx context.Context, fileId string) (string, error) {
 			err = errors.NewError(404, "invalid_request_error", "No such File object: "+fileId, "invalid_request_error", "id")
 		}
 		logger.Error(ctx, err)
-		return "", err
+		return nil, err
 	}
 
+	return taskFile, nil
+}
+
+func getFileModel(ctx context.Context, taskFile *entity.TaskFile) (string, error) {
+
 	var data []byte
 
-	if config.Cfg.FileTask.IsEnableStorage && taskFile.FilePath != "" {
+	if (config.Cfg.FileTask.IsEnableStorage || taskFile.IsNative) && taskFile.FilePath != "" {
 		if bytes := gfile.GetBytes(taskFile.FilePath); bytes != nil {
 			data = bytes
 		}
@@ -0,0 +1,127 @@
+package batch
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	v1 "github.com/iimeta/fastapi/v2/api/batch/v1"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model/do"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// 创建网关批处理任务, 由批处理任务异步执行
+func createNative(ctx context.Context, mak *common.MAK, params *v1.CreateReq, inputFile *entity.TaskFile) (response smodel.BatchResponse, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "createNative time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	request := util.ConvToMap(params.BatchCreateRequest)
+	endpoint := gconv.String(request["endpoint"])
+
+	if !slices.Contains(batchEndpoints, endpoint) {
+		return response, errors.NewError(400, "invalid_value", "Invalid value for 'endpoint': "+endpoint+" is not supported.", "invalid_request_error", "endpoint")
+	}
+
+	if !inputFile.IsNative {
+		return response, errors.NewError(400, "invalid_request_error", "File '"+inputFile.FileId+"' was not uploaded for gateway batch processing.", "invalid_request_error", "input_file_id")
+	}
+
+	completionWindow := gconv.String(request["completion_window"])
+	if completionWindow == "" {
+		completionWindow = "24h"
+	}
+
+	priority := "normal"
+	if metadata := gconv.Map(request["metadata"]); gconv.String(metadata["priority"]) == "low" {
+		priority = "low"
+	}
+
+	taskBatch := &entity.TaskBatch{
+		BatchId:      "batch_" + grand.S(24),
+		InputFileId:  inputFile.FileId,
+		Status:       "validating",
+		ExpiresAt:    time.Now().Add(24 * time.Hour).UnixMilli(),
+		Endpoint:     endpoint,
+		CreatedAt:    now,
+		ResponseData: map[string]any{"completion_window": completionWindow, "metadata": request["metadata"]},
+	}
+
+	responseData := batchObject(taskBatch)
+
+	if _, err = dao.TaskBatch.Insert(ctx, &do.TaskBatch{
+		TraceId:      gtrace.GetTraceID(ctx),
+		UserId:       service.Session().GetUserId(ctx),
+		AppId:        service.Session().GetAppId(ctx),
+		Model:        mak.ReqModel.Name,
+		BatchId:      taskBatch.BatchId,
+		InputFileId:  taskBatch.InputFileId,
+		Status:       taskBatch.Status,
+		ExpiresAt:    taskBatch.ExpiresAt,
+		ResponseData: responseData,
+		IsNative:     true,
+		Endpoint:     endpoint,
+		Priority:     priority,
+		Rid:          service.Session().GetRid(ctx),
+	}); err != nil {
+		logger.Error(ctx, err)
+		return response, err
+	}
+
+	response = smodel.BatchResponse{
+		Id:            taskBatch.BatchId,
+		ResponseBytes: gjson.MustEncode(responseData),
+		TotalTime:     gtime.TimestampMilli() - now,
+	}
+
+	return response, nil
+}
+
+// 取消网关批处理任务, 由批处理任务在当前批次完成后停止
+func cancelNative(ctx context.Context, taskBatch *entity.TaskBatch) (response smodel.BatchResponse, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "cancelNative time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if !slices.Contains([]string{"validating", "in_progress"}, taskBatch.Status) {
+		return response, errors.NewError(409, "invalid_request_error", "Cannot cancel a batch with status '"+taskBatch.Status+"'.", "invalid_request_error", nil)
+	}
+
+	taskBatch.Status = "cancelling"
+	taskBatch.CancellingAt = now
+	taskBatch.ResponseData = batchObject(taskBatch)
+
+	if err = dao.TaskBatch.UpdateOne(ctx, bson.M{"_id": taskBatch.Id, "status": bson.M{"$in": []string{"validating", "in_progress"}}}, bson.M{
+		"status":        taskBatch.Status,
+		"cancelling_at": taskBatch.CancellingAt,
+		"response_data": taskBatch.ResponseData,
+	}); err != nil {
+		logger.Error(ctx, err)
+		return response, err
+	}
+
+	response = smodel.BatchResponse{
+		Id:            taskBatch.BatchId,
+		ResponseBytes: gjson.MustEncode(taskBatch.ResponseData),
+		TotalTime:     gtime.TimestampMilli() - now,
+	}
+
+	return response, nil
+}
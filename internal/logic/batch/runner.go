@@ -0,0 +1,659 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/os/gtimer"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model/do"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// 每批处理行数, 每批处理完成后记录进度
+const batchChunkSize = 50
+
+// 网关批处理支持的端点
+var batchEndpoints = []string{"/v1/chat/completions", "/v1/embeddings"}
+
+// 节点并发通道[优先级]
+var (
+	lanes   = make(map[string]*lane)
+	lanesMu sync.Mutex
+)
+
+type lane struct {
+	size int
+	sem  chan struct{}
+}
+
+type batchRequest struct {
+	CustomId string         `json:"custom_id"`
+	Method   string         `json:"method"`
+	Url      string         `json:"url"`
+	Body     map[string]any `json:"body"`
+}
+
+type batchResult struct {
+	data    []byte
+	isError bool
+}
+
+func init() {
+	_ = gtimer.AddSingleton(gctx.New(), 10*time.Second, func(ctx context.Context) {
+		runBatchTasks(gctx.New())
+	})
+}
+
+// 执行网关批处理任务
+func runBatchTasks(ctx context.Context) {
+
+	if config.Cfg.BatchTask == nil || !config.Cfg.BatchTask.Open {
+		return
+	}
+
+	results, err := dao.TaskBatch.Find(ctx, bson.M{
+		"is_native": true,
+		"status":    bson.M{"$in": []string{"validating", "in_progress", "finalizing", "cancelling"}},
+	}, &dao.FindOptions{SortFields: []string{"created_at"}})
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	for _, result := range results {
+
+		if !lockBatchTask(ctx, result.BatchId) {
+			continue
+		}
+
+		taskBatch := result
+
+		if err := grpool.AddWithRecover(ctx, func(ctx context.Context) {
+			defer unlockBatchTask(ctx, taskBatch.BatchId)
+			runBatchTask(ctx, taskBatch)
+		}, func(ctx context.Context, exception error) {
+			logger.Errorf(ctx, "runBatchTasks batchId: %s, panic: %v", taskBatch.BatchId, exception)
+		}); err != nil {
+			logger.Error(ctx, err)
+			unlockBatchTask(ctx, taskBatch.BatchId)
+		}
+	}
+}
+
+func runBatchTask(ctx context.Context, taskBatch *entity.TaskBatch) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "runBatchTask batchId: %s, status: %s, time: %d", taskBatch.BatchId, taskBatch.Status, gtime.TimestampMilli()-now)
+	}()
+
+	lines, err := readBatchInput(ctx, taskBatch)
+	if err != nil {
+		failBatchTask(ctx, taskBatch, []g.Map{{"code": "file_not_found", "message": err.Error(), "param": "input_file_id", "line": nil}})
+		return
+	}
+
+	if taskBatch.Status == "validating" {
+
+		if errs := validateBatchInput(taskBatch, lines); len(errs) > 0 {
+			failBatchTask(ctx, taskBatch, errs)
+			return
+		}
+
+		taskBatch.Status = "in_progress"
+		taskBatch.InProgressAt = gtime.TimestampMilli()
+		taskBatch.Total = len(lines)
+
+		if err = updateBatchTask(ctx, taskBatch); err != nil {
+			return
+		}
+	}
+
+	if taskBatch.Status == "in_progress" {
+		if err = processBatchTask(ctx, taskBatch, lines); err != nil {
+			// 下次调度时从已记录的进度继续
+			logger.Error(ctx, err)
+			return
+		}
+	}
+
+	finalizeBatchTask(ctx, taskBatch)
+}
+
+// 读取输入文件, 忽略空行
+func readBatchInput(ctx context.Context, taskBatch *entity.TaskBatch) ([][]byte, error) {
+
+	taskFile, err := dao.TaskFile.FindOne(ctx, bson.M{"file_id": taskBatch.InputFileId, "creator": taskBatch.Creator})
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if taskFile.FilePath == "" || !gfile.Exists(taskFile.FilePath) {
+		err = errors.Newf("input file %s not found", taskBatch.InputFileId)
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	lines := make([][]byte, 0)
+	for _, line := range bytes.Split(gfile.GetBytes(taskFile.FilePath), []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// 验证输入文件, 返回OpenAI格式的错误列表
+func validateBatchInput(taskBatch *entity.TaskBatch, lines [][]byte) []g.Map {
+
+	errs := make([]g.Map, 0)
+
+	if len(lines) == 0 {
+		return append(errs, g.Map{"code": "empty_file", "message": "The input file is empty.", "param": nil, "line": nil})
+	}
+
+	customIds := make(map[string]bool, len(lines))
+
+	for i, line := range lines {
+
+		request := batchRequest{}
+		if err := json.Unmarshal(line, &request); err != nil {
+			errs = append(errs, g.Map{"code": "invalid_json_line", "message": "This line is not parseable as valid JSON.", "param": nil, "line": i + 1})
+			continue
+		}
+
+		if request.CustomId == "" {
+			errs = append(errs, g.Map{"code": "missing_required_parameter", "message": "Missing required parameter: 'custom_id'.", "param": "custom_id", "line": i + 1})
+		} else if customIds[request.CustomId] {
+			errs = append(errs, g.Map{"code": "duplicate_custom_id", "message": "The custom_id for this request is a duplicate of another request.", "param": "custom_id", "line": i + 1})
+		}
+
+		customIds[request.CustomId] = true
+
+		if request.Method != "POST" {
+			errs = append(errs, g.Map{"code": "invalid_value", "message": "Invalid value for 'method': only POST is supported.", "param": "method", "line": i + 1})
+		}
+
+		if !slices.Contains(batchEndpoints, request.Url) {
+			errs = append(errs, g.Map{"code": "invalid_url", "message": fmt.Sprintf("Invalid value for 'url': %s is not supported.", request.Url), "param": "url", "line": i + 1})
+		} else if taskBatch.Endpoint != "" && request.Url != taskBatch.Endpoint {
+			errs = append(errs, g.Map{"code": "mismatched_url", "message": "The provided URL does not match the batch endpoint.", "param": "url", "line": i + 1})
+		}
+
+		if request.Body == nil {
+			errs = append(errs, g.Map{"code": "missing_required_parameter", "message": "Missing required parameter: 'body'.", "param": "body", "line": i + 1})
+		}
+	}
+
+	return errs
+}
+
+// 分批执行请求, 每批完成后记录进度, 遇到取消或过期时停止
+func processBatchTask(ctx context.Context, taskBatch *entity.TaskBatch, lines [][]byte) error {
+
+	outputPath := batchFilePath(taskBatch.BatchId, "output")
+	errorPath := batchFilePath(taskBatch.BatchId, "error")
+
+	// 丢弃上次中断时未记录进度的数据
+	if err := truncateBatchFile(outputPath, taskBatch.OutputBytes); err != nil {
+		return err
+	}
+
+	if err := truncateBatchFile(errorPath, taskBatch.ErrorBytes); err != nil {
+		return err
+	}
+
+	for taskBatch.Offset < len(lines) {
+
+		current, err := dao.TaskBatch.FindById(ctx, taskBatch.Id)
+		if err != nil {
+			return err
+		}
+
+		if current.Status == "cancelling" {
+			taskBatch.Status = current.Status
+			taskBatch.CancellingAt = current.CancellingAt
+			return nil
+		}
+
+		if taskBatch.ExpiresAt > 0 && gtime.TimestampMilli() > taskBatch.ExpiresAt {
+			taskBatch.Status = "expired"
+			return nil
+		}
+
+		end := min(taskBatch.Offset+batchChunkSize, len(lines))
+		results := executeBatchChunk(ctx, taskBatch, lines[taskBatch.Offset:end])
+
+		// 按行顺序写入
+		var output, errorOutput bytes.Buffer
+		for _, result := range results {
+			if result.isError {
+				errorOutput.Write(result.data)
+				errorOutput.WriteByte('\n')
+				taskBatch.Failed++
+			} else {
+				output.Write(result.data)
+				output.WriteByte('\n')
+				taskBatch.Completed++
+			}
+		}
+
+		if output.Len() > 0 {
+			if err = gfile.PutBytesAppend(outputPath, output.Bytes()); err != nil {
+				return err
+			}
+			taskBatch.OutputBytes += int64(output.Len())
+		}
+
+		if errorOutput.Len() > 0 {
+			if err = gfile.PutBytesAppend(errorPath, errorOutput.Bytes()); err != nil {
+				return err
+			}
+			taskBatch.ErrorBytes += int64(errorOutput.Len())
+		}
+
+		taskBatch.Offset = end
+
+		if err = updateBatchTask(ctx, taskBatch); err != nil {
+			return err
+		}
+
+		if _, err = redis.Expire(ctx, fmt.Sprintf(consts.LOCK_BATCH_TASK_KEY, taskBatch.BatchId), lockSeconds()); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	taskBatch.Status = "finalizing"
+	taskBatch.FinalizingAt = gtime.TimestampMilli()
+
+	return updateBatchTask(ctx, taskBatch)
+}
+
+// 按优先级通道并发执行一批请求, 结果与输入行顺序一致
+func executeBatchChunk(ctx context.Context, taskBatch *entity.TaskBatch, lines [][]byte) []batchResult {
+
+	var (
+		results = make([]batchResult, len(lines))
+		sem     = getLane(taskBatch.Priority)
+		wg      sync.WaitGroup
+	)
+
+	for i, line := range lines {
+
+		sem <- struct{}{}
+		wg.Add(1)
+
+		if err := grpool.AddWithRecover(ctx, func(ctx context.Context) {
+			results[i] = executeBatchRequest(ctx, taskBatch, line)
+			<-sem
+			wg.Done()
+		}, func(ctx context.Context, exception error) {
+			logger.Errorf(ctx, "executeBatchChunk batchId: %s, panic: %v", taskBatch.BatchId, exception)
+			results[i] = batchErrorResult(line, "internal_error", exception.Error())
+			<-sem
+			wg.Done()
+		}); err != nil {
+			logger.Error(ctx, err)
+			results[i] = batchErrorResult(line, "internal_error", err.Error())
+			<-sem
+			wg.Done()
+		}
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// 通过本节点执行单个请求, 与普通请求一样经过路由、重试和计费
+func executeBatchRequest(ctx context.Context, taskBatch *entity.TaskBatch, line []byte) batchResult {
+
+	request := batchRequest{}
+	if err := json.Unmarshal(line, &request); err != nil {
+		return batchErrorResult(line, "invalid_json_line", err.Error())
+	}
+
+	delete(request.Body, "stream")
+	delete(request.Body, "stream_options")
+
+	// 批处理任务随上下文传入, 由中间件按创建者密钥鉴权, 不经过网络和请求头
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, consts.BATCH_TASK_KEY, taskBatch), config.Cfg.Base.LongTimeout*time.Second)
+	defer cancel()
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Url, bytes.NewReader(gjson.MustEncode(request.Body)))
+	if err != nil {
+		logger.Error(ctx, err)
+		return batchErrorResult(line, "request_failed", err.Error())
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.RemoteAddr = "127.0.0.1:0"
+
+	response := httptest.NewRecorder()
+
+	// 在本节点内经过中间件、控制器、服务和管道处理
+	g.Server().ServeHTTP(response, httpRequest)
+
+	body := response.Body.Bytes()
+
+	var responseBody any = json.RawMessage(body)
+	if !json.Valid(body) {
+		responseBody = string(body)
+	}
+
+	data := gjson.MustEncode(g.Map{
+		"id":        "batch_req_" + grand.S(24),
+		"custom_id": request.CustomId,
+		"response": g.Map{
+			"status_code": response.Code,
+			"request_id":  response.Header().Get(consts.TRACE_ID),
+			"body":        responseBody,
+		},
+		"error": nil,
+	})
+
+	return batchResult{data: data, isError: response.Code != http.StatusOK}
+}
+
+func batchErrorResult(line []byte, code, message string) batchResult {
+
+	request := batchRequest{}
+	_ = json.Unmarshal(line, &request)
+
+	return batchResult{
+		data: gjson.MustEncode(g.Map{
+			"id":        "batch_req_" + grand.S(24),
+			"custom_id": request.CustomId,
+			"response":  nil,
+			"error":     g.Map{"code": code, "message": message},
+		}),
+		isError: true,
+	}
+}
+
+// 生成输出文件和错误文件, 并设置最终状态
+func finalizeBatchTask(ctx context.Context, taskBatch *entity.TaskBatch) {
+
+	if taskBatch.OutputFileId == "" && taskBatch.OutputBytes > 0 {
+		taskBatch.OutputFileId = createBatchFile(ctx, taskBatch, "output", taskBatch.OutputBytes)
+	}
+
+	if taskBatch.ErrorFileId == "" && taskBatch.ErrorBytes > 0 {
+		taskBatch.ErrorFileId = createBatchFile(ctx, taskBatch, "error", taskBatch.ErrorBytes)
+	}
+
+	now := gtime.TimestampMilli()
+
+	switch taskBatch.Status {
+	case "finalizing":
+		taskBatch.Status = "completed"
+		taskBatch.CompletedAt = now
+	case "cancelling":
+		taskBatch.Status = "cancelled"
+		taskBatch.CancelledAt = now
+	case "expired":
+		taskBatch.Error = map[string]any{"expired_at": now}
+	default:
+		return
+	}
+
+	_ = updateBatchTask(ctx, taskBatch)
+}
+
+func createBatchFile(ctx context.Context, taskBatch *entity.TaskBatch, kind string, size int64) string {
+
+	fileId := "file-" + grand.S(24)
+
+	if _, err := dao.TaskFile.Insert(ctx, &do.TaskFile{
+		TraceId:      taskBatch.TraceId,
+		UserId:       taskBatch.UserId,
+		AppId:        taskBatch.AppId,
+		Model:        taskBatch.Model,
+		Purpose:      "batch_output",
+		FileId:       fileId,
+		FileName:     fmt.Sprintf("%s_%s.jsonl", taskBatch.BatchId, kind),
+		Bytes:        int(size),
+		Status:       "processed",
+		FilePath:     batchFilePath(taskBatch.BatchId, kind),
+		BatchTraceId: taskBatch.TraceId,
+		IsNative:     true,
+		Rid:          taskBatch.Rid,
+		Creator:      taskBatch.Creator,
+		Updater:      taskBatch.Creator,
+	}); err != nil {
+		logger.Error(ctx, err)
+		return ""
+	}
+
+	return fileId
+}
+
+func failBatchTask(ctx context.Context, taskBatch *entity.TaskBatch, errs []g.Map) {
+	taskBatch.Status = "failed"
+	taskBatch.FailedAt = gtime.TimestampMilli()
+	taskBatch.Error = map[string]any{"object": "list", "data": errs}
+	_ = updateBatchTask(ctx, taskBatch)
+}
+
+// 更新任务进度, 同时更新OpenAI格式的响应数据
+func updateBatchTask(ctx context.Context, taskBatch *entity.TaskBatch) error {
+
+	taskBatch.ResponseData = batchObject(taskBatch)
+
+	filter := bson.M{"_id": taskBatch.Id}
+
+	// 进度更新不覆盖接口写入的取消状态
+	if taskBatch.Status == "in_progress" || taskBatch.Status == "finalizing" {
+		filter["status"] = bson.M{"$ne": "cancelling"}
+	}
+
+	if err := dao.TaskBatch.UpdateOne(ctx, filter, bson.M{
+		"status":         taskBatch.Status,
+		"output_file_id": taskBatch.OutputFileId,
+		"error_file_id":  taskBatch.ErrorFileId,
+		"in_progress_at": taskBatch.InProgressAt,
+		"finalizing_at":  taskBatch.FinalizingAt,
+		"completed_at":   taskBatch.CompletedAt,
+		"cancelled_at":   taskBatch.CancelledAt,
+		"failed_at":      taskBatch.FailedAt,
+		"total":          taskBatch.Total,
+		"completed":      taskBatch.Completed,
+		"failed":         taskBatch.Failed,
+		"offset":         taskBatch.Offset,
+		"output_bytes":   taskBatch.OutputBytes,
+		"error_bytes":    taskBatch.ErrorBytes,
+		"error":          taskBatch.Error,
+		"response_data":  taskBatch.ResponseData,
+		"updater":        taskBatch.Creator,
+	}); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	return nil
+}
+
+// OpenAI格式的批处理对象
+func batchObject(taskBatch *entity.TaskBatch) map[string]any {
+
+	seconds := func(milli int64) any {
+		if milli == 0 {
+			return nil
+		}
+		return milli / 1000
+	}
+
+	object := g.Map{
+		"id":                taskBatch.BatchId,
+		"object":            "batch",
+		"endpoint":          taskBatch.Endpoint,
+		"errors":            nil,
+		"input_file_id":     taskBatch.InputFileId,
+		"completion_window": "24h",
+		"status":            taskBatch.Status,
+		"output_file_id":    nil,
+		"error_file_id":     nil,
+		"created_at":        seconds(taskBatch.CreatedAt),
+		"in_progress_at":    seconds(taskBatch.InProgressAt),
+		"expires_at":        seconds(taskBatch.ExpiresAt),
+		"finalizing_at":     seconds(taskBatch.FinalizingAt),
+		"completed_at":      seconds(taskBatch.CompletedAt),
+		"failed_at":         seconds(taskBatch.FailedAt),
+		"expired_at":        nil,
+		"cancelling_at":     seconds(taskBatch.CancellingAt),
+		"cancelled_at":      seconds(taskBatch.CancelledAt),
+		"request_counts": g.Map{
+			"total":     taskBatch.Total,
+			"completed": taskBatch.Completed,
+			"failed":    taskBatch.Failed,
+		},
+		"metadata": nil,
+	}
+
+	if taskBatch.ResponseData != nil {
+		object["metadata"] = taskBatch.ResponseData["metadata"]
+		if completionWindow, ok := taskBatch.ResponseData["completion_window"]; ok {
+			object["completion_window"] = completionWindow
+		}
+	}
+
+	if taskBatch.OutputFileId != "" {
+		object["output_file_id"] = taskBatch.OutputFileId
+	}
+
+	if taskBatch.ErrorFileId != "" {
+		object["error_file_id"] = taskBatch.ErrorFileId
+	}
+
+	if taskBatch.Status == "failed" && taskBatch.Error != nil {
+		object["errors"] = taskBatch.Error
+	}
+
+	if taskBatch.Status == "expired" && taskBatch.Error != nil {
+		object["expired_at"] = seconds(toInt64(taskBatch.Error["expired_at"]))
+	}
+
+	return object
+}
+
+func toInt64(value any) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func batchFilePath(batchId, kind string) string {
+	return gfile.Join(common.BatchStorageDir(), fmt.Sprintf("%s_%s.jsonl", batchId, kind))
+}
+
+// 截断到已记录的字节数
+func truncateBatchFile(path string, size int64) error {
+
+	if !gfile.Exists(path) {
+		if size > 0 {
+			return errors.Newf("batch file %s lost", path)
+		}
+		return nil
+	}
+
+	return os.Truncate(path, size)
+}
+
+// 节点级并发通道, 配置变化时重建
+func getLane(priority string) chan struct{} {
+
+	size := 10
+	if priority == "low" {
+		size = 2
+	}
+
+	if config.Cfg.BatchTask != nil {
+		if priority == "low" && config.Cfg.BatchTask.LowPriorityConcurrency > 0 {
+			size = config.Cfg.BatchTask.LowPriorityConcurrency
+		} else if priority != "low" && config.Cfg.BatchTask.Concurrency > 0 {
+			size = config.Cfg.BatchTask.Concurrency
+		}
+	}
+
+	lanesMu.Lock()
+	defer lanesMu.Unlock()
+
+	if l, ok := lanes[priority]; ok && l.size == size {
+		return l.sem
+	}
+
+	l := &lane{size: size, sem: make(chan struct{}, size)}
+	lanes[priority] = l
+
+	return l.sem
+}
+
+func lockSeconds() int64 {
+
+	if config.Cfg.BatchTask == nil || config.Cfg.BatchTask.LockMinutes <= 0 {
+		return 600
+	}
+
+	return int64((config.Cfg.BatchTask.LockMinutes * time.Minute).Seconds())
+}
+
+// 任务锁, 同一任务只由一个节点执行
+func lockBatchTask(ctx context.Context, batchId string) bool {
+
+	key := fmt.Sprintf(consts.LOCK_BATCH_TASK_KEY, batchId)
+
+	ok, err := redis.SetNX(ctx, key, gctx.CtxId(ctx))
+	if err != nil {
+		logger.Error(ctx, err)
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	if _, err = redis.Expire(ctx, key, lockSeconds()); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	return true
+}
+
+func unlockBatchTask(ctx context.Context, batchId string) {
+	if _, err := redis.Del(ctx, fmt.Sprintf(consts.LOCK_BATCH_TASK_KEY, batchId)); err != nil {
+		logger.Error(ctx, err)
+	}
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"context"
+
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+// 流式响应缓存
+type streamCache struct {
+	Chunks      []string      `json:"chunks"`                 // 已发送的数据块
+	Completion  string        `json:"completion,omitempty"`   // 补全内容
+	ServiceTier string        `json:"service_tier,omitempty"` // 服务层级
+	Usage       *smodel.Usage `json:"usage,omitempty"`        // 用量
+}
+
+// 回放流式响应缓存
+func (cache *streamCache) replay(ctx context.Context) error {
+
+	for _, chunk := range cache.Chunks {
+		if err := util.SSEServer(ctx, chunk); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+	}
+
+	if err := util.SSEServer(ctx, "[DONE]"); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	return nil
+}
@@ -7,11 +7,10 @@ import (
 	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
-	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
-	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
 	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
@@ -27,6 +26,39 @@ import (
 
 type sChat struct{}
 
+// 流式请求
+type completionsStream struct {
+	responseChan chan *smodel.ChatCompletionResponse
+	first        *smodel.ChatCompletionResponse // 首个数据块, 对冲请求以收到首个数据块为就绪
+	cancel       context.CancelFunc             // 取消上游请求, 流式超时时及时释放上游连接
+	span         *gtrace.Span                   // 上游链路, 流读取完毕时结束
+	isDone       bool                           // 已收到结束数据块(含错误), 上游不再发送
+}
+
+// 释放上游流, 取消上游请求并在后台读取剩余数据块至结束, 响应通道由上游持有, 不在此关闭
+func (s *completionsStream) release(ctx context.Context) {
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	if s.isDone {
+		return
+	}
+
+	s.isDone = true
+
+	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		for response := range s.responseChan {
+			if response == nil || response.Error != nil {
+				return
+			}
+		}
+	}, nil); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
 func init() {
 	service.RegisterChat(New())
 }
@@ -43,171 +75,97 @@ func (s *sChat) Completions(ctx context.Context, params smodel.ChatCompletionReq
 		logger.Debugf(ctx, "sChat Completions time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
+	var request smodel.ChatCompletionRequest
+
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name:        "sChat Completions",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
+				CacheRequest:       params,
 			}
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, done bool, err error) {
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: response,
-					Action:            consts.ACTION_COMPLETIONS,
-					Usage:             response.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          response.ConnTime,
-					Duration:          response.Duration,
-					TotalTime:         response.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := params
-
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		request.Model = mak.RealModel.Model
-	}
-
-	// 预设配置
-	if mak.RealModel.IsEnablePresetConfig {
-
-		// 替换预设提示词
-		if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-			if request.Messages[0].Role == sconsts.ROLE_SYSTEM {
-				request.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, request.Messages[1:]...)
-			} else {
-				request.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, request.Messages...)
+			// 命中响应缓存
+			if !attempt.Mak.IsCacheHit() {
+				return response, false, nil
 			}
-		}
 
-		// 检查MaxTokens取值范围
-		if request.MaxTokens != 0 {
-			if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-				request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-			} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-				request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
+			if err = attempt.Mak.ScanResponseCache(&response); err != nil {
+				logger.Error(ctx, err)
+				return response, true, err
 			}
-		}
-	}
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sChat Completions request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
+			response.ConnTime = 0
+			response.Duration = 0
+			response.TotalTime = 0
+
+			return response, true, nil
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+			// 护栏脱敏后的消息
+			params.Messages = attempt.Mak.Messages
+			request = presetRequest(attempt.Mak, params)
+			request.Model = attempt.UpstreamModel(ctx, request.Model)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
+
+			if common.IsHedge(attempt.Mak) {
+				result := s.hedgeCompletions(ctx, attempt.Mak, params, request)
+				attempt.Defer(result.Cancel)
+				attempt.Mak = result.Mak
+				return result.Result, result.Err
 			}
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).ChatCompletions(ctx, request)
-	if err != nil {
-		logger.Error(ctx, err)
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, request)
+			common.EndSpan(upstreamSpan, err)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ChatCompletionResponse) (smodel.ChatCompletionResponse, error) {
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			// 根据上游响应头记录密钥限流状态
+			service.ModelAgent().RecordUpstreamHeaders(ctx, attempt.Mak.ModelAgent, attempt.Mak.Key, response.ResponseHeaders)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			// 护栏检查输出, 写入响应缓存前检查
+			if err := attempt.GuardCompletion(ctx, &response); err != nil {
+				return response, err
 			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
+			// 写入响应缓存
+			attempt.Mak.SaveResponseCache(ctx, response)
 
-					return response, err
-				}
+			return response, nil
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *smodel.ChatCompletionResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
 			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				ChatCompletionRes: res,
+				Action:            consts.ACTION_COMPLETIONS,
+				Usage:             res.Usage,
+				ConnTime:          res.ConnTime,
+				Duration:          res.Duration,
+				TotalTime:         res.TotalTime,
 			}
-
-			return s.Completions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // CompletionsStream
@@ -219,299 +177,345 @@ func (s *sChat) CompletionsStream(ctx context.Context, params smodel.ChatComplet
 	}()
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
+		request     smodel.ChatCompletionRequest
 		completion  string
 		serviceTier string
 		connTime    int64
 		duration    int64
 		totalTime   int64
 		usage       *smodel.Usage
-		retryInfo   *mcommon.Retry
+		chunks      []string
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					Completion:        completion,
-					ServiceTier:       serviceTier,
-					Action:            consts.ACTION_COMPLETIONS,
-					Usage:             usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[*completionsStream]{
+		Name:        "sChat CompletionsStream",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
+				CacheRequest:       params,
 			}
-		}
-	}()
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) (*completionsStream, bool, error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			// 命中响应缓存
+			if !attempt.Mak.IsCacheHit() {
+				return nil, false, nil
+			}
 
-	request := params
+			cache := new(streamCache)
+			if err := attempt.Mak.ScanResponseCache(cache); err != nil {
+				logger.Error(ctx, err)
+				return nil, true, err
+			}
 
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		request.Model = mak.RealModel.Model
-	}
+			completion, serviceTier, usage = cache.Completion, cache.ServiceTier, cache.Usage
+
+			return nil, true, cache.replay(ctx)
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+			// 护栏脱敏后的消息
+			params.Messages = attempt.Mak.Messages
+			request = presetRequest(attempt.Mak, params)
+			request.Model = attempt.UpstreamModel(ctx, request.Model)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (*completionsStream, error) {
+
+			if common.IsHedge(attempt.Mak) {
+
+				result := s.hedgeCompletionsStream(ctx, attempt.Mak, params, request)
+				attempt.Defer(result.Cancel)
+				attempt.Mak = result.Mak
+
+				// 已收到首个数据块, 其中的错误由响应处理统一处理
+				if result.Result != nil {
+					attempt.EndStreamSpan(result.Result.span, nil)
+					return result.Result, nil
+				}
 
-	// 预设配置
-	if mak.RealModel.IsEnablePresetConfig {
-
-		// 替换预设提示词
-		if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-			if request.Messages[0].Role == sconsts.ROLE_SYSTEM {
-				request.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, request.Messages[1:]...)
-			} else {
-				request.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, request.Messages...)
+				return nil, result.Err
 			}
-		}
 
-		// 检查MaxTokens取值范围
-		if request.MaxTokens != 0 {
-			if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-				request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-			} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-				request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStream")
+			upstreamCtx, cancel := context.WithCancel(upstreamCtx)
+			responseChan, err := common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, request)
+			attempt.EndStreamSpan(upstreamSpan, err)
+			if err != nil {
+				cancel()
+				return nil, err
 			}
-		}
-	}
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sChat CompletionsStream request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
-			}
-		}
-	}
+			return &completionsStream{responseChan: responseChan, cancel: cancel}, nil
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, stream *completionsStream) (*completionsStream, error) {
 
-	response, err := common.NewAdapter(ctx, mak, true).ChatCompletionsStream(ctx, request)
-	if err != nil {
-		logger.Error(ctx, err)
+			mak := attempt.Mak
+			streamTimeout := common.NewStreamTimeout(mak)
+			guardrail := attempt.Guardrail.Stream()
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			defer stream.release(ctx)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			// 输出数据块
+			send := func(response *smodel.ChatCompletionResponse) error {
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				// 数据透传
+				if mak.Passthrough != nil && slices.Contains(mak.Passthrough.ResParams, "res_data") && response.ResponseBytes != nil {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					if mak.ReqModel.IsEnableForward {
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+						data := make(map[string]any)
+						if err := gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
+							logger.Error(ctx, err)
+							return err
+						}
 
-		if isRetry {
+						if _, ok := data["model"]; ok {
+							data["model"] = mak.ReqModel.Model
+						}
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+						response.ResponseBytes = gjson.MustEncode(data)
+					}
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+					if err := util.SSEServer(ctx, string(response.ResponseBytes)); err != nil {
+						logger.Error(ctx, err)
+						return err
+					}
 
-					if mak.RealModel.IsEnableFallback {
+					if mak.IsCacheable() {
+						chunks = append(chunks, string(response.ResponseBytes))
+					}
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
+					return nil
+				}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
+				if mak.ReqModel.IsEnableForward {
+					response.Model = mak.ReqModel.Model
+				}
 
+				data := gjson.MustEncodeString(response)
+
+				if err := util.SSEServer(ctx, data); err != nil {
+					logger.Error(ctx, err)
 					return err
 				}
-			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+				if mak.IsCacheable() {
+					chunks = append(chunks, data)
+				}
+
+				return nil
 			}
 
-			return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+			for {
 
-		return err
-	}
+				response := stream.first
+				if response != nil {
+					stream.first = nil
+					streamTimeout.Received()
+				} else {
 
-	defer close(response)
+					received, err := common.StreamRecv(ctx, streamTimeout, stream.responseChan)
+					if err != nil {
+
+						// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+						if common.IsStreamStalled(err) {
+							common.StreamErrorEvent(ctx, err)
+							return stream, err
+						}
 
-	for {
+						// 未向客户端输出, 转入重试
+						return stream, attempt.UpstreamError(err)
+					}
 
-		response := <-response
+					response = received
+				}
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-		if response.Error != nil {
+				if response.Error != nil {
 
-			if errors.Is(response.Error, io.EOF) {
+					stream.isDone = true
 
-				if err = util.SSEServer(ctx, "[DONE]"); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
+					if errors.Is(response.Error, io.EOF) {
 
-				return nil
-			}
+						// 补发护栏暂缓的内容
+						if flush := guardrail.Flush(ctx); flush != nil {
+							if err := send(flush); err != nil {
+								return stream, err
+							}
+						}
 
-			err = response.Error
+						if err := util.SSEServer(ctx, "[DONE]"); err != nil {
+							logger.Error(ctx, err)
+							return stream, err
+						}
 
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+						// 写入响应缓存
+						mak.SaveResponseCache(ctx, &streamCache{
+							Chunks:      chunks,
+							Completion:  completion,
+							ServiceTier: serviceTier,
+							Usage:       usage,
+						})
 
-			if _, isDisabled := common.IsNeedRetry(err); isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+						return stream, nil
+					}
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					err := response.Error
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
-				}
-			}
+					// 记录错误次数和禁用
+					service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
 
-			return err
-		}
+					if _, isDisabled := common.IsNeedRetry(err); isDisabled {
+						if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+							service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-			if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
-				completion += response.Choices[0].Delta.Audio.Transcript
-			} else {
-				if len(response.Choices) > 1 {
-					for i, choice := range response.Choices {
-						completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
-					}
-				} else {
-					if response.Choices[0].Delta.ReasoningContent != nil {
-						completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+						}, nil); err != nil {
+							logger.Error(ctx, err)
+						}
 					}
-					completion += response.Choices[0].Delta.Content
+
+					return stream, err
 				}
-			}
-		}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
-			completion += gconv.String(response.Choices[0].Delta.ToolCalls)
-		}
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+				}
 
-		if response.ServiceTier != "" {
-			serviceTier = response.ServiceTier
-		}
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+					if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
+						completion += response.Choices[0].Delta.Audio.Transcript
+					} else {
+						if len(response.Choices) > 1 {
+							for i, choice := range response.Choices {
+								completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+							}
+						} else {
+							if response.Choices[0].Delta.ReasoningContent != nil {
+								completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+							}
+							completion += response.Choices[0].Delta.Content
+						}
+					}
+				}
 
-		if response.Usage != nil {
-			logger.Infof(ctx, "sChat CompletionsStream Usage: %s", response.ResponseBytes)
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
+					completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+				}
 
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
+				if response.ServiceTier != "" {
+					serviceTier = response.ServiceTier
 				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
+
+				// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+				flush, err := guardrail.Chunk(ctx, response)
+				if err != nil {
+					common.StreamErrorEvent(ctx, err)
+					return stream, err
 				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+				if flush != nil {
+					if err := send(flush); err != nil {
+						return stream, err
+					}
 				}
-				if response.Usage.CacheCreationInputTokens != 0 {
-					usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+
+				if response.Usage != nil {
+					logger.Infof(ctx, "sChat CompletionsStream Usage: %s", response.ResponseBytes)
+
+					if usage == nil {
+						usage = response.Usage
+					} else {
+						if response.Usage.PromptTokens != 0 {
+							usage.PromptTokens = response.Usage.PromptTokens
+						}
+						if response.Usage.CompletionTokens != 0 {
+							usage.CompletionTokens = response.Usage.CompletionTokens
+						}
+						if response.Usage.TotalTokens != 0 {
+							usage.TotalTokens = response.Usage.TotalTokens
+						} else {
+							usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+						}
+						if response.Usage.CacheCreationInputTokens != 0 {
+							usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+						}
+						if response.Usage.CacheReadInputTokens != 0 {
+							usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+						}
+					}
 				}
-				if response.Usage.CacheReadInputTokens != 0 {
-					usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+
+				if err := send(response); err != nil {
+					return stream, err
 				}
 			}
-		}
-
-		// 数据透传
-		if mak.Passthrough != nil && slices.Contains(mak.Passthrough.ResParams, "res_data") && response.ResponseBytes != nil {
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ *completionsStream, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				Action:            consts.ACTION_COMPLETIONS,
+				Completion:        completion,
+				ServiceTier:       serviceTier,
+				Usage:             usage,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
+			}
+		},
+	}
 
-			if mak.ReqModel.IsEnableForward {
+	// 流中断或输出被拦截已以错误事件结束流
+	if _, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); common.IsStreamStalled(err) || common.IsOutputBlocked(err) {
+		return nil
+	}
 
-				data := make(map[string]any)
-				if err = gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
+	return err
+}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.ReqModel.Model
-				}
+// 按模型预设配置处理请求
+func presetRequest(mak *common.MAK, params smodel.ChatCompletionRequest) smodel.ChatCompletionRequest {
 
-				response.ResponseBytes = gjson.MustEncode(data)
-			}
+	request := params
 
-			if err = util.SSEServer(ctx, string(response.ResponseBytes)); err != nil {
-				logger.Error(ctx, err)
-				return err
-			}
+	if !mak.RealModel.IsEnablePresetConfig {
+		return request
+	}
 
+	// 替换预设提示词
+	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
+		if request.Messages[0].Role == sconsts.ROLE_SYSTEM {
+			request.Messages = append([]smodel.ChatCompletionMessage{{
+				Role:    sconsts.ROLE_SYSTEM,
+				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
+			}}, request.Messages[1:]...)
 		} else {
+			request.Messages = append([]smodel.ChatCompletionMessage{{
+				Role:    sconsts.ROLE_SYSTEM,
+				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
+			}}, request.Messages...)
+		}
+	}
 
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
-			}
-
-			if err = util.SSEServer(ctx, gjson.MustEncodeString(response)); err != nil {
-				logger.Error(ctx, err)
-				return err
-			}
+	// 检查MaxTokens取值范围
+	if request.MaxTokens != 0 {
+		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
+			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
+		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
+			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
 		}
 	}
+
+	return request
 }
@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"context"
+	"io"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/text/gstr"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// Completions 对冲请求
+func (s *sChat) hedgeCompletions(ctx context.Context, mak *common.MAK, params, request smodel.ChatCompletionRequest) *common.HedgeResult[smodel.ChatCompletionResponse] {
+	return common.Hedge(ctx, mak, func(ctx context.Context, hedgeMak *common.MAK) (smodel.ChatCompletionResponse, error) {
+
+		hedgeRequest := request
+		if hedgeMak != mak {
+			hedgeRequest = replaceHedgeModel(ctx, hedgeMak, request)
+		}
+
+		upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, hedgeMak, "ChatCompletions")
+		response, err := common.NewAdapter(upstreamCtx, hedgeMak, false).ChatCompletions(upstreamCtx, hedgeRequest)
+		common.EndSpan(upstreamSpan, err)
+
+		return response, err
+
+	}, func(ctx context.Context, result *common.HedgeResult[smodel.ChatCompletionResponse]) {
+
+		recordHedgeError(ctx, result.Mak, result.Err)
+
+		if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+			common.AfterHandler(ctx, result.Mak, &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				ChatCompletionRes: result.Result,
+				Action:            consts.ACTION_COMPLETIONS,
+				Usage:             result.Result.Usage,
+				Error:             result.Err,
+				RetryInfo:         result.RetryInfo(),
+				ConnTime:          result.Result.ConnTime,
+				Duration:          result.Result.Duration,
+				TotalTime:         result.TotalTime,
+				EnterTime:         g.RequestFromCtx(ctx).EnterTime.TimestampMilli(),
+			})
+
+		}); err != nil {
+			logger.Error(ctx, err)
+		}
+	})
+}
+
+// CompletionsStream 对冲请求, 以收到首个数据块为就绪
+func (s *sChat) hedgeCompletionsStream(ctx context.Context, mak *common.MAK, params, request smodel.ChatCompletionRequest) *common.HedgeResult[*completionsStream] {
+	return common.Hedge(ctx, mak, func(ctx context.Context, hedgeMak *common.MAK) (*completionsStream, error) {
+
+		hedgeRequest := request
+		if hedgeMak != mak {
+			hedgeRequest = replaceHedgeModel(ctx, hedgeMak, request)
+		}
+
+		upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, hedgeMak, "ChatCompletionsStream")
+		responseChan, err := common.NewAdapter(upstreamCtx, hedgeMak, true).ChatCompletionsStream(upstreamCtx, hedgeRequest)
+		if err != nil {
+			common.EndSpan(upstreamSpan, err)
+			return nil, err
+		}
+
+		stream := &completionsStream{responseChan: responseChan, span: upstreamSpan}
+
+		// 未收到首个数据块时不返回流, 上游已结束或已取消
+		select {
+		case first, ok := <-responseChan:
+			if !ok || first == nil {
+				common.EndSpan(upstreamSpan, errors.ERR_UPSTREAM_CLOSED)
+				return nil, errors.ERR_UPSTREAM_CLOSED
+			}
+			stream.first = first
+		case <-ctx.Done():
+			stream.release(ctx)
+			common.EndSpan(upstreamSpan, ctx.Err())
+			return nil, ctx.Err()
+		}
+
+		if stream.first.Error != nil {
+
+			stream.isDone = true
+
+			if !errors.Is(stream.first.Error, io.EOF) {
+				return stream, stream.first.Error
+			}
+		}
+
+		return stream, nil
+
+	}, func(ctx context.Context, result *common.HedgeResult[*completionsStream]) {
+
+		var (
+			connTime int64
+			duration int64
+		)
+
+		if result.Result != nil {
+
+			result.Result.release(ctx)
+			common.EndSpan(result.Result.span, result.Err)
+
+			if result.Result.first != nil {
+				connTime = result.Result.first.ConnTime
+				duration = result.Result.first.Duration
+			}
+		}
+
+		recordHedgeError(ctx, result.Mak, result.Err)
+
+		if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+			common.AfterHandler(ctx, result.Mak, &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				Action:            consts.ACTION_COMPLETIONS,
+				Error:             result.Err,
+				RetryInfo:         result.RetryInfo(),
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         result.TotalTime,
+				EnterTime:         g.RequestFromCtx(ctx).EnterTime.TimestampMilli(),
+			})
+
+		}); err != nil {
+			logger.Error(ctx, err)
+		}
+	})
+}
+
+// 对冲请求使用的模型代理不同, 按其模型替换配置重新设置请求模型
+func replaceHedgeModel(ctx context.Context, mak *common.MAK, request smodel.ChatCompletionRequest) smodel.ChatCompletionRequest {
+
+	if !gstr.Contains(mak.RealModel.Model, "*") {
+		request.Model = mak.RealModel.Model
+	}
+
+	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
+		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
+			if replaceModel == request.Model {
+				logger.Infof(ctx, "replaceHedgeModel request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
+				request.Model = mak.ModelAgent.TargetModels[i]
+				mak.RealModel.Model = request.Model
+				break
+			}
+		}
+	}
+
+	return request
+}
+
+// 记录未被使用的对冲请求的错误次数, 被取消的不记录
+func recordHedgeError(ctx context.Context, mak *common.MAK, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
+	}
+}
@@ -2,19 +2,13 @@ package chat
 
 import (
 	"context"
-	"slices"
 
-	"github.com/gogf/gf/v2/frame/g"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
-	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
 	"github.com/iimeta/fastapi/v2/internal/consts"
 	"github.com/iimeta/fastapi/v2/internal/logic/common"
 	"github.com/iimeta/fastapi/v2/internal/model"
 	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
-	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
 )
 
@@ -26,157 +20,60 @@ func (s *sChat) SmartCompletions(ctx context.Context, params smodel.ChatCompleti
 		logger.Debugf(ctx, "sChat SmartCompletions time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              reqModel.Model,
-			Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
-			Messages:           params.Messages,
-			ReqModel:           reqModel,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: response,
-					Action:            consts.ACTION_COMPLETIONS,
-					Usage:             response.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          response.ConnTime,
-					Duration:          response.Duration,
-					TotalTime:         response.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-					IsSmartMatch:      true,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	params.Model = mak.RealModel.Model
-
-	// 预设配置
-	if mak.RealModel.IsEnablePresetConfig {
-
-		// 替换预设提示词
-		if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-			if params.Messages[0].Role == sconsts.ROLE_SYSTEM {
-				params.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, params.Messages[1:]...)
-			} else {
-				params.Messages = append([]smodel.ChatCompletionMessage{{
-					Role:    sconsts.ROLE_SYSTEM,
-					Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-				}}, params.Messages...)
-			}
-		}
-
-		// 检查MaxTokens取值范围
-		if params.MaxTokens != 0 {
-			if mak.RealModel.PresetConfig.MinTokens != 0 && params.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-				params.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-			} else if mak.RealModel.PresetConfig.MaxTokens != 0 && params.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-				params.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-			}
-		}
-	}
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sChat SmartCompletions params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-				params.Model = mak.ModelAgent.TargetModels[i]
-				break
+	var request smodel.ChatCompletionRequest
+
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name:              "sChat SmartCompletions",
+		IsSkipRecordError: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              reqModel.Model,
+				Endpoint:           consts.ENDPOINT_CHAT_COMPLETIONS,
+				Messages:           params.Messages,
+				ReqModel:           reqModel,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).ChatCompletions(ctx, params)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.SmartCompletions(g.RequestFromCtx(ctx).GetCtx(), params, reqModel, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.SmartCompletions(g.RequestFromCtx(ctx).GetCtx(), params, reqModel, nil, fallbackModel)
-							}
-						}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+
+			request = params
+			request.Model = attempt.Mak.RealModel.Model
+			request = presetRequest(attempt.Mak, request)
+
+			if attempt.Mak.ModelAgent != nil && attempt.Mak.ModelAgent.IsEnableModelReplace {
+				for i, replaceModel := range attempt.Mak.ModelAgent.ReplaceModels {
+					if replaceModel == request.Model {
+						logger.Infof(ctx, "sChat SmartCompletions params.Model: %s replaced %s", request.Model, attempt.Mak.ModelAgent.TargetModels[i])
+						request.Model = attempt.Mak.ModelAgent.TargetModels[i]
+						break
 					}
-
-					return response, err
 				}
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, request)
+			common.EndSpan(upstreamSpan, err)
+
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: request,
+				ChatCompletionRes: res,
+				Action:            consts.ACTION_COMPLETIONS,
+				Usage:             res.Usage,
+				ConnTime:          res.ConnTime,
+				Duration:          res.Duration,
+				TotalTime:         res.TotalTime,
+				IsSmartMatch:      true,
 			}
-
-			return s.SmartCompletions(g.RequestFromCtx(ctx).GetCtx(), params, reqModel, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"slices"
 	"strings"
-	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/text/gstr"
@@ -14,9 +13,9 @@ import (
 	"github.com/iimeta/fastapi-sdk/v2/openai"
 	"github.com/iimeta/fastapi-sdk/v2/options"
 	"github.com/iimeta/fastapi/v2/internal/config"
-	"github.com/iimeta/fastapi/v2/internal/model"
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/tracing"
 )
 
 func NewAdapter(ctx context.Context, mak *MAK, isLong bool) (adapter sdk.Adapter) {
@@ -51,21 +50,17 @@ func NewAdapter(ctx context.Context, mak *MAK, isLong bool) (adapter sdk.Adapter
 		Path:                 mak.Path,
 		Stream:               isLong,
 		Action:               g.RequestFromCtx(ctx).GetRouter("action", "").String(),
-		Timeout:              config.Cfg.Base.ShortTimeout * time.Second,
-		ProxyUrl:             config.Cfg.Http.ProxyUrl,
+		Timeout:              upstreamTimeout(mak, isLong),
+		ProxyUrl:             upstreamProxyUrl(mak),
 		ReqPassthroughParams: getReqPassthroughParams(mak.Passthrough),
 		ResPassthroughParams: getResPassthroughParams(mak.Passthrough),
-		PassthroughHeader:    getPassthroughHeaders(ctx, mak.Passthrough),
+		PassthroughHeader:    injectTraceHeaders(ctx, getPassthroughHeaders(ctx, mak.Passthrough)),
 	}
 
 	if mak.Passthrough != nil && slices.Contains(mak.Passthrough.ReqParams, "req_path") {
 		options.Path = g.RequestFromCtx(ctx).URL.Path
 	}
 
-	if isLong {
-		options.Timeout = config.Cfg.Base.LongTimeout * time.Second
-	}
-
 	if mak.RealModel.IsEnablePresetConfig {
 		options.IsSupportSystemRole = &mak.RealModel.PresetConfig.IsSupportSystemRole
 		options.IsSupportStream = &mak.RealModel.PresetConfig.IsSupportStream
@@ -101,14 +96,10 @@ func NewAdapterOfficial(ctx context.Context, mak *MAK, isLong bool) (adapter sdk
 		Path:                 mak.Path,
 		Stream:               isLong,
 		Action:               g.RequestFromCtx(ctx).GetRouter("action", "").String(),
-		Timeout:              config.Cfg.Base.ShortTimeout * time.Second,
-		ProxyUrl:             config.Cfg.Http.ProxyUrl,
+		Timeout:              upstreamTimeout(mak, isLong),
+		ProxyUrl:             upstreamProxyUrl(mak),
 		ReqPassthroughParams: []string{"req_data"},
-		PassthroughHeader:    getPassthroughHeaders(ctx, mak.Passthrough),
-	}
-
-	if isLong {
-		options.Timeout = config.Cfg.Base.LongTimeout * time.Second
+		PassthroughHeader:    injectTraceHeaders(ctx, getPassthroughHeaders(ctx, mak.Passthrough)),
 	}
 
 	if mak.RealModel.IsEnablePresetConfig {
@@ -139,14 +130,10 @@ func NewAdapterOpenAI(ctx context.Context, mak *MAK, isLong bool) *openai.OpenAI
 		Path:                 mak.Path,
 		Stream:               isLong,
 		Action:               g.RequestFromCtx(ctx).GetRouter("action", "").String(),
-		Timeout:              config.Cfg.Base.ShortTimeout * time.Second,
-		ProxyUrl:             config.Cfg.Http.ProxyUrl,
+		Timeout:              upstreamTimeout(mak, isLong),
+		ProxyUrl:             upstreamProxyUrl(mak),
 		ReqPassthroughParams: []string{"req_data"},
-		PassthroughHeader:    getPassthroughHeaders(ctx, mak.Passthrough),
-	}
-
-	if isLong {
-		options.Timeout = config.Cfg.Base.LongTimeout * time.Second
+		PassthroughHeader:    injectTraceHeaders(ctx, getPassthroughHeaders(ctx, mak.Passthrough)),
 	}
 
 	if mak.RealModel.IsEnablePresetConfig {
@@ -167,15 +154,15 @@ func NewAdapterOpenAI(ctx context.Context, mak *MAK, isLong bool) *openai.OpenAI
 	return openai.NewAdapter(ctx, options)
 }
 
-func NewRealtimeClient(ctx context.Context, model *model.Model, key, baseUrl, path string) *sdk.RealtimeClient {
-	return sdk.NewRealtimeClient(ctx, model.Model, key, baseUrl, path, config.Cfg.Http.ProxyUrl)
+func NewRealtimeClient(ctx context.Context, mak *MAK) *sdk.RealtimeClient {
+	return sdk.NewRealtimeClient(ctx, mak.RealModel.Model, mak.RealKey, mak.BaseUrl, mak.Path, upstreamProxyUrl(mak))
 }
 
-func NewModerationClient(ctx context.Context, m *model.Model, key, baseUrl, path string, passthrough *EffectivePassthrough) *sdk.ModerationClient {
+func NewModerationClient(ctx context.Context, mak *MAK) *sdk.ModerationClient {
 
-	g.RequestFromCtx(ctx).SetCtxVar("passthrough", passthrough)
+	g.RequestFromCtx(ctx).SetCtxVar("passthrough", mak.Passthrough)
 
-	return sdk.NewModerationClient(ctx, m.Model, key, baseUrl, path, config.Cfg.Base.ShortTimeout*time.Second, config.Cfg.Http.ProxyUrl, getReqPassthroughParams(passthrough), getPassthroughHeaders(ctx, passthrough))
+	return sdk.NewModerationClient(ctx, mak.RealModel.Model, mak.RealKey, mak.BaseUrl, mak.Path, upstreamTimeout(mak, false), upstreamProxyUrl(mak), getReqPassthroughParams(mak.Passthrough), injectTraceHeaders(ctx, getPassthroughHeaders(ctx, mak.Passthrough)))
 }
 
 func NewConverter(ctx context.Context, provider string) sdk.Converter {
@@ -238,3 +225,13 @@ func getPassthroughHeaders(ctx context.Context, pt *EffectivePassthrough) map[st
 	}
 	return headers
 }
+
+// 注入链路追踪上下文到上游请求头
+func injectTraceHeaders(ctx context.Context, headers map[string]string) map[string]string {
+
+	if !config.Cfg.Trace.Open {
+		return headers
+	}
+
+	return tracing.Inject(ctx, headers)
+}
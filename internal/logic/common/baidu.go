@@ -3,10 +3,12 @@ package common
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi/v2/internal/consts"
@@ -19,13 +21,16 @@ import (
 
 var baiduCache = cache.New() // [key]AccessToken
 
-func getBaiduToken(ctx context.Context, key, baseUrl, proxyUrl string) string {
+func getBaiduToken(ctx context.Context, key, baseUrl, proxyUrl string, transport http.RoundTripper) string {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "getBaiduToken time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "getBaiduToken")
+	defer span.End()
+
 	if accessTokenCacheValue := baiduCache.GetVal(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key)); accessTokenCacheValue != nil {
 		return accessTokenCacheValue.(string)
 	}
@@ -61,7 +66,7 @@ func getBaiduToken(ctx context.Context, key, baseUrl, proxyUrl string) string {
 	url := fmt.Sprintf("%s://%s/oauth/2.0/token", parse.Scheme, parse.Host)
 
 	getBaiduTokenRes := new(model.GetBaiduTokenRes)
-	if err = util.HttpPost(ctx, url, nil, data, &getBaiduTokenRes, proxyUrl); err != nil {
+	if err = util.HttpPost(ctx, url, nil, data, &getBaiduTokenRes, proxyUrl, transport); err != nil {
 		logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
 		return ""
 	}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"context"
+
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+)
+
+// 是否网关批处理, 开启批处理任务且上游不支持批处理API
+func IsNativeBatch(ctx context.Context, mak *MAK) bool {
+
+	if config.Cfg.BatchTask == nil || !config.Cfg.BatchTask.Open {
+		return false
+	}
+
+	return GetProviderCode(ctx, mak.Provider) != sconsts.PROVIDER_OPENAI
+}
+
+// 是否网关批处理请求, 批处理任务仅由节点内执行批处理请求时随上下文传入
+func IsBatchRequest(ctx context.Context) bool {
+	_, ok := ctx.Value(consts.BATCH_TASK_KEY).(*entity.TaskBatch)
+	return ok
+}
+
+// 网关批处理文件存储目录
+func BatchStorageDir() string {
+
+	if config.Cfg.BatchTask == nil || config.Cfg.BatchTask.StorageDir == "" {
+		return "./resource/batch/"
+	}
+
+	return config.Cfg.BatchTask.StorageDir
+}
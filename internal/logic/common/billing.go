@@ -5,7 +5,9 @@ import (
 	"math"
 	"slices"
 
+	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
@@ -13,11 +15,15 @@ import (
 	"github.com/iimeta/fastapi/v2/internal/consts"
 	"github.com/iimeta/fastapi/v2/internal/model"
 	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/utility/util"
 )
 
 // 计算花费
 func Billing(ctx context.Context, mak *MAK, billingData *common.BillingData, billingItems ...string) (spend common.Spend) {
 
+	_, span := gtrace.NewSpan(ctx, "Billing")
+	defer span.End()
+
 	if billingItems == nil || len(billingItems) == 0 {
 		billingItems = mak.ReqModel.Pricing.BillingItems
 	}
@@ -53,6 +59,8 @@ func Billing(ctx context.Context, mak *MAK, billingData *common.BillingData, bil
 			videoGeneration(ctx, mak, billingData, &spend)
 		case "search":
 			search(ctx, mak, billingData, &spend)
+		case "rerank":
+			rerank(ctx, mak, billingData, &spend)
 		case "once":
 			once(ctx, mak, billingData, &spend)
 		}
@@ -114,6 +122,10 @@ func Billing(ctx context.Context, mak *MAK, billingData *common.BillingData, bil
 		spend.TotalSpendTokens += spend.Search.SpendTokens
 	}
 
+	if spend.Rerank != nil {
+		spend.TotalSpendTokens += spend.Rerank.SpendTokens
+	}
+
 	if spend.Once != nil && (spend.TotalSpendTokens == 0 || mak.AppKey == nil || slices.Contains(mak.AppKey.BillingMethods, 2)) {
 		spend.TotalSpendTokens = spend.Once.SpendTokens
 	}
@@ -136,6 +148,18 @@ func Billing(ctx context.Context, mak *MAK, billingData *common.BillingData, bil
 		}
 	}
 
+	// 命中响应缓存按倍率计费
+	if billingData.IsCacheHit {
+		spend.ResponseCacheRatio = &billingData.CacheRatio
+		spend.TotalSpendTokens = discountTokens(spend.TotalSpendTokens, billingData.CacheRatio)
+	}
+
+	// 网关批处理折扣
+	if billingData.IsBatch && mak.ReqModel.Pricing.BatchDiscount > 0 {
+		spend.BatchDiscount = &mak.ReqModel.Pricing.BatchDiscount
+		spend.TotalSpendTokens = discountTokens(spend.TotalSpendTokens, mak.ReqModel.Pricing.BatchDiscount)
+	}
+
 	return spend
 }
 
@@ -870,6 +894,41 @@ func search(ctx context.Context, mak *MAK, billingData *common.BillingData, spen
 	spend.Search.SpendTokens = int(math.Ceil(consts.QUOTA_DEFAULT_UNIT * spend.Search.Pricing.OnceRatio))
 }
 
+// 重排序
+func rerank(ctx context.Context, mak *MAK, billingData *common.BillingData, spend *common.Spend) {
+
+	if mak.ReqModel.Pricing.Rerank == nil {
+		return
+	}
+
+	if spend.Rerank == nil {
+		spend.Rerank = new(common.RerankSpend)
+	}
+
+	spend.Rerank.Pricing = mak.ReqModel.Pricing.Rerank
+	spend.Rerank.Documents = billingData.RerankDocuments
+
+	if spend.Rerank.Pricing.Mode == "document" {
+		spend.Rerank.SpendTokens = int(math.Ceil(consts.QUOTA_DEFAULT_UNIT * spend.Rerank.Pricing.OnceRatio * float64(spend.Rerank.Documents)))
+		return
+	}
+
+	// 按官方计费时优先使用上游返回的搜索单元数, 否则按每搜索单元文档数计算
+	if billingData.RerankSearchUnits > 0 && mak.ReqModel.Pricing.BillingRule != 2 {
+		spend.Rerank.SearchUnits = billingData.RerankSearchUnits
+	} else {
+
+		documents := spend.Rerank.Pricing.Documents
+		if documents <= 0 {
+			documents = 100
+		}
+
+		spend.Rerank.SearchUnits = max(int(math.Ceil(float64(spend.Rerank.Documents)/float64(documents))), 1)
+	}
+
+	spend.Rerank.SpendTokens = int(math.Ceil(consts.QUOTA_DEFAULT_UNIT * spend.Rerank.Pricing.OnceRatio * float64(spend.Rerank.SearchUnits)))
+}
+
 // 一次
 func once(ctx context.Context, mak *MAK, billingData *common.BillingData, spend *common.Spend) {
 
@@ -978,3 +1037,98 @@ func discountTokens(tokens int, discount float64) int {
 	// 整数向上取整除法: ceil(a/b) = (a + b - 1) / b (a, b 均非负)
 	return int((int64(tokens)*basis + scale - 1) / scale)
 }
+
+// 当前时段的有效定价, 按模型时段折扣和分组时段折扣依次打折, 返回折后定价和总折扣
+func EffectivePricing(ctx context.Context, m *model.Model, group *model.Group) (*common.Pricing, float64) {
+
+	discount := 1.0
+
+	// 模型时段折扣
+	if modelTimeRule := MatchTimeRule(ctx, m.TimeRules); modelTimeRule != nil {
+		discount *= modelTimeRule.Discount
+	}
+
+	// 分组时段折扣
+	if group != nil {
+		if groupTimeRule := MatchTimeRule(ctx, group.TimeRules, m); groupTimeRule != nil {
+			discount *= groupTimeRule.Discount
+		}
+	}
+
+	pricing := new(common.Pricing)
+	if err := gjson.Unmarshal(gjson.MustEncode(m.Pricing), pricing); err != nil {
+		return &m.Pricing, 1
+	}
+
+	if discount == 1 {
+		return pricing, discount
+	}
+
+	ratio := func(r *float64) {
+		*r = util.Round(*r*discount, 6)
+	}
+
+	cache := func(cachePricing *common.CachePricing) {
+		if cachePricing != nil {
+			ratio(&cachePricing.ReadRatio)
+			ratio(&cachePricing.WriteRatio)
+			ratio(&cachePricing.Write5MRatio)
+			ratio(&cachePricing.Write1HRatio)
+		}
+	}
+
+	for _, text := range append(pricing.Text, pricing.TieredText...) {
+		ratio(&text.InputRatio)
+		ratio(&text.OutputRatio)
+		ratio(&text.ReasoningRatio)
+	}
+
+	for _, textCache := range append(pricing.TextCache, pricing.TieredTextCache...) {
+		cache(textCache)
+	}
+
+	cache(pricing.ImageCache)
+	cache(pricing.AudioCache)
+	cache(pricing.VideoCache)
+
+	if pricing.Image != nil {
+		ratio(&pricing.Image.InputRatio)
+		ratio(&pricing.Image.OutputRatio)
+	}
+
+	if pricing.Audio != nil {
+		ratio(&pricing.Audio.InputRatio)
+		ratio(&pricing.Audio.OutputRatio)
+	}
+
+	if pricing.Video != nil {
+		ratio(&pricing.Video.InputRatio)
+		ratio(&pricing.Video.OutputRatio)
+	}
+
+	for _, imageGeneration := range pricing.ImageGeneration {
+		ratio(&imageGeneration.OnceRatio)
+	}
+
+	for _, vision := range pricing.Vision {
+		ratio(&vision.OnceRatio)
+	}
+
+	for _, videoGeneration := range pricing.VideoGeneration {
+		ratio(&videoGeneration.OnceRatio)
+	}
+
+	for _, search := range pricing.Search {
+		ratio(&search.OnceRatio)
+	}
+
+	if pricing.Rerank != nil {
+		ratio(&pricing.Rerank.OnceRatio)
+	}
+
+	if pricing.Once != nil {
+		ratio(&pricing.Once.OnceRatio)
+	}
+
+	return pricing, util.Round(discount, 6)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gogf/gf/v2/text/gregex"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
+	serrors "github.com/iimeta/fastapi-sdk/v2/errors"
 	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/errors"
 	"github.com/iimeta/fastapi/v2/internal/model"
@@ -55,8 +56,8 @@ func (s *sCommon) ParseSecretKey(ctx context.Context, secretKey string) (int, in
 	return gconv.Int(userId), gconv.Int(appId), nil
 }
 
-// 记录错误次数和禁用
-func (s *sCommon) RecordError(ctx context.Context, model *model.Model, key *model.Key, modelAgent *model.ModelAgent) {
+// 记录错误次数和禁用, 上游限流错误同时记录限流状态
+func (s *sCommon) RecordError(ctx context.Context, model *model.Model, key *model.Key, modelAgent *model.ModelAgent, err error) {
 
 	sessionKeepHit := service.Session().GetSessionKeepHit(ctx)
 
@@ -72,6 +73,10 @@ func (s *sCommon) RecordError(ctx context.Context, model *model.Model, key *mode
 
 		service.ModelAgent().RecordErrorKey(ctx, modelAgent, key)
 
+		if isUpstreamRateLimited(err) {
+			service.ModelAgent().RecordUpstreamRateLimited(ctx, modelAgent, key)
+		}
+
 		if !sessionKeepHit {
 			service.ModelAgent().RecordError(ctx, model, modelAgent)
 		}
@@ -97,6 +102,11 @@ func IsNeedRetry(err error) (isRetry bool, isDisabled bool) {
 		return false, false
 	}
 
+	// 首个数据块超时, 未向客户端输出, 转入重试
+	if errors.Is(err, errors.ERR_FIRST_TOKEN_TIMEOUT) {
+		return true, false
+	}
+
 	defer func() {
 		// 自动重试错误
 		if isRetry && len(config.Cfg.AutoRetryError.Errors) > 0 {
@@ -131,6 +141,58 @@ func IsNeedRetry(err error) (isRetry bool, isDisabled bool) {
 	return config.Cfg.AutoRetryError.Open, false
 }
 
+// 是否上游故障, 客户端取消、内部错误和请求参数错误不计入
+func isUpstreamFailure(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, errors.ERR_FIRST_TOKEN_TIMEOUT) || errors.Is(err, errors.ERR_STREAM_IDLE_TIMEOUT) {
+		return true
+	}
+
+	if _, ok := err.(errors.IFastApiError); ok {
+		return false
+	}
+
+	requestError := &serrors.RequestError{}
+	if errors.As(err, &requestError) {
+		return isUpstreamFailureStatus(requestError.HttpStatusCode)
+	}
+
+	apiError := &serrors.ApiError{}
+	if errors.As(err, &apiError) {
+		return isUpstreamFailureStatus(apiError.HttpStatusCode)
+	}
+
+	return true
+}
+
+// 是否上游限流错误
+func isUpstreamRateLimited(err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	requestError := &serrors.RequestError{}
+	if errors.As(err, &requestError) {
+		return requestError.HttpStatusCode == 429
+	}
+
+	apiError := &serrors.ApiError{}
+	if errors.As(err, &apiError) {
+		return apiError.HttpStatusCode == 429
+	}
+
+	return false
+}
+
+func isUpstreamFailureStatus(status int) bool {
+	return status == 0 || status == 408 || status == 429 || status >= 500
+}
+
 func IsMaxRetry(agentTotal, retry int) bool {
 
 	if config.Cfg.Base.ErrRetry > 0 && retry == config.Cfg.Base.ErrRetry {
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
-	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
@@ -28,25 +27,25 @@ func ConvQuota(quota int, n ...int) float64 {
 	return util.Round(float64(quota)/consts.QUOTA_DEFAULT_UNIT, n[0])
 }
 
-func ConvResponsesToChatCompletionsRequest(request *ghttp.Request, isChatCompletions bool) smodel.ChatCompletionRequest {
+func ConvResponsesToChatCompletionsRequest(ctx context.Context, body []byte, isChatCompletions bool) smodel.ChatCompletionRequest {
 
 	now := gtime.TimestampMilli()
 	defer func() {
-		logger.Debugf(request.GetCtx(), "ConvResponsesToChatCompletionsRequest time: %d", gtime.TimestampMilli()-now)
+		logger.Debugf(ctx, "ConvResponsesToChatCompletionsRequest time: %d", gtime.TimestampMilli()-now)
 	}()
 
 	if isChatCompletions {
 		chatCompletionRequest := smodel.ChatCompletionRequest{}
-		if err := json.Unmarshal(request.GetBody(), &chatCompletionRequest); err != nil {
-			logger.Error(request.GetCtx(), err)
+		if err := json.Unmarshal(body, &chatCompletionRequest); err != nil {
+			logger.Error(ctx, err)
 			return smodel.ChatCompletionRequest{}
 		}
 		return chatCompletionRequest
 	}
 
 	responsesReq := smodel.OpenAIResponsesReq{}
-	if err := json.Unmarshal(request.GetBody(), &responsesReq); err != nil {
-		logger.Error(request.GetCtx(), err)
+	if err := json.Unmarshal(body, &responsesReq); err != nil {
+		logger.Error(ctx, err)
 		return smodel.ChatCompletionRequest{}
 	}
 
@@ -70,7 +69,7 @@ func ConvResponsesToChatCompletionsRequest(request *ghttp.Request, isChatComplet
 
 			inputs := make([]smodel.OpenAIResponsesInput, 0)
 			if err := json.Unmarshal(gjson.MustEncode(value), &inputs); err != nil {
-				logger.Error(request.GetCtx(), err)
+				logger.Error(ctx, err)
 				return chatCompletionRequest
 			}
 
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
+	serrors "github.com/iimeta/fastapi-sdk/v2/errors"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 后备
+type Fallback struct {
+	ModelAgent *model.ModelAgent // 后备模型代理
+	Model      *model.Model      // 后备模型
+	RetryInfo  *mcommon.Retry    // 跳转前请求的重试信息
+}
+
+// 按后备链获取下一个后备, 依次向后匹配步骤的触发条件, 已跳过的步骤不再匹配, 无匹配时返回nil
+// isRetry 为错误是否可重试, 未配置触发条件的步骤仅在可重试时触发
+func NextFallback(ctx context.Context, mak *MAK, err error, isRetry bool, retry int) *Fallback {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "NextFallback time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if err == nil || IsAborted(err) {
+		return nil
+	}
+
+	state := fallbackState(ctx, mak)
+	if state == nil {
+		return nil
+	}
+
+	// 每次后备均会向后推进, 切换次数不会超过后备链长度, 超过时说明状态未能保存, 避免无限递归
+	if mak.fallbackHops >= len(state.Chain) {
+		return nil
+	}
+
+	errClass := ErrClass(err)
+
+	for state.Next < len(state.Chain) {
+
+		step := state.Chain[state.Next]
+		state.Next++
+
+		if !matchFallbackStep(step, errClass, isRetry) {
+			continue
+		}
+
+		// 仅切换模型代理时不能是当前模型代理
+		if step.Model == "" && mak.ModelAgent != nil && step.ModelAgent == mak.ModelAgent.Id {
+			continue
+		}
+
+		fallback := &Fallback{}
+
+		if step.ModelAgent != "" {
+			if fallback.ModelAgent, _ = service.ModelAgent().GetFallback(ctx, step.ModelAgent); fallback.ModelAgent == nil {
+				continue
+			}
+		}
+
+		if step.Model != "" {
+			if fallback.Model, _ = service.Model().GetFallbackModel(ctx, step.Model); fallback.Model == nil {
+				continue
+			}
+		} else {
+			// 仅切换模型代理时沿用当前的后备模型
+			fallback.Model = mak.FallbackModel
+		}
+
+		hop := &mcommon.FallbackHop{
+			Step:     state.Next,
+			ErrClass: errClass,
+		}
+
+		if fallback.Model != nil {
+			hop.Model = fallback.Model.Model
+		}
+
+		if fallback.ModelAgent != nil {
+			hop.ModelAgentName = fallback.ModelAgent.Name
+		}
+
+		fallback.RetryInfo = &mcommon.Retry{
+			IsRetry:    true,
+			RetryCount: retry,
+			ErrMsg:     err.Error(),
+			Fallback:   hop,
+		}
+
+		logger.Infof(ctx, "NextFallback step: %d, err class: %s, model: %s, model agent: %s", hop.Step, errClass, hop.Model, hop.ModelAgentName)
+
+		return fallback
+	}
+
+	return nil
+}
+
+// 获取后备链执行状态, 首次后备时按当前模型确定后备链, 模型未配置时使用分组的后备配置
+// 状态同时保存在MAK和会话中, 会话通过 r.SetCtxVar 保存, 需从请求上下文读取最新值
+func fallbackState(ctx context.Context, mak *MAK) *mcommon.FallbackState {
+
+	if mak.fallbackState != nil {
+		return mak.fallbackState
+	}
+
+	if r := g.RequestFromCtx(ctx); r != nil {
+		ctx = r.GetCtx()
+	}
+
+	if state := service.Session().GetFallbackState(ctx); state != nil {
+		mak.fallbackState = state
+		return state
+	}
+
+	state := new(mcommon.FallbackState)
+
+	if mak.RealModel != nil && mak.RealModel.IsEnableFallback && mak.RealModel.FallbackConfig != nil {
+		state.Chain = fallbackChain(mak.RealModel.FallbackConfig)
+	}
+
+	if len(state.Chain) == 0 && mak.Group != nil && mak.Group.IsEnableFallback && mak.Group.FallbackConfig != nil {
+		state.Chain = fallbackChain(mak.Group.FallbackConfig)
+	}
+
+	service.Session().SaveFallbackState(ctx, state)
+	mak.fallbackState = state
+
+	return state
+}
+
+// 后备链, 未配置时按后备模型代理、后备模型的顺序兼容旧配置
+func fallbackChain(fallbackConfig *mcommon.FallbackConfig) []*mcommon.FallbackStep {
+
+	if len(fallbackConfig.Chain) > 0 {
+		return fallbackConfig.Chain
+	}
+
+	chain := make([]*mcommon.FallbackStep, 0)
+
+	if fallbackConfig.ModelAgent != "" {
+		chain = append(chain, &mcommon.FallbackStep{
+			ModelAgent:     fallbackConfig.ModelAgent,
+			ModelAgentName: fallbackConfig.ModelAgentName,
+		})
+	}
+
+	if fallbackConfig.Model != "" {
+		chain = append(chain, &mcommon.FallbackStep{
+			Model:     fallbackConfig.Model,
+			ModelName: fallbackConfig.ModelName,
+		})
+	}
+
+	return chain
+}
+
+func matchFallbackStep(step *mcommon.FallbackStep, errClass string, isRetry bool) bool {
+
+	if step.Model == "" && step.ModelAgent == "" {
+		return false
+	}
+
+	if len(step.Conditions) == 0 {
+		return isRetry
+	}
+
+	return errClass != "" && slices.Contains(step.Conditions, errClass)
+}
+
+// 错误类型, 用于匹配后备触发条件
+func ErrClass(err error) string {
+
+	if err == nil {
+		return ""
+	}
+
+	if errors.Is(err, errors.ERR_NO_AVAILABLE_MODEL_AGENT) || errors.Is(err, errors.ERR_ALL_MODEL_AGENT) ||
+		errors.Is(err, errors.ERR_NO_AVAILABLE_MODEL_AGENT_KEY) || errors.Is(err, errors.ERR_ALL_MODEL_AGENT_KEY) {
+		return consts.ERR_CLASS_NO_AVAILABLE
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return consts.ERR_CLASS_TIMEOUT
+	}
+
+	errMsg := gstr.ToLower(err.Error())
+
+	switch {
+	case gstr.Contains(errMsg, "context_length_exceeded") || gstr.Contains(errMsg, "maximum context length") ||
+		gstr.Contains(errMsg, "prompt is too long") || gstr.Contains(errMsg, "input is too long"):
+		return consts.ERR_CLASS_CONTEXT_LENGTH_EXCEEDED
+	case gstr.Contains(errMsg, "content_filter") || gstr.Contains(errMsg, "content management policy") ||
+		gstr.Contains(errMsg, "content_policy_violation"):
+		return consts.ERR_CLASS_CONTENT_FILTER
+	}
+
+	status := 0
+
+	requestError := &serrors.RequestError{}
+	apiError := &serrors.ApiError{}
+
+	if errors.As(err, &requestError) {
+		status = requestError.HttpStatusCode
+	} else if errors.As(err, &apiError) {
+		status = apiError.HttpStatusCode
+	}
+
+	switch {
+	case status == 429 || gstr.Contains(errMsg, "rate limit") || gstr.Contains(errMsg, "rate_limit"):
+		return consts.ERR_CLASS_RATE_LIMIT
+	case status == 408 || status == 504 || gstr.Contains(errMsg, "timeout") || gstr.Contains(errMsg, "deadline exceeded"):
+		return consts.ERR_CLASS_TIMEOUT
+	case status >= 500:
+		return consts.ERR_CLASS_SERVER_ERROR
+	}
+
+	return ""
+}
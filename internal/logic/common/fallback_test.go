@@ -0,0 +1,111 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+var errRateLimit = errors.New("rate limit reached")
+
+// 模拟后备模型, 仅实现获取后备模型
+type fakeModel struct {
+	service.IModel
+}
+
+func (f *fakeModel) GetFallbackModel(ctx context.Context, id string) (*model.Model, error) {
+	return &model.Model{Id: id, Model: id}, nil
+}
+
+// 模拟后备模型代理, 仅实现获取后备模型代理
+type fakeModelAgent struct {
+	service.IModelAgent
+}
+
+func (f *fakeModelAgent) GetFallback(ctx context.Context, id string) (*model.ModelAgent, error) {
+	return &model.ModelAgent{Id: id, Name: id}, nil
+}
+
+func TestPipelineFallbackChain(t *testing.T) {
+
+	testConfig(false, 3)
+
+	service.RegisterModel(&fakeModel{})
+	service.RegisterModelAgent(&fakeModelAgent{})
+
+	// 后备模型 → 后备模型代理, 切换模型代理时沿用已切换的后备模型
+	state := &mcommon.FallbackState{
+		Chain: []*mcommon.FallbackStep{
+			{Model: "gpt-4o-mini", Conditions: []string{consts.ERR_CLASS_RATE_LIMIT}},
+			{ModelAgent: "agent-2", Conditions: []string{consts.ERR_CLASS_RATE_LIMIT}},
+		},
+	}
+
+	type attemptInfo struct {
+		model      string
+		modelAgent string
+	}
+
+	var (
+		attempts = make([]attemptInfo, 0)
+		upstream = &fakeUpstream{errs: []error{errRateLimit, errRateLimit}}
+	)
+
+	pipeline := &Pipeline[string]{
+		Name:              "test",
+		IsSkipRecordError: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *MAK {
+			return &MAK{
+				Model:              "gpt-4o",
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				fallbackState:      state,
+			}
+		},
+		Route: func(ctx context.Context, attempt *Attempt) error {
+
+			info := attemptInfo{model: "gpt-4o"}
+			if attempt.Mak.FallbackModel != nil {
+				info.model = attempt.Mak.FallbackModel.Model
+			}
+
+			if attempt.Mak.FallbackModelAgent != nil {
+				info.modelAgent = attempt.Mak.FallbackModelAgent.Id
+			}
+
+			attempts = append(attempts, info)
+
+			attempt.Mak.ReqModel = &model.Model{Model: "gpt-4o"}
+			attempt.Mak.RealModel = &model.Model{Model: info.model}
+
+			return nil
+		},
+		Upstream: upstream.call,
+	}
+
+	res, err := pipeline.Execute(testRequestCtx(), nil, nil)
+	if err != nil || res != "ok" {
+		t.Fatalf("Execute: %q, %v, want %q, nil", res, err, "ok")
+	}
+
+	want := []attemptInfo{
+		{model: "gpt-4o"},
+		{model: "gpt-4o-mini"},
+		{model: "gpt-4o-mini", modelAgent: "agent-2"},
+	}
+
+	if len(attempts) != len(want) {
+		t.Fatalf("attempts: %+v, want %+v", attempts, want)
+	}
+
+	for i := range want {
+		if attempts[i] != want[i] {
+			t.Fatalf("attempt %d: %+v, want %+v", i, attempts[i], want[i])
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
@@ -30,6 +31,9 @@ func getGcpToken(ctx context.Context, key *model.Key, proxyUrl string) (string,
 		logger.Debugf(ctx, "getGcpToken time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "getGcpToken")
+	defer span.End()
+
 	adc := scommon.ApplicationDefaultCredentials{}
 	if err := json.Unmarshal([]byte(key.Key), &adc); err != nil {
 		logger.Errorf(ctx, "getGcpToken json.Unmarshal key: %s, error: %v", key.Key, err)
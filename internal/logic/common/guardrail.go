@@ -0,0 +1,713 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/lb"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 个人信息识别规则
+type piiRule struct {
+	typ     string
+	regexp  *regexp.Regexp
+	replace string
+}
+
+// 按顺序识别, 身份证号先于银行卡号
+var piiRules = []piiRule{
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{"id_card", regexp.MustCompile(`\b[1-9]\d{5}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`), "[ID_CARD]"},
+	{"bank_card", regexp.MustCompile(`\b\d{4}(?:[ \-]?\d{4}){2,3}(?:\d{1,3})?\b`), "[BANK_CARD]"},
+	{"phone", regexp.MustCompile(`(?:\+?86[ \-]?)?\b1[3-9]\d{9}\b`), "[PHONE]"},
+}
+
+// 原始响应中的输出文本字段, 如: choices[].message.content、content[].text、delta
+var outputKeys = []string{"content", "text", "delta", "reasoning_content", "thinking"}
+
+// 已编译的屏蔽正则, 无效的正则缓存为nil, 仅记录一次错误
+var blockedPatterns sync.Map
+
+// 调用审核模型, 测试时可替换
+var moderationUpstream = callModeration
+
+// 护栏, 应用密钥、应用、分组的护栏配置同时生效
+type Guardrail struct {
+	configs       []*mcommon.Guardrail
+	hits          []*mcommon.GuardrailHit
+	isInputMasked bool // 输入是否已脱敏, 原始请求体需同步脱敏
+}
+
+// 获取生效的护栏, 均未启用时返回nil
+func NewGuardrail(mak *MAK) *Guardrail {
+
+	guardrail := new(Guardrail)
+
+	if mak.AppKey != nil && mak.AppKey.Guardrail != nil && mak.AppKey.Guardrail.Open {
+		guardrail.configs = append(guardrail.configs, mak.AppKey.Guardrail)
+	}
+
+	if mak.App != nil && mak.App.Guardrail != nil && mak.App.Guardrail.Open {
+		guardrail.configs = append(guardrail.configs, mak.App.Guardrail)
+	}
+
+	if mak.Group != nil && mak.Group.Guardrail != nil && mak.Group.Guardrail.Open {
+		guardrail.configs = append(guardrail.configs, mak.Group.Guardrail)
+	}
+
+	if len(guardrail.configs) == 0 {
+		return nil
+	}
+
+	return guardrail
+}
+
+// 命中记录
+func (g *Guardrail) Hits() []*mcommon.GuardrailHit {
+
+	if g == nil {
+		return nil
+	}
+
+	return g.hits
+}
+
+func (g *Guardrail) hit(ctx context.Context, stage, rule, match, action string) {
+
+	logger.Infof(ctx, "Guardrail hit stage: %s, rule: %s, match: %s, action: %s", stage, rule, match, action)
+
+	g.hits = append(g.hits, &mcommon.GuardrailHit{
+		Stage:  stage,
+		Rule:   rule,
+		Match:  match,
+		Action: action,
+	})
+}
+
+// 是否检查输出
+func (g *Guardrail) IsCheckOutput() bool {
+
+	if g == nil {
+		return false
+	}
+
+	for _, config := range g.configs {
+		if config.IsCheckOutput {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 检查输入, 按屏蔽词、屏蔽正则、个人信息、审核模型的顺序检查, 返回个人信息脱敏后的消息
+func (g *Guardrail) CheckInput(ctx context.Context, mak *MAK, messages []smodel.ChatCompletionMessage) ([]smodel.ChatCompletionMessage, error) {
+
+	if g == nil {
+		return messages, nil
+	}
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Guardrail CheckInput time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	texts := make([]string, 0, len(messages))
+	for _, message := range messages {
+		texts = append(texts, messageText(message.Content))
+	}
+
+	text := strings.Join(texts, "\n")
+
+	if err := g.checkBlocked(ctx, "input", text); err != nil {
+		return messages, err
+	}
+
+	if piiTypes, isBlock := g.piiTypes(); len(piiTypes) > 0 {
+
+		if isBlock {
+			for _, rule := range piiRules {
+				if slices.Contains(piiTypes, rule.typ) && rule.match(text) {
+					g.hit(ctx, "input", "pii", rule.typ, "block")
+					return messages, errors.ERR_GUARDRAIL_PII_DETECTED
+				}
+			}
+		} else {
+
+			hits := len(g.hits)
+
+			masked := make([]smodel.ChatCompletionMessage, len(messages))
+			for i, message := range messages {
+				message.Content = g.maskContent(ctx, "input", piiTypes, message.Content)
+				masked[i] = message
+			}
+
+			messages = masked
+			g.isInputMasked = len(g.hits) > hits
+		}
+	}
+
+	if moderationModel := g.moderationModel(); moderationModel != "" {
+		if err := g.moderate(ctx, mak, moderationModel, messages); err != nil {
+			return messages, err
+		}
+	}
+
+	return messages, nil
+}
+
+// 检查输出, 命中屏蔽词或屏蔽正则时拦截
+func (g *Guardrail) CheckOutput(ctx context.Context, text string) error {
+
+	if !g.IsCheckOutput() {
+		return nil
+	}
+
+	return g.checkBlocked(ctx, "output", text)
+}
+
+// 原始请求体个人信息脱敏, 仅在输入已脱敏时处理, 命中已在检查输入时记录
+func (g *Guardrail) MaskBody(body []byte) []byte {
+
+	if g == nil || !g.isInputMasked || body == nil {
+		return body
+	}
+
+	piiTypes, _ := g.piiTypes()
+
+	body, _ = replaceJson(body, nil, func(text string) string {
+
+		// 跳过 data URL, 如: base64 图片
+		if strings.HasPrefix(text, "data:") {
+			return text
+		}
+
+		masked, _ := maskPii(piiTypes, text)
+
+		return masked
+	})
+
+	return body
+}
+
+// 检查非流式输出, 命中屏蔽规则时拦截, 个人信息按配置脱敏, 透传的原始响应同步脱敏
+func (g *Guardrail) CheckCompletion(ctx context.Context, response *smodel.ChatCompletionResponse) error {
+
+	if !g.IsCheckOutput() {
+		return nil
+	}
+
+	texts := make([]string, 0)
+	for _, choice := range response.Choices {
+		if choice.Message != nil {
+			texts = append(texts, messageText(choice.Message.Content))
+		}
+	}
+
+	// 原始响应中的输出文本, 如: 旧版补全的 choices[].text
+	if response.ResponseBytes != nil {
+		replaceJson(response.ResponseBytes, outputKeys, func(text string) string {
+			texts = append(texts, text)
+			return text
+		})
+	}
+
+	if err := g.checkBlocked(ctx, "output", strings.Join(texts, "\n")); err != nil {
+		return err
+	}
+
+	piiTypes, _ := g.piiTypes()
+	if len(piiTypes) == 0 {
+		return nil
+	}
+
+	hits := len(g.hits)
+
+	for _, choice := range response.Choices {
+		if choice.Message != nil {
+			choice.Message.Content = g.maskContent(ctx, "output", piiTypes, choice.Message.Content)
+		}
+	}
+
+	if response.ResponseBytes != nil {
+
+		// 已在消息脱敏时记录命中的, 原始响应不再重复记录
+		isHit := len(g.hits) > hits
+
+		response.ResponseBytes, _ = replaceJson(response.ResponseBytes, outputKeys, func(text string) string {
+
+			if isHit {
+				masked, _ := maskPii(piiTypes, text)
+				return masked
+			}
+
+			return g.maskText(ctx, "output", piiTypes, text)
+		})
+	}
+
+	return nil
+}
+
+// 屏蔽词和屏蔽正则
+func (g *Guardrail) checkBlocked(ctx context.Context, stage, text string) error {
+
+	blockedErr := errors.ERR_GUARDRAIL_BLOCKED
+	if stage == "output" {
+		blockedErr = errors.ERR_GUARDRAIL_OUTPUT_BLOCKED
+	}
+
+	lower := strings.ToLower(text)
+
+	for _, config := range g.configs {
+
+		for _, term := range config.BlockedTerms {
+			if term != "" && strings.Contains(lower, strings.ToLower(term)) {
+				g.hit(ctx, stage, "blocked_term", term, "block")
+				return blockedErr
+			}
+		}
+
+		for _, pattern := range config.BlockedPatterns {
+
+			re := blockedPattern(ctx, pattern)
+			if re == nil {
+				continue
+			}
+
+			if re.MatchString(text) {
+				g.hit(ctx, stage, "blocked_pattern", pattern, "block")
+				return blockedErr
+			}
+		}
+	}
+
+	return nil
+}
+
+// 编译屏蔽正则, 按正则缓存
+func blockedPattern(ctx context.Context, pattern string) *regexp.Regexp {
+
+	if value, ok := blockedPatterns.Load(pattern); ok {
+		return value.(*regexp.Regexp)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logger.Errorf(ctx, "Guardrail invalid pattern: %s, error: %v", pattern, err)
+		re = nil
+	}
+
+	blockedPatterns.Store(pattern, re)
+
+	return re
+}
+
+// 个人信息类型, 任一配置为拦截时拦截
+func (g *Guardrail) piiTypes() (piiTypes []string, isBlock bool) {
+
+	for _, config := range g.configs {
+
+		if len(config.PiiTypes) == 0 {
+			continue
+		}
+
+		for _, piiType := range config.PiiTypes {
+			if !slices.Contains(piiTypes, piiType) {
+				piiTypes = append(piiTypes, piiType)
+			}
+		}
+
+		if config.PiiAction == 2 {
+			isBlock = true
+		}
+	}
+
+	return piiTypes, isBlock
+}
+
+// 审核模型, 优先级: 应用密钥 > 应用 > 分组
+func (g *Guardrail) moderationModel() string {
+
+	for _, config := range g.configs {
+		if config.ModerationModel != "" {
+			return config.ModerationModel
+		}
+	}
+
+	return ""
+}
+
+// 调用审核模型预检用户消息
+func (g *Guardrail) moderate(ctx context.Context, mak *MAK, moderationModel string, messages []smodel.ChatCompletionMessage) error {
+
+	texts := make([]string, 0)
+	for _, message := range messages {
+		if message.Role == sconsts.ROLE_USER {
+			if text := messageText(message.Content); text != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+
+	if len(texts) == 0 {
+		return nil
+	}
+
+	response, err := moderationUpstream(ctx, mak, smodel.ModerationRequest{
+		Model: moderationModel,
+		Input: strings.Join(texts, "\n"),
+	})
+
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	for _, result := range gjson.New(gjson.MustEncode(response)).GetJsons("results") {
+
+		if !result.Get("flagged").Bool() {
+			continue
+		}
+
+		categories := make([]string, 0)
+		for category, flagged := range result.Get("categories").Map() {
+			if gconv.Bool(flagged) {
+				categories = append(categories, category)
+			}
+		}
+
+		slices.Sort(categories)
+
+		g.hit(ctx, "input", "moderation", strings.Join(categories, ","), "block")
+
+		return errors.ERR_GUARDRAIL_MODERATION_FLAGGED
+	}
+
+	return nil
+}
+
+// 直接调用审核模型, 不经过路由, 不影响当前请求的会话、速率限制、预授权额度和后备状态, 审核请求单独计费和记录日志
+func callModeration(ctx context.Context, mak *MAK, request smodel.ModerationRequest) (response smodel.ModerationResponse, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Guardrail callModeration time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	moderationMak, err := newModerationMAK(ctx, mak, request.Model)
+	if err != nil {
+		logger.Error(ctx, err)
+		return response, err
+	}
+
+	params := request
+	params.Model = (&Attempt{Mak: moderationMak, name: "Guardrail callModeration"}).ReplaceModel(ctx, params.Model)
+
+	response, err = NewModerationClient(ctx, moderationMak).TextModerations(ctx, params)
+
+	// 审核请求单独计费和记录日志
+	after := &mcommon.AfterHandler{
+		ModerationReq: request,
+		Action:        consts.ACTION_MODERATIONS,
+		Usage:         response.Usage,
+		TotalTime:     response.TotalTime,
+		Error:         err,
+		IsGuardrail:   true,
+		EnterTime:     now,
+	}
+
+	if response.Results != nil {
+		after.Completion = gconv.String(response.Results)
+	}
+
+	after.InternalTime = gtime.TimestampMilli() - now - after.TotalTime
+
+	if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+		AfterHandler(ctx, moderationMak, after)
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	return response, err
+}
+
+// 审核模型的MAK, 在当前分组中确定模型后直接选取模型代理和密钥
+func newModerationMAK(ctx context.Context, mak *MAK, moderationModel string) (*MAK, error) {
+
+	reqModel, err := service.Model().GetModelByGroup(ctx, moderationModel, mak.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	realModel := *reqModel
+
+	moderationMak := &MAK{
+		Model:     moderationModel,
+		Endpoint:  consts.ENDPOINT_MODERATIONS,
+		ReqModel:  reqModel,
+		RealModel: &realModel,
+		User:      mak.User,
+		App:       mak.App,
+		AppKey:    mak.AppKey,
+		Group:     mak.Group,
+	}
+
+	modelAgentIds := reqModel.ModelAgents
+	if mak.Group.IsEnableModelAgent {
+		modelAgentIds = mak.Group.ModelAgents
+	}
+
+	modelAgents, err := service.ModelAgent().GetCacheList(ctx, modelAgentIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	modelAgentList := make([]*model.ModelAgent, 0)
+	for _, modelAgent := range modelAgents {
+		// 过滤被禁用和不支持审核接口的模型代理
+		if modelAgent.Status == 1 && (len(modelAgent.Endpoints) == 0 || slices.Contains(modelAgent.Endpoints, consts.ENDPOINT_MODERATIONS)) {
+			modelAgentList = append(modelAgentList, modelAgent)
+		}
+	}
+
+	if moderationMak.ModelAgent = lb.NewModelAgentWeight(modelAgentList).PickModelAgent(); moderationMak.ModelAgent == nil {
+		return nil, errors.ERR_NO_AVAILABLE_MODEL_AGENT
+	}
+
+	moderationMak.AgentTotal = len(modelAgentList)
+	moderationMak.Provider = moderationMak.ModelAgent.ProviderId
+	moderationMak.BaseUrl = moderationMak.ModelAgent.BaseUrl
+	moderationMak.Path = moderationMak.ModelAgent.Path
+
+	if moderationMak.KeyTotal, moderationMak.Key, err = service.ModelAgent().PickKey(ctx, moderationMak.ModelAgent); err != nil {
+		return nil, err
+	}
+
+	if err = getRealKey(ctx, moderationMak); err != nil {
+		return nil, err
+	}
+
+	moderationMak.Passthrough = GetEffectivePassthrough(ctx, reqModel, moderationMak.ModelAgent)
+
+	return moderationMak, nil
+}
+
+// 消息内容脱敏, 多模态内容仅处理文本部分
+func (g *Guardrail) maskContent(ctx context.Context, stage string, piiTypes []string, content any) any {
+
+	switch value := content.(type) {
+	case string:
+		return g.maskText(ctx, stage, piiTypes, value)
+	case []any:
+
+		contents := make([]any, 0, len(value))
+		for _, part := range value {
+
+			if m, ok := part.(map[string]any); ok && m["type"] == "text" {
+
+				copied := make(map[string]any, len(m))
+				for k, v := range m {
+					copied[k] = v
+				}
+
+				copied["text"] = g.maskText(ctx, stage, piiTypes, gconv.String(m["text"]))
+				part = copied
+			}
+
+			contents = append(contents, part)
+		}
+
+		return contents
+	}
+
+	return content
+}
+
+func (g *Guardrail) maskText(ctx context.Context, stage string, piiTypes []string, text string) string {
+
+	masked, types := maskPii(piiTypes, text)
+
+	for _, typ := range types {
+		g.hit(ctx, stage, "pii", typ, "mask")
+	}
+
+	return masked
+}
+
+// 个人信息脱敏, 返回脱敏后的文本和命中的类型
+func maskPii(piiTypes []string, text string) (string, []string) {
+
+	types := make([]string, 0)
+
+	for _, rule := range piiRules {
+
+		if !slices.Contains(piiTypes, rule.typ) {
+			continue
+		}
+
+		masked := rule.regexp.ReplaceAllStringFunc(text, func(match string) string {
+
+			if !rule.isValid(match) {
+				return match
+			}
+
+			return rule.replace
+		})
+
+		if masked != text {
+			types = append(types, rule.typ)
+			text = masked
+		}
+	}
+
+	return text, types
+}
+
+// 替换JSON中的字符串, keys 为空时处理全部字符串, 否则仅处理指定字段, 返回是否有替换, 未替换时返回原数据
+func replaceJson(data []byte, keys []string, replace func(text string) string) ([]byte, bool) {
+
+	var value any
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&value); err != nil {
+		return data, false
+	}
+
+	isReplaced := false
+
+	value = walkJson(value, "", keys, func(text string) string {
+
+		replaced := replace(text)
+		if replaced != text {
+			isReplaced = true
+		}
+
+		return replaced
+	})
+
+	if !isReplaced {
+		return data, false
+	}
+
+	replaced, err := json.Marshal(value)
+	if err != nil {
+		return data, false
+	}
+
+	return replaced, true
+}
+
+// 遍历JSON值替换字符串, 数组元素沿用数组的字段
+func walkJson(value any, key string, keys []string, replace func(text string) string) any {
+
+	switch v := value.(type) {
+	case string:
+		if len(keys) == 0 || slices.Contains(keys, key) {
+			return replace(v)
+		}
+	case map[string]any:
+		for k, item := range v {
+			v[k] = walkJson(item, k, keys, replace)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = walkJson(item, key, keys, replace)
+		}
+	}
+
+	return value
+}
+
+// 是否包含个人信息
+func (rule piiRule) match(text string) bool {
+
+	for _, match := range rule.regexp.FindAllString(text, -1) {
+		if rule.isValid(match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 银行卡号按校验位过滤误识别
+func (rule piiRule) isValid(match string) bool {
+	return rule.typ != "bank_card" || isLuhn(match)
+}
+
+// 消息内容的文本
+func messageText(content any) string {
+
+	switch value := content.(type) {
+	case string:
+		return value
+	case []any:
+
+		texts := make([]string, 0)
+		for _, part := range value {
+			if m, ok := part.(map[string]any); ok && m["type"] == "text" {
+				texts = append(texts, gconv.String(m["text"]))
+			}
+		}
+
+		return strings.Join(texts, "\n")
+	}
+
+	return gconv.String(content)
+}
+
+// 银行卡号校验
+func isLuhn(number string) bool {
+
+	digits := make([]int, 0, len(number))
+	for _, c := range number {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+
+	if len(digits) < 13 {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+
+		digit := digits[i]
+
+		if (len(digits)-1-i)%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// 是否输出被护栏拦截, 按已输出内容计费
+func IsOutputBlocked(err error) bool {
+	return err != nil && errors.Is(err, errors.ERR_GUARDRAIL_OUTPUT_BLOCKED)
+}
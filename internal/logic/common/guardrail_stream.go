@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/gogf/gf/v2/util/gconv"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+)
+
+const (
+	guardrailWindowSize = 512 // 屏蔽词检查窗口长度, 跨数据块的屏蔽词在窗口内检查
+	guardrailHoldSize   = 64  // 暂缓输出的最大长度, 超出部分直接输出
+)
+
+// 可能为未完整输出的个人信息的末尾内容, 如: 邮箱、以空格或连字符分隔的号码
+var piiTail = regexp.MustCompile(`(?:[A-Za-z0-9._%+@\-]|\d[ \-])+$`)
+
+// 流式输出护栏, 屏蔽词在已输出内容的末尾窗口内检查,
+// 末尾可能为跨数据块个人信息的内容暂缓输出, 与后续数据块合并后脱敏
+type GuardrailStream struct {
+	guardrail *Guardrail
+	piiTypes  []string
+	window    string                         // 已检查内容的末尾
+	pending   string                         // 暂缓输出的内容, 未脱敏
+	template  *smodel.ChatCompletionResponse // 最近的内容数据块, 补发暂缓内容时使用
+	text      string                         // 模板数据块原始响应中的内容
+}
+
+// 流式输出护栏, 不检查输出时返回nil
+func (g *Guardrail) Stream() *GuardrailStream {
+
+	if !g.IsCheckOutput() {
+		return nil
+	}
+
+	piiTypes, _ := g.piiTypes()
+
+	return &GuardrailStream{
+		guardrail: g,
+		piiTypes:  piiTypes,
+	}
+}
+
+// 检查数据块, 命中屏蔽规则时拦截, 脱敏并改写数据块的内容和原始响应, 返回需在当前数据块前补发暂缓内容的数据块
+func (s *GuardrailStream) Chunk(ctx context.Context, response *smodel.ChatCompletionResponse) (*smodel.ChatCompletionResponse, error) {
+
+	if s == nil {
+		return nil, nil
+	}
+
+	if len(response.Choices) == 0 || response.Choices[0].Delta == nil || response.Choices[0].Delta.Content == "" {
+
+		// 非内容数据块的原始响应可能包含完整输出, 如: response.output_text.done
+		if len(s.piiTypes) > 0 && response.ResponseBytes != nil {
+			response.ResponseBytes, _ = replaceJson(response.ResponseBytes, outputKeys, func(text string) string {
+				masked, _ := maskPii(s.piiTypes, text)
+				return masked
+			})
+		}
+
+		return s.Flush(ctx), nil
+	}
+
+	choice := response.Choices[0]
+	text := choice.Delta.Content
+
+	if s.window += text; len(s.window) > guardrailWindowSize {
+		s.window = s.window[len(s.window)-guardrailWindowSize:]
+	}
+
+	if err := s.guardrail.CheckOutput(ctx, s.window); err != nil {
+		return nil, err
+	}
+
+	if len(s.piiTypes) == 0 {
+		return nil, nil
+	}
+
+	masked := s.guardrail.maskText(ctx, "output", s.piiTypes, s.pending+text)
+
+	// 最后的数据块不再暂缓
+	hold := ""
+	if gconv.String(choice.FinishReason) == "" {
+		if hold = piiTail.FindString(masked); len(hold) > guardrailHoldSize {
+			hold = hold[len(hold)-guardrailHoldSize:]
+		}
+	}
+
+	output := masked[:len(masked)-len(hold)]
+
+	if response.ResponseBytes != nil {
+
+		data, ok := replaceText(response.ResponseBytes, text, output)
+		if !ok {
+
+			// 原始响应中未找到内容, 补发暂缓内容后按数据块脱敏
+			flush := s.Flush(ctx)
+
+			response.ResponseBytes, _ = replaceJson(response.ResponseBytes, outputKeys, func(text string) string {
+				masked, _ := maskPii(s.piiTypes, text)
+				return masked
+			})
+
+			choice.Delta.Content, _ = maskPii(s.piiTypes, text)
+
+			return flush, nil
+		}
+
+		s.template = contentChunk(response, text)
+		s.template.ResponseBytes = response.ResponseBytes
+		response.ResponseBytes = data
+
+	} else {
+		s.template = contentChunk(response, text)
+	}
+
+	s.text = text
+	s.pending = hold
+	choice.Delta.Content = output
+
+	return nil, nil
+}
+
+// 补发暂缓内容的数据块, 无暂缓内容时返回nil, 流结束前调用
+func (s *GuardrailStream) Flush(ctx context.Context) *smodel.ChatCompletionResponse {
+
+	if s == nil || s.pending == "" || s.template == nil {
+		return nil
+	}
+
+	chunk := contentChunk(s.template, s.pending)
+
+	if s.template.ResponseBytes != nil {
+		chunk.ResponseBytes, _ = replaceText(s.template.ResponseBytes, s.text, s.pending)
+	}
+
+	s.pending = ""
+
+	return chunk
+}
+
+// 复制数据块, 内容替换为指定内容, 不含用量和结束原因
+func contentChunk(response *smodel.ChatCompletionResponse, content string) *smodel.ChatCompletionResponse {
+
+	chunk := *response
+	chunk.Usage = nil
+
+	choice := response.Choices[0]
+	choice.FinishReason = ""
+
+	delta := *choice.Delta
+	delta.Content = content
+	choice.Delta = &delta
+
+	chunk.Choices = append(response.Choices[:0:0], choice)
+
+	return &chunk
+}
+
+// 替换原始响应中首个与内容相同的输出文本, 返回是否找到
+func replaceText(data []byte, text, replaced string) ([]byte, bool) {
+
+	isFound := false
+
+	data, _ = replaceJson(data, outputKeys, func(value string) string {
+
+		if isFound || value != text {
+			return value
+		}
+
+		isFound = true
+
+		return replaced
+	})
+
+	return data, isFound
+}
@@ -0,0 +1,233 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+)
+
+// 模拟审核模型, 记录请求并返回指定结果
+type fakeModeration struct {
+	response string
+	err      error
+	requests []smodel.ModerationRequest
+}
+
+func (f *fakeModeration) call(ctx context.Context, mak *MAK, request smodel.ModerationRequest) (response smodel.ModerationResponse, err error) {
+
+	f.requests = append(f.requests, request)
+
+	if f.err != nil {
+		return response, f.err
+	}
+
+	if err = gjson.Unmarshal([]byte(f.response), &response); err != nil {
+		return response, err
+	}
+
+	return response, nil
+}
+
+func TestGuardrailModerate(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		config    *mcommon.Guardrail
+		messages  []smodel.ChatCompletionMessage
+		response  string
+		upstream  error
+		wantErr   error
+		wantCalls int
+		wantInput string // 发送给审核模型的内容
+		wantHits  []string
+	}{
+		{
+			name:      "flagged",
+			config:    &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest"},
+			messages:  []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_SYSTEM, Content: "you are a helper"}, {Role: sconsts.ROLE_USER, Content: "bad words"}},
+			response:  `{"results":[{"flagged":true,"categories":{"violence":true,"hate":true,"sexual":false}}]}`,
+			wantErr:   errors.ERR_GUARDRAIL_MODERATION_FLAGGED,
+			wantCalls: 1,
+			wantInput: "bad words",
+			wantHits:  []string{"input/moderation/hate,violence/block"},
+		},
+		{
+			name:      "not flagged",
+			config:    &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest"},
+			messages:  []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_USER, Content: "hello"}, {Role: sconsts.ROLE_ASSISTANT, Content: "hi"}, {Role: sconsts.ROLE_USER, Content: "how are you"}},
+			response:  `{"results":[{"flagged":false,"categories":{"violence":false}}]}`,
+			wantCalls: 1,
+			wantInput: "hello\nhow are you",
+		},
+		{
+			name:      "masked before moderation",
+			config:    &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest", PiiTypes: []string{"email"}, PiiAction: 1},
+			messages:  []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_USER, Content: "mail john@example.com"}},
+			response:  `{"results":[{"flagged":false}]}`,
+			wantCalls: 1,
+			wantInput: "mail [EMAIL]",
+			wantHits:  []string{"input/pii/email/mask"},
+		},
+		{
+			name:      "upstream error",
+			config:    &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest"},
+			messages:  []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_USER, Content: "hello"}},
+			upstream:  errUpstream,
+			wantErr:   errUpstream,
+			wantCalls: 1,
+			wantInput: "hello",
+		},
+		{
+			name:     "no user message",
+			config:   &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest"},
+			messages: []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_SYSTEM, Content: "you are a helper"}},
+		},
+		{
+			name:     "blocked before moderation",
+			config:   &mcommon.Guardrail{Open: true, ModerationModel: "omni-moderation-latest", BlockedTerms: []string{"secret"}},
+			messages: []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_USER, Content: "tell me the SECRET"}},
+			wantErr:  errors.ERR_GUARDRAIL_BLOCKED,
+			wantHits: []string{"input/blocked_term/secret/block"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fake := &fakeModeration{response: tt.response, err: tt.upstream}
+
+			moderationUpstream = fake.call
+			defer func() {
+				moderationUpstream = callModeration
+			}()
+
+			guardrail := &Guardrail{configs: []*mcommon.Guardrail{tt.config}}
+
+			_, err := guardrail.CheckInput(context.Background(), &MAK{}, tt.messages)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(fake.requests) != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", len(fake.requests), tt.wantCalls)
+			}
+
+			if tt.wantCalls > 0 {
+
+				if fake.requests[0].Model != tt.config.ModerationModel {
+					t.Errorf("model = %s, want %s", fake.requests[0].Model, tt.config.ModerationModel)
+				}
+
+				if input := fake.requests[0].Input; input != tt.wantInput {
+					t.Errorf("input = %v, want %s", input, tt.wantInput)
+				}
+			}
+
+			hits := make([]string, 0)
+			for _, hit := range guardrail.Hits() {
+				hits = append(hits, strings.Join([]string{hit.Stage, hit.Rule, hit.Match, hit.Action}, "/"))
+			}
+
+			if strings.Join(hits, ";") != strings.Join(tt.wantHits, ";") {
+				t.Errorf("hits = %v, want %v", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestGuardrailStream(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		config     *mcommon.Guardrail
+		chunks     []string
+		wantOutput string
+		wantErr    error
+	}{
+		{
+			name:       "email across chunks",
+			config:     &mcommon.Guardrail{Open: true, IsCheckOutput: true, PiiTypes: []string{"email"}, PiiAction: 1},
+			chunks:     []string{"contact john.d", "oe@exam", "ple.com for details"},
+			wantOutput: "contact [EMAIL] for details",
+		},
+		{
+			name:       "pending flushed at end",
+			config:     &mcommon.Guardrail{Open: true, IsCheckOutput: true, PiiTypes: []string{"email"}, PiiAction: 1},
+			chunks:     []string{"mail to ", "john@example.com", " or bob"},
+			wantOutput: "mail to [EMAIL] or bob",
+		},
+		{
+			name:    "blocked term across chunks",
+			config:  &mcommon.Guardrail{Open: true, IsCheckOutput: true, BlockedTerms: []string{"secret"}},
+			chunks:  []string{"the sec", "ret is"},
+			wantErr: errors.ERR_GUARDRAIL_OUTPUT_BLOCKED,
+		},
+		{
+			name:       "output not checked",
+			config:     &mcommon.Guardrail{Open: true, PiiTypes: []string{"email"}, PiiAction: 1},
+			chunks:     []string{"mail john@", "example.com"},
+			wantOutput: "mail john@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ctx := context.Background()
+			guardrail := (&Guardrail{configs: []*mcommon.Guardrail{tt.config}}).Stream()
+
+			output := ""
+			for _, content := range tt.chunks {
+
+				response := &smodel.ChatCompletionResponse{
+					Choices: []smodel.ChatCompletionChoice{{
+						Delta: &smodel.ChatCompletionStreamChoiceDelta{Content: content},
+					}},
+					ResponseBytes: gjson.MustEncode(map[string]any{"choices": []any{map[string]any{"delta": map[string]any{"content": content}}}}),
+				}
+
+				flush, err := guardrail.Chunk(ctx, response)
+				if err != nil {
+					if err != tt.wantErr {
+						t.Fatalf("err = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+
+				if flush != nil {
+					output += flush.Choices[0].Delta.Content
+				}
+
+				// 原始响应与数据块内容同步改写
+				if raw := gjson.New(response.ResponseBytes).Get("choices.0.delta.content").String(); raw != response.Choices[0].Delta.Content {
+					t.Fatalf("raw = %s, content = %s", raw, response.Choices[0].Delta.Content)
+				}
+
+				output += response.Choices[0].Delta.Content
+			}
+
+			if flush := guardrail.Flush(ctx); flush != nil {
+
+				if raw := gjson.New(flush.ResponseBytes).Get("choices.0.delta.content").String(); raw != flush.Choices[0].Delta.Content {
+					t.Fatalf("flush raw = %s, content = %s", raw, flush.Choices[0].Delta.Content)
+				}
+
+				output += flush.Choices[0].Delta.Content
+			}
+
+			if tt.wantErr != nil {
+				t.Fatalf("err = nil, want %v", tt.wantErr)
+			}
+
+			if output != tt.wantOutput {
+				t.Errorf("output = %s, want %s", output, tt.wantOutput)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/consts"
@@ -18,6 +19,7 @@ import (
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
 	"github.com/iimeta/fastapi/v2/utility/redis"
+	"github.com/iimeta/fastapi/v2/utility/util"
 )
 
 // 前置处理器
@@ -29,10 +31,19 @@ func AfterHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 
 	defer func() {
 
+		// 记录请求结果, 用于负载策略和熔断
+		if mak.ModelAgent != nil && mak.Key != nil {
+			service.ModelAgent().RecordResult(ctx, mak.RealModel, mak.Group, mak.ModelAgent, mak.Key, mak.inflight, after.ConnTime, isUpstreamFailure(after.Error))
+		}
+
 		if after.RetryInfo != nil {
+			service.Metrics().Retry(ctx, metricsLabels(ctx, mak, after))
 			return // 重试中间状态不记录
 		}
 
+		// 记录请求指标
+		service.Metrics().Request(ctx, metricsLabels(ctx, mak, after), after)
+
 		status := 1
 		if after.Error != nil {
 			status = -1
@@ -41,8 +52,40 @@ func AfterHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 		// 记录智能检查计数
 		recordSmartHealthCheck(ctx, mak, status)
 
-		// 记录会话保持
-		handleSessionKeep(ctx, mak, after)
+		// 护栏审核请求不影响所属请求的会话保持和速率限制
+		if !after.IsGuardrail {
+
+			// 记录会话保持
+			handleSessionKeep(ctx, mak, after)
+
+			// 按实际用量修正速率限制预扣Token
+			if !after.IsSmartMatch {
+				if after.Usage != nil {
+					service.RateLimit().Correct(ctx, after.Usage.TotalTokens)
+				} else {
+					service.RateLimit().Correct(ctx, 0)
+				}
+			}
+		}
+
+		// 未记录花费的释放预授权额度, 已记录的在记录完成后释放
+		if after.Spend.TotalSpendTokens == 0 || after.IsSmartMatch {
+			releaseQuotaHold(ctx, mak)
+		}
+
+		// 记录上游用量
+		if mak.ModelAgent != nil && mak.Key != nil {
+			if after.Usage != nil {
+				service.ModelAgent().RecordUpstreamUsage(ctx, mak.ModelAgent, mak.Key, after.Usage.TotalTokens, after.Spend.TotalSpendTokens)
+			} else {
+				service.ModelAgent().RecordUpstreamUsage(ctx, mak.ModelAgent, mak.Key, 0, after.Spend.TotalSpendTokens)
+			}
+		}
+
+		// 导出用量事件
+		if service.UsageExport().IsOpen() {
+			service.UsageExport().Export(ctx, usageEvent(ctx, mak, after))
+		}
 	}()
 
 	if after.IsFile {
@@ -54,7 +97,7 @@ func AfterHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 	}
 
 	switch mak.ReqModel.Type {
-	case 1, 100, 101, 102, 7, 103:
+	case 1, 100, 101, 102, 7, 103, 9:
 		textHandler(ctx, mak, after)
 	case 2, 3, 4:
 		imageHandler(ctx, mak, after)
@@ -69,7 +112,8 @@ func AfterHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 
 func textHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 
-	if after.RetryInfo == nil && (after.Error == nil || IsAborted(after.Error)) {
+	// 流中断或输出被护栏拦截时按已输出内容计费
+	if after.RetryInfo == nil && (after.Error == nil || IsAborted(after.Error) || IsStreamStalled(after.Error) || IsOutputBlocked(after.Error)) {
 
 		if after.ServiceTier == "" {
 			after.ServiceTier = after.ChatCompletionRes.ServiceTier
@@ -85,7 +129,12 @@ func textHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 			Completion:            after.Completion,
 			ServiceTier:           after.ServiceTier,
 			Usage:                 after.Usage,
-			IsAborted:             IsAborted(after.Error),
+			IsAborted:             IsAborted(after.Error) || IsStreamStalled(after.Error) || IsOutputBlocked(after.Error),
+			IsCacheHit:            mak.IsCacheHit(),
+			CacheRatio:            mak.cacheRatio(),
+			IsBatch:               IsBatchRequest(ctx),
+			RerankDocuments:       after.RerankDocuments,
+			RerankSearchUnits:     after.RerankSearchUnits,
 		}
 
 		if billingData.Completion == "" && len(after.ChatCompletionRes.Choices) > 0 && after.ChatCompletionRes.Choices[0].Message != nil {
@@ -178,6 +227,9 @@ func textHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 		RetryInfo:          after.RetryInfo,
 		Spend:              after.Spend,
 		IsSmartMatch:       after.IsSmartMatch,
+		IsCacheHit:         mak.IsCacheHit(),
+		Guardrails:         after.Guardrails,
+		PromptTemplate:     service.Session().GetPrompt(ctx),
 	})
 }
 
@@ -481,7 +533,9 @@ func fileHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 				ExpiresAt:    after.FileRes.ExpiresAt,
 				Status:       after.FileRes.Status,
 				FileUrl:      after.FileRes.FileUrl,
+				FilePath:     after.FilePath,
 				ResponseData: after.ResponseData,
+				IsNative:     after.IsNativeBatch,
 				Rid:          service.Session().GetRid(ctx),
 			}
 
@@ -551,7 +605,8 @@ func batchHandler(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 			logger.Error(ctx, err)
 		}
 
-		if after.Action == consts.ACTION_CREATE {
+		// 网关批处理任务已在创建时写入
+		if after.Action == consts.ACTION_CREATE && !after.IsNativeBatch {
 
 			taskBatch := do.TaskBatch{
 				TraceId:      gtrace.GetTraceID(ctx),
@@ -760,6 +815,10 @@ func recordSmartHealthCheck(ctx context.Context, mak *MAK, status int) {
 // 记录会话保持
 func handleSessionKeep(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) {
 
+	if service.Session().GetSessionKey(ctx) != nil && mak.ReqModel != nil {
+		service.Metrics().SessionKeep(ctx, mak.ReqModel.Model, service.Session().GetSessionKeepHit(ctx))
+	}
+
 	if after.Error == nil {
 		HandleSessionKeepSuccess(ctx, mak)
 		return
@@ -771,3 +830,107 @@ func handleSessionKeep(ctx context.Context, mak *MAK, after *mcommon.AfterHandle
 
 	HandleSessionKeepFailure(ctx, mak)
 }
+
+// 请求指标标签
+func metricsLabels(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) mcommon.MetricsLabels {
+
+	labels := mcommon.MetricsLabels{
+		Endpoint: mak.Endpoint,
+		Status:   "success",
+	}
+
+	if mak.ReqModel != nil {
+		labels.Model = mak.ReqModel.Model
+	}
+
+	if mak.RealModel != nil {
+		labels.RealModel = mak.RealModel.Model
+	}
+
+	if mak.Provider != "" {
+		labels.Provider = GetProviderCode(ctx, mak.Provider)
+	}
+
+	if mak.ModelAgent != nil {
+		labels.ModelAgent = mak.ModelAgent.Name
+	}
+
+	if after.Error != nil {
+		if IsAborted(after.Error) {
+			labels.Status = "aborted"
+		} else {
+			labels.Status = "error"
+		}
+	}
+
+	return labels
+}
+
+// 用量事件
+func usageEvent(ctx context.Context, mak *MAK, after *mcommon.AfterHandler) *model.UsageEvent {
+
+	labels := metricsLabels(ctx, mak, after)
+
+	event := &model.UsageEvent{
+		SchemaVersion:  consts.USAGE_EVENT_SCHEMA_VERSION,
+		EventId:        util.GenerateId(),
+		EventTime:      gtime.TimestampMilli(),
+		TraceId:        gtrace.GetTraceID(ctx),
+		Rid:            service.Session().GetRid(ctx),
+		UserId:         service.Session().GetUserId(ctx),
+		AppId:          service.Session().GetAppId(ctx),
+		GroupId:        after.Spend.GroupId,
+		GroupName:      after.Spend.GroupName,
+		Endpoint:       mak.Endpoint,
+		Action:         after.Action,
+		Model:          labels.Model,
+		RealModel:      labels.RealModel,
+		Provider:       labels.Provider,
+		ModelAgentName: labels.ModelAgent,
+		IsSmartMatch:   after.IsSmartMatch,
+		IsCacheHit:     mak.IsCacheHit(),
+		Spend:          after.Spend,
+		Status:         labels.Status,
+		Timings: model.UsageEventTimings{
+			ReqTime:      after.EnterTime,
+			ConnTime:     after.ConnTime,
+			Duration:     after.Duration,
+			TotalTime:    after.TotalTime,
+			InternalTime: after.InternalTime,
+		},
+	}
+
+	if mak.AppKey != nil {
+		event.AppKeyId = mak.AppKey.Id
+	}
+
+	if event.GroupId == "" && mak.Group != nil {
+		event.GroupId = mak.Group.Id
+		event.GroupName = mak.Group.Name
+	}
+
+	if mak.ModelAgent != nil {
+		event.ModelAgentId = mak.ModelAgent.Id
+	}
+
+	if mak.Key != nil {
+		event.KeyId = mak.Key.Id
+	}
+
+	if after.Usage != nil {
+		event.Tokens = model.UsageEventTokens{
+			Prompt:     after.Usage.PromptTokens,
+			Completion: after.Usage.CompletionTokens,
+			Total:      after.Usage.TotalTokens,
+			Cached:     after.Usage.PromptTokensDetails.CachedTokens,
+			CacheWrite: after.Usage.PromptTokensDetails.CacheWriteTokens,
+			Reasoning:  after.Usage.OutputTokensDetails.ReasoningTokens,
+		}
+	}
+
+	if after.Error != nil {
+		event.ErrMsg = after.Error.Error()
+	}
+
+	return event
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 对冲请求结果
+type HedgeResult[T any] struct {
+	Mak       *MAK  // 请求的模型代理和密钥
+	Result    T     // 请求结果
+	Err       error // 请求错误
+	TotalTime int64 // 从发起到就绪的耗时
+	cancel    context.CancelFunc
+}
+
+// 取消请求, 使用完请求结果后调用
+func (r *HedgeResult[T]) Cancel() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// 对冲请求的重试信息, 记录日志但不计费
+func (r *HedgeResult[T]) RetryInfo() *mcommon.Retry {
+
+	errMsg := "hedged request cancelled"
+	if r.Err != nil {
+		errMsg = r.Err.Error()
+	}
+
+	return &mcommon.Retry{
+		IsRetry: true,
+		IsHedge: true,
+		ErrMsg:  errMsg,
+	}
+}
+
+// 是否启用对冲请求
+func IsHedge(mak *MAK) bool {
+	return mak.RealModel != nil && mak.RealModel.IsEnableHedge && mak.RealModel.HedgeConfig != nil && mak.RealModel.HedgeConfig.Delay > 0 &&
+		mak.ModelAgent != nil && mak.AgentTotal > 1 && mak.FallbackModelAgent == nil
+}
+
+// 对冲请求, 首个请求在延迟时间内未就绪时向其它模型代理发起相同请求, 使用先成功就绪的结果并取消另一个
+// call 需在就绪后返回, 非流式为收到响应, 流式为收到首个数据块; loser 处理未被使用的结果, 如: 记录日志
+func Hedge[T any](ctx context.Context, mak *MAK, call func(ctx context.Context, mak *MAK) (T, error), loser func(ctx context.Context, result *HedgeResult[T])) *HedgeResult[T] {
+
+	results := make(chan *HedgeResult[T], 2)
+
+	start := func(mak *MAK) *HedgeResult[T] {
+
+		callCtx, cancel := context.WithCancel(ctx)
+		result := &HedgeResult[T]{Mak: mak, cancel: cancel}
+
+		go func() {
+
+			now := gtime.TimestampMilli()
+			defer func() {
+				if r := recover(); r != nil {
+					result.Err = fmt.Errorf("hedge panic: %v", r)
+				}
+				result.TotalTime = gtime.TimestampMilli() - now
+				results <- result
+			}()
+
+			result.Result, result.Err = call(callCtx, mak)
+		}()
+
+		return result
+	}
+
+	primary := start(mak)
+
+	timer := time.NewTimer(time.Duration(mak.RealModel.HedgeConfig.Delay) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-results:
+		return primary
+	case <-timer.C:
+	}
+
+	hedgeMak, err := mak.NewHedge(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		<-results
+		return primary
+	}
+
+	logger.Infof(ctx, "Hedge model: %s, model agent: %s not ready in %dms, hedge to model agent: %s", mak.RealModel.Model, mak.ModelAgent.Name, mak.RealModel.HedgeConfig.Delay, hedgeMak.ModelAgent.Name)
+
+	hedge := start(hedgeMak)
+
+	winner, other := primary, hedge
+	if first := <-results; first == hedge {
+		winner, other = hedge, primary
+	}
+
+	if winner.Err != nil {
+
+		// 先就绪的失败时等待另一个, 均失败时使用首个请求的结果进行重试
+		<-results
+
+		if other.Err == nil || other == primary {
+			winner, other = other, winner
+		}
+
+		handleHedgeLoser(ctx, other, loser)
+
+		return winner
+	}
+
+	other.Cancel()
+
+	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		<-results
+		handleHedgeLoser(ctx, other, loser)
+	}, nil); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	logger.Infof(ctx, "Hedge winner model agent: %s, total time: %d", winner.Mak.ModelAgent.Name, winner.TotalTime)
+
+	return winner
+}
+
+func handleHedgeLoser[T any](ctx context.Context, result *HedgeResult[T], loser func(ctx context.Context, result *HedgeResult[T])) {
+
+	defer result.Cancel()
+
+	if loser != nil {
+		loser(ctx, result)
+	}
+}
+
+// 创建对冲请求, 排除首个请求的模型代理重新挑选
+func (mak *MAK) NewHedge(ctx context.Context) (*MAK, error) {
+
+	// 记录为错误模型代理, 挑选时过滤
+	service.Session().RecordErrorModelAgent(ctx, mak.ModelAgent.Id)
+
+	hedge := &MAK{
+		Model:         mak.Model,
+		Endpoint:      mak.Endpoint,
+		Messages:      mak.Messages,
+		ReqModel:      mak.ReqModel,
+		FallbackModel: mak.FallbackModel,
+		User:          mak.User,
+		App:           mak.App,
+		AppKey:        mak.AppKey,
+		Group:         mak.Group,
+		HoldData:      mak.HoldData,
+		quotaHold:     mak.quotaHold, // 共用预授权, 由胜出的请求释放
+		responseCache: mak.responseCache,
+	}
+
+	if err := hedge.InitMAK(ctx); err != nil {
+		return nil, err
+	}
+
+	if hedge.ModelAgent.Id == mak.ModelAgent.Id {
+		return nil, errors.ERR_NO_AVAILABLE_MODEL_AGENT
+	}
+
+	return hedge, nil
+}
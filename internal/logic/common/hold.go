@@ -0,0 +1,296 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi-sdk/v2/tiktoken"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+// 读取当前额度并清理过期预授权后, 剩余额度扣除已预授权额度足够时才预授权
+// KEYS: [额度所在的Key, 预授权有序集合, 预授权额度哈希]
+// ARGV: [额度所在的字段, 预授权额度, 预授权成员, 当前时间, 过期时间, 过期毫秒数]
+// 返回: [结果(1:通过, 0:额度不足, -1:未设置额度), 已预授权额度, 当前额度]
+const holdScript = `
+local quota = tonumber(redis.call('HGET', KEYS[1], ARGV[1]))
+if quota == nil then
+	return {-1, 0, 0}
+end
+
+local amount = tonumber(ARGV[2])
+local member = ARGV[3]
+local now = tonumber(ARGV[4])
+
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+if #expired > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+	redis.call('HDEL', KEYS[3], unpack(expired))
+end
+
+local held = 0
+for _, value in ipairs(redis.call('HVALS', KEYS[3])) do
+	held = held + tonumber(value)
+end
+
+if quota - held < amount then
+	return {0, held, quota}
+end
+
+redis.call('ZADD', KEYS[2], tonumber(ARGV[5]), member)
+redis.call('HSET', KEYS[3], member, amount)
+redis.call('PEXPIRE', KEYS[2], tonumber(ARGV[6]))
+redis.call('PEXPIRE', KEYS[3], tonumber(ARGV[6]))
+
+return {1, held, quota}
+`
+
+// 释放预授权
+// KEYS: [预授权有序集合, 预授权额度哈希]
+// ARGV: [预授权成员]
+const releaseScript = `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`
+
+// 额度预授权
+type quotaHold struct {
+	member  string           // 预授权成员
+	amount  int              // 预授权额度
+	targets []*quotaHoldItem // 已预授权的对象
+	once    sync.Once
+}
+
+// 预授权对象
+type quotaHoldItem struct {
+	name     string // 预授权对象标识, 如: user:1
+	usageKey string // 额度所在的Key
+	field    string // 额度所在的字段
+	err      error  // 额度不足时返回的错误
+}
+
+// 预授权额度, 按请求参数预估最大花费, 在用户、代理商、应用、密钥和分组的剩余额度中预留
+func holdQuota(ctx context.Context, mak *MAK) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "holdQuota time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if mak.quotaHold != nil || mak.HoldData == nil || config.Cfg.Quota == nil || !config.Cfg.Quota.Hold {
+		return nil
+	}
+
+	r := g.RequestFromCtx(ctx)
+
+	// 重试和回退复用同一个预授权
+	if r != nil {
+		if hold, ok := r.GetCtxVar(consts.SESSION_QUOTA_HOLD).Val().(*quotaHold); ok {
+			mak.quotaHold = hold
+			return nil
+		}
+	}
+
+	_, span := gtrace.NewSpan(ctx, "holdQuota")
+	defer span.End()
+
+	amount := estimateQuota(ctx, mak)
+	if amount <= 0 {
+		return nil
+	}
+
+	hold := &quotaHold{
+		member: gtrace.GetTraceID(ctx),
+		amount: amount,
+	}
+
+	if hold.member == "" {
+		hold.member = grand.S(32)
+	}
+
+	items := []*quotaHoldItem{{
+		name:     fmt.Sprintf("user:%d", service.Session().GetUserId(ctx)),
+		usageKey: getUserUsageKey(ctx),
+		field:    consts.USER_QUOTA_FIELD,
+		err:      errors.ERR_INSUFFICIENT_QUOTA,
+	}}
+
+	if rid := service.Session().GetRid(ctx); rid != 0 {
+		items = append(items, &quotaHoldItem{
+			name:     fmt.Sprintf("reseller:%d", rid),
+			usageKey: getResellerUsageKey(ctx),
+			field:    consts.RESELLER_QUOTA_FIELD,
+			err:      errors.ERR_RESELLER_INSUFFICIENT_QUOTA,
+		})
+	}
+
+	if service.Session().GetAppIsLimitQuota(ctx) {
+		items = append(items, &quotaHoldItem{
+			name:     fmt.Sprintf("app:%d", service.Session().GetAppId(ctx)),
+			usageKey: getUserUsageKey(ctx),
+			field:    getAppTotalTokensField(ctx),
+			err:      errors.ERR_INSUFFICIENT_QUOTA,
+		})
+	}
+
+	if service.Session().GetKeyIsLimitQuota(ctx) {
+		items = append(items, &quotaHoldItem{
+			name:     "key:" + service.Session().GetSecretKey(ctx),
+			usageKey: getUserUsageKey(ctx),
+			field:    getAppKeyTotalTokensField(ctx),
+			err:      errors.ERR_INSUFFICIENT_QUOTA,
+		})
+	}
+
+	if mak.Group != nil && mak.Group.IsLimitQuota {
+		items = append(items, &quotaHoldItem{
+			name:     "group:" + mak.Group.Id,
+			usageKey: consts.API_GROUP_USAGE_KEY,
+			field:    mak.Group.Id,
+			err:      errors.ERR_GROUP_INSUFFICIENT_QUOTA,
+		})
+	}
+
+	expire := holdExpire()
+
+	for _, item := range items {
+
+		// 读取额度和预授权在同一脚本中执行
+		reply, err := redis.Eval(ctx, holdScript, append([]string{item.usageKey}, holdKeys(item)...), item.field, amount, hold.member, gtime.TimestampMilli(), gtime.TimestampMilli()+expire, expire)
+		if err != nil {
+			// 预授权异常时放行, 不影响正常请求
+			logger.Error(ctx, err)
+			continue
+		}
+
+		values := reply.Ints()
+		if len(values) != 3 {
+			logger.Errorf(ctx, "holdQuota unexpected reply: %s", reply.String())
+			continue
+		}
+
+		if values[0] == -1 {
+			continue
+		}
+
+		if values[0] != 1 {
+			logger.Errorf(ctx, "holdQuota %s quota: %d, held: %d, amount: %d", item.name, values[2], values[1], amount)
+			hold.release(ctx)
+			return item.err
+		}
+
+		hold.targets = append(hold.targets, item)
+	}
+
+	logger.Infof(ctx, "holdQuota member: %s, amount: %d, targets: %d", hold.member, amount, len(hold.targets))
+
+	mak.quotaHold = hold
+
+	if r != nil {
+		r.SetCtxVar(consts.SESSION_QUOTA_HOLD, hold)
+	}
+
+	return nil
+}
+
+// 释放预授权额度, 实际花费由RecordSpend记录
+func releaseQuotaHold(ctx context.Context, mak *MAK) {
+	if mak.quotaHold != nil {
+		mak.quotaHold.once.Do(func() {
+			mak.quotaHold.release(ctx)
+		})
+	}
+}
+
+func (hold *quotaHold) release(ctx context.Context) {
+
+	for _, item := range hold.targets {
+		if _, err := redis.Eval(ctx, releaseScript, holdKeys(item), hold.member); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	hold.targets = nil
+}
+
+// 预估最大花费
+func estimateQuota(ctx context.Context, mak *MAK) (quota int) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf(ctx, "estimateQuota panic: %v", r)
+			quota = 0
+		}
+	}()
+
+	billingData := *mak.HoldData
+
+	// 按提示词Token数和最大输出Token数预估, 多个回复(n)时输出按回复数累计
+	if len(billingData.ChatCompletionRequest.Messages) > 0 {
+
+		model := mak.ReqModel.Model
+		if !tiktoken.IsEncodingForModel(model) {
+			model = consts.DEFAULT_MODEL
+		}
+
+		promptTokens := TokensFromMessages(ctx, model, billingData.ChatCompletionRequest.Messages)
+
+		maxTokens := billingData.ChatCompletionRequest.MaxTokens
+		if maxTokens == 0 {
+			maxTokens = gconv.Int(billingData.ChatCompletionRequest.MaxCompletionTokens)
+		}
+
+		if mak.RealModel.IsEnablePresetConfig && mak.RealModel.PresetConfig.MaxTokens != 0 && (maxTokens == 0 || maxTokens > mak.RealModel.PresetConfig.MaxTokens) {
+			maxTokens = mak.RealModel.PresetConfig.MaxTokens
+		}
+
+		if maxTokens == 0 {
+			maxTokens = config.Cfg.Quota.HoldMaxTokens
+		}
+
+		if n := billingData.ChatCompletionRequest.N; n > 1 {
+			maxTokens *= n
+		}
+
+		billingData.Usage = &smodel.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: maxTokens,
+			TotalTokens:      promptTokens + maxTokens,
+		}
+	}
+
+	return Billing(ctx, mak, &billingData).TotalSpendTokens
+}
+
+// 以额度所在的Key作为哈希标签, 与额度在同一槽位, 兼容集群模式
+func holdKeys(item *quotaHoldItem) []string {
+	return []string{fmt.Sprintf(consts.QUOTA_HOLD_KEY, item.usageKey, item.field), fmt.Sprintf(consts.QUOTA_HOLD_AMOUNT_KEY, item.usageKey, item.field)}
+}
+
+// 预授权过期毫秒数, 防止异常退出时预授权无法释放
+func holdExpire() int64 {
+
+	if config.Cfg.Quota.HoldExpire > 0 {
+		return int64(config.Cfg.Quota.HoldExpire * time.Second / time.Millisecond)
+	}
+
+	if config.Cfg.Base.LongTimeout > 0 {
+		return int64(config.Cfg.Base.LongTimeout*time.Second/time.Millisecond) + 60000
+	}
+
+	return 600000
+}
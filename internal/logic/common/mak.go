@@ -6,14 +6,16 @@ import (
 	"slices"
 
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
 	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
-	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/errors"
 	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
 	"github.com/iimeta/fastapi/v2/internal/service"
 	"github.com/iimeta/fastapi/v2/utility/logger"
 )
@@ -38,7 +40,14 @@ type MAK struct {
 	App                *model.App
 	AppKey             *model.AppKey
 	Group              *model.Group
-	Passthrough        *EffectivePassthrough // 有效透传配置
+	Passthrough        *EffectivePassthrough  // 有效透传配置
+	HoldData           *mcommon.BillingData   // 额度预授权预估数据
+	CacheRequest       any                    // 响应缓存请求数据, 为空时不缓存
+	quotaHold          *quotaHold             // 额度预授权
+	fallbackState      *mcommon.FallbackState // 后备链执行状态
+	fallbackHops       int                    // 路由时已切换的后备次数
+	inflight           string                 // 并发成员
+	responseCache      *responseCache         // 响应缓存
 }
 
 func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
@@ -48,6 +57,11 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		logger.Debugf(ctx, "MAK InitMAK time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	ctx, span := gtrace.NewSpan(ctx, "MAK InitMAK")
+	defer func() {
+		EndSpan(span, err)
+	}()
+
 	if mak.RealModel == nil {
 		mak.RealModel = new(model.Model)
 	}
@@ -175,6 +189,17 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		}
 	}
 
+	// 分组速率限制, 并按提示词预估预扣Token
+	promptTokens := 0
+	if len(mak.Messages) > 0 && service.RateLimit().IsLimitTokens(ctx, mak.Group) {
+		promptTokens = TokensFromMessages(ctx, mak.Model, mak.Messages)
+	}
+
+	if err = service.RateLimit().Charge(ctx, mak.Group, promptTokens); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	if mak.Endpoint != "" {
 
 		service.Session().SaveEndpoint(ctx, mak.Endpoint)
@@ -204,6 +229,18 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		}
 	}
 
+	// 命中响应缓存时直接返回
+	if lookupResponseCache(ctx, mak) {
+		mak.Provider = mak.RealModel.ProviderId
+		return nil
+	}
+
+	// 额度预授权
+	if err = holdQuota(ctx, mak); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	mak.Provider = mak.RealModel.ProviderId
 
 	if mak.Group != nil && mak.Group.IsEnableModelAgent {
@@ -254,19 +291,8 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 				if err != nil {
 					logger.Error(ctx, err)
 
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.FallbackModelAgent == nil {
-							if mak.FallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); mak.FallbackModelAgent != nil {
-								return mak.InitMAK(ctx)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && mak.FallbackModel == nil {
-							if mak.FallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); mak.FallbackModel != nil {
-								return mak.InitMAK(ctx)
-							}
-						}
+					if mak.switchFallback(NextFallback(ctx, mak, err, true, 0)) {
+						return mak.InitMAK(ctx)
 					}
 
 					return err
@@ -309,26 +335,24 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 			return mak.InitMAK(ctx)
 		}
 
-		service.ModelAgent().RecordError(ctx, mak.RealModel, mak.ModelAgent)
+		// 模型代理密钥均已达到上游限制或已熔断, 切换其它模型代理
+		if errors.Is(err, errors.ERR_UPSTREAM_RATE_LIMITED) || errors.Is(err, errors.ERR_CIRCUIT_BREAKER_OPEN) {
+			if mak.AgentTotal > 1 && !slices.Contains(service.Session().GetErrorModelAgents(ctx), mak.ModelAgent.Id) {
+				service.Session().RecordErrorModelAgent(ctx, mak.ModelAgent.Id)
+				mak.ModelAgent = nil
+				return mak.InitMAK(ctx)
+			}
+		} else {
+			service.ModelAgent().RecordError(ctx, mak.RealModel, mak.ModelAgent)
+		}
 
 		if errors.Is(err, errors.ERR_NO_AVAILABLE_MODEL_AGENT_KEY) {
 			service.ModelAgent().Disabled(ctx, mak.ModelAgent, errors.ERR_NO_AVAILABLE_MODEL_AGENT_KEY.(errors.IFastApiError).ErrMessage())
 			return mak.InitMAK(ctx)
 		}
 
-		if mak.RealModel.IsEnableFallback {
-
-			if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && mak.FallbackModelAgent == nil {
-				if mak.FallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); mak.FallbackModelAgent != nil {
-					return mak.InitMAK(ctx)
-				}
-			}
-
-			if mak.RealModel.FallbackConfig.Model != "" && mak.FallbackModel == nil {
-				if mak.FallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); mak.FallbackModel != nil {
-					return mak.InitMAK(ctx)
-				}
-			}
+		if mak.switchFallback(NextFallback(ctx, mak, err, true, 0)) {
+			return mak.InitMAK(ctx)
 		}
 
 		return err
@@ -338,7 +362,7 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		logger.Error(ctx, err)
 
 		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
 
 		isRetry, isDisabled := IsNeedRetry(err)
 
@@ -362,12 +386,40 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		return err
 	}
 
+	// 记录进行中的请求, 用于最少并发负载策略
+	mak.inflight = service.ModelAgent().AcquireInflight(ctx, mak.RealModel, mak.Group, mak.ModelAgent, mak.Key)
+
 	mak.Passthrough = GetEffectivePassthrough(ctx, mak.ReqModel, mak.ModelAgent)
 
 	return nil
 }
 
-func getRealKey(ctx context.Context, mak *MAK) error {
+// 路由时切换到后备, 后备与当前相同时不切换, 避免重复初始化
+func (mak *MAK) switchFallback(fallback *Fallback) bool {
+
+	if fallback == nil {
+		return false
+	}
+
+	isSameModelAgent := fallback.ModelAgent == nil || (mak.FallbackModelAgent != nil && mak.FallbackModelAgent.Id == fallback.ModelAgent.Id)
+	isSameModel := fallback.Model == nil || (mak.FallbackModel != nil && mak.FallbackModel.Id == fallback.Model.Id)
+
+	if isSameModelAgent && isSameModel {
+		return false
+	}
+
+	mak.FallbackModelAgent, mak.FallbackModel = fallback.ModelAgent, fallback.Model
+	mak.fallbackHops++
+
+	return true
+}
+
+func getRealKey(ctx context.Context, mak *MAK) (err error) {
+
+	ctx, span := gtrace.NewSpan(ctx, "MAK getRealKey")
+	defer func() {
+		EndSpan(span, err)
+	}()
 
 	provider := mak.RealModel.ProviderId
 
@@ -379,7 +431,7 @@ func getRealKey(ctx context.Context, mak *MAK) error {
 
 	if providerCode == sconsts.PROVIDER_GCP_CLAUDE || providerCode == sconsts.PROVIDER_GCP_GEMINI {
 
-		projectId, key, err := getGcpToken(ctx, mak.Key, config.Cfg.Http.ProxyUrl)
+		projectId, key, err := getGcpToken(ctx, mak.Key, upstreamProxyUrl(mak))
 		if err != nil {
 			logger.Error(ctx, err)
 			return err
@@ -400,10 +452,23 @@ func getRealKey(ctx context.Context, mak *MAK) error {
 		mak.Path = fmt.Sprintf(mak.Path, projectId, mak.RealModel.Model)
 
 	} else if providerCode == sconsts.PROVIDER_BAIDU {
-		mak.RealKey = getBaiduToken(ctx, mak.Key.Key, mak.BaseUrl, config.Cfg.Http.ProxyUrl)
+		mak.RealKey = getBaiduToken(ctx, mak.Key.Key, mak.BaseUrl, upstreamProxyUrl(mak), upstreamTransport(ctx, mak))
 	} else {
 		mak.RealKey = mak.Key.Key
 	}
 
 	return nil
 }
+
+// 设置上游路径, 模型代理未配置路径时使用请求端点, 基础地址以/v1结尾时去掉端点的/v1前缀
+func (mak *MAK) SetUpstreamPath(endpoint string) {
+
+	if mak.Path != "" {
+		return
+	}
+
+	mak.Path = endpoint
+	if gstr.HasSuffix(mak.BaseUrl, "/v1") && gstr.HasPrefix(endpoint, "/v1/") {
+		mak.Path = endpoint[3:]
+	}
+}
@@ -0,0 +1,277 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/utility/crypto"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+const (
+	maxTransports        = 256              // 缓存的传输层数量上限
+	transportIdleTimeout = 30 * time.Minute // 超过该时间未使用的传输层移除
+)
+
+// 传输层缓存, 按模型代理和模型缓存, 配置变更时替换
+type transportEntry struct {
+	digest    string // 配置摘要
+	transport *http.Transport
+	usedAt    time.Time
+}
+
+var (
+	transportsMutex sync.Mutex
+	transports      = make(map[string]*transportEntry) // [模型代理ID:模型ID]
+)
+
+// 上游网络配置, 模型配置覆盖代理配置
+func upstreamNetwork(mak *MAK) *mcommon.UpstreamNetwork {
+
+	var agentNetwork, modelNetwork *mcommon.UpstreamNetwork
+
+	if mak.ModelAgent != nil {
+		agentNetwork = mak.ModelAgent.Network
+	}
+
+	if mak.RealModel != nil {
+		modelNetwork = mak.RealModel.Network
+	}
+
+	if modelNetwork == nil {
+		return agentNetwork
+	}
+
+	if agentNetwork == nil {
+		return modelNetwork
+	}
+
+	network := *agentNetwork
+
+	if modelNetwork.IsDirect {
+		network.IsDirect = true
+		network.ProxyUrl = ""
+	} else if modelNetwork.ProxyUrl != "" {
+		network.IsDirect = false
+		network.ProxyUrl = modelNetwork.ProxyUrl
+	}
+
+	if modelNetwork.ConnectTimeout > 0 {
+		network.ConnectTimeout = modelNetwork.ConnectTimeout
+	}
+
+	if modelNetwork.FirstByteTimeout > 0 {
+		network.FirstByteTimeout = modelNetwork.FirstByteTimeout
+	}
+
+	if modelNetwork.IdleConnTimeout > 0 {
+		network.IdleConnTimeout = modelNetwork.IdleConnTimeout
+	}
+
+	if modelNetwork.TotalTimeout > 0 {
+		network.TotalTimeout = modelNetwork.TotalTimeout
+	}
+
+	if modelNetwork.Tls != nil {
+		network.Tls = modelNetwork.Tls
+	}
+
+	return &network
+}
+
+// 上游代理地址, 未配置时使用全局代理
+func upstreamProxyUrl(mak *MAK) string {
+
+	network := upstreamNetwork(mak)
+
+	if network == nil {
+		return config.Cfg.Http.ProxyUrl
+	}
+
+	if network.IsDirect {
+		return ""
+	}
+
+	if network.ProxyUrl != "" {
+		return network.ProxyUrl
+	}
+
+	return config.Cfg.Http.ProxyUrl
+}
+
+// 上游总超时时间, 未配置时使用全局长/短连接超时时间
+func upstreamTimeout(mak *MAK, isLong bool) time.Duration {
+
+	if network := upstreamNetwork(mak); network != nil && network.TotalTimeout > 0 {
+		return network.TotalTimeout * time.Second
+	}
+
+	if isLong {
+		return config.Cfg.Base.LongTimeout * time.Second
+	}
+
+	return config.Cfg.Base.ShortTimeout * time.Second
+}
+
+// 上游传输层, 用于网关自身发起的上游请求(如: 获取令牌), 无连接/首字节超时、空闲连接保持时间和TLS配置时返回nil, 使用默认传输层
+func upstreamTransport(ctx context.Context, mak *MAK) http.RoundTripper {
+
+	network := upstreamNetwork(mak)
+	if network == nil || (network.ConnectTimeout == 0 && network.FirstByteTimeout == 0 && network.IdleConnTimeout == 0 && network.Tls == nil) {
+		return nil
+	}
+
+	proxyUrl := upstreamProxyUrl(mak)
+
+	data, err := json.Marshal(network)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil
+	}
+
+	var owner string
+	if mak.ModelAgent != nil {
+		owner = mak.ModelAgent.Id
+	}
+
+	if mak.RealModel != nil {
+		owner += ":" + mak.RealModel.Id
+	}
+
+	digest := crypto.SM3(proxyUrl + string(data))
+	now := time.Now()
+
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+
+	entry, ok := transports[owner]
+	if ok && entry.digest == digest {
+		entry.usedAt = now
+		return entry.transport
+	}
+
+	transport, err := newTransport(network, proxyUrl)
+	if err != nil {
+		logger.Errorf(ctx, "upstreamTransport newTransport error: %v", err)
+		return nil
+	}
+
+	// 配置已变更, 关闭被替换的传输层的空闲连接
+	if ok {
+		entry.transport.CloseIdleConnections()
+		delete(transports, owner)
+	}
+
+	evictTransports(now)
+
+	transports[owner] = &transportEntry{
+		digest:    digest,
+		transport: transport,
+		usedAt:    now,
+	}
+
+	return transport
+}
+
+// 移除长时间未使用的传输层, 仍达到上限时移除最久未使用的, 需持有锁
+func evictTransports(now time.Time) {
+
+	var (
+		oldest   string
+		oldestAt time.Time
+	)
+
+	for owner, entry := range transports {
+
+		if now.Sub(entry.usedAt) > transportIdleTimeout {
+			entry.transport.CloseIdleConnections()
+			delete(transports, owner)
+			continue
+		}
+
+		if oldest == "" || entry.usedAt.Before(oldestAt) {
+			oldest, oldestAt = owner, entry.usedAt
+		}
+	}
+
+	if len(transports) >= maxTransports && oldest != "" {
+		transports[oldest].transport.CloseIdleConnections()
+		delete(transports, oldest)
+	}
+}
+
+func newTransport(network *mcommon.UpstreamNetwork, proxyUrl string) (*http.Transport, error) {
+
+	dialer := &net.Dialer{
+		Timeout:   network.ConnectTimeout * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   network.ConnectTimeout * time.Second,
+		ResponseHeaderTimeout: network.FirstByteTimeout * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if network.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = network.IdleConnTimeout * time.Second
+	}
+
+	if proxyUrl != "" {
+		proxy, err := url.Parse(proxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if network.Tls != nil {
+
+		tlsConfig := &tls.Config{
+			ServerName:         network.Tls.ServerName,
+			InsecureSkipVerify: network.Tls.InsecureSkipVerify,
+		}
+
+		if network.Tls.CaCert != "" {
+
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+
+			if !pool.AppendCertsFromPEM([]byte(network.Tls.CaCert)) {
+				return nil, errors.New("invalid tls ca_cert")
+			}
+
+			tlsConfig.RootCAs = pool
+		}
+
+		if network.Tls.ClientCert != "" && network.Tls.ClientKey != "" {
+
+			cert, err := tls.X509KeyPair([]byte(network.Tls.ClientCert), []byte(network.Tls.ClientKey))
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+)
+
+func testNetworkMak(agentId string, connectTimeout time.Duration) *MAK {
+	return &MAK{
+		ModelAgent: &model.ModelAgent{
+			Id: agentId,
+			Network: &mcommon.UpstreamNetwork{
+				IsDirect:       true,
+				ConnectTimeout: connectTimeout,
+			},
+		},
+		RealModel: &model.Model{Id: "model"},
+	}
+}
+
+func TestUpstreamTransport(t *testing.T) {
+
+	ctx := gctx.New()
+
+	transports = make(map[string]*transportEntry)
+
+	// 配置未变更时复用, 变更时替换
+	first := upstreamTransport(ctx, testNetworkMak("agent", 5))
+	if same := upstreamTransport(ctx, testNetworkMak("agent", 5)); same != first {
+		t.Fatal("transport not reused for the same config")
+	}
+
+	if replaced := upstreamTransport(ctx, testNetworkMak("agent", 10)); replaced == first {
+		t.Fatal("transport not replaced after config changed")
+	}
+
+	if len(transports) != 1 {
+		t.Fatalf("transports: %d, want 1", len(transports))
+	}
+
+	// 无传输层配置时使用默认传输层
+	if transport := upstreamTransport(ctx, testNetworkMak("agent", 0)); transport != nil {
+		t.Fatal("transport should be nil without network settings")
+	}
+
+	// 长时间未使用的移除, 达到上限时移除最久未使用的
+	transports["agent:model"].usedAt = time.Now().Add(-2 * transportIdleTimeout)
+
+	for i := 0; i < maxTransports+10; i++ {
+		upstreamTransport(ctx, testNetworkMak(fmt.Sprintf("agent-%d", i), 5))
+	}
+
+	if len(transports) != maxTransports {
+		t.Fatalf("transports: %d, want %d", len(transports), maxTransports)
+	}
+
+	if _, ok := transports["agent:model"]; ok {
+		t.Fatal("idle transport not evicted")
+	}
+
+	if _, ok := transports["agent-0:model"]; ok {
+		t.Fatal("least recently used transport not evicted")
+	}
+}
@@ -0,0 +1,441 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 请求执行管道, 各接口按阶段接入, 统一处理路由、上游错误、重试、计费方式降级、后备和记账
+type Pipeline[T any] struct {
+	// 名称, 用于日志, 如: sChat Completions
+	Name string
+	// 上游错误时不记录错误次数, 如: 智能匹配
+	IsSkipRecordError bool
+	// 启用护栏, 路由后检查输入, 非流式对话和 Responses 响应检查输出, 流式响应由 Attempt.Guardrail 按数据块检查
+	IsGuardrail bool
+	// 构建每次尝试的MAK
+	NewMAK func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *MAK
+	// 路由, 确定模型、模型代理和密钥, 未设置时使用 MAK.InitMAK
+	Route func(ctx context.Context, attempt *Attempt) error
+	// 路由后前置处理, done 为 true 时直接返回结果, 如: 命中响应缓存
+	Before func(ctx context.Context, attempt *Attempt) (res T, done bool, err error)
+	// 请求转换, 如: 预设配置、模型替换
+	Transform func(ctx context.Context, attempt *Attempt) error
+	// 上游调用, 错误按重试和后备策略处理
+	Upstream func(ctx context.Context, attempt *Attempt) (res T, err error)
+	// 响应转换, 错误不再重试, 经 Attempt.UpstreamError 标记的上游错误除外
+	Response func(ctx context.Context, attempt *Attempt, res T) (T, error)
+	// 返回前处理, 每次尝试结束时执行, 仅在已确定模型时调用, 如: 替换成调用的模型
+	Finish func(ctx context.Context, attempt *Attempt, res *T)
+	// 记账, 每次尝试结束时异步执行, 仅在已确定模型时调用, 返回nil时不记账
+	Accounting func(ctx context.Context, attempt *Attempt, res T, err error) *mcommon.AfterHandler
+	guardrail  *Guardrail                     // 生效的护栏, 首次尝试时确定
+	messages   []smodel.ChatCompletionMessage // 护栏脱敏后的消息
+	isGuarded  bool                           // 是否已检查输入, 重试时不再检查
+}
+
+// 单次尝试
+type Attempt struct {
+	Mak       *MAK           // 本次尝试的模型代理和密钥, 对冲请求时可替换为实际使用的
+	Retry     []int          // 已重试次数
+	RetryInfo *mcommon.Retry // 本次尝试失败转入下次尝试时的重试信息
+	Guardrail *Guardrail     // 生效的护栏, 未启用时为nil
+	name      string
+	cleanups  []func()
+	spans     []*gtrace.Span
+	upstream  bool
+	isGuarded bool
+}
+
+// 注册本次尝试结束时执行的清理, 如: 取消对冲请求
+func (a *Attempt) Defer(cleanup func()) {
+	a.cleanups = append(a.cleanups, cleanup)
+}
+
+// 流式上游链路在本次尝试结束(流读取完毕)时结束, 建立连接失败时立即结束
+func (a *Attempt) EndStreamSpan(span *gtrace.Span, err error) {
+
+	if err != nil {
+		EndSpan(span, err)
+		return
+	}
+
+	a.spans = append(a.spans, span)
+}
+
+// 结束本次尝试的流式上游链路
+func (a *Attempt) endSpans(err error) {
+
+	for _, span := range a.spans {
+		EndSpan(span, err)
+	}
+
+	a.spans = nil
+}
+
+// 标记为上游错误, 响应转换返回时按重试和后备策略处理, 如: 流式响应中途的上游错误
+func (a *Attempt) UpstreamError(err error) error {
+	a.upstream = true
+	return err
+}
+
+// 下次尝试
+type nextAttempt struct {
+	modelAgent *model.ModelAgent
+	model      *model.Model
+	retry      []int
+	retryInfo  *mcommon.Retry
+}
+
+// 执行请求, 失败时按重试和后备策略重新执行
+func (p *Pipeline[T]) Execute(ctx context.Context, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (res T, err error) {
+
+	attempt := &Attempt{
+		Mak:   p.NewMAK(fallbackModelAgent, fallbackModel),
+		Retry: retry,
+		name:  p.Name,
+	}
+
+	defer func() {
+
+		if attempt.Mak.ReqModel != nil && attempt.Mak.RealModel != nil {
+
+			if p.Finish != nil {
+				p.Finish(ctx, attempt, &res)
+			}
+
+			if p.Accounting != nil {
+				p.accounting(ctx, attempt, res, err)
+			}
+		}
+
+		attempt.endSpans(err)
+
+		for i := len(attempt.cleanups) - 1; i >= 0; i-- {
+			attempt.cleanups[i]()
+		}
+	}()
+
+	if p.Route != nil {
+		err = p.Route(ctx, attempt)
+	} else {
+		err = attempt.Mak.InitMAK(ctx)
+	}
+
+	if err != nil {
+		logger.Error(ctx, err)
+		return res, err
+	}
+
+	if p.IsGuardrail {
+		if err = p.guard(ctx, attempt); err != nil {
+			return res, err
+		}
+	}
+
+	if p.Before != nil {
+
+		var done bool
+		if res, done, err = p.Before(ctx, attempt); err != nil || done {
+			return res, err
+		}
+	}
+
+	if p.Transform != nil {
+		if err = p.Transform(ctx, attempt); err != nil {
+			logger.Error(ctx, err)
+			return res, err
+		}
+	}
+
+	if res, err = p.Upstream(ctx, attempt); err != nil {
+		logger.Error(ctx, err)
+		return p.retry(ctx, attempt, res, err, fallbackModelAgent, fallbackModel)
+	}
+
+	if p.Response != nil {
+		if res, err = p.Response(ctx, attempt, res); err != nil && attempt.upstream {
+			return p.retry(ctx, attempt, res, err, fallbackModelAgent, fallbackModel)
+		}
+	}
+
+	// 护栏检查非流式对话和 Responses 响应
+	if p.IsGuardrail && err == nil {
+		switch response := any(&res).(type) {
+		case *smodel.ChatCompletionResponse:
+			err = attempt.GuardCompletion(ctx, response)
+		case *smodel.OpenAIResponsesRes:
+			err = attempt.GuardResponses(ctx, response)
+		}
+	}
+
+	return res, err
+}
+
+// 护栏检查输入, 个人信息脱敏后的消息用于本次尝试
+func (p *Pipeline[T]) guard(ctx context.Context, attempt *Attempt) error {
+
+	if !p.isGuarded {
+
+		p.isGuarded = true
+		p.guardrail = NewGuardrail(attempt.Mak)
+
+		var err error
+		if p.messages, err = p.guardrail.CheckInput(ctx, attempt.Mak, attempt.Mak.Messages); err != nil {
+			return err
+		}
+	}
+
+	attempt.Guardrail = p.guardrail
+
+	if p.guardrail != nil {
+		attempt.Mak.Messages = p.messages
+	}
+
+	return nil
+}
+
+// 记账, 补充错误、重试信息和耗时后异步执行
+func (p *Pipeline[T]) accounting(ctx context.Context, attempt *Attempt, res T, err error) {
+
+	enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
+	endTime := gtime.TimestampMilli()
+	mak, retryInfo := attempt.Mak, attempt.RetryInfo
+	guardrails := p.guardrail.Hits()
+
+	if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+		after := p.Accounting(ctx, attempt, res, err)
+		if after == nil {
+			return
+		}
+
+		after.Error = err
+		after.RetryInfo = retryInfo
+		after.EnterTime = enterTime
+		after.Guardrails = guardrails
+
+		// 转入下次尝试的请求未收到数据
+		if retryInfo != nil {
+			after.Completion = ""
+			after.ServiceTier = ""
+			after.Usage = nil
+			after.ImageResponse = smodel.ImageResponse{}
+			after.ImageFilePaths = nil
+			after.ImageExpiresAt = 0
+			after.ConnTime = 0
+			after.Duration = 0
+			after.TotalTime = 0
+		}
+
+		after.InternalTime = endTime - enterTime - after.TotalTime
+
+		AfterHandler(ctx, mak, after)
+
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 上游错误时按重试和后备策略重新执行, 无需再次尝试时返回原错误
+func (p *Pipeline[T]) retry(ctx context.Context, attempt *Attempt, res T, err error, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) (T, error) {
+
+	next := p.next(ctx, attempt, err, fallbackModelAgent, fallbackModel)
+	if next == nil {
+		return res, err
+	}
+
+	attempt.RetryInfo = next.retryInfo
+	attempt.endSpans(err)
+
+	return p.Execute(g.RequestFromCtx(ctx).GetCtx(), next.modelAgent, next.model, next.retry...)
+}
+
+// 上游错误处理, 记录错误并按重试、计费方式降级、后备的顺序确定下次尝试, 无需再次尝试时返回nil
+func (p *Pipeline[T]) next(ctx context.Context, attempt *Attempt, err error, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *nextAttempt {
+
+	mak := attempt.Mak
+	retry := attempt.Retry
+
+	// 记录错误次数和禁用
+	if !p.IsSkipRecordError {
+		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
+	}
+
+	isRetry, isDisabled := IsNeedRetry(err)
+
+	if isDisabled {
+		if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+			service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+
+		}, nil); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	if isRetry {
+
+		if IsMaxRetry(mak.AgentTotal, len(retry)) {
+
+			if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
+				service.Session().SaveModelAgentBillingMethod(ctx, 1)
+				retry = []int{}
+			} else {
+
+				if fallback := NextFallback(ctx, mak, err, true, len(retry)); fallback != nil {
+					return &nextAttempt{
+						modelAgent: fallback.ModelAgent,
+						model:      fallback.Model,
+						retryInfo:  fallback.RetryInfo,
+					}
+				}
+
+				return nil
+			}
+		}
+
+		return &nextAttempt{
+			modelAgent: fallbackModelAgent,
+			model:      fallbackModel,
+			retry:      append(retry, 1),
+			retryInfo: &mcommon.Retry{
+				IsRetry:    true,
+				RetryCount: len(retry),
+				ErrMsg:     err.Error(),
+			},
+		}
+	}
+
+	// 不可重试的错误按触发条件匹配后备
+	if fallback := NextFallback(ctx, mak, err, false, len(retry)); fallback != nil {
+		return &nextAttempt{
+			modelAgent: fallback.ModelAgent,
+			model:      fallback.Model,
+			retryInfo:  fallback.RetryInfo,
+		}
+	}
+
+	return nil
+}
+
+// 护栏检查非流式对话响应, 每次尝试仅检查一次, 如: 写入响应缓存前已检查
+func (a *Attempt) GuardCompletion(ctx context.Context, response *smodel.ChatCompletionResponse) error {
+
+	if a.isGuarded {
+		return nil
+	}
+
+	a.isGuarded = true
+
+	return a.Guardrail.CheckCompletion(ctx, response)
+}
+
+// 护栏检查非流式 Responses 响应, 转换为对话响应检查, 脱敏后的原始响应写回
+func (a *Attempt) GuardResponses(ctx context.Context, response *smodel.OpenAIResponsesRes) error {
+
+	if a.isGuarded || a.Guardrail == nil {
+		return nil
+	}
+
+	completion := ConvResponsesToChatCompletionsResponse(ctx, *response)
+
+	if err := a.GuardCompletion(ctx, &completion); err != nil {
+		return err
+	}
+
+	if completion.ResponseBytes != nil {
+
+		masked := smodel.OpenAIResponsesRes{}
+		if err := gjson.Unmarshal(completion.ResponseBytes, &masked); err != nil {
+			logger.Error(ctx, err)
+			return nil
+		}
+
+		response.Output = masked.Output
+		response.ResponseBytes = completion.ResponseBytes
+	}
+
+	return nil
+}
+
+// 上游请求的模型, 非通配模型使用实际模型, 并按模型代理的模型替换配置替换
+func (a *Attempt) UpstreamModel(ctx context.Context, reqModel string) string {
+
+	if !gstr.Contains(a.Mak.RealModel.Model, "*") {
+		reqModel = a.Mak.RealModel.Model
+	}
+
+	return a.ReplaceModel(ctx, reqModel)
+}
+
+// 按模型代理的模型替换配置替换请求模型, 替换后同步实际模型
+func (a *Attempt) ReplaceModel(ctx context.Context, reqModel string) string {
+
+	mak := a.Mak
+
+	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
+		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
+			if replaceModel == reqModel {
+				logger.Infof(ctx, "%s request.Model: %s replaced %s", a.name, reqModel, mak.ModelAgent.TargetModels[i])
+				reqModel = mak.ModelAgent.TargetModels[i]
+				mak.RealModel.Model = reqModel
+				break
+			}
+		}
+	}
+
+	return reqModel
+}
+
+// 按模型代理的模型替换配置替换原始请求体中的模型, 返回替换后的模型和请求体
+func (a *Attempt) ReplaceBodyModel(ctx context.Context, body []byte, reqModel string) (string, []byte, error) {
+
+	replaced := a.ReplaceModel(ctx, reqModel)
+	if replaced == reqModel {
+		return replaced, body, nil
+	}
+
+	data := make(map[string]any)
+	if err := json.Unmarshal(body, &data); err != nil {
+		return replaced, nil, err
+	}
+
+	if _, ok := data["model"]; ok {
+		data["model"] = replaced
+	}
+
+	return replaced, gjson.MustEncode(data), nil
+}
+
+// 替换模型, 并按上游协议转换请求, 转换函数为空时原样转发, 护栏脱敏输入时请求同步脱敏
+func (a *Attempt) TransformBody(ctx context.Context, body []byte, reqModel string, params *smodel.ChatCompletionRequest, conv func(body []byte) any) ([]byte, error) {
+
+	model, body, err := a.ReplaceBodyModel(ctx, a.Guardrail.MaskBody(body), reqModel)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Model = model
+	params.Messages = a.Mak.Messages
+
+	if conv == nil {
+		return body, nil
+	}
+
+	return gjson.MustEncode(conv(body)), nil
+}
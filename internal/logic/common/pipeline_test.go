@@ -0,0 +1,226 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+)
+
+var errUpstream = errors.New("upstream error")
+
+// 模拟上游, 按顺序返回各次调用的结果, nil 表示成功
+type fakeUpstream struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeUpstream) call(ctx context.Context, attempt *Attempt) (string, error) {
+
+	f.calls++
+
+	if f.calls <= len(f.errs) && f.errs[f.calls-1] != nil {
+		return "", f.errs[f.calls-1]
+	}
+
+	return "ok", nil
+}
+
+// 模拟请求上下文, 管道重试时从请求中获取上下文
+func testRequestCtx() context.Context {
+
+	r := &ghttp.Request{
+		Request:   httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		EnterTime: gtime.Now(),
+	}
+
+	return r.GetCtx()
+}
+
+func testConfig(isRetry bool, errRetry int) {
+	config.Cfg = &config.Config{
+		SysConfig: &entity.SysConfig{
+			Base:              &mcommon.Base{ErrRetry: errRetry},
+			AutoDisabledError: &mcommon.AutoDisabledError{},
+			AutoRetryError:    &mcommon.AutoRetryError{Open: isRetry},
+			NotRetryError:     &mcommon.NotRetryError{},
+		},
+	}
+}
+
+func TestPipelineExecute(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		isRetry        bool
+		errRetry       int
+		upstreamErrs   []error
+		responseErrs   int   // 响应转换中标记为上游错误的次数
+		routeErr       error // 路由错误
+		isBeforeDone   bool  // 前置处理直接返回
+		wantRes        string
+		wantErr        error
+		wantCalls      int
+		wantRetryCount []int // 各次尝试记账时的重试次数, -1 表示未转入下次尝试
+	}{
+		{
+			name:           "success",
+			isRetry:        true,
+			errRetry:       3,
+			wantRes:        "ok",
+			wantCalls:      1,
+			wantRetryCount: []int{-1},
+		},
+		{
+			name:           "retry then success",
+			isRetry:        true,
+			errRetry:       3,
+			upstreamErrs:   []error{errUpstream, errUpstream},
+			wantRes:        "ok",
+			wantCalls:      3,
+			wantRetryCount: []int{0, 1, -1},
+		},
+		{
+			name:           "not retry",
+			isRetry:        false,
+			errRetry:       3,
+			upstreamErrs:   []error{errUpstream},
+			wantErr:        errUpstream,
+			wantCalls:      1,
+			wantRetryCount: []int{-1},
+		},
+		{
+			name:           "response upstream error retry",
+			isRetry:        true,
+			errRetry:       3,
+			responseErrs:   1,
+			wantRes:        "ok",
+			wantCalls:      2,
+			wantRetryCount: []int{0, -1},
+		},
+		{
+			name:           "before done",
+			isRetry:        true,
+			errRetry:       3,
+			isBeforeDone:   true,
+			wantRes:        "cache",
+			wantCalls:      0,
+			wantRetryCount: []int{-1},
+		},
+		{
+			name:     "route error",
+			isRetry:  true,
+			errRetry: 3,
+			routeErr: errUpstream,
+			wantErr:  errUpstream,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			testConfig(tt.isRetry, tt.errRetry)
+
+			var (
+				upstream  = &fakeUpstream{errs: tt.upstreamErrs}
+				responses = 0
+				finishes  = 0
+				cleanups  = 0
+				records   = make(chan *mcommon.Retry, 8)
+			)
+
+			pipeline := &Pipeline[string]{
+				Name:              "test",
+				IsSkipRecordError: true,
+				NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *MAK {
+					return &MAK{
+						Model:         "gpt-4o",
+						fallbackState: new(mcommon.FallbackState),
+					}
+				},
+				Route: func(ctx context.Context, attempt *Attempt) error {
+
+					if tt.routeErr != nil {
+						return tt.routeErr
+					}
+
+					attempt.Mak.ReqModel = &model.Model{Model: "gpt-4o"}
+					attempt.Mak.RealModel = &model.Model{Model: "gpt-4o"}
+					attempt.Defer(func() {
+						cleanups++
+					})
+
+					return nil
+				},
+				Before: func(ctx context.Context, attempt *Attempt) (string, bool, error) {
+					return "cache", tt.isBeforeDone, nil
+				},
+				Upstream: upstream.call,
+				Response: func(ctx context.Context, attempt *Attempt, res string) (string, error) {
+
+					if responses++; responses <= tt.responseErrs {
+						return res, attempt.UpstreamError(errUpstream)
+					}
+
+					return res, nil
+				},
+				Finish: func(ctx context.Context, attempt *Attempt, res *string) {
+					finishes++
+				},
+				Accounting: func(ctx context.Context, attempt *Attempt, res string, err error) *mcommon.AfterHandler {
+					records <- attempt.RetryInfo
+					return nil
+				},
+			}
+
+			res, err := pipeline.Execute(testRequestCtx(), nil, nil)
+
+			if res != tt.wantRes || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Execute: %q, %v, want %q, %v", res, err, tt.wantRes, tt.wantErr)
+			}
+
+			if upstream.calls != tt.wantCalls {
+				t.Fatalf("upstream calls: %d, want %d", upstream.calls, tt.wantCalls)
+			}
+
+			if finishes != len(tt.wantRetryCount) || cleanups != len(tt.wantRetryCount) {
+				t.Fatalf("finishes: %d, cleanups: %d, want %d", finishes, cleanups, len(tt.wantRetryCount))
+			}
+
+			// 记账异步执行, 按完成顺序收集后比较
+			retryCounts := make(map[int]int)
+			for range tt.wantRetryCount {
+				select {
+				case retryInfo := <-records:
+					if retryInfo == nil {
+						retryCounts[-1]++
+					} else {
+						retryCounts[retryInfo.RetryCount]++
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("accounting: %d, want %d", len(retryCounts), len(tt.wantRetryCount))
+				}
+			}
+
+			for _, retryCount := range tt.wantRetryCount {
+				if retryCounts[retryCount]--; retryCounts[retryCount] < 0 {
+					t.Fatalf("accounting retry count: %d not recorded", retryCount)
+				}
+			}
+
+			select {
+			case <-records:
+				t.Fatal("accounting called more than once per attempt")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
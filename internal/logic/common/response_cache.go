@@ -0,0 +1,156 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gogf/gf/v2/container/gvar"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+// 响应缓存
+type responseCache struct {
+	key    string
+	config *mcommon.ResponseCache
+	data   *gvar.Var // 命中的缓存数据
+}
+
+// 查询响应缓存, 命中时不再挑选模型代理
+func lookupResponseCache(ctx context.Context, mak *MAK) bool {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "lookupResponseCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if mak.CacheRequest == nil {
+		return false
+	}
+
+	cfg := getResponseCacheConfig(mak)
+	if cfg == nil {
+		return false
+	}
+
+	_, span := gtrace.NewSpan(ctx, "lookupResponseCache")
+	defer span.End()
+
+	// 请求哈希包含分组和模型, 不同分组或模型的相同请求不共用缓存
+	data, err := gjson.Encode(g.Map{
+		"endpoint": mak.Endpoint,
+		"group":    mak.Group.Id,
+		"model":    mak.ReqModel.Id,
+		"request":  mak.CacheRequest,
+	})
+	if err != nil {
+		logger.Error(ctx, err)
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+
+	mak.responseCache = &responseCache{
+		key:    fmt.Sprintf(consts.RESPONSE_CACHE_KEY, service.Session().GetUserId(ctx), hex.EncodeToString(hash[:])),
+		config: cfg,
+	}
+
+	reply, err := redis.Get(ctx, mak.responseCache.key)
+	if err != nil {
+		// 缓存异常时放行, 不影响正常请求
+		logger.Error(ctx, err)
+		return false
+	}
+
+	if reply.IsNil() || reply.IsEmpty() {
+		return false
+	}
+
+	mak.responseCache.data = reply
+
+	logger.Infof(ctx, "lookupResponseCache hit key: %s", mak.responseCache.key)
+
+	return true
+}
+
+// 是否命中响应缓存
+func (mak *MAK) IsCacheHit() bool {
+	return mak.responseCache != nil && mak.responseCache.data != nil
+}
+
+// 是否需要写入响应缓存
+func (mak *MAK) IsCacheable() bool {
+	return mak.responseCache != nil && mak.responseCache.data == nil
+}
+
+// 读取命中的响应缓存
+func (mak *MAK) ScanResponseCache(v any) error {
+
+	if !mak.IsCacheHit() {
+		return nil
+	}
+
+	return gjson.Unmarshal(mak.responseCache.data.Bytes(), v)
+}
+
+// 写入响应缓存
+func (mak *MAK) SaveResponseCache(ctx context.Context, v any) {
+
+	if !mak.IsCacheable() {
+		return
+	}
+
+	data, err := gjson.Encode(v)
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	ttl := int64(mak.responseCache.config.Ttl)
+	if ttl <= 0 {
+		ttl = 3600
+	}
+
+	if err = redis.SetEX(ctx, mak.responseCache.key, string(data), ttl); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 命中响应缓存的计费倍率
+func (mak *MAK) cacheRatio() float64 {
+
+	if !mak.IsCacheHit() {
+		return 1
+	}
+
+	return mak.responseCache.config.Ratio
+}
+
+// 获取生效的响应缓存配置, 优先级: 应用密钥 > 分组 > 模型
+func getResponseCacheConfig(mak *MAK) *mcommon.ResponseCache {
+
+	var cfg *mcommon.ResponseCache
+
+	if mak.AppKey != nil && mak.AppKey.ResponseCache != nil {
+		cfg = mak.AppKey.ResponseCache
+	} else if mak.Group != nil && mak.Group.ResponseCache != nil {
+		cfg = mak.Group.ResponseCache
+	} else if mak.ReqModel != nil && mak.ReqModel.ResponseCache != nil {
+		cfg = mak.ReqModel.ResponseCache
+	}
+
+	if cfg == nil || !cfg.Open {
+		return nil
+	}
+
+	return cfg
+}
@@ -0,0 +1,602 @@
+package common_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gtime"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/audio"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/chat"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/embedding"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/image"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/internal/service"
+)
+
+// 上游错误响应, 按状态码返回
+var upstreamErrors = map[int]string{
+	http.StatusBadRequest:          `{"error":{"message":"Invalid value for 'input'.","type":"invalid_request_error","code":null}}`,
+	http.StatusTooManyRequests:     `{"error":{"message":"Rate limit reached for requests","type":"requests","code":"rate_limit_exceeded"}}`,
+	http.StatusInternalServerError: `{"error":{"message":"The server had an error while processing your request.","type":"server_error","code":null}}`,
+}
+
+// 模拟上游, 按顺序返回各次请求的状态码, 记录请求的模型代理
+type routeUpstream struct {
+	mu          sync.Mutex
+	endpoint    string
+	contentType string
+	success     string
+	statuses    []int
+	hits        []string
+}
+
+func (u *routeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// 请求路径为 /{模型代理}/v1/{接口}, 接口不符时记录完整路径
+	agent, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !strings.HasSuffix(r.URL.Path, u.endpoint) {
+		agent = r.URL.Path
+	}
+
+	u.hits = append(u.hits, agent)
+
+	if status := u.statuses[min(len(u.hits), len(u.statuses))-1]; status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(upstreamErrors[status]))
+		return
+	}
+
+	w.Header().Set("Content-Type", u.contentType)
+	_, _ = w.Write([]byte(u.success))
+}
+
+func (u *routeUpstream) reset(statuses []int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.statuses = statuses
+	u.hits = nil
+}
+
+func (u *routeUpstream) getHits() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return slices.Clone(u.hits)
+}
+
+// 路由测试环境, 模拟服务共用, 各用例开始时重置
+type routeEnv struct {
+	mu                  sync.Mutex
+	baseUrl             string
+	reqModel            model.Model
+	fallbackModel       model.Model
+	billingMethods      []int                  // 应用密钥和模型支持的计费方式
+	billingMethod       int                    // 会话中的计费方式
+	savedBillingMethods []int                  // 已保存的计费方式
+	fallbackState       *mcommon.FallbackState // 会话中的后备链执行状态
+	recordErrors        int                    // 记录错误次数
+	accounting          chan string            // 各次尝试的记账, 格式: 模型代理 模型 结果
+}
+
+func (e *routeEnv) reset(reqModel, fallbackModel model.Model, billingMethods []int) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.reqModel = reqModel
+	e.fallbackModel = fallbackModel
+	e.billingMethods = billingMethods
+	e.billingMethod = 0
+	e.savedBillingMethods = nil
+	e.fallbackState = nil
+	e.recordErrors = 0
+	e.accounting = make(chan string, 8)
+}
+
+func (e *routeEnv) modelAgent(id string) *model.ModelAgent {
+	return &model.ModelAgent{
+		Id:         id,
+		Name:       id,
+		ProviderId: sconsts.PROVIDER_OPENAI,
+		BaseUrl:    e.baseUrl + "/" + id + "/v1",
+	}
+}
+
+// 记账结果, 转入下次尝试的为 retry 或 fallback:{后备步骤}
+func (e *routeEnv) record(modelAgent *model.ModelAgent, realModel *model.Model, retryInfo *mcommon.Retry, err error) {
+
+	e.mu.Lock()
+	label := "model"
+	if realModel.Model == e.fallbackModel.Model {
+		label = "fallback-model"
+	}
+	accounting := e.accounting
+	e.mu.Unlock()
+
+	result := "ok"
+	if retryInfo != nil {
+		result = "retry"
+		if retryInfo.Fallback != nil {
+			result = fmt.Sprintf("fallback:%d", retryInfo.Fallback.Step)
+		}
+	} else if err != nil {
+		result = "error"
+	}
+
+	accounting <- fmt.Sprintf("%s %s %s", modelAgent.Id, label, result)
+}
+
+type routeSession struct {
+	service.ISession
+	env *routeEnv
+}
+
+func (s *routeSession) GetRid(ctx context.Context) int                        { return 0 }
+func (s *routeSession) GetUserId(ctx context.Context) int                     { return 1 }
+func (s *routeSession) GetAppId(ctx context.Context) int                      { return 1 }
+func (s *routeSession) GetSecretKey(ctx context.Context) string               { return "sk-test" }
+func (s *routeSession) SaveEndpoint(ctx context.Context, endpoint string)     {}
+func (s *routeSession) GetSessionKey(ctx context.Context) *mcommon.SessionKey { return nil }
+func (s *routeSession) GetSessionKeepHit(ctx context.Context) bool            { return false }
+func (s *routeSession) GetErrorModelAgents(ctx context.Context) []string      { return nil }
+func (s *routeSession) RecordErrorModelAgent(ctx context.Context, id string)  {}
+func (s *routeSession) GetPrompt(ctx context.Context) *mcommon.Prompt         { return nil }
+
+func (s *routeSession) GetModelAgentBillingMethod(ctx context.Context) int {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	return s.env.billingMethod
+}
+
+func (s *routeSession) SaveModelAgentBillingMethod(ctx context.Context, billingMethod int) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	s.env.billingMethod = billingMethod
+	s.env.savedBillingMethods = append(s.env.savedBillingMethods, billingMethod)
+}
+
+func (s *routeSession) GetFallbackState(ctx context.Context) *mcommon.FallbackState {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	return s.env.fallbackState
+}
+
+func (s *routeSession) SaveFallbackState(ctx context.Context, state *mcommon.FallbackState) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	s.env.fallbackState = state
+}
+
+type routeUser struct{ service.IUser }
+
+func (s *routeUser) GetCache(ctx context.Context, userId int) (*model.User, error) {
+	return &model.User{Groups: []string{"group"}}, nil
+}
+
+type routeApp struct{ service.IApp }
+
+func (s *routeApp) GetCache(ctx context.Context, appId int) (*model.App, error) {
+	return &model.App{}, nil
+}
+
+type routeAppKey struct {
+	service.IAppKey
+	env *routeEnv
+}
+
+func (s *routeAppKey) GetCache(ctx context.Context, secretKey string) (*model.AppKey, error) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	return &model.AppKey{BillingMethods: s.env.billingMethods}, nil
+}
+
+type routeGroup struct {
+	service.IGroup
+	env *routeEnv
+}
+
+func (s *routeGroup) PickGroupAndModel(ctx context.Context, appKey *model.AppKey, m string, ids ...string) (*model.Model, *model.Group, error) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	reqModel := s.env.reqModel
+	return &reqModel, &model.Group{Id: "group", Name: "group"}, nil
+}
+
+type routeModel struct {
+	service.IModel
+	env *routeEnv
+}
+
+func (s *routeModel) GetFallbackModel(ctx context.Context, id string) (*model.Model, error) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	fallbackModel := s.env.fallbackModel
+	return &fallbackModel, nil
+}
+
+// 模拟模型代理, 按次计费时使用 agent-2, 按Tokens计费时使用 agent-1
+type routeModelAgent struct {
+	service.IModelAgent
+	env *routeEnv
+}
+
+func (s *routeModelAgent) Pick(ctx context.Context, m *model.Model) (int, *model.ModelAgent, error) {
+
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+
+	if s.env.billingMethod == 2 {
+		return 1, s.env.modelAgent("agent-2"), nil
+	}
+
+	return 1, s.env.modelAgent("agent-1"), nil
+}
+
+func (s *routeModelAgent) PickKey(ctx context.Context, modelAgent *model.ModelAgent) (int, *model.Key, error) {
+	return 1, &model.Key{Id: modelAgent.Id + "-key", Key: "sk-" + modelAgent.Id}, nil
+}
+
+func (s *routeModelAgent) GetFallback(ctx context.Context, id string) (*model.ModelAgent, error) {
+	return s.env.modelAgent(id), nil
+}
+
+func (s *routeModelAgent) AcquireInflight(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key) string {
+	return ""
+}
+
+func (s *routeModelAgent) RecordUpstreamHeaders(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, header http.Header) {
+}
+
+func (s *routeModelAgent) RecordResult(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key, member string, latency int64, isFailure bool) {
+}
+
+func (s *routeModelAgent) RecordUpstreamUsage(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, totalTokens, spendQuota int) {
+}
+
+type routeProvider struct{ service.IProvider }
+
+func (s *routeProvider) GetCache(ctx context.Context, id string) (*model.Provider, error) {
+	return &model.Provider{Id: id, Code: sconsts.PROVIDER_OPENAI}, nil
+}
+
+type routeRateLimit struct{ service.IRateLimit }
+
+func (s *routeRateLimit) IsLimitTokens(ctx context.Context, group *model.Group) bool { return false }
+func (s *routeRateLimit) Charge(ctx context.Context, group *model.Group, tokens int) error {
+	return nil
+}
+func (s *routeRateLimit) Correct(ctx context.Context, totalTokens int) {}
+
+// 模拟公共服务, 记录上游错误次数
+type routeCommon struct {
+	service.ICommon
+	env *routeEnv
+}
+
+func (s *routeCommon) RecordError(ctx context.Context, m *model.Model, key *model.Key, modelAgent *model.ModelAgent, err error) {
+	s.env.mu.Lock()
+	defer s.env.mu.Unlock()
+	s.env.recordErrors++
+}
+
+type routeMetrics struct{ service.IMetrics }
+
+func (s *routeMetrics) Retry(ctx context.Context, labels mcommon.MetricsLabels) {}
+func (s *routeMetrics) Request(ctx context.Context, labels mcommon.MetricsLabels, after *mcommon.AfterHandler) {
+}
+func (s *routeMetrics) SessionKeep(ctx context.Context, model string, hit bool) {}
+
+type routeUsageExport struct{ service.IUsageExport }
+
+func (s *routeUsageExport) IsOpen() bool { return false }
+
+// 模拟日志, 各次尝试的记账结果写入测试环境
+type routeLog struct {
+	service.ILog
+	env *routeEnv
+}
+
+func (s *routeLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
+	s.env.record(textLog.ModelAgent, textLog.RealModel, textLog.RetryInfo, textLog.CompletionsRes.Error)
+}
+
+func (s *routeLog) Image(ctx context.Context, imageLog model.LogImage, retry ...int) {
+	s.env.record(imageLog.ModelAgent, imageLog.RealModel, imageLog.RetryInfo, imageLog.ImageRes.Error)
+}
+
+func (s *routeLog) Audio(ctx context.Context, audioLog model.LogAudio, retry ...int) {
+	s.env.record(audioLog.ModelAgent, audioLog.RealModel, audioLog.RetryInfo, audioLog.AudioRes.Error)
+}
+
+func newRouteEnv(baseUrl string) *routeEnv {
+
+	env := &routeEnv{baseUrl: baseUrl}
+
+	service.RegisterSession(&routeSession{env: env})
+	service.RegisterUser(&routeUser{})
+	service.RegisterApp(&routeApp{})
+	service.RegisterAppKey(&routeAppKey{env: env})
+	service.RegisterGroup(&routeGroup{env: env})
+	service.RegisterModel(&routeModel{env: env})
+	service.RegisterModelAgent(&routeModelAgent{env: env})
+	service.RegisterProvider(&routeProvider{})
+	service.RegisterRateLimit(&routeRateLimit{})
+	service.RegisterCommon(&routeCommon{env: env})
+	service.RegisterMetrics(&routeMetrics{})
+	service.RegisterUsageExport(&routeUsageExport{})
+	service.RegisterLog(&routeLog{env: env})
+
+	return env
+}
+
+func routeConfig(isRetry bool, errRetry int) {
+	config.Cfg = &config.Config{
+		SysConfig: &entity.SysConfig{
+			Core:                      &mcommon.Core{},
+			Http:                      &mcommon.Http{},
+			Base:                      &mcommon.Base{ErrRetry: errRetry, ShortTimeout: 10, LongTimeout: 10},
+			AutoDisabledError:         &mcommon.AutoDisabledError{},
+			AutoRetryError:            &mcommon.AutoRetryError{Open: isRetry},
+			NotRetryError:             &mcommon.NotRetryError{},
+			NotShieldError:            &mcommon.NotShieldError{},
+			Quota:                     &mcommon.Quota{},
+			ImageTask:                 &mcommon.ImageTask{},
+			ImageStorage:              &mcommon.ImageStorage{},
+			ModelAgentHealthCheckTask: &mcommon.ModelAgentHealthCheckTask{},
+		},
+	}
+}
+
+// 模拟请求上下文, 管道重试时从请求中获取上下文
+func routeRequestCtx(path string) context.Context {
+
+	r := &ghttp.Request{
+		Request:   httptest.NewRequest(http.MethodPost, path, nil),
+		EnterTime: gtime.Now(),
+	}
+
+	return r.GetCtx()
+}
+
+func TestPipelineRoutes(t *testing.T) {
+
+	routes := []struct {
+		name          string
+		path          string // 网关接口路径
+		endpoint      string // 上游接口路径
+		modelType     int
+		model         string
+		fallbackModel string
+		contentType   string
+		success       string // 上游成功响应
+		call          func(ctx context.Context, model string) error
+	}{
+		{
+			name:          "chat",
+			path:          "/v1/chat/completions",
+			endpoint:      "/chat/completions",
+			modelType:     1,
+			model:         "gpt-4o",
+			fallbackModel: "gpt-4o-mini",
+			contentType:   "application/json",
+			success:       `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`,
+			call: func(ctx context.Context, m string) error {
+				_, err := service.Chat().Completions(ctx, smodel.ChatCompletionRequest{
+					Model:    m,
+					Messages: []smodel.ChatCompletionMessage{{Role: sconsts.ROLE_USER, Content: "hi"}},
+				}, nil, nil)
+				return err
+			},
+		},
+		{
+			name:          "embeddings",
+			path:          "/v1/embeddings",
+			endpoint:      "/embeddings",
+			modelType:     7,
+			model:         "text-embedding-3-large",
+			fallbackModel: "text-embedding-3-small",
+			contentType:   "application/json",
+			success:       `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],"model":"text-embedding-3-large","usage":{"prompt_tokens":1,"total_tokens":1}}`,
+			call: func(ctx context.Context, m string) error {
+				_, err := service.Embedding().Embeddings(ctx, []byte(fmt.Sprintf(`{"model":%q,"input":"hi"}`, m)), nil, nil)
+				return err
+			},
+		},
+		{
+			name:          "image",
+			path:          "/v1/images/generations",
+			endpoint:      "/images/generations",
+			modelType:     2,
+			model:         "gpt-image-1",
+			fallbackModel: "dall-e-3",
+			contentType:   "application/json",
+			success:       `{"created":1,"data":[{"url":"https://example.com/image.png"}]}`,
+			call: func(ctx context.Context, m string) error {
+				_, err := service.Image().Generations(ctx, []byte(fmt.Sprintf(`{"model":%q,"prompt":"a cat"}`, m)), nil, nil)
+				return err
+			},
+		},
+		{
+			name:          "audio",
+			path:          "/v1/audio/speech",
+			endpoint:      "/audio/speech",
+			modelType:     5,
+			model:         "tts-1-hd",
+			fallbackModel: "tts-1",
+			contentType:   "audio/mpeg",
+			success:       "audio",
+			call: func(ctx context.Context, m string) error {
+				_, err := service.Audio().Speech(ctx, []byte(fmt.Sprintf(`{"model":%q,"input":"hi","voice":"alloy"}`, m)), nil, nil)
+				return err
+			},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		isRetry            bool
+		errRetry           int
+		billingMethods     []int // 应用密钥和模型支持的计费方式
+		isFallback         bool  // 启用后备链: 后备模型 → 后备模型代理 agent-2, 均在限流时触发
+		statuses           []int // 各次上游请求的状态码
+		wantErr            bool
+		wantHits           []string // 各次上游请求的模型代理
+		wantBillingMethods []int    // 依次保存的计费方式
+		wantRecordErrors   int
+		wantAccounting     []string // 各次尝试的记账, 不分先后
+	}{
+		{
+			name:               "success",
+			isRetry:            true,
+			errRetry:           3,
+			billingMethods:     []int{1},
+			statuses:           []int{http.StatusOK},
+			wantHits:           []string{"agent-1"},
+			wantBillingMethods: []int{1},
+			wantAccounting:     []string{"agent-1 model ok"},
+		},
+		{
+			name:               "retry then success",
+			isRetry:            true,
+			errRetry:           3,
+			billingMethods:     []int{1},
+			statuses:           []int{http.StatusInternalServerError, http.StatusOK},
+			wantHits:           []string{"agent-1", "agent-1"},
+			wantBillingMethods: []int{1},
+			wantRecordErrors:   1,
+			wantAccounting:     []string{"agent-1 model retry", "agent-1 model ok"},
+		},
+		{
+			name:               "fallback hops",
+			isRetry:            true,
+			errRetry:           0,
+			billingMethods:     []int{1},
+			isFallback:         true,
+			statuses:           []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK},
+			wantHits:           []string{"agent-1", "agent-1", "agent-2"},
+			wantBillingMethods: []int{1},
+			wantRecordErrors:   2,
+			wantAccounting:     []string{"agent-1 model fallback:1", "agent-1 fallback-model fallback:2", "agent-2 fallback-model ok"},
+		},
+		{
+			name:               "billing method downgrade",
+			isRetry:            true,
+			errRetry:           0,
+			billingMethods:     []int{1, 2},
+			statuses:           []int{http.StatusInternalServerError, http.StatusOK},
+			wantHits:           []string{"agent-2", "agent-1"},
+			wantBillingMethods: []int{2, 1},
+			wantRecordErrors:   1,
+			wantAccounting:     []string{"agent-2 model retry", "agent-1 model ok"},
+		},
+		{
+			name:               "not retry",
+			isRetry:            false,
+			errRetry:           3,
+			billingMethods:     []int{1},
+			statuses:           []int{http.StatusBadRequest},
+			wantErr:            true,
+			wantHits:           []string{"agent-1"},
+			wantBillingMethods: []int{1},
+			wantRecordErrors:   1,
+			wantAccounting:     []string{"agent-1 model error"},
+		},
+	}
+
+	upstream := new(routeUpstream)
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	env := newRouteEnv(server.URL)
+
+	for _, route := range routes {
+		for _, tt := range tests {
+			t.Run(route.name+"/"+tt.name, func(t *testing.T) {
+
+				routeConfig(tt.isRetry, tt.errRetry)
+
+				reqModel := model.Model{
+					Id:         route.model,
+					Model:      route.model,
+					Type:       route.modelType,
+					ProviderId: sconsts.PROVIDER_OPENAI,
+					Pricing:    mcommon.Pricing{BillingMethods: tt.billingMethods},
+				}
+
+				fallbackModel := reqModel
+				fallbackModel.Id, fallbackModel.Model = route.fallbackModel, route.fallbackModel
+
+				if tt.isFallback {
+					reqModel.IsEnableFallback = true
+					reqModel.FallbackConfig = &mcommon.FallbackConfig{
+						Chain: []*mcommon.FallbackStep{
+							{Model: route.fallbackModel, Conditions: []string{consts.ERR_CLASS_RATE_LIMIT}},
+							{ModelAgent: "agent-2", Conditions: []string{consts.ERR_CLASS_RATE_LIMIT}},
+						},
+					}
+				}
+
+				env.reset(reqModel, fallbackModel, tt.billingMethods)
+
+				upstream.endpoint, upstream.contentType, upstream.success = route.endpoint, route.contentType, route.success
+				upstream.reset(tt.statuses)
+
+				err := route.call(routeRequestCtx(route.path), route.model)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("call: %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if hits := upstream.getHits(); !slices.Equal(hits, tt.wantHits) {
+					t.Fatalf("hits: %v, want %v", hits, tt.wantHits)
+				}
+
+				// 记账异步执行
+				accounting := make([]string, 0, len(tt.wantAccounting))
+				for range tt.wantAccounting {
+					select {
+					case record := <-env.accounting:
+						accounting = append(accounting, record)
+					case <-time.After(5 * time.Second):
+						t.Fatalf("accounting: %v, want %v", accounting, tt.wantAccounting)
+					}
+				}
+
+				want := slices.Clone(tt.wantAccounting)
+				slices.Sort(accounting)
+				slices.Sort(want)
+
+				if !slices.Equal(accounting, want) {
+					t.Fatalf("accounting: %v, want %v", accounting, want)
+				}
+
+				env.mu.Lock()
+				defer env.mu.Unlock()
+
+				if !slices.Equal(env.savedBillingMethods, tt.wantBillingMethods) {
+					t.Fatalf("billing methods: %v, want %v", env.savedBillingMethods, tt.wantBillingMethods)
+				}
+
+				if env.recordErrors != tt.wantRecordErrors {
+					t.Fatalf("record errors: %d, want %d", env.recordErrors, tt.wantRecordErrors)
+				}
+			})
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/consts"
@@ -23,6 +24,12 @@ func RecordSpend(ctx context.Context, spend common.Spend, mak *MAK) error {
 		logger.Debugf(ctx, "RecordSpend time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "RecordSpend")
+	defer span.End()
+
+	// 记录花费后释放预授权额度
+	defer releaseQuotaHold(ctx, mak)
+
 	if spend.TotalSpendTokens == 0 {
 		return nil
 	}
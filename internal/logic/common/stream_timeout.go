@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+// 流式超时, 首个数据块超时和数据块间隔超时
+type StreamTimeout struct {
+	firstToken time.Duration
+	idle       time.Duration
+	isReceived bool // 是否已收到数据块
+}
+
+func NewStreamTimeout(mak *MAK) *StreamTimeout {
+
+	streamTimeout := new(StreamTimeout)
+
+	if mak.RealModel != nil && mak.RealModel.StreamTimeout != nil {
+		streamTimeout.firstToken = mak.RealModel.StreamTimeout.FirstToken * time.Second
+		streamTimeout.idle = mak.RealModel.StreamTimeout.Idle * time.Second
+	}
+
+	return streamTimeout
+}
+
+// 标记已收到数据块, 如: 对冲请求已收到首个数据块
+func (t *StreamTimeout) Received() {
+	t.isReceived = true
+}
+
+// 接收流式数据块, 未收到数据块前按首个数据块超时, 之后按数据块间隔超时
+func StreamRecv[T any](ctx context.Context, t *StreamTimeout, ch chan T) (data T, err error) {
+
+	timeout := t.idle
+	if !t.isReceived {
+		timeout = t.firstToken
+	}
+
+	if timeout <= 0 {
+		data = <-ch
+		t.isReceived = true
+		return data, nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case data = <-ch:
+		t.isReceived = true
+		return data, nil
+	case <-timer.C:
+		if !t.isReceived {
+			logger.Errorf(ctx, "StreamRecv first token timeout: %s", timeout)
+			return data, errors.ERR_FIRST_TOKEN_TIMEOUT
+		}
+		logger.Errorf(ctx, "StreamRecv idle timeout: %s", timeout)
+		return data, errors.ERR_STREAM_IDLE_TIMEOUT
+	}
+}
+
+// 是否流中断, 已向客户端输出后上游数据块间隔超时
+func IsStreamStalled(err error) bool {
+	return err != nil && errors.Is(err, errors.ERR_STREAM_IDLE_TIMEOUT)
+}
+
+// 以错误事件结束流, 客户端可据此识别不完整的响应
+func StreamErrorEvent(ctx context.Context, err error, event ...string) {
+
+	data := g.Map{
+		"type":  "error",
+		"error": errors.Error(ctx, err).Unwrap(),
+	}
+
+	if err := util.SSEServer(ctx, gjson.MustEncodeString(data), event...); err != nil {
+		logger.Error(ctx, err)
+	}
+}
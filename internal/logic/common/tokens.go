@@ -44,3 +44,39 @@ func TokensFromString(ctx context.Context, model, text string) int {
 
 	return tokens
 }
+
+// 合并流式用量, 以非零值为准
+func MergeUsage(usage, delta *smodel.Usage) *smodel.Usage {
+
+	if usage == nil {
+		return delta
+	}
+
+	if delta.PromptTokens != 0 {
+		usage.PromptTokens = delta.PromptTokens
+	}
+
+	if delta.CompletionTokens != 0 {
+		usage.CompletionTokens = delta.CompletionTokens
+	}
+
+	if delta.TotalTokens != 0 {
+		usage.TotalTokens = delta.TotalTokens
+	} else {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	if delta.CacheCreationInputTokens != 0 {
+		usage.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+
+	if delta.CacheReadInputTokens != 0 {
+		usage.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+
+	if delta.PromptTokensDetails.CachedTokens != 0 {
+		usage.PromptTokensDetails = delta.PromptTokensDetails
+	}
+
+	return usage
+}
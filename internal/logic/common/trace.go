@@ -0,0 +1,52 @@
+package common
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/net/gtrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 开始上游调用链路, 返回的ctx需传递给适配器以注入traceparent
+func StartUpstreamSpan(ctx context.Context, mak *MAK, name string) (context.Context, *gtrace.Span) {
+
+	attrs := []attribute.KeyValue{
+		attribute.String("fastapi.provider", GetProviderCode(ctx, mak.Provider)),
+		attribute.String("fastapi.base_url", mak.BaseUrl),
+	}
+
+	if mak.ReqModel != nil {
+		attrs = append(attrs, attribute.String("fastapi.model", mak.ReqModel.Model))
+	}
+
+	if mak.RealModel != nil {
+		attrs = append(attrs, attribute.String("fastapi.real_model", mak.RealModel.Model))
+	}
+
+	if mak.ModelAgent != nil {
+		attrs = append(attrs, attribute.String("fastapi.model_agent_id", mak.ModelAgent.Id), attribute.String("fastapi.model_agent", mak.ModelAgent.Name))
+	}
+
+	if mak.Key != nil {
+		attrs = append(attrs, attribute.String("fastapi.key_id", mak.Key.Id))
+	}
+
+	if mak.AgentTotal > 0 {
+		attrs = append(attrs, attribute.Int("fastapi.agent_total", mak.AgentTotal))
+	}
+
+	return gtrace.NewSpan(ctx, "upstream "+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// 结束链路, 记录错误状态
+func EndSpan(span *gtrace.Span, err error) {
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
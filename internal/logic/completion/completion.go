@@ -0,0 +1,451 @@
+package completion
+
+import (
+	"context"
+	"io"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+type sCompletion struct{}
+
+func init() {
+	service.RegisterCompletion(New())
+}
+
+func New() service.ICompletion {
+	return &sCompletion{}
+}
+
+// Completions
+func (s *sCompletion) Completions(ctx context.Context, data []byte, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (response smodel.ChatCompletionResponse, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sCompletion Completions time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	params := model.CompletionRequest{}
+	if err = gjson.Unmarshal(data, &params); err != nil {
+		logger.Errorf(ctx, "sCompletion Completions Unmarshal error: %v", err)
+		return response, err
+	}
+
+	var (
+		request     any
+		completion  string
+		isConverted bool
+	)
+
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name:        "sCompletion Completions",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_COMPLETIONS,
+				Messages:           promptMessages(params),
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+
+			// 护栏脱敏后的请求体
+			body := attempt.Guardrail.MaskBody(data)
+
+			if isConverted = isConvert(ctx, attempt.Mak); isConverted {
+				request, err = convRequest(ctx, attempt, body, params)
+				return err
+			}
+
+			if request, err = buildRequest(ctx, attempt, body, params); err != nil {
+				return err
+			}
+
+			attempt.Mak.SetUpstreamPath(consts.ENDPOINT_COMPLETIONS)
+
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "Completions")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, request)
+			common.EndSpan(upstreamSpan, err)
+
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ChatCompletionResponse) (smodel.ChatCompletionResponse, error) {
+
+			// 旧版响应的补全内容在choices[].text, 需按旧版格式解析
+			result := model.CompletionResponse{}
+			if isConverted {
+				result = convResponse(response)
+				response.ResponseBytes = gjson.MustEncode(result)
+			} else if err := gjson.Unmarshal(response.ResponseBytes, &result); err != nil {
+				logger.Error(ctx, err)
+				return response, err
+			}
+
+			completion = choicesText(result.Choices)
+
+			if result.Usage != nil {
+				response.Usage = result.Usage
+			}
+
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.ResponseBytes = replaceModel(ctx, attempt.Mak, response.ResponseBytes)
+			}
+
+			return response, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: smodel.ChatCompletionRequest{
+					Model:    params.Model,
+					Messages: attempt.Mak.Messages,
+					Stream:   params.Stream,
+				},
+				Action:     consts.ACTION_COMPLETIONS,
+				Completion: completion,
+				Usage:      res.Usage,
+				ConnTime:   res.ConnTime,
+				Duration:   res.Duration,
+				TotalTime:  res.TotalTime,
+			}
+		},
+	}
+
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
+}
+
+// CompletionsStream
+func (s *sCompletion) CompletionsStream(ctx context.Context, data []byte, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sCompletion CompletionsStream time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	params := model.CompletionRequest{}
+	if err = gjson.Unmarshal(data, &params); err != nil {
+		logger.Errorf(ctx, "sCompletion CompletionsStream Unmarshal error: %v", err)
+		return err
+	}
+
+	var (
+		request     any
+		completion  string
+		isConverted bool
+		connTime    int64
+		duration    int64
+		totalTime   int64
+		usage       *smodel.Usage
+		cancel      context.CancelFunc
+	)
+
+	pipeline := &common.Pipeline[chan *smodel.ChatCompletionResponse]{
+		Name:        "sCompletion CompletionsStream",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_COMPLETIONS,
+				Messages:           promptMessages(params),
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+
+			// 护栏脱敏后的请求体
+			body := attempt.Guardrail.MaskBody(data)
+
+			if isConverted = isConvert(ctx, attempt.Mak); isConverted {
+				request, err = convRequest(ctx, attempt, body, params)
+				return err
+			}
+
+			if request, err = buildRequest(ctx, attempt, body, params); err != nil {
+				return err
+			}
+
+			attempt.Mak.SetUpstreamPath(consts.ENDPOINT_COMPLETIONS)
+
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response chan *smodel.ChatCompletionResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "CompletionsStream")
+			upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, request)
+			attempt.EndStreamSpan(upstreamSpan, err)
+			if err != nil {
+				cancel()
+			}
+
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan *smodel.ChatCompletionResponse) (chan *smodel.ChatCompletionResponse, error) {
+
+			mak := attempt.Mak
+			streamTimeout := common.NewStreamTimeout(mak)
+			guardrail := attempt.Guardrail.Stream()
+
+			defer func() {
+				cancel()
+				close(responseChan)
+			}()
+
+			for {
+
+				response, err := common.StreamRecv(ctx, streamTimeout, responseChan)
+				if err != nil {
+
+					// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+					if common.IsStreamStalled(err) {
+						common.StreamErrorEvent(ctx, err)
+						return responseChan, err
+					}
+
+					// 未向客户端输出, 转入重试
+					return responseChan, attempt.UpstreamError(err)
+				}
+
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
+
+				if response.Error != nil {
+
+					if errors.Is(response.Error, io.EOF) {
+
+						// 补发护栏暂缓的内容
+						if flush := guardrail.Flush(ctx); flush != nil {
+							if err := util.SSEServer(ctx, string(flush.ResponseBytes)); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
+							}
+						}
+
+						if err := util.SSEServer(ctx, "[DONE]"); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+
+						return responseChan, nil
+					}
+
+					err := response.Error
+
+					// 记录错误次数和禁用
+					service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
+
+					if _, isDisabled := common.IsNeedRetry(err); isDisabled {
+						if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+							service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+
+						}, nil); err != nil {
+							logger.Error(ctx, err)
+						}
+					}
+
+					return responseChan, err
+				}
+
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+				}
+
+				chunk := model.CompletionResponse{}
+				if isConverted {
+
+					if len(response.Choices) == 0 && response.Usage == nil {
+						continue
+					}
+
+					chunk = convResponse(*response)
+					response.ResponseBytes = gjson.MustEncode(chunk)
+
+				} else {
+
+					if response.ResponseBytes == nil {
+						continue
+					}
+
+					if err := gjson.Unmarshal(response.ResponseBytes, &chunk); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
+				}
+
+				completion += choicesText(chunk.Choices)
+
+				if chunk.Usage != nil {
+					logger.Infof(ctx, "sCompletion CompletionsStream Usage: %s", response.ResponseBytes)
+					usage = chunk.Usage
+				}
+
+				// 替换成调用的模型
+				if mak.ReqModel.IsEnableForward {
+					response.ResponseBytes = replaceModel(ctx, mak, response.ResponseBytes)
+				}
+
+				// 护栏检查输出, 旧版数据块的补全内容在choices[].text, 命中屏蔽规则时以错误事件结束流
+				guarded := guardChunk(response.ResponseBytes, chunk.Choices)
+
+				flush, err := guardrail.Chunk(ctx, guarded)
+				if err != nil {
+					common.StreamErrorEvent(ctx, err)
+					return responseChan, err
+				}
+
+				if flush != nil {
+					if err := util.SSEServer(ctx, string(flush.ResponseBytes)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
+				}
+
+				if err := util.SSEServer(ctx, string(guarded.ResponseBytes)); err != nil {
+					logger.Error(ctx, err)
+					return responseChan, err
+				}
+			}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: smodel.ChatCompletionRequest{
+					Model:    params.Model,
+					Messages: attempt.Mak.Messages,
+					Stream:   params.Stream,
+				},
+				Action:     consts.ACTION_COMPLETIONS,
+				Completion: completion,
+				Usage:      usage,
+				ConnTime:   connTime,
+				Duration:   duration,
+				TotalTime:  totalTime,
+			}
+		},
+	}
+
+	// 流中断或输出被拦截已以错误事件结束流
+	if _, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); common.IsStreamStalled(err) || common.IsOutputBlocked(err) {
+		return nil
+	}
+
+	return err
+}
+
+// 按模型代理设置请求模型, 其余参数原样透传
+func buildRequest(ctx context.Context, attempt *common.Attempt, data []byte, params model.CompletionRequest) ([]byte, error) {
+
+	request := make(map[string]any)
+	if err := gjson.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	request["model"] = attempt.UpstreamModel(ctx, params.Model)
+
+	// 流式请求时要求返回用量, 用于计费
+	if params.Stream {
+		if _, ok := request["stream_options"]; !ok {
+			request["stream_options"] = g.Map{"include_usage": true}
+		}
+	}
+
+	return gjson.Encode(request)
+}
+
+// 提示词转换为消息, 用于计算令牌数和记录日志
+func promptMessages(params model.CompletionRequest) []smodel.ChatCompletionMessage {
+
+	var prompt string
+
+	switch value := params.Prompt.(type) {
+	case string:
+		prompt = value
+	case []any:
+		for _, v := range value {
+			prompt += gconv.String(v)
+		}
+	default:
+		prompt = gconv.String(value)
+	}
+
+	if params.Suffix != "" {
+		prompt += params.Suffix
+	}
+
+	return []smodel.ChatCompletionMessage{{
+		Role:    sconsts.ROLE_USER,
+		Content: prompt,
+	}}
+}
+
+// 旧版数据块转换为护栏检查的数据块, 补全内容作为增量内容
+func guardChunk(responseBytes []byte, choices []model.CompletionChoice) *smodel.ChatCompletionResponse {
+
+	chunk := &smodel.ChatCompletionResponse{
+		ResponseBytes: responseBytes,
+	}
+
+	if text := choicesText(choices); text != "" {
+
+		choice := smodel.ChatCompletionChoice{
+			Delta: &smodel.ChatCompletionStreamChoiceDelta{
+				Content: text,
+			},
+		}
+
+		if choices[len(choices)-1].FinishReason != "" {
+			choice.FinishReason = "stop"
+		}
+
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+
+	return chunk
+}
+
+func choicesText(choices []model.CompletionChoice) (text string) {
+	for _, choice := range choices {
+		text += choice.Text
+	}
+	return text
+}
+
+func replaceModel(ctx context.Context, mak *common.MAK, responseBytes []byte) []byte {
+
+	data := make(map[string]any)
+	if err := gjson.Unmarshal(responseBytes, &data); err != nil {
+		logger.Error(ctx, err)
+		return responseBytes
+	}
+
+	if _, ok := data["model"]; ok {
+		data["model"] = mak.ReqModel.Model
+	}
+
+	return gjson.MustEncode(data)
+}
@@ -0,0 +1,80 @@
+package completion
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// 转换为对话的请求参数, 旧版特有参数(echo, best_of, logprobs等)上游不支持, 不转换
+var convParams = []string{
+	"max_tokens", "temperature", "top_p", "n", "stop", "presence_penalty", "frequency_penalty", "logit_bias", "seed", "user",
+}
+
+// 上游不支持旧版补全接口时转换为对话接口, 仅 OpenAI 支持旧版补全
+func isConvert(ctx context.Context, mak *common.MAK) bool {
+	return common.GetProviderCode(ctx, mak.Provider) != sconsts.PROVIDER_OPENAI
+}
+
+// 旧版补全请求转换为对话请求, 提示词和后缀合并为用户消息, 使用护栏脱敏后的消息
+func convRequest(ctx context.Context, attempt *common.Attempt, data []byte, params model.CompletionRequest) (smodel.ChatCompletionRequest, error) {
+
+	request := make(map[string]any)
+	if err := gjson.Unmarshal(data, &request); err != nil {
+		return smodel.ChatCompletionRequest{}, err
+	}
+
+	chatRequest := g.Map{
+		"model":    attempt.UpstreamModel(ctx, params.Model),
+		"messages": attempt.Mak.Messages,
+		"stream":   params.Stream,
+	}
+
+	if params.Stream {
+		chatRequest["stream_options"] = g.Map{"include_usage": true}
+	}
+
+	for _, param := range convParams {
+		if value, ok := request[param]; ok {
+			chatRequest[param] = value
+		}
+	}
+
+	return common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvChatCompletionsRequest(ctx, gjson.MustEncode(chatRequest))
+}
+
+// 对话响应转换为旧版补全响应
+func convResponse(response smodel.ChatCompletionResponse) model.CompletionResponse {
+
+	result := model.CompletionResponse{
+		Id:      response.Id,
+		Object:  "text_completion",
+		Created: gconv.Int64(response.Created),
+		Model:   response.Model,
+		Usage:   response.Usage,
+	}
+
+	for _, choice := range response.Choices {
+
+		completionChoice := model.CompletionChoice{
+			Index:        choice.Index,
+			FinishReason: gconv.String(choice.FinishReason),
+		}
+
+		if choice.Message != nil {
+			completionChoice.Text = gconv.String(choice.Message.Content)
+		} else if choice.Delta != nil {
+			completionChoice.Text = choice.Delta.Content
+		}
+
+		result.Choices = append(result.Choices, completionChoice)
+	}
+
+	return result
+}
@@ -19,6 +19,7 @@ import (
 	_ "github.com/iimeta/fastapi/v2/internal/logic/key"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/model"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/model_agent"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/prompt_template"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/provider"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/reseller"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/user"
@@ -73,6 +74,7 @@ func init() {
 	channels = append(channels, consts.CHANGE_CHANNEL_KEY)
 	channels = append(channels, consts.CHANGE_CHANNEL_AGENT)
 	channels = append(channels, consts.CHANGE_CHANNEL_GROUP)
+	channels = append(channels, consts.CHANGE_CHANNEL_PROMPT_TEMPLATE)
 	channels = append(channels, consts.REFRESH_CHANNEL_API)
 
 	conn, _, err := redis.Subscribe(ctx, channels[0], channels[1:]...)
@@ -114,6 +116,8 @@ func init() {
 				err = service.ModelAgent().Subscribe(gctx.New(), msg.Payload)
 			case config.Cfg.Core.ChannelPrefix + consts.CHANGE_CHANNEL_GROUP:
 				err = service.Group().Subscribe(gctx.New(), msg.Payload)
+			case config.Cfg.Core.ChannelPrefix + consts.CHANGE_CHANNEL_PROMPT_TEMPLATE:
+				err = service.PromptTemplate().Subscribe(gctx.New(), msg.Payload)
 			case config.Cfg.Core.ChannelPrefix + consts.REFRESH_CHANNEL_API:
 				err = core.Refresh(gctx.New())
 			}
@@ -342,5 +346,17 @@ func (s *sCore) Refresh(ctx context.Context) error {
 		}
 	}
 
+	promptTemplates, err := service.PromptTemplate().List(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	// 已删除的提示词模板同时从缓存中移除
+	if err = service.PromptTemplate().RefreshCache(ctx, promptTemplates); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,303 @@
+package dashboard
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/container/gset"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 模型类型对应的输入输出模态
+var modalities = map[int][2][]string{
+	1:   {{"text"}, {"text"}},
+	2:   {{"text"}, {"image"}},
+	3:   {{"text", "image"}, {"text"}},
+	4:   {{"text", "image"}, {"image"}},
+	5:   {{"text"}, {"audio"}},
+	6:   {{"audio"}, {"text"}},
+	7:   {{"text"}, {"embedding"}},
+	8:   {{"text", "image"}, {"video"}},
+	9:   {{"text"}, {"rerank"}},
+	100: {{"text", "image"}, {"text"}},
+	101: {{"text", "audio"}, {"text", "audio"}},
+	102: {{"text", "audio"}, {"text", "audio"}},
+	103: {{"text", "image"}, {"embedding"}},
+}
+
+// Models
+func (s *sDashboard) Models(ctx context.Context, isFastAPI bool) (*model.DashboardModelsRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard Models time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	models, err := s.models(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard Models error: %v", err)
+		return nil, err
+	}
+
+	modelsRes := &model.DashboardModelsRes{
+		Object: "list",
+		Data:   []model.DashboardModelsData{},
+	}
+
+	for _, m := range models {
+
+		modelsData, err := s.modelsData(ctx, m, isFastAPI)
+		if err != nil {
+			logger.Errorf(ctx, "sDashboard Models error: %v", err)
+			return nil, err
+		}
+
+		modelsRes.Data = append(modelsRes.Data, *modelsData)
+	}
+
+	return modelsRes, nil
+}
+
+// Model
+func (s *sDashboard) Model(ctx context.Context, id string, isFastAPI bool) (*model.DashboardModelsData, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard Model time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	m, err := s.model(ctx, id)
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard Model id: %s, error: %v", id, err)
+		return nil, err
+	}
+
+	return s.modelsData(ctx, m, isFastAPI)
+}
+
+// Anthropic Models
+func (s *sDashboard) AnthropicModels(ctx context.Context) (*model.AnthropicModelsRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard AnthropicModels time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	models, err := s.models(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard AnthropicModels error: %v", err)
+		return nil, err
+	}
+
+	modelsRes := &model.AnthropicModelsRes{
+		Data: []*model.AnthropicModel{},
+	}
+
+	for _, m := range models {
+		modelsRes.Data = append(modelsRes.Data, anthropicModel(m))
+	}
+
+	if len(modelsRes.Data) > 0 {
+		modelsRes.FirstId = &modelsRes.Data[0].Id
+		modelsRes.LastId = &modelsRes.Data[len(modelsRes.Data)-1].Id
+	}
+
+	return modelsRes, nil
+}
+
+// Anthropic Model
+func (s *sDashboard) AnthropicModel(ctx context.Context, id string) (*model.AnthropicModel, error) {
+
+	m, err := s.model(ctx, id)
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard AnthropicModel id: %s, error: %v", id, err)
+		return nil, err
+	}
+
+	return anthropicModel(m), nil
+}
+
+// 当前密钥可用的模型, 按模型去重
+func (s *sDashboard) models(ctx context.Context) ([]*model.Model, error) {
+
+	modelIds := make([]string, 0)
+
+	if len(service.Session().GetUser(ctx).Groups) > 0 {
+		ids, err := service.Group().GetModelIds(ctx, service.Session().GetUser(ctx).Groups...)
+		if err != nil {
+			return nil, err
+		}
+		modelIds = append(modelIds, ids...)
+	}
+
+	if app, err := service.App().GetCache(ctx, service.Session().GetAppId(ctx)); err != nil {
+		return nil, err
+	} else if len(app.Models) > 0 {
+		modelIds = app.Models
+	} else if app.IsBindGroup {
+		if modelIds, err = service.Group().GetModelIds(ctx, app.Group); err != nil {
+			return nil, err
+		}
+	} else if appKey, err := service.AppKey().GetCache(ctx, service.Session().GetSecretKey(ctx)); err != nil {
+		return nil, err
+	} else if len(appKey.Models) > 0 {
+		modelIds = appKey.Models
+	} else if appKey.IsBindGroup {
+		if modelIds, err = service.Group().GetModelIds(ctx, appKey.Group); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(modelIds) == 0 {
+		return nil, nil
+	}
+
+	models, err := service.Model().GetCacheList(ctx, modelIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.Model, 0)
+	ids := gset.NewStrSet()
+
+	for _, m := range models {
+		if m.Status == 1 && ids.AddIfNotExist(m.Model) {
+			list = append(list, m)
+		}
+	}
+
+	return list, nil
+}
+
+// 当前密钥可用的指定模型
+func (s *sDashboard) model(ctx context.Context, id string) (*model.Model, error) {
+
+	models, err := s.models(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range models {
+		if m.Model == id {
+			return m, nil
+		}
+	}
+
+	return nil, errors.ERR_MODEL_NOT_FOUND
+}
+
+func (s *sDashboard) modelsData(ctx context.Context, m *model.Model, isFastAPI bool) (*model.DashboardModelsData, error) {
+
+	provider, err := service.Provider().GetCache(ctx, m.ProviderId)
+	if err != nil {
+		return nil, err
+	}
+
+	modelsData := &model.DashboardModelsData{
+		Id:      m.Model,
+		Object:  "model",
+		OwnedBy: gstr.ToLower(provider.Code),
+		Created: gconv.Int(m.CreatedAt / 1000),
+		Root:    m.Model,
+		Permission: []model.Permission{{
+			Id:                "modelperm-" + m.Model,
+			Object:            "model_permission",
+			Created:           gconv.Int(m.CreatedAt / 1000),
+			AllowCreateEngine: true,
+			AllowSampling:     true,
+			AllowLogprobs:     true,
+			AllowView:         true,
+			Organization:      "*",
+		}},
+	}
+
+	if !isFastAPI {
+		return modelsData, nil
+	}
+
+	modelsData.FastAPI = &model.FastAPI{
+		Provider:            provider.Name,
+		Code:                provider.Code,
+		Model:               m.Model,
+		Type:                m.Type,
+		Endpoints:           m.Endpoints,
+		ContextWindow:       m.ContextWindow,
+		InputModalities:     modalities[m.Type][0],
+		OutputModalities:    modalities[m.Type][1],
+		IsSupportStream:     true,
+		IsSupportSystemRole: true,
+		Pricing:             m.Pricing,
+		Remark:              m.Remark,
+	}
+
+	// 未启用预设配置时不做限制
+	if m.IsEnablePresetConfig {
+		modelsData.FastAPI.MaxOutputTokens = m.PresetConfig.MaxTokens
+		modelsData.FastAPI.IsSupportStream = m.PresetConfig.IsSupportStream
+		modelsData.FastAPI.IsSupportSystemRole = m.PresetConfig.IsSupportSystemRole
+	}
+
+	group := s.group(ctx, m)
+	if group != nil {
+		modelsData.FastAPI.Group = group.Name
+	}
+
+	modelsData.FastAPI.EffectivePricing, modelsData.FastAPI.Discount = common.EffectivePricing(ctx, m, group)
+
+	return modelsData, nil
+}
+
+// 调用方使用该模型时的计费分组, 与请求时的分组选择一致, 无法确定时返回nil
+func (s *sDashboard) group(ctx context.Context, m *model.Model) *model.Group {
+
+	app, err := service.App().GetCache(ctx, service.Session().GetAppId(ctx))
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard group GetApp error: %v", err)
+		return nil
+	}
+
+	appKey, err := service.AppKey().GetCache(ctx, service.Session().GetSecretKey(ctx))
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard group GetAppKey error: %v", err)
+		return nil
+	}
+
+	var group *model.Group
+
+	if appKey.IsBindGroup {
+		group, err = service.Group().GetCache(ctx, appKey.Group)
+	} else if app.IsBindGroup {
+		group, err = service.Group().GetCache(ctx, app.Group)
+	} else {
+		_, group, err = service.Group().PickGroupAndModel(ctx, appKey, m.Model, service.Session().GetUser(ctx).Groups...)
+	}
+
+	if err != nil {
+		logger.Debugf(ctx, "sDashboard group model: %s, error: %v", m.Model, err)
+		return nil
+	}
+
+	return group
+}
+
+func anthropicModel(m *model.Model) *model.AnthropicModel {
+
+	displayName := m.Name
+	if displayName == "" {
+		displayName = m.Model
+	}
+
+	return &model.AnthropicModel{
+		Type:        "model",
+		Id:          m.Model,
+		DisplayName: displayName,
+		CreatedAt:   time.UnixMilli(m.CreatedAt).UTC().Format(time.RFC3339),
+	}
+}
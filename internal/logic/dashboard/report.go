@@ -0,0 +1,390 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// 时间桶宽度(秒)
+var bucketWidths = map[string]int64{
+	"1m": 60,
+	"1h": 3600,
+	"1d": 86400,
+}
+
+// 每页时间桶数[默认, 最大]
+var bucketLimits = map[string][2]int{
+	"1m": {60, 1440},
+	"1h": {24, 168},
+	"1d": {7, 31},
+}
+
+// 分组对应的日志字段
+var groupFields = map[string]string{
+	"model":      "$model",
+	"app_id":     "$app_id",
+	"api_key_id": "$creator",
+	"endpoint":   "$path",
+	"status":     "$status",
+}
+
+// 日志状态
+var logStatus = map[int]string{
+	1:  "success",
+	2:  "aborted",
+	4:  "stream_stalled",
+	-1: "error",
+}
+
+// 参与统计的日志集合
+var logCollections = []string{
+	dao.LOG_TEXT,
+	dao.LOG_IMAGE,
+	dao.LOG_AUDIO,
+	dao.LOG_VIDEO,
+	dao.LOG_FILE,
+	dao.LOG_BATCH,
+	dao.LOG_GENERAL,
+}
+
+type report struct {
+	endTime int64
+	groupBy []string
+	hasMore bool
+	buckets []*model.DashboardBucket
+	rows    map[int64][]*reportRow // 时间桶开始时间(秒) -> 统计结果
+}
+
+type reportRow struct {
+	Id                reportGroup `bson:"_id"`
+	Requests          int         `bson:"requests"`
+	InputTokens       int         `bson:"input_tokens"`
+	OutputTokens      int         `bson:"output_tokens"`
+	InputCachedTokens int         `bson:"input_cached_tokens"`
+	SpendTokens       int         `bson:"spend_tokens"`
+}
+
+type reportGroup struct {
+	Bucket   int64  `bson:"bucket"`
+	Model    string `bson:"model"`
+	AppId    int    `bson:"app_id"`
+	ApiKey   string `bson:"api_key_id"`
+	Endpoint string `bson:"endpoint"`
+	Status   int    `bson:"status"`
+}
+
+// Usage明细
+func (s *sDashboard) UsageDetail(ctx context.Context, params model.DashboardReportReq) (*model.DashboardReportRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard UsageDetail time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	r, err := s.report(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range r.buckets {
+		for _, row := range r.rows[bucket.StartTime] {
+			bucket.Results = append(bucket.Results, &model.DashboardUsageResult{
+				Object:            "organization.usage.completions.result",
+				InputTokens:       row.InputTokens,
+				OutputTokens:      row.OutputTokens,
+				InputCachedTokens: row.InputCachedTokens,
+				NumModelRequests:  row.Requests,
+				SpendTokens:       row.SpendTokens,
+				DashboardGroup:    r.group(row),
+			})
+		}
+	}
+
+	return r.res(), nil
+}
+
+// Costs
+func (s *sDashboard) Costs(ctx context.Context, params model.DashboardReportReq) (*model.DashboardReportRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard Costs time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	r, err := s.report(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range r.buckets {
+		for _, row := range r.rows[bucket.StartTime] {
+			bucket.Results = append(bucket.Results, &model.DashboardCostsResult{
+				Object: "organization.costs.result",
+				Amount: model.DashboardAmount{
+					Value:    common.ConvQuota(row.SpendTokens),
+					Currency: "usd",
+				},
+				DashboardGroup: r.group(row),
+			})
+		}
+	}
+
+	return r.res(), nil
+}
+
+// 按时间桶和分组统计当前页的日志
+func (s *sDashboard) report(ctx context.Context, params model.DashboardReportReq) (*report, error) {
+
+	if params.StartTime <= 0 {
+		return nil, errors.NewError(400, "missing_required_parameter", "Missing required parameter: 'start_time'.", "invalid_request_error", "start_time")
+	}
+
+	if params.EndTime <= 0 {
+		params.EndTime = gtime.Timestamp()
+	}
+
+	if params.EndTime <= params.StartTime {
+		return nil, errors.NewError(400, "invalid_value", "'end_time' must be greater than 'start_time'.", "invalid_request_error", "end_time")
+	}
+
+	if params.BucketWidth == "" {
+		params.BucketWidth = "1d"
+	}
+
+	bucketWidth, ok := bucketWidths[params.BucketWidth]
+	if !ok {
+		return nil, errors.NewErrorf(400, "invalid_value", "Invalid 'bucket_width': %s, supported values are: 1m, 1h, 1d.", "invalid_request_error", "bucket_width", params.BucketWidth)
+	}
+
+	limits := bucketLimits[params.BucketWidth]
+	if params.Limit <= 0 {
+		params.Limit = limits[0]
+	} else if params.Limit > limits[1] {
+		return nil, errors.NewErrorf(400, "invalid_value", "Invalid 'limit': %d, maximum for bucket_width %s is %d.", "invalid_request_error", "limit", params.Limit, params.BucketWidth, limits[1])
+	}
+
+	groupBy := make([]string, 0)
+	for _, value := range params.GroupBy {
+		for _, field := range strings.Split(value, ",") {
+
+			if field = strings.TrimSpace(field); field == "" || slices.Contains(groupBy, field) {
+				continue
+			}
+
+			if _, ok := groupFields[field]; !ok {
+				return nil, errors.NewErrorf(400, "invalid_value", "Invalid 'group_by': %s, supported values are: model, app_id, api_key_id, endpoint, status.", "invalid_request_error", "group_by", field)
+			}
+
+			groupBy = append(groupBy, field)
+		}
+	}
+
+	// 当前页开始时间, 按时间桶宽度对齐
+	pageStart := params.StartTime
+	if params.Page != "" {
+
+		cursor, err := strconv.ParseInt(params.Page, 10, 64)
+		if err != nil || cursor < params.StartTime || cursor >= params.EndTime {
+			return nil, errors.NewErrorf(400, "invalid_value", "Invalid 'page': %s.", "invalid_request_error", "page", params.Page)
+		}
+
+		pageStart = params.StartTime + (cursor-params.StartTime)/bucketWidth*bucketWidth
+	}
+
+	pageEnd := min(pageStart+int64(params.Limit)*bucketWidth, params.EndTime)
+
+	r := &report{
+		endTime: pageEnd,
+		groupBy: groupBy,
+		hasMore: pageEnd < params.EndTime,
+		rows:    make(map[int64][]*reportRow),
+	}
+
+	for start := pageStart; start < pageEnd; start += bucketWidth {
+		r.buckets = append(r.buckets, &model.DashboardBucket{
+			Object:    "bucket",
+			StartTime: start,
+			EndTime:   min(start+bucketWidth, pageEnd),
+			Results:   make([]any, 0),
+		})
+	}
+
+	filter := scopeFilter(ctx)
+	filter["req_time"] = bson.M{"$gte": pageStart * 1000, "$lt": pageEnd * 1000}
+	filter["status"] = bson.M{"$ne": 3} // 重试中间状态不统计
+
+	if len(params.Models) > 0 {
+		filter["model"] = bson.M{"$in": params.Models}
+	}
+
+	id := bson.M{
+		"bucket": bson.M{"$subtract": bson.A{"$req_time", bson.M{"$mod": bson.A{bson.M{"$subtract": bson.A{"$req_time", pageStart * 1000}}, bucketWidth * 1000}}}},
+	}
+
+	for _, field := range groupBy {
+		id[field] = groupFields[field]
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id":                 id,
+			"requests":            bson.M{"$sum": 1},
+			"input_tokens":        sumFields("spend.text.input_tokens", "spend.tiered_text.input_tokens", "spend.image.input_tokens", "spend.audio.input_tokens", "spend.video.input_tokens", "spend.once.input_tokens", "spend.text_cache.read_tokens", "spend.text_cache.write_tokens", "spend.tiered_text_cache.read_tokens", "spend.tiered_text_cache.write_tokens"),
+			"output_tokens":       sumFields("spend.text.output_tokens", "spend.tiered_text.output_tokens", "spend.image.output_tokens", "spend.audio.output_tokens", "spend.video.output_tokens", "spend.once.output_tokens"),
+			"input_cached_tokens": sumFields("spend.text_cache.read_tokens", "spend.tiered_text_cache.read_tokens", "spend.image_cache.read_tokens", "spend.audio_cache.read_tokens", "spend.video_cache.read_tokens"),
+			"spend_tokens":        sumFields("spend.total_spend_tokens"),
+		}},
+	}
+
+	// 各日志集合的同一分组合并
+	merged := make(map[string]*reportRow)
+	keys := make([]string, 0)
+
+	for _, collection := range logCollections {
+
+		rows := make([]*reportRow, 0)
+		if err := dao.Aggregate(ctx, dao.LogText.Database, collection, pipeline, &rows); err != nil {
+			logger.Errorf(ctx, "sDashboard report collection: %s, error: %v", collection, err)
+			return nil, err
+		}
+
+		for _, row := range rows {
+
+			key := fmt.Sprintf("%d|%s|%d|%s|%s|%d", row.Id.Bucket, row.Id.Model, row.Id.AppId, row.Id.ApiKey, row.Id.Endpoint, row.Id.Status)
+
+			if m, ok := merged[key]; ok {
+				m.Requests += row.Requests
+				m.InputTokens += row.InputTokens
+				m.OutputTokens += row.OutputTokens
+				m.InputCachedTokens += row.InputCachedTokens
+				m.SpendTokens += row.SpendTokens
+			} else {
+				merged[key] = row
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	slices.Sort(keys)
+
+	apiKeys := make([]string, 0)
+	for _, key := range keys {
+
+		row := merged[key]
+		row.Id.Bucket /= 1000
+
+		r.rows[row.Id.Bucket] = append(r.rows[row.Id.Bucket], row)
+
+		if row.Id.ApiKey != "" && !slices.Contains(apiKeys, row.Id.ApiKey) {
+			apiKeys = append(apiKeys, row.Id.ApiKey)
+		}
+	}
+
+	// 日志中记录的是密钥, 返回密钥ID
+	if len(apiKeys) > 0 {
+
+		appKeys, err := dao.AppKey.Find(ctx, bson.M{"key": bson.M{"$in": apiKeys}})
+		if err != nil {
+			logger.Errorf(ctx, "sDashboard report AppKey Find error: %v", err)
+			return nil, err
+		}
+
+		ids := make(map[string]string)
+		for _, appKey := range appKeys {
+			ids[appKey.Key] = appKey.Id
+		}
+
+		for _, rows := range r.rows {
+			for _, row := range rows {
+				if row.Id.ApiKey != "" {
+					if id, ok := ids[row.Id.ApiKey]; ok {
+						row.Id.ApiKey = id
+					} else {
+						row.Id.ApiKey = util.MaskKey(row.Id.ApiKey)
+					}
+				}
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// 分组字段, 未参与分组的为 null
+func (r *report) group(row *reportRow) model.DashboardGroup {
+
+	group := model.DashboardGroup{}
+
+	for _, field := range r.groupBy {
+		switch field {
+		case "model":
+			group.Model = &row.Id.Model
+		case "app_id":
+			group.AppId = &row.Id.AppId
+		case "api_key_id":
+			group.ApiKeyId = &row.Id.ApiKey
+		case "endpoint":
+			group.Endpoint = &row.Id.Endpoint
+		case "status":
+			status := logStatus[row.Id.Status]
+			group.Status = &status
+		}
+	}
+
+	return group
+}
+
+func (r *report) res() *model.DashboardReportRes {
+
+	res := &model.DashboardReportRes{
+		Object:  "page",
+		Data:    r.buckets,
+		HasMore: r.hasMore,
+	}
+
+	if r.hasMore {
+		nextPage := strconv.FormatInt(r.endTime, 10)
+		res.NextPage = &nextPage
+	}
+
+	return res
+}
+
+// 数据范围: 密钥单独限额时只统计当前密钥, 应用单独限额时统计当前应用, 否则统计当前用户
+func scopeFilter(ctx context.Context) bson.M {
+
+	filter := bson.M{"user_id": service.Session().GetUserId(ctx)}
+
+	if service.Session().GetKeyIsLimitQuota(ctx) {
+		filter["app_id"] = service.Session().GetAppId(ctx)
+		filter["creator"] = service.Session().GetSecretKey(ctx)
+	} else if service.Session().GetAppIsLimitQuota(ctx) {
+		filter["app_id"] = service.Session().GetAppId(ctx)
+	}
+
+	return filter
+}
+
+func sumFields(fields ...string) bson.M {
+
+	values := make(bson.A, 0, len(fields))
+	for _, field := range fields {
+		values = append(values, bson.M{"$ifNull": bson.A{"$" + field, 0}})
+	}
+
+	return bson.M{"$sum": bson.M{"$add": values}}
+}
@@ -2,11 +2,7 @@ package embedding
 
 import (
 	"context"
-	"slices"
 
-	"github.com/gogf/gf/v2/frame/g"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
@@ -43,138 +39,70 @@ func (s *sEmbedding) Embeddings(ctx context.Context, data []byte, fallbackModelA
 		return response, err
 	}
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_EMBEDDINGS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
+	pipeline := &common.Pipeline[smodel.EmbeddingResponse]{
+		Name: "sEmbedding Embeddings",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_EMBEDDINGS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				CacheRequest:       params,
 			}
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) (response smodel.EmbeddingResponse, done bool, err error) {
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					EmbeddingReq: params,
-					Action:       consts.ACTION_EMBEDDINGS,
-					Usage:        response.Usage,
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				if retryInfo == nil && len(response.Data) > 0 {
-					afterHandler.Completion = gconv.String(response.Data[0])
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+			// 命中响应缓存
+			if !attempt.Mak.IsCacheHit() {
+				return response, false, nil
 			}
-		}
-	}()
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := params
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sEmbedding Embeddings request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				break
+			if err = attempt.Mak.ScanResponseCache(&response); err != nil {
+				logger.Error(ctx, err)
+				return response, true, err
 			}
-		}
-	}
 
-	response, err = common.NewAdapter(ctx, mak, false).TextEmbeddings(ctx, data)
-	if err != nil {
-		logger.Error(ctx, err)
+			response.TotalTime = 0
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			return response, true, nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.EmbeddingResponse, err error) {
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "TextEmbeddings")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).TextEmbeddings(upstreamCtx, data)
+			common.EndSpan(upstreamSpan, err)
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.EmbeddingResponse) (smodel.EmbeddingResponse, error) {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			// 写入响应缓存
+			attempt.Mak.SaveResponseCache(ctx, response)
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			return response, nil
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *smodel.EmbeddingResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
 			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Embeddings(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Embeddings(g.RequestFromCtx(ctx).GetCtx(), data, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.EmbeddingResponse, err error) *mcommon.AfterHandler {
+
+			after := &mcommon.AfterHandler{
+				EmbeddingReq: params,
+				Action:       consts.ACTION_EMBEDDINGS,
+				Usage:        res.Usage,
+				TotalTime:    res.TotalTime,
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			if len(res.Data) > 0 {
+				after.Completion = gconv.String(res.Data[0])
 			}
 
-			return s.Embeddings(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+			return after
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
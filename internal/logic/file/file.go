@@ -3,7 +3,6 @@ package file
 import (
 	"context"
 	"fmt"
-	"slices"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
@@ -48,133 +47,50 @@ func (s *sFile) Upload(ctx context.Context, params *v1.UploadReq, fallbackModelA
 		logger.Debugf(ctx, "sFile Upload time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
+	var filePath string
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					Action:       consts.ACTION_UPLOAD,
-					IsFile:       true,
-					FileId:       response.Id,
-					FileRes:      response,
-					RequestData:  util.ConvToMap(params.FileUploadRequest),
-					ResponseData: util.ConvToMap(response.ResponseBytes),
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.FileResponse]{
+		Name: "sFile Upload",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.FileResponse, err error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := *params
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sFile Upload request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
+			// 网关批处理文件存储在本地
+			if params.Purpose == "batch" && common.IsNativeBatch(ctx, attempt.Mak) {
+				response, filePath, err = uploadNative(ctx, params)
+				return response, err
 			}
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).FileUpload(ctx, request.FileUploadRequest)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+			request := *params
+			request.Model = attempt.ReplaceModel(ctx, request.Model)
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Upload(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Upload(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
-			}
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "FileUpload")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).FileUpload(upstreamCtx, request.FileUploadRequest)
+			common.EndSpan(upstreamSpan, err)
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.FileResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				Action:        consts.ACTION_UPLOAD,
+				IsFile:        true,
+				FileId:        res.Id,
+				FileRes:       res,
+				IsNativeBatch: filePath != "",
+				FilePath:      filePath,
+				RequestData:   util.ConvToMap(params.FileUploadRequest),
+				ResponseData:  util.ConvToMap(res.ResponseBytes),
+				TotalTime:     res.TotalTime,
 			}
-
-			return s.Upload(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // List
@@ -543,13 +459,19 @@ func (s *sFile) Content(ctx context.Context, params *v1.ContentReq) (response sm
 		return response, err
 	}
 
-	if config.Cfg.FileTask.IsEnableStorage && taskFile.FilePath != "" {
+	if (config.Cfg.FileTask.IsEnableStorage || taskFile.IsNative) && taskFile.FilePath != "" {
 		if bytes := gfile.GetBytes(taskFile.FilePath); bytes != nil {
 			response = smodel.FileContentResponse{Data: bytes}
 			return response, nil
 		}
 	}
 
+	// 网关批处理文件仅存储在本地
+	if taskFile.IsNative {
+		err = errors.NewError(404, "invalid_request_error", "No such File object: "+params.FileId, "invalid_request_error", "id")
+		return response, err
+	}
+
 	var adapter sdk.AdapterGroup
 
 	if taskFile.Purpose != "batch_output" {
@@ -0,0 +1,71 @@
+package file
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/grand"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	v1 "github.com/iimeta/fastapi/v2/api/file/v1"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 网关批处理文件上传, 存储在本地, 返回文件存储路径
+func uploadNative(ctx context.Context, params *v1.UploadReq) (response smodel.FileResponse, filePath string, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "uploadNative time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if params.File == nil {
+		return response, "", errors.NewError(400, "missing_required_parameter", "Missing required parameter: 'file'.", "invalid_request_error", "file")
+	}
+
+	file, err := params.File.Open()
+	if err != nil {
+		logger.Error(ctx, err)
+		return response, "", err
+	}
+
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(ctx, err)
+		}
+	}()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		logger.Error(ctx, err)
+		return response, "", err
+	}
+
+	fileId := "file-" + grand.S(24)
+	filePath = gfile.Join(common.BatchStorageDir(), fileId+".jsonl")
+
+	if err = gfile.PutBytes(filePath, data); err != nil {
+		logger.Error(ctx, err)
+		return response, "", err
+	}
+
+	response = smodel.FileResponse{
+		Id:        fileId,
+		Object:    "file",
+		Purpose:   params.Purpose,
+		Filename:  params.File.Filename,
+		Bytes:     len(data),
+		CreatedAt: time.Now().Unix(),
+		Status:    "processed",
+		TotalTime: gtime.TimestampMilli() - now,
+	}
+
+	response.ResponseBytes = gjson.MustEncode(response)
+
+	return response, filePath, nil
+}
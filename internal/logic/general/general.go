@@ -7,7 +7,6 @@ import (
 	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
-	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
@@ -49,131 +48,45 @@ func (s *sGeneral) General(ctx context.Context, request *ghttp.Request, fallback
 		return response, err
 	}
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           request.URL.Path,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					RequestData:       request.GetRequestMap(),
-					ResponseData:      util.ConvToMap(response.ResponseBytes),
-					ChatCompletionReq: params,
-					ChatCompletionRes: response,
-					Action:            generalAction(request.URL.Path),
-					Usage:             response.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          response.ConnTime,
-					Duration:          response.Duration,
-					TotalTime:         response.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name: "sGeneral General",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           request.URL.Path,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	if mak.Path == "" {
-		mak.Path = request.URL.Path
-		if gstr.HasSuffix(mak.BaseUrl, "/v1beta") {
-			mak.Path = mak.Path[7:]
-		} else if gstr.HasSuffix(mak.BaseUrl, "/v1") {
-			mak.Path = mak.Path[3:]
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).ChatCompletions(ctx, request.GetBody())
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+			setPath(attempt.Mak, request.URL.Path)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, request.GetBody())
+			common.EndSpan(upstreamSpan, err)
+
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				RequestData:       request.GetRequestMap(),
+				ResponseData:      util.ConvToMap(res.ResponseBytes),
+				ChatCompletionReq: params,
+				ChatCompletionRes: res,
+				Action:            generalAction(request.URL.Path),
+				Usage:             res.Usage,
+				ConnTime:          res.ConnTime,
+				Duration:          res.Duration,
+				TotalTime:         res.TotalTime,
 			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.General(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.General(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
-			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.General(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // GeneralStream
@@ -191,264 +104,202 @@ func (s *sGeneral) GeneralStream(ctx context.Context, request *ghttp.Request, fa
 	}
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           request.URL.Path,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
 		completion  string
 		serviceTier string
 		connTime    int64
 		duration    int64
 		totalTime   int64
 		usage       *smodel.Usage
-		retryInfo   *mcommon.Retry
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					RequestData:       request.GetRequestMap(),
-					ChatCompletionReq: params,
-					Completion:        completion,
-					ServiceTier:       serviceTier,
-					Action:            generalAction(request.URL.Path),
-					Usage:             usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[chan *smodel.ChatCompletionResponse]{
+		Name: "sGeneral GeneralStream",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           request.URL.Path,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+			setPath(attempt.Mak, request.URL.Path)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (responseChan chan *smodel.ChatCompletionResponse, err error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStream")
+			responseChan, err = common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, request.GetBody())
+			attempt.EndStreamSpan(upstreamSpan, err)
 
-	if mak.Path == "" {
-		mak.Path = request.URL.Path
-		if gstr.HasSuffix(mak.BaseUrl, "/v1beta") {
-			mak.Path = mak.Path[7:]
-		} else if gstr.HasSuffix(mak.BaseUrl, "/v1") {
-			mak.Path = mak.Path[3:]
-		}
-	}
-
-	response, err := common.NewAdapter(ctx, mak, true).ChatCompletionsStream(ctx, request.GetBody())
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan *smodel.ChatCompletionResponse) (_ chan *smodel.ChatCompletionResponse, err error) {
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			mak := attempt.Mak
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			defer close(responseChan)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			for {
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+				response := <-responseChan
 
-		if isRetry {
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+				if response.Error != nil {
 
-					if mak.RealModel.IsEnableFallback {
+					if errors.Is(response.Error, io.EOF) {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.GeneralStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-							}
+						if err = util.SSEServer(ctx, "[DONE]"); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
 						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.GeneralStream(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-							}
-						}
+						return responseChan, nil
 					}
 
-					return err
-				}
-			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.GeneralStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return err
-	}
-
-	defer close(response)
-
-	for {
+					err = response.Error
 
-		response := <-response
+					// 记录错误次数和禁用
+					service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+					if _, isDisabled := common.IsNeedRetry(err); isDisabled {
+						if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 
-		if response.Error != nil {
+							service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
 
-			if errors.Is(response.Error, io.EOF) {
+						}, nil); err != nil {
+							logger.Error(ctx, err)
+						}
+					}
 
-				if err = util.SSEServer(ctx, "[DONE]"); err != nil {
-					logger.Error(ctx, err)
-					return err
+					return responseChan, err
 				}
 
-				return nil
-			}
-
-			err = response.Error
-
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-			if _, isDisabled := common.IsNeedRetry(err); isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+				}
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+					if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
+						completion += response.Choices[0].Delta.Audio.Transcript
+					} else {
+						if len(response.Choices) > 1 {
+							for i, choice := range response.Choices {
+								completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+							}
+						} else {
+							if response.Choices[0].Delta.ReasoningContent != nil {
+								completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+							}
+							completion += response.Choices[0].Delta.Content
+						}
+					}
+				}
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
+					completion += gconv.String(response.Choices[0].Delta.ToolCalls)
 				}
-			}
 
-			return err
-		}
+				if response.ServiceTier != "" {
+					serviceTier = response.ServiceTier
+				}
 
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+				if response.Usage != nil {
+					logger.Infof(ctx, "sGeneral GeneralStream Usage: %s", response.ResponseBytes)
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-			if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
-				completion += response.Choices[0].Delta.Audio.Transcript
-			} else {
-				if len(response.Choices) > 1 {
-					for i, choice := range response.Choices {
-						completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
-					}
-				} else {
-					if response.Choices[0].Delta.ReasoningContent != nil {
-						completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+					if usage == nil {
+						usage = response.Usage
+					} else {
+						if response.Usage.PromptTokens != 0 {
+							usage.PromptTokens = response.Usage.PromptTokens
+						}
+						if response.Usage.CompletionTokens != 0 {
+							usage.CompletionTokens = response.Usage.CompletionTokens
+						}
+						if response.Usage.TotalTokens != 0 {
+							usage.TotalTokens = response.Usage.TotalTokens
+						} else {
+							usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+						}
+						if response.Usage.CacheCreationInputTokens != 0 {
+							usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+						}
+						if response.Usage.CacheReadInputTokens != 0 {
+							usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+						}
 					}
-					completion += response.Choices[0].Delta.Content
 				}
-			}
-		}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
-			completion += gconv.String(response.Choices[0].Delta.ToolCalls)
-		}
+				// 数据透传
+				if mak.Passthrough != nil && slices.Contains(mak.Passthrough.ResParams, "res_data") && response.ResponseBytes != nil {
 
-		if response.ServiceTier != "" {
-			serviceTier = response.ServiceTier
-		}
+					if mak.ReqModel.IsEnableForward {
 
-		if response.Usage != nil {
-			logger.Infof(ctx, "sGeneral GeneralStream Usage: %s", response.ResponseBytes)
+						data := make(map[string]any)
+						if err = gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
 
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
-				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
-				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-				}
-				if response.Usage.CacheCreationInputTokens != 0 {
-					usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
-				}
-				if response.Usage.CacheReadInputTokens != 0 {
-					usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
-				}
-			}
-		}
+						if _, ok := data["model"]; ok {
+							data["model"] = mak.ReqModel.Model
+						}
+
+						response.ResponseBytes = gjson.MustEncode(data)
+					}
 
-		// 数据透传
-		if mak.Passthrough != nil && slices.Contains(mak.Passthrough.ResParams, "res_data") && response.ResponseBytes != nil {
+					if err = util.SSEServer(ctx, string(response.ResponseBytes)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 
-			if mak.ReqModel.IsEnableForward {
+				} else {
 
-				data := make(map[string]any)
-				if err = gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
+					if mak.ReqModel.IsEnableForward {
+						response.Model = mak.ReqModel.Model
+					}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.ReqModel.Model
+					if err = util.SSEServer(ctx, gjson.MustEncodeString(response)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-
-				response.ResponseBytes = gjson.MustEncode(data)
 			}
-
-			if err = util.SSEServer(ctx, string(response.ResponseBytes)); err != nil {
-				logger.Error(ctx, err)
-				return err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				RequestData:       request.GetRequestMap(),
+				ChatCompletionReq: params,
+				Action:            generalAction(request.URL.Path),
+				Completion:        completion,
+				ServiceTier:       serviceTier,
+				Usage:             usage,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
 			}
+		},
+	}
 
-		} else {
+	_, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
-			}
+	return err
+}
 
-			if err = util.SSEServer(ctx, gjson.MustEncodeString(response)); err != nil {
-				logger.Error(ctx, err)
-				return err
-			}
+// 模型代理未配置路径时使用请求路径
+func setPath(mak *common.MAK, path string) {
+	if mak.Path == "" {
+		mak.Path = path
+		if gstr.HasSuffix(mak.BaseUrl, "/v1beta") {
+			mak.Path = mak.Path[7:]
+		} else if gstr.HasSuffix(mak.BaseUrl, "/v1") {
+			mak.Path = mak.Path[3:]
 		}
 	}
 }
@@ -5,13 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
@@ -45,261 +42,205 @@ func (s *sGoogle) Completions(ctx context.Context, request *ghttp.Request, fallb
 	}()
 
 	var (
-		converter = common.NewConverter(ctx, sconsts.PROVIDER_GOOGLE)
-		params    = convToChatCompletionRequest(request)
-		mak       = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           googleAction(request.URL.Path),
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo       *mcommon.Retry
-		imageFilePaths  []string
-		imageExpiresAt  int64
-		storedImageData []smodel.ImageResponseData
+		converter        = common.NewConverter(ctx, sconsts.PROVIDER_GOOGLE)
+		params           = convToChatCompletionRequest(request)
+		reqModel         = params.Model
+		action           = googleAction(request.URL.Path)
+		protocol         string
+		body             []byte
+		res              any
+		embeddingRequest smodel.EmbeddingRequest
+		imageFilePaths   []string
+		imageExpiresAt   int64
+		storedImageData  []smodel.ImageResponseData
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
+	pipeline := &common.Pipeline[smodel.ChatCompletionResponse]{
+		Name:        "sGoogle Completions",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           action,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
+			}
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, done bool, err error) {
 
-		if mak.ReqModel != nil && mak.RealModel != nil {
+			protocol = upstreamProtocol(ctx, attempt.Mak)
 
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
+			if protocol == protocolGenerateContent || action != actionCountTokens {
+				return response, false, nil
 			}
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				after := &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: response,
-					Action:            googleAction(request.URL.Path),
-					Usage:             response.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          response.ConnTime,
-					Duration:          response.Duration,
-					TotalTime:         response.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				}
+			// 非 Gemini 上游本地估算, 与上游提供商无关
+			totalTokens, err := countTokens(ctx, request.GetBody(), reqModel)
+			if err != nil {
+				logger.Error(ctx, err)
+				return response, true, err
+			}
 
-				if mak.ReqModel.Type == 2 {
+			response.Model = reqModel
+			response.ResponseBytes = gjson.MustEncode(g.Map{"totalTokens": totalTokens})
 
-					if after.ImageGenerationRequest, err = converter.ConvImageGenerationsRequest(ctx, request.GetBody()); err != nil {
-						logger.Error(ctx, err)
-					}
+			return response, true, nil
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+			body, err = attempt.TransformBody(ctx, request.GetBody(), reqModel, &params, requestConverter(protocol, action, false, &params))
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.ChatCompletionResponse, err error) {
 
-					if after.ImageGenerationRequest.Model == "" {
-						after.ImageGenerationRequest.Model = mak.ReqModel.Model
-					}
+			switch {
+			case protocol == protocolGenerateContent:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsOfficial")
+				res, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, false).ChatCompletionsOfficial(upstreamCtx, body)
+				common.EndSpan(upstreamSpan, err)
 
-					if after.ImageResponse, err = converter.ConvImageGenerationsResponse(ctx, response.ResponseBytes); err != nil {
-						logger.Error(ctx, err)
-					}
+			case isEmbedAction(action):
 
-					// 日志优先用转储结果; 未转储时从响应提取 URL(上游已返回 url 的场景)
-					if len(storedImageData) > 0 {
-						after.ImageResponse.Data = storedImageData
-					} else if logData := extractGoogleImageDataForLog(response.ResponseBytes); len(logData) > 0 {
-						after.ImageResponse.Data = normalizeImageDataUrls(logData)
-					} else {
-						after.ImageResponse.Data = normalizeImageDataUrls(after.ImageResponse.Data)
-					}
-					after.ImageFilePaths = imageFilePaths
-					after.ImageExpiresAt = imageExpiresAt
+				if embeddingRequest, err = common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvTextEmbeddingsRequest(ctx, body); err != nil {
+					logger.Error(ctx, err)
+					return response, err
 				}
 
-				common.AfterHandler(ctx, mak, after)
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "TextEmbeddings")
+				res, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).TextEmbeddings(upstreamCtx, body)
+				common.EndSpan(upstreamSpan, err)
 
-	//request := *params
-	//
-	//if !gstr.Contains(mak.RealModel.Model, "*") {
-	//	request.Model = mak.RealModel.Model
-	//}
-	//
-	//// 预设配置
-	//if mak.RealModel.IsEnablePresetConfig {
-	//
-	//	// 替换预设提示词
-	//	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-	//		if request.Messages[0].Role == consts.ROLE_SYSTEM {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages[1:]...)
-	//		} else {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages...)
-	//		}
-	//	}
-	//
-	//	// 检查MaxTokens取值范围
-	//	if request.MaxTokens != 0 {
-	//		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-	//		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-	//		}
-	//	}
-	//}
-
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sGoogle Completions params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
+			default:
 
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
+				var chatCompletionRequest smodel.ChatCompletionRequest
+				if chatCompletionRequest, err = common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvChatCompletionsRequest(ctx, body); err != nil {
 					logger.Error(ctx, err)
 					return response, err
 				}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
-				}
-
-				body = gjson.MustEncode(data)
-
-				break
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+				res, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, chatCompletionRequest)
+				common.EndSpan(upstreamSpan, err)
 			}
-		}
-	}
 
-	res, err := common.NewAdapterOfficial(ctx, mak, false).ChatCompletionsOfficial(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ChatCompletionResponse) (smodel.ChatCompletionResponse, error) {
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			var err error
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if r, ok := res.(smodel.ChatCompletionResponse); ok {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				// 转换为 Gemini generateContent 响应
+				response = r
+				response.ResponseBytes = gjson.MustEncode(convChatCompletionsToGenerateContent(gjson.New(gjson.MustEncode(r)).Map(), reqModel))
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+			} else if r, ok := res.(smodel.EmbeddingResponse); ok {
 
-		if isRetry {
+				// 转换为 Gemini 向量响应
+				response.Model = r.Model
+				response.Usage = r.Usage
+				response.TotalTime = r.TotalTime
+				response.ResponseBytes = gjson.MustEncode(convEmbeddingsToEmbedContent(gjson.New(gjson.MustEncode(r)).Map(), action))
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			} else if r, ok := res.(*smodel.GoogleChatCompletionRes); ok {
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
+				if r.Err == nil && r.ResponseBytes != nil {
+					if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
+						logger.Error(ctx, err)
+						return response, err
+					}
 				} else {
+					response.ResponseBytes = r.ResponseBytes
+					response.Error = r.Err
+				}
 
-					if mak.RealModel.IsEnableFallback {
+				response.TotalTime = r.TotalTime
+				response.ResponseHeaders = r.ResponseHeaders
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-							}
-						}
+			} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-							}
-						}
+				if r.Error == nil && r.ResponseBytes != nil {
+					if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
+						logger.Error(ctx, err)
+						return response, err
 					}
+				} else {
+					response.ResponseBytes = r.ResponseBytes
+					response.Error = r.Error
+				}
 
+				response.TotalTime = r.TotalTime
+				response.ResponseHeaders = r.ResponseHeaders
+
+			} else {
+				if err := json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
+					logger.Error(ctx, err)
 					return response, err
 				}
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			// 绘图类型开启转储时, 将 inlineData base64 落盘并按配置替换为 URL
+			if attempt.Mak.ReqModel.Type == 2 && response.ResponseBytes != nil {
+				response.ResponseBytes, imageFilePaths, imageExpiresAt, storedImageData = saveGoogleImageStorage(ctx, response.ResponseBytes, 0)
 			}
 
-			return s.Completions(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
-	}
-
-	if r, ok := res.(*smodel.GoogleChatCompletionRes); ok {
-
-		if r.Err == nil && r.ResponseBytes != nil {
-			if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
-				logger.Error(ctx, err)
-				return response, err
+			return response, nil
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *smodel.ChatCompletionResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
+			}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ChatCompletionResponse, err error) *mcommon.AfterHandler {
+
+			mak := attempt.Mak
+
+			after := &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				ChatCompletionRes: res,
+				EmbeddingReq:      embeddingRequest,
+				Action:            action,
+				Usage:             res.Usage,
+				ConnTime:          res.ConnTime,
+				Duration:          res.Duration,
+				TotalTime:         res.TotalTime,
 			}
-		} else {
-			response.ResponseBytes = r.ResponseBytes
-			response.Error = r.Err
-		}
 
-		response.TotalTime = r.TotalTime
-		response.ResponseHeaders = r.ResponseHeaders
+			if mak.ReqModel.Type == 2 {
 
-	} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
+				var err error
+				if after.ImageGenerationRequest, err = converter.ConvImageGenerationsRequest(ctx, request.GetBody()); err != nil {
+					logger.Error(ctx, err)
+				}
 
-		if r.Error == nil && r.ResponseBytes != nil {
-			if response, err = converter.ConvChatCompletionsResponse(ctx, r.ResponseBytes); err != nil {
-				logger.Error(ctx, err)
-				return response, err
-			}
-		} else {
-			response.ResponseBytes = r.ResponseBytes
-			response.Error = r.Error
-		}
+				if after.ImageGenerationRequest.Model == "" {
+					after.ImageGenerationRequest.Model = mak.ReqModel.Model
+				}
 
-		response.TotalTime = r.TotalTime
-		response.ResponseHeaders = r.ResponseHeaders
+				if after.ImageResponse, err = converter.ConvImageGenerationsResponse(ctx, res.ResponseBytes); err != nil {
+					logger.Error(ctx, err)
+				}
 
-	} else {
-		if err = json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
-			logger.Error(ctx, err)
-			return response, err
-		}
-	}
+				// 日志优先用转储结果; 未转储时从响应提取 URL(上游已返回 url 的场景)
+				if len(storedImageData) > 0 {
+					after.ImageResponse.Data = storedImageData
+				} else if logData := extractGoogleImageDataForLog(res.ResponseBytes); len(logData) > 0 {
+					after.ImageResponse.Data = normalizeImageDataUrls(logData)
+				} else {
+					after.ImageResponse.Data = normalizeImageDataUrls(after.ImageResponse.Data)
+				}
+				after.ImageFilePaths = imageFilePaths
+				after.ImageExpiresAt = imageExpiresAt
+			}
 
-	// 绘图类型开启转储时, 将 inlineData base64 落盘并按配置替换为 URL
-	if mak.ReqModel != nil && mak.ReqModel.Type == 2 && response.ResponseBytes != nil {
-		response.ResponseBytes, imageFilePaths, imageExpiresAt, storedImageData = saveGoogleImageStorage(ctx, response.ResponseBytes, 0)
+			return after
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // CompletionsStream
@@ -311,418 +252,403 @@ func (s *sGoogle) CompletionsStream(ctx context.Context, request *ghttp.Request,
 	}()
 
 	var (
-		converter = common.NewConverter(ctx, sconsts.PROVIDER_GOOGLE)
-		params    = convToChatCompletionRequest(request)
-		mak       = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           googleAction(request.URL.Path),
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		completion      string
-		connTime        int64
-		duration        int64
-		totalTime       int64
-		usage           *smodel.Usage
-		retryInfo       *mcommon.Retry
-		imageFilePaths  []string
-		imageExpiresAt  int64
-		storedImageData []smodel.ImageResponseData
+		converter           = common.NewConverter(ctx, sconsts.PROVIDER_GOOGLE)
+		params              = convToChatCompletionRequest(request)
+		reqModel            = params.Model
+		action              = googleAction(request.URL.Path)
+		protocol            string
+		body                []byte
+		completion          string
+		connTime            int64
+		duration            int64
+		totalTime           int64
+		usage               *smodel.Usage
+		imageFilePaths      []string
+		imageExpiresAt      int64
+		storedImageData     []smodel.ImageResponseData
+		cancel              context.CancelFunc
+		chatCompletionsChan chan *smodel.ChatCompletionResponse
 	)
 
 	params.Stream = true
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				after := &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					Completion:        completion,
-					Action:            googleAction(request.URL.Path),
-					Usage:             usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				}
-
-				if mak.ReqModel.Type == 2 {
-
-					if after.ImageGenerationRequest, err = converter.ConvImageGenerationsRequest(ctx, request.GetBody()); err != nil {
-						logger.Error(ctx, err)
-					}
+	pipeline := &common.Pipeline[chan any]{
+		Name:        "sGoogle CompletionsStream",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           action,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
+			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+			protocol = upstreamProtocol(ctx, attempt.Mak)
+			body, err = attempt.TransformBody(ctx, request.GetBody(), reqModel, &params, requestConverter(protocol, action, true, &params))
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (responseChan chan any, err error) {
 
-					if after.ImageGenerationRequest.Model == "" {
-						after.ImageGenerationRequest.Model = mak.ReqModel.Model
-					}
+			switch protocol {
+			case protocolChatCompletions:
 
-					after.ImageResponse = smodel.ImageResponse{
-						Data:      normalizeImageDataUrls(storedImageData),
-						TotalTime: totalTime,
-					}
-					after.ImageFilePaths = imageFilePaths
-					after.ImageExpiresAt = imageExpiresAt
+				var chatCompletionRequest smodel.ChatCompletionRequest
+				if chatCompletionRequest, err = common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvChatCompletionsRequest(ctx, body); err != nil {
+					logger.Error(ctx, err)
+					return nil, err
 				}
 
-				common.AfterHandler(ctx, mak, after)
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStream")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				chatCompletionsChan, err = common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, chatCompletionRequest)
+				attempt.EndStreamSpan(upstreamSpan, err)
 
-			}); err != nil {
-				logger.Error(ctx, err)
+			default:
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStreamOfficial")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				responseChan, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, true).ChatCompletionsStreamOfficial(upstreamCtx, body)
+				attempt.EndStreamSpan(upstreamSpan, err)
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
-
-	//request := *params
-	//
-	//if !gstr.Contains(mak.RealModel.Model, "*") {
-	//	request.Model = mak.RealModel.Model
-	//}
-	//
-	//// 预设配置
-	//if mak.RealModel.IsEnablePresetConfig {
-	//
-	//	// 替换预设提示词
-	//	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-	//		if request.Messages[0].Role == consts.ROLE_SYSTEM {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages[1:]...)
-	//		} else {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages...)
-	//		}
-	//	}
-	//
-	//	// 检查MaxTokens取值范围
-	//	if request.MaxTokens != 0 {
-	//		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-	//		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-	//		}
-	//	}
-	//}
-
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sGoogle CompletionsStream params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
 
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
+			if err != nil {
+				cancel()
+			}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
-				}
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan any) (chan any, error) {
 
-				body = gjson.MustEncode(data)
+			var (
+				mak = attempt.Mak
+				err error
+			)
 
-				break
-			}
-		}
-	}
+			streamTimeout := common.NewStreamTimeout(mak)
+			guardrail := attempt.Guardrail.Stream()
 
-	response, err := common.NewAdapterOfficial(ctx, mak, true).ChatCompletionsStreamOfficial(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
+			// 转换为 Gemini streamGenerateContent 数据块
+			if protocol == protocolChatCompletions {
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+				defer func() {
+					cancel()
+					close(chatCompletionsChan)
+				}()
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+				generateContentStream := newGenerateContentStream(reqModel)
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				for {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					response, err := common.StreamRecv(ctx, streamTimeout, chatCompletionsChan)
+					if err != nil {
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+						// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+						if common.IsStreamStalled(err) {
+							common.StreamErrorEvent(ctx, err)
+							return responseChan, err
+						}
 
-		if isRetry {
+						// 未向客户端输出, 转入重试
+						return responseChan, attempt.UpstreamError(err)
+					}
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+					connTime = response.ConnTime
+					duration = response.Duration
+					totalTime = response.TotalTime
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+					if response.Error != nil {
 
-					if mak.RealModel.IsEnableFallback {
+						if errors.Is(response.Error, io.EOF) {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
+							// 补发护栏暂缓的内容
+							if flush := guardrail.Flush(ctx); flush != nil {
+								if err := generateContentStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+									logger.Error(ctx, err)
+									return responseChan, err
 								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
 							}
-						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
+							if err := generateContentStream.stop(ctx); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
 							}
+
+							return responseChan, nil
 						}
-					}
 
-					return err
-				}
-			}
+						return responseChan, attempt.UpstreamError(response.Error)
+					}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
+					if response.ResponseHeaders != nil {
+						service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+					}
 
-			return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+					if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
 
-		return err
-	}
+						if response.Choices[0].Delta.ReasoningContent != nil {
+							completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+						}
 
-	defer close(response)
+						completion += response.Choices[0].Delta.Content
 
-	for {
+						if response.Choices[0].Delta.ToolCalls != nil {
+							completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+						}
+					}
 
-		res := <-response
+					// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+					flush, err := guardrail.Chunk(ctx, response)
+					if err != nil {
+						common.StreamErrorEvent(ctx, err)
+						return responseChan, err
+					}
 
-		var response smodel.ChatCompletionResponse
+					if flush != nil {
+						if err := generateContentStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					}
 
-		if r, ok := res.(*smodel.GoogleChatCompletionRes); ok {
+					if response.Usage != nil {
+						logger.Infof(ctx, "sGoogle CompletionsStream Usage: %s", gjson.MustEncodeString(response.Usage))
+						usage = common.MergeUsage(usage, response.Usage)
+					}
 
-			if r.Err == nil && r.ResponseBytes != nil {
-				if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
-					logger.Error(ctx, err)
-					return err
+					if err := generateContentStream.chatCompletionsChunk(ctx, gjson.MustEncode(response)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-			} else {
-				response.ResponseBytes = r.ResponseBytes
-				response.Error = r.Err
 			}
 
-			response.ConnTime = r.ConnTime
-			response.Duration = r.Duration
-			response.TotalTime = r.TotalTime
-			response.ResponseHeaders = r.ResponseHeaders
+			defer func() {
+				cancel()
+				close(responseChan)
+			}()
 
-		} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
+			for {
 
-			if r.Error == nil && r.ResponseBytes != nil {
-				if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
-			} else {
-				response.ResponseBytes = r.ResponseBytes
-				response.Error = r.Error
-			}
+				var res any
+				if res, err = common.StreamRecv(ctx, streamTimeout, responseChan); err != nil {
 
-			response.ConnTime = r.ConnTime
-			response.Duration = r.Duration
-			response.TotalTime = r.TotalTime
-			response.ResponseHeaders = r.ResponseHeaders
-
-		} else {
-			if err = json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
-				logger.Error(ctx, err)
-				return err
-			}
-		}
+					// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+					if common.IsStreamStalled(err) {
+						common.StreamErrorEvent(ctx, err)
+						return responseChan, err
+					}
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+					// 未向客户端输出, 转入重试
+					return responseChan, attempt.UpstreamError(err)
+				}
 
-		if response.Error != nil {
+				var response smodel.ChatCompletionResponse
 
-			if errors.Is(response.Error, io.EOF) {
-				return nil
-			}
+				if r, ok := res.(*smodel.GoogleChatCompletionRes); ok {
 
-			err = response.Error
+					if r.Err == nil && r.ResponseBytes != nil {
+						if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					} else {
+						response.ResponseBytes = r.ResponseBytes
+						response.Error = r.Err
+					}
 
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+					response.ConnTime = r.ConnTime
+					response.Duration = r.Duration
+					response.TotalTime = r.TotalTime
+					response.ResponseHeaders = r.ResponseHeaders
 
-			isRetry, isDisabled := common.IsNeedRetry(err)
+				} else if r, ok := res.(*smodel.ChatCompletionResponse); ok {
 
-			if isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+					if r.Error == nil && r.ResponseBytes != nil {
+						if response, err = converter.ConvChatCompletionsStreamResponse(ctx, r.ResponseBytes); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					} else {
+						response.ResponseBytes = r.ResponseBytes
+						response.Error = r.Error
+					}
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					response.ConnTime = r.ConnTime
+					response.Duration = r.Duration
+					response.TotalTime = r.TotalTime
+					response.ResponseHeaders = r.ResponseHeaders
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
+				} else {
+					if err := json.Unmarshal(gjson.MustEncode(res), &response); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-			}
-
-			if isRetry {
 
-				if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-					if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-						service.Session().SaveModelAgentBillingMethod(ctx, 1)
-						retry = []int{}
-					} else {
+				if response.Error != nil {
 
-						if mak.RealModel.IsEnableFallback {
+					if errors.Is(response.Error, io.EOF) {
 
-							if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-								if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-								}
-							}
-
-							if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-								if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-								}
+						// 补发护栏暂缓的内容
+						if flush := guardrail.Flush(ctx); flush != nil {
+							if err := util.SSEServer(ctx, string(flush.ResponseBytes)); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
 							}
 						}
 
-						return err
+						return responseChan, nil
 					}
-				}
 
-				retryInfo = &mcommon.Retry{
-					IsRetry:    true,
-					RetryCount: len(retry),
-					ErrMsg:     err.Error(),
+					return responseChan, attempt.UpstreamError(response.Error)
 				}
 
-				return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-			}
-
-			return err
-		}
-
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+				}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-			if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
-				completion += response.Choices[0].Delta.Audio.Transcript
-			} else {
-				if len(response.Choices) > 1 {
-					for i, choice := range response.Choices {
-						completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+					if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
+						completion += response.Choices[0].Delta.Audio.Transcript
+					} else {
+						if len(response.Choices) > 1 {
+							for i, choice := range response.Choices {
+								completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+							}
+						} else {
+							completion += response.Choices[0].Delta.Content
+						}
 					}
-				} else {
-					completion += response.Choices[0].Delta.Content
 				}
-			}
-		}
 
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
-			completion += gconv.String(response.Choices[0].Delta.ToolCalls)
-		}
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
+					completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+				}
 
-		if response.Usage != nil {
-			logger.Infof(ctx, "sGoogle CompletionsStream Usage: %s", response.ResponseBytes)
+				// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+				flush, err := guardrail.Chunk(ctx, &response)
+				if err != nil {
+					common.StreamErrorEvent(ctx, err)
+					return responseChan, err
+				}
 
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
+				if flush != nil {
+					if err := util.SSEServer(ctx, string(flush.ResponseBytes)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
 				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
+
+				if response.Usage != nil {
+					logger.Infof(ctx, "sGoogle CompletionsStream Usage: %s", response.ResponseBytes)
+					usage = common.MergeUsage(usage, response.Usage)
 				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+				// 绘图类型: 开启转储则落盘并按配置替换 URL; 未转储时提取响应中已有 URL 供日志记录
+				if mak.ReqModel.Type == 2 && response.ResponseBytes != nil {
+					var paths []string
+					var exp int64
+					var imgData []smodel.ImageResponseData
+					response.ResponseBytes, paths, exp, imgData = saveGoogleImageStorage(ctx, response.ResponseBytes, len(imageFilePaths))
+					if len(imgData) > 0 {
+						imageFilePaths = append(imageFilePaths, paths...)
+						storedImageData = append(storedImageData, imgData...)
+						if exp > 0 {
+							imageExpiresAt = exp
+						}
+					} else {
+						for _, item := range extractGoogleImageDataForLog(response.ResponseBytes) {
+							if item.Url != "" {
+								storedImageData = append(storedImageData, item)
+							}
+						}
+					}
 				}
-				if response.Usage.CacheCreationInputTokens != 0 {
-					usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+
+				data := make(map[string]any)
+				if err := gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
+					logger.Error(ctx, err)
+					return responseChan, err
 				}
-				if response.Usage.CacheReadInputTokens != 0 {
-					usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
+
+				if err := util.SSEServer(ctx, gjson.MustEncodeString(data)); err != nil {
+					logger.Error(ctx, err)
+					return responseChan, err
 				}
 			}
-		}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan any, err error) *mcommon.AfterHandler {
+
+			mak := attempt.Mak
+
+			after := &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				Action:            action,
+				Completion:        completion,
+				Usage:             usage,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
+			}
 
-		// 绘图类型: 开启转储则落盘并按配置替换 URL; 未转储时提取响应中已有 URL 供日志记录
-		if mak.ReqModel != nil && mak.ReqModel.Type == 2 && response.ResponseBytes != nil {
-			var paths []string
-			var exp int64
-			var imgData []smodel.ImageResponseData
-			response.ResponseBytes, paths, exp, imgData = saveGoogleImageStorage(ctx, response.ResponseBytes, len(imageFilePaths))
-			if len(imgData) > 0 {
-				imageFilePaths = append(imageFilePaths, paths...)
-				storedImageData = append(storedImageData, imgData...)
-				if exp > 0 {
-					imageExpiresAt = exp
+			if mak.ReqModel.Type == 2 {
+
+				var err error
+				if after.ImageGenerationRequest, err = converter.ConvImageGenerationsRequest(ctx, request.GetBody()); err != nil {
+					logger.Error(ctx, err)
 				}
-			} else {
-				for _, item := range extractGoogleImageDataForLog(response.ResponseBytes) {
-					if item.Url != "" {
-						storedImageData = append(storedImageData, item)
-					}
+
+				if after.ImageGenerationRequest.Model == "" {
+					after.ImageGenerationRequest.Model = mak.ReqModel.Model
 				}
+
+				after.ImageResponse = smodel.ImageResponse{
+					Data:      normalizeImageDataUrls(storedImageData),
+					TotalTime: totalTime,
+				}
+				after.ImageFilePaths = imageFilePaths
+				after.ImageExpiresAt = imageExpiresAt
 			}
-		}
 
-		data := make(map[string]any)
-		if err = gjson.Unmarshal(response.ResponseBytes, &data); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+			return after
+		},
+	}
 
-		if err = util.SSEServer(ctx, gjson.MustEncodeString(data)); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+	// 流中断或输出被拦截已以错误事件结束流
+	if _, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); common.IsStreamStalled(err) || common.IsOutputBlocked(err) {
+		return nil
 	}
+
+	return err
+}
+
+// 本地估算输入令牌数, 兼容包装在 generateContentRequest 中的请求
+func countTokens(ctx context.Context, body []byte, model string) (totalTokens int, err error) {
+
+	if generateContentRequest := gconv.Map(field(gjson.New(body).Map(), "generateContentRequest", "generate_content_request")); generateContentRequest != nil {
+		body = gjson.MustEncode(generateContentRequest)
+	}
+
+	chatCompletionRequest, err := common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvChatCompletionsRequest(ctx, gjson.MustEncode(convGenerateContentToChatCompletions(body, model, false)))
+	if err != nil {
+		return 0, err
+	}
+
+	totalTokens = common.TokensFromMessages(ctx, model, chatCompletionRequest.Messages)
+
+	if chatCompletionRequest.Tools != nil {
+		totalTokens += common.TokensFromString(ctx, model, gjson.MustEncodeString(chatCompletionRequest.Tools))
+	}
+
+	return totalTokens, nil
 }
 
 func convToChatCompletionRequest(request *ghttp.Request) smodel.ChatCompletionRequest {
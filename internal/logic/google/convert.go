@@ -0,0 +1,654 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+)
+
+// 上游协议
+const (
+	protocolGenerateContent = "generate_content" // Gemini generateContent, 原样转发
+	protocolChatCompletions = "chat_completions" // Chat Completions, 含 Anthropic 等由适配器转换的提供商
+)
+
+// 接口动作
+const (
+	actionCountTokens        = "countTokens"
+	actionEmbedContent       = "embedContent"
+	actionBatchEmbedContents = "batchEmbedContents"
+)
+
+// 按模型代理的提供商选择上游协议
+func upstreamProtocol(ctx context.Context, mak *common.MAK) string {
+
+	provider := common.GetProviderCode(ctx, mak.Provider)
+
+	// 含 Gemini 的云厂商渠道也使用 generateContent 协议
+	if provider == sconsts.PROVIDER_GOOGLE || provider == sconsts.PROVIDER_GCP_GEMINI || gstr.ContainsI(provider, "gemini") {
+		return protocolGenerateContent
+	}
+
+	return protocolChatCompletions
+}
+
+// 按上游协议选择请求转换, generateContent 协议原样转发
+func requestConverter(protocol, action string, isStream bool, params *smodel.ChatCompletionRequest) func(body []byte) any {
+
+	if protocol == protocolGenerateContent {
+		return nil
+	}
+
+	// 转换时模型已替换
+	return func(body []byte) any {
+
+		if isEmbedAction(action) {
+			return convEmbedContentToEmbeddings(body, params.Model, action)
+		}
+
+		return convGenerateContentToChatCompletions(body, params.Model, isStream)
+	}
+}
+
+// 是否为向量接口动作
+func isEmbedAction(action string) bool {
+	return action == actionEmbedContent || action == actionBatchEmbedContents
+}
+
+// 取字段值, Gemini 请求同时接受驼峰和下划线两种字段名
+func field(data map[string]any, keys ...string) any {
+
+	for _, key := range keys {
+		if value, ok := data[key]; ok && value != nil {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// 内容的文本部分按顺序拼接
+func partsText(content map[string]any) string {
+
+	texts := make([]string, 0)
+	for _, part := range gconv.Maps(content["parts"]) {
+		if text, ok := part["text"]; ok && !gconv.Bool(part["thought"]) {
+			texts = append(texts, gconv.String(text))
+		}
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+// 内联数据或文件数据转换为图片地址, 内联数据转换为 data URL
+func partImageUrl(part map[string]any) string {
+
+	if inlineData := gconv.Map(field(part, "inlineData", "inline_data")); inlineData != nil {
+		return "data:" + gconv.String(field(inlineData, "mimeType", "mime_type")) + ";base64," + gconv.String(inlineData["data"])
+	}
+
+	if fileData := gconv.Map(field(part, "fileData", "file_data")); fileData != nil {
+		return gconv.String(field(fileData, "fileUri", "file_uri"))
+	}
+
+	return ""
+}
+
+// Gemini Schema 类型为大写枚举, 转换为 JSON Schema 小写类型
+func convSchema(schema any) any {
+
+	switch value := schema.(type) {
+	case map[string]any:
+
+		converted := make(map[string]any, len(value))
+		for key, item := range value {
+			if key == "type" {
+				if typ, ok := item.(string); ok {
+					converted[key] = strings.ToLower(typ)
+					continue
+				}
+			}
+			converted[key] = convSchema(item)
+		}
+
+		return converted
+
+	case []any:
+
+		converted := make([]any, 0, len(value))
+		for _, item := range value {
+			converted = append(converted, convSchema(item))
+		}
+
+		return converted
+	}
+
+	return schema
+}
+
+// 思考预算转换为推理强度, -1 为动态思考
+func reasoningEffort(thinkingConfig map[string]any) string {
+
+	if thinkingConfig == nil {
+		return ""
+	}
+
+	if level := gconv.String(field(thinkingConfig, "thinkingLevel", "thinking_level")); level != "" {
+		return strings.ToLower(level)
+	}
+
+	thinkingBudget := field(thinkingConfig, "thinkingBudget", "thinking_budget")
+	if thinkingBudget == nil {
+		return ""
+	}
+
+	budgetTokens := gconv.Int(thinkingBudget)
+
+	switch {
+	case budgetTokens == 0:
+		return ""
+	case budgetTokens < 0:
+		return "medium"
+	case budgetTokens <= 4096:
+		return "low"
+	case budgetTokens <= 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// Gemini generateContent 请求转换为 Chat Completions 请求
+func convGenerateContentToChatCompletions(body []byte, model string, isStream bool) map[string]any {
+
+	request := gjson.New(body).Map()
+
+	var (
+		messages = make([]any, 0)
+		callIds  = make(map[string][]string) // 按函数名待匹配的工具调用ID, Gemini 函数调用可不带ID
+		callSeq  int
+	)
+
+	if system := partsText(gconv.Map(field(request, "systemInstruction", "system_instruction"))); system != "" {
+		messages = append(messages, g.Map{"role": sconsts.ROLE_SYSTEM, "content": system})
+	}
+
+	for _, content := range gconv.Maps(request["contents"]) {
+
+		if content["role"] == sconsts.ROLE_MODEL {
+
+			var (
+				text      string
+				toolCalls = make([]any, 0)
+			)
+
+			for _, part := range gconv.Maps(content["parts"]) {
+
+				// 思考内容不回传上游
+				if gconv.Bool(part["thought"]) {
+					continue
+				}
+
+				if functionCall := gconv.Map(field(part, "functionCall", "function_call")); functionCall != nil {
+
+					name := gconv.String(functionCall["name"])
+
+					id := gconv.String(functionCall["id"])
+					if id == "" {
+						callSeq++
+						id = "call_" + gconv.String(callSeq) + "_" + name
+					}
+
+					callIds[name] = append(callIds[name], id)
+
+					toolCalls = append(toolCalls, g.Map{
+						"id":   id,
+						"type": "function",
+						"function": g.Map{
+							"name":      name,
+							"arguments": gjson.MustEncodeString(functionCall["args"]),
+						},
+					})
+
+					continue
+				}
+
+				text += gconv.String(part["text"])
+			}
+
+			assistant := g.Map{"role": sconsts.ROLE_ASSISTANT, "content": text}
+			if len(toolCalls) > 0 {
+				assistant["tool_calls"] = toolCalls
+			}
+
+			messages = append(messages, assistant)
+
+			continue
+		}
+
+		contents := make([]any, 0)
+
+		for _, part := range gconv.Maps(content["parts"]) {
+
+			if functionResponse := gconv.Map(field(part, "functionResponse", "function_response")); functionResponse != nil {
+
+				name := gconv.String(functionResponse["name"])
+
+				// 工具结果需紧跟在助手的工具调用之后, 未带ID时按函数名依次匹配
+				id := gconv.String(functionResponse["id"])
+				if ids := callIds[name]; id == "" && len(ids) > 0 {
+					id, callIds[name] = ids[0], ids[1:]
+				}
+
+				messages = append(messages, g.Map{
+					"role":         sconsts.ROLE_TOOL,
+					"tool_call_id": id,
+					"content":      gjson.MustEncodeString(functionResponse["response"]),
+				})
+
+				continue
+			}
+
+			if text, ok := part["text"]; ok {
+				contents = append(contents, g.Map{"type": "text", "text": text})
+				continue
+			}
+
+			if url := partImageUrl(part); url != "" {
+				contents = append(contents, g.Map{"type": "image_url", "image_url": g.Map{"url": url}})
+			}
+		}
+
+		if len(contents) > 0 {
+			messages = append(messages, g.Map{"role": sconsts.ROLE_USER, "content": contents})
+		}
+	}
+
+	chatCompletionRequest := g.Map{
+		"model":    model,
+		"messages": messages,
+	}
+
+	generationConfig := gconv.Map(field(request, "generationConfig", "generation_config"))
+
+	if maxOutputTokens := field(generationConfig, "maxOutputTokens", "max_output_tokens"); maxOutputTokens != nil {
+		chatCompletionRequest["max_tokens"] = maxOutputTokens
+	}
+
+	if generationConfig["temperature"] != nil {
+		chatCompletionRequest["temperature"] = generationConfig["temperature"]
+	}
+
+	if topP := field(generationConfig, "topP", "top_p"); topP != nil {
+		chatCompletionRequest["top_p"] = topP
+	}
+
+	if topK := field(generationConfig, "topK", "top_k"); topK != nil {
+		chatCompletionRequest["top_k"] = topK
+	}
+
+	if candidateCount := field(generationConfig, "candidateCount", "candidate_count"); candidateCount != nil {
+		chatCompletionRequest["n"] = candidateCount
+	}
+
+	if stopSequences := field(generationConfig, "stopSequences", "stop_sequences"); stopSequences != nil {
+		chatCompletionRequest["stop"] = stopSequences
+	}
+
+	if presencePenalty := field(generationConfig, "presencePenalty", "presence_penalty"); presencePenalty != nil {
+		chatCompletionRequest["presence_penalty"] = presencePenalty
+	}
+
+	if frequencyPenalty := field(generationConfig, "frequencyPenalty", "frequency_penalty"); frequencyPenalty != nil {
+		chatCompletionRequest["frequency_penalty"] = frequencyPenalty
+	}
+
+	if generationConfig["seed"] != nil {
+		chatCompletionRequest["seed"] = generationConfig["seed"]
+	}
+
+	if gconv.String(field(generationConfig, "responseMimeType", "response_mime_type")) == "application/json" {
+
+		chatCompletionRequest["response_format"] = g.Map{"type": "json_object"}
+
+		if schema := field(generationConfig, "responseJsonSchema", "response_json_schema", "responseSchema", "response_schema"); schema != nil {
+			chatCompletionRequest["response_format"] = g.Map{
+				"type":        "json_schema",
+				"json_schema": g.Map{"name": "response", "schema": convSchema(schema)},
+			}
+		}
+	}
+
+	if effort := reasoningEffort(gconv.Map(field(generationConfig, "thinkingConfig", "thinking_config"))); effort != "" {
+		chatCompletionRequest["reasoning_effort"] = effort
+	}
+
+	if isStream {
+		chatCompletionRequest["stream"] = true
+		chatCompletionRequest["stream_options"] = g.Map{"include_usage": true}
+	}
+
+	// 仅转换函数声明, 内置工具(googleSearch、codeExecution 等)上游无法执行
+	chatCompletionTools := make([]any, 0)
+	for _, tool := range gconv.Maps(request["tools"]) {
+		for _, functionDeclaration := range gconv.Maps(field(tool, "functionDeclarations", "function_declarations")) {
+
+			function := g.Map{
+				"name":        functionDeclaration["name"],
+				"description": functionDeclaration["description"],
+			}
+
+			if parameters := field(functionDeclaration, "parametersJsonSchema", "parameters_json_schema", "parameters"); parameters != nil {
+				function["parameters"] = convSchema(parameters)
+			}
+
+			chatCompletionTools = append(chatCompletionTools, g.Map{"type": "function", "function": function})
+		}
+	}
+
+	if len(chatCompletionTools) > 0 {
+		chatCompletionRequest["tools"] = chatCompletionTools
+	}
+
+	toolConfig := gconv.Map(field(request, "toolConfig", "tool_config"))
+	if functionCallingConfig := gconv.Map(field(toolConfig, "functionCallingConfig", "function_calling_config")); functionCallingConfig != nil {
+
+		switch gconv.String(functionCallingConfig["mode"]) {
+		case "AUTO":
+			chatCompletionRequest["tool_choice"] = "auto"
+		case "NONE":
+			chatCompletionRequest["tool_choice"] = "none"
+		case "ANY", "VALIDATED":
+
+			chatCompletionRequest["tool_choice"] = "required"
+
+			// 仅允许一个函数时指定该函数
+			if allowedFunctionNames := gconv.Strings(field(functionCallingConfig, "allowedFunctionNames", "allowed_function_names")); len(allowedFunctionNames) == 1 {
+				chatCompletionRequest["tool_choice"] = g.Map{"type": "function", "function": g.Map{"name": allowedFunctionNames[0]}}
+			}
+		}
+	}
+
+	return chatCompletionRequest
+}
+
+// Gemini 向量请求转换为 Embeddings 请求, 批量请求的模型和维度以首个请求为准
+func convEmbedContentToEmbeddings(body []byte, model string, action string) map[string]any {
+
+	request := gjson.New(body).Map()
+
+	requests := []map[string]any{request}
+	if action == actionBatchEmbedContents {
+		requests = gconv.Maps(request["requests"])
+	}
+
+	inputs := make([]string, 0)
+	for _, embedRequest := range requests {
+		inputs = append(inputs, partsText(gconv.Map(embedRequest["content"])))
+	}
+
+	embeddingRequest := g.Map{
+		"model":           model,
+		"input":           inputs,
+		"encoding_format": "float",
+	}
+
+	if len(requests) > 0 {
+		if outputDimensionality := field(requests[0], "outputDimensionality", "output_dimensionality"); outputDimensionality != nil {
+			embeddingRequest["dimensions"] = outputDimensionality
+		}
+	}
+
+	return embeddingRequest
+}
+
+// Embeddings 响应转换为 Gemini 向量响应
+func convEmbeddingsToEmbedContent(response map[string]any, action string) map[string]any {
+
+	embeddings := make([]any, 0)
+	for _, data := range gconv.Maps(response["data"]) {
+		embeddings = append(embeddings, g.Map{"values": data["embedding"]})
+	}
+
+	if action == actionBatchEmbedContents {
+		return g.Map{"embeddings": embeddings}
+	}
+
+	if len(embeddings) == 0 {
+		return g.Map{"embedding": g.Map{"values": []any{}}}
+	}
+
+	return g.Map{"embedding": embeddings[0]}
+}
+
+// Chat Completions 结束原因转换为 Gemini 结束原因, 工具调用也以 STOP 结束
+func finishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// 内容过滤类别对应的 Gemini 危害类别, 按输出顺序排列
+var harmCategories = [][2]string{
+	{"harassment", "HARM_CATEGORY_HARASSMENT"},
+	{"hate", "HARM_CATEGORY_HATE_SPEECH"},
+	{"sexual", "HARM_CATEGORY_SEXUALLY_EXPLICIT"},
+	{"violence", "HARM_CATEGORY_DANGEROUS_CONTENT"},
+	{"self_harm", "HARM_CATEGORY_DANGEROUS_CONTENT"},
+}
+
+// 内容过滤严重程度对应的 Gemini 危害概率
+var harmProbabilities = map[string]string{
+	"safe":   "NEGLIGIBLE",
+	"low":    "LOW",
+	"medium": "MEDIUM",
+	"high":   "HIGH",
+}
+
+// 内容过滤结果转换为 Gemini 安全评级, 同一危害类别取最高概率
+func safetyRatings(contentFilterResults map[string]any) []any {
+
+	var (
+		ratings = make([]any, 0)
+		indexes = make(map[string]int)
+		levels  = map[string]int{"NEGLIGIBLE": 1, "LOW": 2, "MEDIUM": 3, "HIGH": 4}
+	)
+
+	for _, harmCategory := range harmCategories {
+
+		result, ok := contentFilterResults[harmCategory[0]]
+		if !ok {
+			continue
+		}
+
+		filterResult := gconv.Map(result)
+
+		probability, ok := harmProbabilities[gconv.String(filterResult["severity"])]
+		if !ok {
+			probability = "NEGLIGIBLE"
+		}
+
+		rating := g.Map{"category": harmCategory[1], "probability": probability}
+		if gconv.Bool(filterResult["filtered"]) {
+			rating["blocked"] = true
+		}
+
+		index, ok := indexes[harmCategory[1]]
+		if !ok {
+			indexes[harmCategory[1]] = len(ratings)
+			ratings = append(ratings, rating)
+			continue
+		}
+
+		existing := ratings[index].(g.Map)
+		if levels[probability] > levels[gconv.String(existing["probability"])] {
+			existing["probability"] = probability
+		}
+
+		if rating["blocked"] != nil {
+			existing["blocked"] = true
+		}
+	}
+
+	return ratings
+}
+
+// 工具调用参数解析为对象
+func functionArgs(arguments string) any {
+
+	args := make(map[string]any)
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return map[string]any{}
+		}
+	}
+
+	return args
+}
+
+// Chat Completions 用量转换为 Gemini 用量, 候选令牌数不含思考令牌数
+func convUsageMetadata(usage map[string]any) map[string]any {
+
+	if usage == nil {
+		return nil
+	}
+
+	promptTokensDetails := gconv.Map(usage["prompt_tokens_details"])
+	completionTokensDetails := gconv.Map(usage["completion_tokens_details"])
+
+	cachedTokens := gconv.Int(promptTokensDetails["cached_tokens"])
+	if cachedTokens == 0 {
+		// DeepSeek
+		cachedTokens = gconv.Int(usage["prompt_cache_hit_tokens"])
+	}
+
+	var (
+		promptTokens     = gconv.Int(usage["prompt_tokens"])
+		completionTokens = gconv.Int(usage["completion_tokens"])
+		reasoningTokens  = gconv.Int(completionTokensDetails["reasoning_tokens"])
+		totalTokens      = gconv.Int(usage["total_tokens"])
+	)
+
+	if totalTokens == 0 {
+		totalTokens = promptTokens + completionTokens
+	}
+
+	usageMetadata := g.Map{
+		"promptTokenCount":     promptTokens,
+		"candidatesTokenCount": max(completionTokens-reasoningTokens, 0),
+		"totalTokenCount":      totalTokens,
+	}
+
+	if cachedTokens > 0 {
+		usageMetadata["cachedContentTokenCount"] = cachedTokens
+	}
+
+	if reasoningTokens > 0 {
+		usageMetadata["thoughtsTokenCount"] = reasoningTokens
+	}
+
+	return usageMetadata
+}
+
+func responseId(id string) string {
+
+	if id == "" {
+		return grand.S(24)
+	}
+
+	return id
+}
+
+// 提示词被过滤时的提示反馈
+func promptFeedback(response map[string]any) map[string]any {
+
+	for _, promptFilterResult := range gconv.Maps(field(response, "prompt_filter_results", "prompt_annotations")) {
+
+		ratings := safetyRatings(gconv.Map(promptFilterResult["content_filter_results"]))
+
+		for _, rating := range gconv.Maps(ratings) {
+			if gconv.Bool(rating["blocked"]) {
+				return g.Map{"blockReason": "SAFETY", "safetyRatings": ratings}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Chat Completions 响应转换为 Gemini generateContent 响应
+func convChatCompletionsToGenerateContent(response map[string]any, model string) map[string]any {
+
+	candidates := make([]any, 0)
+
+	for _, choice := range gconv.Maps(response["choices"]) {
+
+		var (
+			message = gconv.Map(choice["message"])
+			parts   = make([]any, 0)
+		)
+
+		if reasoningContent := gconv.String(message["reasoning_content"]); reasoningContent != "" {
+			parts = append(parts, g.Map{"text": reasoningContent, "thought": true})
+		}
+
+		if text := gconv.String(message["content"]); text != "" {
+			parts = append(parts, g.Map{"text": text})
+		}
+
+		for _, toolCall := range gconv.Maps(message["tool_calls"]) {
+			function := gconv.Map(toolCall["function"])
+			parts = append(parts, g.Map{
+				"functionCall": g.Map{
+					"id":   toolCall["id"],
+					"name": function["name"],
+					"args": functionArgs(gconv.String(function["arguments"])),
+				},
+			})
+		}
+
+		candidate := g.Map{
+			"content":      g.Map{"role": sconsts.ROLE_MODEL, "parts": parts},
+			"finishReason": finishReason(gconv.String(choice["finish_reason"])),
+			"index":        gconv.Int(choice["index"]),
+		}
+
+		if ratings := safetyRatings(gconv.Map(choice["content_filter_results"])); len(ratings) > 0 {
+			candidate["safetyRatings"] = ratings
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	generateContentResponse := g.Map{
+		"candidates":   candidates,
+		"modelVersion": model,
+		"responseId":   responseId(gconv.String(response["id"])),
+	}
+
+	if feedback := promptFeedback(response); feedback != nil {
+		generateContentResponse["promptFeedback"] = feedback
+	}
+
+	if usageMetadata := convUsageMetadata(gconv.Map(response["usage"])); usageMetadata != nil {
+		generateContentResponse["usageMetadata"] = usageMetadata
+	}
+
+	return generateContentResponse
+}
@@ -0,0 +1,145 @@
+package google
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+// 流式响应转换, 将 Chat Completions 数据块转换为 Gemini streamGenerateContent 数据块
+type generateContentStream struct {
+	model         string
+	id            string
+	finishReason  string
+	safetyRatings []any
+	toolCalls     []map[string]any // 按索引累积的工具调用, 参数完整后随结束数据块输出
+	usage         map[string]any
+	isStopped     bool
+}
+
+func newGenerateContentStream(model string) *generateContentStream {
+	return &generateContentStream{
+		model: model,
+	}
+}
+
+func (s *generateContentStream) send(ctx context.Context, parts []any, isFinal bool) error {
+
+	candidate := g.Map{
+		"content": g.Map{"role": sconsts.ROLE_MODEL, "parts": parts},
+		"index":   0,
+	}
+
+	data := g.Map{
+		"candidates":   []any{candidate},
+		"modelVersion": s.model,
+		"responseId":   s.id,
+	}
+
+	if isFinal {
+
+		if s.finishReason == "" {
+			s.finishReason = "STOP"
+		}
+
+		candidate["finishReason"] = s.finishReason
+
+		if len(s.safetyRatings) > 0 {
+			candidate["safetyRatings"] = s.safetyRatings
+		}
+
+		if s.usage != nil {
+			data["usageMetadata"] = s.usage
+		}
+	}
+
+	return util.SSEServer(ctx, gjson.MustEncodeString(data))
+}
+
+// Chat Completions 数据块
+func (s *generateContentStream) chatCompletionsChunk(ctx context.Context, data []byte) error {
+
+	chunk := gjson.New(data).Map()
+
+	if s.id == "" {
+		s.id = responseId(gconv.String(chunk["id"]))
+	}
+
+	if usage := convUsageMetadata(gconv.Map(chunk["usage"])); usage != nil {
+		s.usage = usage
+	}
+
+	choices := gconv.Maps(chunk["choices"])
+	if len(choices) == 0 {
+		return nil
+	}
+
+	delta := gconv.Map(choices[0]["delta"])
+	parts := make([]any, 0)
+
+	if reasoningContent := gconv.String(delta["reasoning_content"]); reasoningContent != "" {
+		parts = append(parts, g.Map{"text": reasoningContent, "thought": true})
+	}
+
+	if content := gconv.String(delta["content"]); content != "" {
+		parts = append(parts, g.Map{"text": content})
+	}
+
+	for _, toolCall := range gconv.Maps(delta["tool_calls"]) {
+
+		function := gconv.Map(toolCall["function"])
+
+		// 新的工具调用
+		if index := gconv.Int(toolCall["index"]); index >= len(s.toolCalls) {
+			s.toolCalls = append(s.toolCalls, g.Map{"id": toolCall["id"], "name": function["name"], "arguments": ""})
+		}
+
+		current := s.toolCalls[len(s.toolCalls)-1]
+		current["arguments"] = gconv.String(current["arguments"]) + gconv.String(function["arguments"])
+	}
+
+	if ratings := safetyRatings(gconv.Map(choices[0]["content_filter_results"])); len(ratings) > 0 {
+		s.safetyRatings = ratings
+	}
+
+	if reason := finishReason(gconv.String(choices[0]["finish_reason"])); reason != "" {
+		s.finishReason = reason
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	return s.send(ctx, parts, false)
+}
+
+// 结束流, 输出工具调用、结束原因和用量
+func (s *generateContentStream) stop(ctx context.Context) error {
+
+	if s.isStopped {
+		return nil
+	}
+
+	s.isStopped = true
+
+	if s.id == "" {
+		s.id = responseId("")
+	}
+
+	parts := make([]any, 0)
+	for _, toolCall := range s.toolCalls {
+		parts = append(parts, g.Map{
+			"functionCall": g.Map{
+				"id":   toolCall["id"],
+				"name": toolCall["name"],
+				"args": functionArgs(gconv.String(toolCall["arguments"])),
+			},
+		})
+	}
+
+	return s.send(ctx, parts, true)
+}
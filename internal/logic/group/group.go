@@ -5,6 +5,7 @@ import (
 	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/iimeta/fastapi/v2/internal/consts"
 	"github.com/iimeta/fastapi/v2/internal/dao"
@@ -62,6 +63,11 @@ func (s *sGroup) GetById(ctx context.Context, id string) (*model.Group, error) {
 		UsedQuota:          group.UsedQuota,
 		IsEnableForward:    group.IsEnableForward,
 		ForwardConfig:      group.ForwardConfig,
+		RateLimit:          group.RateLimit,
+		IsEnableFallback:   group.IsEnableFallback,
+		FallbackConfig:     group.FallbackConfig,
+		ResponseCache:      group.ResponseCache,
+		Guardrail:          group.Guardrail,
 		IsPublic:           group.IsPublic,
 		Weight:             group.Weight,
 		ExpiresAt:          group.ExpiresAt,
@@ -102,6 +108,11 @@ func (s *sGroup) List(ctx context.Context) ([]*model.Group, error) {
 			UsedQuota:          result.UsedQuota,
 			IsEnableForward:    result.IsEnableForward,
 			ForwardConfig:      result.ForwardConfig,
+			RateLimit:          result.RateLimit,
+			IsEnableFallback:   result.IsEnableFallback,
+			FallbackConfig:     result.FallbackConfig,
+			ResponseCache:      result.ResponseCache,
+			Guardrail:          result.Guardrail,
 			IsPublic:           result.IsPublic,
 			Weight:             result.Weight,
 			ExpiresAt:          result.ExpiresAt,
@@ -238,6 +249,11 @@ func (s *sGroup) UpdateCache(ctx context.Context, newData *entity.Group) {
 		UsedQuota:          newData.UsedQuota,
 		IsEnableForward:    newData.IsEnableForward,
 		ForwardConfig:      newData.ForwardConfig,
+		RateLimit:          newData.RateLimit,
+		IsEnableFallback:   newData.IsEnableFallback,
+		FallbackConfig:     newData.FallbackConfig,
+		ResponseCache:      newData.ResponseCache,
+		Guardrail:          newData.Guardrail,
 		IsPublic:           newData.IsPublic,
 		Weight:             newData.Weight,
 		ExpiresAt:          newData.ExpiresAt,
@@ -319,6 +335,9 @@ func (s *sGroup) PickGroupAndModel(ctx context.Context, appKey *model.AppKey, m
 		logger.Debugf(ctx, "sGroup PickGroupAndModel time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sGroup PickGroupAndModel")
+	defer span.End()
+
 	groups, err := s.GetCacheList(ctx, ids...)
 	if err != nil {
 		logger.Error(ctx, err)
@@ -58,172 +58,85 @@ func (s *sImage) Generations(ctx context.Context, data []byte, fallbackModelAgen
 		logger.Debugf(ctx, "sImage Generations time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	params, err := common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvImageGenerationsRequest(ctx, data)
+	reqParams, err := common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvImageGenerationsRequest(ctx, data)
 	if err != nil {
 		logger.Errorf(ctx, "sImage Generations ConvImageGenerationsRequest error: %v", err)
 		return response, err
 	}
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_IMAGE_GENERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		imageResponse  smodel.ImageResponse
-		retryInfo      *mcommon.Retry
-		imageFilePaths []string
-		imageExpiresAt int64
+		params                 smodel.ImageGenerationRequest
+		request                smodel.ImageGenerationRequest
+		originalResponseFormat string
+		imageResponse          smodel.ImageResponse
+		imageFilePaths         []string
+		imageExpiresAt         int64
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - imageResponse.TotalTime
-		usage := imageResponse.Usage
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ImageGenerationRequest: params,
-					ImageResponse:          imageResponse,
-					Action:                 consts.ACTION_GENERATIONS,
-					ImageFilePaths:         imageFilePaths,
-					ImageExpiresAt:         imageExpiresAt,
-					Usage:                  &usage,
-					Error:                  err,
-					RetryInfo:              retryInfo,
-					TotalTime:              imageResponse.TotalTime,
-					InternalTime:           internalTime,
-					EnterTime:              enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.ImageResponse]{
+		Name: "sImage Generations",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              reqParams.Model,
+				Endpoint:           consts.ENDPOINT_IMAGE_GENERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ImageGenerationRequest: reqParams},
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	if slices.Contains(mak.ReqModel.Pricing.BillingItems, "image_generation") {
-
-		billingData := &mcommon.BillingData{
-			ImageGenerationRequest: params,
-		}
-
-		// 计算花费
-		spend := common.Billing(ctx, mak, billingData, "image_generation")
-		if spend.ImageGeneration.Pricing.Width > 0 && spend.ImageGeneration.Pricing.Height > 0 {
-			if spend.ImageGeneration.Pricing.Quality != "" {
-				params.Quality = spend.ImageGeneration.Pricing.Quality
-			}
-			params.Size = fmt.Sprintf("%dx%d", spend.ImageGeneration.Pricing.Width, spend.ImageGeneration.Pricing.Height)
-		}
-	}
-
-	request := params
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
 
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		request.Model = mak.RealModel.Model
-	}
+			params = reqParams
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sImage Generations request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
+			if quality, size := billingImageSpec(ctx, attempt.Mak, &mcommon.BillingData{ImageGenerationRequest: params}); size != "" {
+				if quality != "" {
+					params.Quality = quality
+				}
+				params.Size = size
 			}
-		}
-	}
-
-	// 开启转储时强制向上游请求b64_json以便落盘, 用户原始response_format另行保留用于控制是否回传base64
-	originalResponseFormat := request.ResponseFormat
-	if config.Cfg.ImageStorage.Open {
-		request.ResponseFormat = "b64_json"
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).ImageGenerations(ctx, gjson.MustEncode(request))
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			request = params
+			request.Model = attempt.UpstreamModel(ctx, request.Model)
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			// 开启转储时强制向上游请求b64_json以便落盘, 用户原始response_format另行保留用于控制是否回传base64
+			originalResponseFormat = request.ResponseFormat
+			if config.Cfg.ImageStorage.Open {
+				request.ResponseFormat = "b64_json"
 			}
-		}
 
-		if isRetry {
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (smodel.ImageResponse, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ImageGenerations")
+			response, err := common.NewAdapter(upstreamCtx, attempt.Mak, false).ImageGenerations(upstreamCtx, gjson.MustEncode(request))
+			common.EndSpan(upstreamSpan, err)
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ImageResponse) (smodel.ImageResponse, error) {
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &response, params.OutputFormat, originalResponseFormat)
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+			imageResponse = response
 
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Generations(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Generations(g.RequestFromCtx(ctx).GetCtx(), data, nil, fallbackModel)
-							}
-						}
-					}
+			return response, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ smodel.ImageResponse, err error) *mcommon.AfterHandler {
 
-					return response, err
-				}
-			}
+			usage := imageResponse.Usage
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return &mcommon.AfterHandler{
+				ImageGenerationRequest: params,
+				ImageResponse:          imageResponse,
+				ImageFilePaths:         imageFilePaths,
+				ImageExpiresAt:         imageExpiresAt,
+				Action:                 consts.ACTION_GENERATIONS,
+				Usage:                  &usage,
+				TotalTime:              imageResponse.TotalTime,
 			}
-
-			return s.Generations(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &response, params.OutputFormat, originalResponseFormat)
-
-	imageResponse = response
-
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // GenerationsStream
@@ -234,223 +147,124 @@ func (s *sImage) GenerationsStream(ctx context.Context, data []byte, fallbackMod
 		logger.Debugf(ctx, "sImage GenerationsStream time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	params, err := common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvImageGenerationsRequest(ctx, data)
+	reqParams, err := common.NewConverter(ctx, sconsts.PROVIDER_OPENAI).ConvImageGenerationsRequest(ctx, data)
 	if err != nil {
 		logger.Errorf(ctx, "sImage GenerationsStream ConvImageGenerationsRequest error: %v", err)
 		return err
 	}
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_IMAGE_GENERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
+		params         smodel.ImageGenerationRequest
+		request        smodel.ImageGenerationRequest
 		imageResponse  smodel.ImageResponse
 		usage          *smodel.Usage
 		connTime       int64
 		duration       int64
 		totalTime      int64
-		retryInfo      *mcommon.Retry
 		imageFilePaths []string
 		imageExpiresAt int64
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				imageResponse.TotalTime = totalTime
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ImageGenerationRequest: params,
-					ImageResponse:          imageResponse,
-					Action:                 consts.ACTION_GENERATIONS,
-					ImageFilePaths:         imageFilePaths,
-					ImageExpiresAt:         imageExpiresAt,
-					Usage:                  usage,
-					Error:                  err,
-					RetryInfo:              retryInfo,
-					ConnTime:               connTime,
-					Duration:               duration,
-					TotalTime:              totalTime,
-					InternalTime:           internalTime,
-					EnterTime:              enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
-
-	if slices.Contains(mak.ReqModel.Pricing.BillingItems, "image_generation") {
-
-		billingData := &mcommon.BillingData{
-			ImageGenerationRequest: params,
-		}
-
-		// 计算花费
-		spend := common.Billing(ctx, mak, billingData, "image_generation")
-		if spend.ImageGeneration.Pricing.Width > 0 && spend.ImageGeneration.Pricing.Height > 0 {
-			if spend.ImageGeneration.Pricing.Quality != "" {
-				params.Quality = spend.ImageGeneration.Pricing.Quality
+	pipeline := &common.Pipeline[chan *smodel.ImageResponse]{
+		Name: "sImage GenerationsStream",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              reqParams.Model,
+				Endpoint:           consts.ENDPOINT_IMAGE_GENERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ImageGenerationRequest: reqParams},
 			}
-			params.Size = fmt.Sprintf("%dx%d", spend.ImageGeneration.Pricing.Width, spend.ImageGeneration.Pricing.Height)
-		}
-	}
-
-	request := params
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
 
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		request.Model = mak.RealModel.Model
-	}
+			params = reqParams
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sImage GenerationsStream request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
+			if quality, size := billingImageSpec(ctx, attempt.Mak, &mcommon.BillingData{ImageGenerationRequest: params}); size != "" {
+				if quality != "" {
+					params.Quality = quality
+				}
+				params.Size = size
 			}
-		}
-	}
 
-	response, err := common.NewAdapter(ctx, mak, true).ImageGenerationsStream(ctx, gjson.MustEncode(request))
-	if err != nil {
-		logger.Error(ctx, err)
+			request = params
+			request.Model = attempt.UpstreamModel(ctx, request.Model)
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (chan *smodel.ImageResponse, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ImageGenerationsStream")
+			responseChan, err := common.NewAdapter(upstreamCtx, attempt.Mak, true).ImageGenerationsStream(upstreamCtx, gjson.MustEncode(request))
+			attempt.EndStreamSpan(upstreamSpan, err)
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan *smodel.ImageResponse) (chan *smodel.ImageResponse, error) {
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			defer close(responseChan)
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			for {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				response := <-responseChan
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-		if isRetry {
+				if response.Error != nil {
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.GenerationsStream(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.GenerationsStream(g.RequestFromCtx(ctx).GetCtx(), data, nil, fallbackModel)
-							}
-						}
+					if errors.Is(response.Error, io.EOF) {
+						imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &imageResponse, params.OutputFormat, params.ResponseFormat)
+						return responseChan, nil
 					}
 
-					return err
+					return responseChan, streamError(ctx, attempt.Mak, response.Error)
 				}
-			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.GenerationsStream(g.RequestFromCtx(ctx).GetCtx(), data, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return err
-	}
 
-	defer close(response)
-
-	for {
-
-		response := <-response
-
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
-
-		if response.Error != nil {
-
-			if errors.Is(response.Error, io.EOF) {
-				imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &imageResponse, params.OutputFormat, params.ResponseFormat)
-				return nil
-			}
-
-			err = response.Error
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, attempt.Mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, attempt.Mak.ModelAgent, attempt.Mak.Key, response.ResponseHeaders)
+				}
 
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+				if len(response.Data) > 0 {
+					imageResponse.Data = response.Data
+				}
 
-			if _, isDisabled := common.IsNeedRetry(err); isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if response.Usage.TotalTokens != 0 || response.Usage.InputTokens != 0 || response.Usage.OutputTokens != 0 {
+					logger.Infof(ctx, "sImage GenerationsStream Usage: %s", response.ResponseBytes)
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					usage = &response.Usage
+				}
 
-				}, nil); err != nil {
+				if err := util.SSEServer(ctx, string(response.ResponseBytes), response.Event); err != nil {
 					logger.Error(ctx, err)
+					return responseChan, err
 				}
 			}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.ImageResponse, err error) *mcommon.AfterHandler {
 
-			return err
-		}
-
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+			after := &mcommon.AfterHandler{
+				ImageGenerationRequest: params,
+				ImageResponse:          imageResponse,
+				ImageFilePaths:         imageFilePaths,
+				ImageExpiresAt:         imageExpiresAt,
+				Action:                 consts.ACTION_GENERATIONS,
+				Usage:                  usage,
+				ConnTime:               connTime,
+				Duration:               duration,
+				TotalTime:              totalTime,
+			}
 
-		if len(response.Data) > 0 {
-			imageResponse.Data = response.Data
-		}
+			after.ImageResponse.TotalTime = totalTime
 
-		if response.Usage.TotalTokens != 0 || response.Usage.InputTokens != 0 || response.Usage.OutputTokens != 0 {
-			logger.Infof(ctx, "sImage GenerationsStream Usage: %s", response.ResponseBytes)
+			return after
+		},
+	}
 
-			usage = &response.Usage
-		}
+	_, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 
-		if err = util.SSEServer(ctx, string(response.ResponseBytes), response.Event); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
-	}
+	return err
 }
 
 // Edits
@@ -462,187 +276,71 @@ func (s *sImage) Edits(ctx context.Context, params smodel.ImageEditRequest, fall
 	}()
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_IMAGE_EDITS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		imageResponse  smodel.ImageResponse
-		retryInfo      *mcommon.Retry
-		imageFilePaths []string
-		imageExpiresAt int64
+		request                smodel.ImageEditRequest
+		originalResponseFormat string
+		imageResponse          smodel.ImageResponse
+		imageFilePaths         []string
+		imageExpiresAt         int64
 	)
 
-	if gstr.Contains(g.RequestFromCtx(ctx).Header.Get("Content-Type"), "multipart/form-data") {
-		// form 文件方式提交, image 必填
-		if params.Image == nil {
-			return response, errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGE
-		}
-	} else {
-		// json 方式提交, images 必填
-		if len(params.Images) == 0 {
-			return response, errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGES
-		}
-	}
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - imageResponse.TotalTime
-		usage := imageResponse.Usage
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				imageReq := smodel.ImageGenerationRequest{
-					Prompt:         params.Prompt,
-					Background:     params.Background,
-					Model:          params.Model,
-					N:              params.N,
-					Quality:        params.Quality,
-					ResponseFormat: params.ResponseFormat,
-					Size:           params.Size,
-					User:           params.User,
-					Stream:         params.Stream,
-				}
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ImageGenerationRequest: imageReq,
-					ImageResponse:          imageResponse,
-					Action:                 consts.ACTION_EDITS,
-					ImageFilePaths:         imageFilePaths,
-					ImageExpiresAt:         imageExpiresAt,
-					Usage:                  &usage,
-					Error:                  err,
-					RetryInfo:              retryInfo,
-					TotalTime:              imageResponse.TotalTime,
-					InternalTime:           internalTime,
-					EnterTime:              enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
+	if err = checkEditImage(ctx, params); err != nil {
 		return response, err
 	}
 
-	if slices.Contains(mak.ReqModel.Pricing.BillingItems, "image_generation") {
-
-		billingData := &mcommon.BillingData{
-			ImageEditRequest: params,
-		}
-
-		// 计算花费
-		spend := common.Billing(ctx, mak, billingData, "image_generation")
-		if spend.ImageGeneration.Pricing.Width > 0 && spend.ImageGeneration.Pricing.Height > 0 {
-			if spend.ImageGeneration.Pricing.Quality != "" {
-				params.Quality = spend.ImageGeneration.Pricing.Quality
+	pipeline := &common.Pipeline[smodel.ImageResponse]{
+		Name: "sImage Edits",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_IMAGE_EDITS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ImageEditRequest: params},
 			}
-			params.Size = fmt.Sprintf("%dx%d", spend.ImageGeneration.Pricing.Width, spend.ImageGeneration.Pricing.Height)
-		}
-	}
-
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		params.Model = mak.RealModel.Model
-	}
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sImage Edits request.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
-				break
-			}
-		}
-	}
-
-	// 开启转储时强制向上游请求b64_json以便落盘, 用户原始response_format另行保留用于控制是否回传base64
-	originalResponseFormat := params.ResponseFormat
-	if config.Cfg.ImageStorage.Open {
-		params.ResponseFormat = "b64_json"
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).ImageEdits(ctx, params)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			request = editRequest(ctx, attempt, params)
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			// 开启转储时强制向上游请求b64_json以便落盘, 用户原始response_format另行保留用于控制是否回传base64
+			originalResponseFormat = request.ResponseFormat
+			if config.Cfg.ImageStorage.Open {
+				request.ResponseFormat = "b64_json"
 			}
-		}
 
-		if isRetry {
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (smodel.ImageResponse, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ImageEdits")
+			response, err := common.NewAdapter(upstreamCtx, attempt.Mak, false).ImageEdits(upstreamCtx, request)
+			common.EndSpan(upstreamSpan, err)
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.ImageResponse) (smodel.ImageResponse, error) {
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &response, request.OutputFormat, originalResponseFormat)
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+			imageResponse = response
 
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Edits(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Edits(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
+			return response, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ smodel.ImageResponse, err error) *mcommon.AfterHandler {
 
-					return response, err
-				}
-			}
+			usage := imageResponse.Usage
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return &mcommon.AfterHandler{
+				ImageGenerationRequest: editLogRequest(request),
+				ImageResponse:          imageResponse,
+				ImageFilePaths:         imageFilePaths,
+				ImageExpiresAt:         imageExpiresAt,
+				Action:                 consts.ACTION_EDITS,
+				Usage:                  &usage,
+				TotalTime:              imageResponse.TotalTime,
 			}
-
-			return s.Edits(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &response, params.OutputFormat, originalResponseFormat)
-
-	imageResponse = response
-
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // EditsStream
@@ -654,238 +352,193 @@ func (s *sImage) EditsStream(ctx context.Context, params smodel.ImageEditRequest
 	}()
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_IMAGE_EDITS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
+		request        smodel.ImageEditRequest
 		imageResponse  smodel.ImageResponse
 		usage          *smodel.Usage
 		connTime       int64
 		duration       int64
 		totalTime      int64
-		retryInfo      *mcommon.Retry
 		imageFilePaths []string
 		imageExpiresAt int64
 	)
 
-	if gstr.Contains(g.RequestFromCtx(ctx).Header.Get("Content-Type"), "multipart/form-data") {
-		// form 文件方式提交, image 必填
-		if params.Image == nil {
-			return errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGE
-		}
-	} else {
-		// json 方式提交, images 必填
-		if len(params.Images) == 0 {
-			return errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGES
-		}
+	if err = checkEditImage(ctx, params); err != nil {
+		return err
 	}
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				imageResponse.TotalTime = totalTime
-
-				imageReq := smodel.ImageGenerationRequest{
-					Prompt:         params.Prompt,
-					Background:     params.Background,
-					Model:          params.Model,
-					N:              params.N,
-					Quality:        params.Quality,
-					ResponseFormat: params.ResponseFormat,
-					Size:           params.Size,
-					User:           params.User,
-					Stream:         params.Stream,
-				}
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ImageGenerationRequest: imageReq,
-					ImageResponse:          imageResponse,
-					Action:                 consts.ACTION_EDITS,
-					ImageFilePaths:         imageFilePaths,
-					ImageExpiresAt:         imageExpiresAt,
-					Usage:                  usage,
-					Error:                  err,
-					RetryInfo:              retryInfo,
-					ConnTime:               connTime,
-					Duration:               duration,
-					TotalTime:              totalTime,
-					InternalTime:           internalTime,
-					EnterTime:              enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[chan *smodel.ImageResponse]{
+		Name: "sImage EditsStream",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_IMAGE_EDITS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ImageEditRequest: params},
 			}
-		}
-	}()
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) error {
+			request = editRequest(ctx, attempt, params)
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (chan *smodel.ImageResponse, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ImageEditsStream")
+			responseChan, err := common.NewAdapter(upstreamCtx, attempt.Mak, true).ImageEditsStream(upstreamCtx, request)
+			attempt.EndStreamSpan(upstreamSpan, err)
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan *smodel.ImageResponse) (chan *smodel.ImageResponse, error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			defer close(responseChan)
 
-	if slices.Contains(mak.ReqModel.Pricing.BillingItems, "image_generation") {
+			for {
 
-		billingData := &mcommon.BillingData{
-			ImageEditRequest: params,
-		}
+				response := <-responseChan
 
-		// 计算花费
-		spend := common.Billing(ctx, mak, billingData, "image_generation")
-		if spend.ImageGeneration.Pricing.Width > 0 && spend.ImageGeneration.Pricing.Height > 0 {
-			if spend.ImageGeneration.Pricing.Quality != "" {
-				params.Quality = spend.ImageGeneration.Pricing.Quality
-			}
-			params.Size = fmt.Sprintf("%dx%d", spend.ImageGeneration.Pricing.Width, spend.ImageGeneration.Pricing.Height)
-		}
-	}
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-	if !gstr.Contains(mak.RealModel.Model, "*") {
-		params.Model = mak.RealModel.Model
-	}
+				if response.Error != nil {
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sImage EditsStream request.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
-				break
-			}
-		}
-	}
+					if errors.Is(response.Error, io.EOF) {
+						imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &imageResponse, request.OutputFormat, request.ResponseFormat)
+						return responseChan, nil
+					}
 
-	response, err := common.NewAdapter(ctx, mak, true).ImageEditsStream(ctx, params)
-	if err != nil {
-		logger.Error(ctx, err)
+					return responseChan, streamError(ctx, attempt.Mak, response.Error)
+				}
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+				// 响应头透传
+				if response.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, attempt.Mak.Passthrough, response.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, attempt.Mak.ModelAgent, attempt.Mak.Key, response.ResponseHeaders)
+				}
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+				if len(response.Data) > 0 {
+					imageResponse.Data = response.Data
+				}
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if response.Usage.TotalTokens != 0 || response.Usage.InputTokens != 0 || response.Usage.OutputTokens != 0 {
+					logger.Infof(ctx, "sImage EditsStream Usage: %s", response.ResponseBytes)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+					usage = &response.Usage
+				}
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+				if err := util.SSEServer(ctx, string(response.ResponseBytes), response.Event); err != nil {
+					logger.Error(ctx, err)
+					return responseChan, err
+				}
 			}
-		}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.ImageResponse, err error) *mcommon.AfterHandler {
 
-		if isRetry {
+			after := &mcommon.AfterHandler{
+				ImageGenerationRequest: editLogRequest(request),
+				ImageResponse:          imageResponse,
+				ImageFilePaths:         imageFilePaths,
+				ImageExpiresAt:         imageExpiresAt,
+				Action:                 consts.ACTION_EDITS,
+				Usage:                  usage,
+				ConnTime:               connTime,
+				Duration:               duration,
+				TotalTime:              totalTime,
+			}
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			after.ImageResponse.TotalTime = totalTime
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+			return after
+		},
+	}
 
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.EditsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.EditsStream(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
+	_, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 
-					return err
-				}
-			}
+	return err
+}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
+// 检查图像编辑请求的必填图像
+func checkEditImage(ctx context.Context, params smodel.ImageEditRequest) error {
 
-			return s.EditsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
+	if gstr.Contains(g.RequestFromCtx(ctx).Header.Get("Content-Type"), "multipart/form-data") {
+		// form 文件方式提交, image 必填
+		if params.Image == nil {
+			return errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGE
+		}
+	} else {
+		// json 方式提交, images 必填
+		if len(params.Images) == 0 {
+			return errors.ERR_MISSING_REQUIRED_PARAMETER_IMAGES
 		}
-
-		return err
 	}
 
-	defer close(response)
-
-	for {
+	return nil
+}
 
-		response := <-response
+// 按计费规格、实际模型和模型替换配置构建上游图像编辑请求
+func editRequest(ctx context.Context, attempt *common.Attempt, params smodel.ImageEditRequest) smodel.ImageEditRequest {
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+	request := params
 
-		if response.Error != nil {
+	if quality, size := billingImageSpec(ctx, attempt.Mak, &mcommon.BillingData{ImageEditRequest: request}); size != "" {
+		if quality != "" {
+			request.Quality = quality
+		}
+		request.Size = size
+	}
 
-			if errors.Is(response.Error, io.EOF) {
-				imageFilePaths, imageExpiresAt = saveImageStorage(ctx, &imageResponse, params.OutputFormat, params.ResponseFormat)
-				return nil
-			}
+	request.Model = attempt.UpstreamModel(ctx, request.Model)
 
-			err = response.Error
+	return request
+}
 
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+// 图像编辑请求转为日志记录的图像生成请求
+func editLogRequest(params smodel.ImageEditRequest) smodel.ImageGenerationRequest {
+	return smodel.ImageGenerationRequest{
+		Prompt:         params.Prompt,
+		Background:     params.Background,
+		Model:          params.Model,
+		N:              params.N,
+		Quality:        params.Quality,
+		ResponseFormat: params.ResponseFormat,
+		Size:           params.Size,
+		User:           params.User,
+		Stream:         params.Stream,
+	}
+}
 
-			if _, isDisabled := common.IsNeedRetry(err); isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+// 按计费项的图像规格确定请求的质量和尺寸, 未配置规格时返回空
+func billingImageSpec(ctx context.Context, mak *common.MAK, billingData *mcommon.BillingData) (quality, size string) {
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+	if !slices.Contains(mak.ReqModel.Pricing.BillingItems, "image_generation") {
+		return "", ""
+	}
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
-				}
-			}
+	// 计算花费
+	spend := common.Billing(ctx, mak, billingData, "image_generation")
+	if spend.ImageGeneration.Pricing.Width > 0 && spend.ImageGeneration.Pricing.Height > 0 {
+		return spend.ImageGeneration.Pricing.Quality, fmt.Sprintf("%dx%d", spend.ImageGeneration.Pricing.Width, spend.ImageGeneration.Pricing.Height)
+	}
 
-			return err
-		}
+	return "", ""
+}
 
-		// 响应头透传
-		if response.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, response.ResponseHeaders)
-		}
+// 流式响应中途的上游错误, 记录错误次数并按错误禁用密钥
+func streamError(ctx context.Context, mak *common.MAK, err error) error {
 
-		if len(response.Data) > 0 {
-			imageResponse.Data = response.Data
-		}
+	// 记录错误次数和禁用
+	service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, err)
 
-		if response.Usage.TotalTokens != 0 || response.Usage.InputTokens != 0 || response.Usage.OutputTokens != 0 {
-			logger.Infof(ctx, "sImage EditsStream Usage: %s", response.ResponseBytes)
+	if _, isDisabled := common.IsNeedRetry(err); isDisabled {
+		if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 
-			usage = &response.Usage
-		}
+			service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
 
-		if err = util.SSEServer(ctx, string(response.ResponseBytes), response.Event); err != nil {
+		}, nil); err != nil {
 			logger.Error(ctx, err)
-			return err
 		}
 	}
+
+	return err
 }
 
 // GenerationsAsync
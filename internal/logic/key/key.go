@@ -48,6 +48,7 @@ func (s *sKey) List(ctx context.Context) ([]*model.Key, error) {
 			ModelAgents:    result.ModelAgents,
 			IsNeverDisable: result.IsNeverDisable,
 			UsedQuota:      result.UsedQuota,
+			UpstreamLimit:  result.UpstreamLimit,
 			Status:         result.Status,
 		})
 	}
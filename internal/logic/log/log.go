@@ -4,11 +4,11 @@ import (
 	"context"
 	"slices"
 	"strings"
-	"time"
 
 	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
@@ -16,7 +16,6 @@ import (
 	serrors "github.com/iimeta/fastapi-sdk/v2/errors"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
 	"github.com/iimeta/fastapi/v2/internal/config"
-	"github.com/iimeta/fastapi/v2/internal/dao"
 	"github.com/iimeta/fastapi/v2/internal/errors"
 	"github.com/iimeta/fastapi/v2/internal/logic/common"
 	"github.com/iimeta/fastapi/v2/internal/model"
@@ -28,14 +27,23 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
-type sLog struct{}
+type sLog struct {
+	writer *writer
+}
 
 func init() {
 	service.RegisterLog(New())
 }
 
 func New() service.ILog {
-	return &sLog{}
+	return &sLog{
+		writer: newWriter(gctx.New()),
+	}
+}
+
+// 关闭日志写入, 队列中的日志写入数据库或本地缓冲文件
+func (s *sLog) Close(ctx context.Context) {
+	s.writer.close(ctx)
 }
 
 // 文本日志
@@ -46,33 +54,39 @@ func (s *sLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
 		logger.Debugf(ctx, "sLog Text time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog Text")
+	defer span.End()
+
 	// 不记录此错误日志
 	if textLog.CompletionsRes.Error != nil && checkError(textLog.CompletionsRes.Error) {
 		return
 	}
 
 	text := do.LogText{
-		TraceId:      gtrace.GetTraceID(ctx),
-		UserId:       service.Session().GetUserId(ctx),
-		AppId:        service.Session().GetAppId(ctx),
-		Action:       textLog.Action,
-		IsSmartMatch: textLog.IsSmartMatch,
-		Stream:       textLog.CompletionsReq.Stream,
-		Spend:        textLog.Spend,
-		ConnTime:     textLog.CompletionsRes.ConnTime,
-		Duration:     textLog.CompletionsRes.Duration,
-		TotalTime:    textLog.CompletionsRes.TotalTime,
-		InternalTime: textLog.CompletionsRes.InternalTime,
-		ReqTime:      textLog.CompletionsRes.EnterTime,
-		ReqDate:      gtime.NewFromTimeStamp(textLog.CompletionsRes.EnterTime).Format("Y-m-d"),
-		ClientIp:     g.RequestFromCtx(ctx).GetClientIp(),
-		RemoteIp:     g.RequestFromCtx(ctx).GetRemoteIp(),
-		LocalIp:      util.GetLocalIp(),
-		Status:       1,
-		Host:         g.RequestFromCtx(ctx).GetHost(),
-		Method:       g.RequestFromCtx(ctx).Method,
-		Path:         g.RequestFromCtx(ctx).URL.Path,
-		Rid:          service.Session().GetRid(ctx),
+		TraceId:        gtrace.GetTraceID(ctx),
+		UserId:         service.Session().GetUserId(ctx),
+		AppId:          service.Session().GetAppId(ctx),
+		Action:         textLog.Action,
+		IsSmartMatch:   textLog.IsSmartMatch,
+		IsCacheHit:     textLog.IsCacheHit,
+		Guardrails:     textLog.Guardrails,
+		PromptTemplate: textLog.PromptTemplate,
+		Stream:         textLog.CompletionsReq.Stream,
+		Spend:          textLog.Spend,
+		ConnTime:       textLog.CompletionsRes.ConnTime,
+		Duration:       textLog.CompletionsRes.Duration,
+		TotalTime:      textLog.CompletionsRes.TotalTime,
+		InternalTime:   textLog.CompletionsRes.InternalTime,
+		ReqTime:        textLog.CompletionsRes.EnterTime,
+		ReqDate:        gtime.NewFromTimeStamp(textLog.CompletionsRes.EnterTime).Format("Y-m-d"),
+		ClientIp:       g.RequestFromCtx(ctx).GetClientIp(),
+		RemoteIp:       g.RequestFromCtx(ctx).GetRemoteIp(),
+		LocalIp:        util.GetLocalIp(),
+		Status:         1,
+		Host:           g.RequestFromCtx(ctx).GetHost(),
+		Method:         g.RequestFromCtx(ctx).Method,
+		Path:           g.RequestFromCtx(ctx).URL.Path,
+		Rid:            service.Session().GetRid(ctx),
 	}
 
 	if config.Cfg.Log.Open && slices.Contains(config.Cfg.Log.TextRecords, "prompt") {
@@ -263,6 +277,8 @@ func (s *sLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
 
 		if common.IsAborted(textLog.CompletionsRes.Error) {
 			text.Status = 2
+		} else if common.IsStreamStalled(textLog.CompletionsRes.Error) {
+			text.Status = 4
 		} else {
 			text.Status = -1
 		}
@@ -381,6 +397,8 @@ func (s *sLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
 			IsRetry:    textLog.RetryInfo.IsRetry,
 			RetryCount: textLog.RetryInfo.RetryCount,
 			ErrMsg:     textLog.RetryInfo.ErrMsg,
+			IsHedge:    textLog.RetryInfo.IsHedge,
+			Fallback:   textLog.RetryInfo.Fallback,
 		}
 
 		if text.IsRetry {
@@ -391,10 +409,11 @@ func (s *sLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
 
 	applyTextPrivacy(&text, privacy(ctx))
 
-	if _, err := dao.LogText.Insert(ctx, text); err != nil {
+	if err := s.writer.write(ctx, text); err != nil {
 		logger.Errorf(ctx, "sLog Text error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 
 			if textLog.CompletionsReq != nil {
 				textLog.CompletionsReq.Messages = []smodel.ChatCompletionMessage{{
@@ -410,19 +429,8 @@ func (s *sLog) Text(ctx context.Context, textLog model.LogText, retry ...int) {
 			if textLog.CompletionsRes != nil {
 				textLog.CompletionsRes.Completion = err.Error()
 			}
+			s.Text(ctx, textLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog Text retry: %d", len(retry))
-
-		s.Text(ctx, textLog, retry...)
 	}
 }
 
@@ -434,6 +442,9 @@ func (s *sLog) Image(ctx context.Context, imageLog model.LogImage, retry ...int)
 		logger.Debugf(ctx, "sLog Image time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog Image")
+	defer span.End()
+
 	// 不记录此错误日志
 	if imageLog.ImageRes.Error != nil && checkError(imageLog.ImageRes.Error) {
 		return
@@ -568,6 +579,7 @@ func (s *sLog) Image(ctx context.Context, imageLog model.LogImage, retry ...int)
 			IsRetry:    imageLog.RetryInfo.IsRetry,
 			RetryCount: imageLog.RetryInfo.RetryCount,
 			ErrMsg:     imageLog.RetryInfo.ErrMsg,
+			Fallback:   imageLog.RetryInfo.Fallback,
 		}
 
 		if image.IsRetry {
@@ -578,24 +590,14 @@ func (s *sLog) Image(ctx context.Context, imageLog model.LogImage, retry ...int)
 
 	applyImagePrivacy(&image, privacy(ctx))
 
-	if _, err := dao.LogImage.Insert(ctx, image); err != nil {
+	if err := s.writer.write(ctx, image); err != nil {
 		logger.Errorf(ctx, "sLog Image error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			imageLog.ImageReq.Prompt = err.Error()
+			s.Image(ctx, imageLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog Image retry: %d", len(retry))
-
-		s.Image(ctx, imageLog, retry...)
 	}
 }
 
@@ -607,6 +609,9 @@ func (s *sLog) Audio(ctx context.Context, audioLog model.LogAudio, retry ...int)
 		logger.Debugf(ctx, "sLog Audio time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog Audio")
+	defer span.End()
+
 	// 不记录此错误日志
 	if audioLog.AudioRes.Error != nil && checkError(audioLog.AudioRes.Error) {
 		return
@@ -714,6 +719,7 @@ func (s *sLog) Audio(ctx context.Context, audioLog model.LogAudio, retry ...int)
 			IsRetry:    audioLog.RetryInfo.IsRetry,
 			RetryCount: audioLog.RetryInfo.RetryCount,
 			ErrMsg:     audioLog.RetryInfo.ErrMsg,
+			Fallback:   audioLog.RetryInfo.Fallback,
 		}
 
 		if audio.IsRetry {
@@ -724,24 +730,14 @@ func (s *sLog) Audio(ctx context.Context, audioLog model.LogAudio, retry ...int)
 
 	applyAudioPrivacy(&audio, privacy(ctx))
 
-	if _, err := dao.LogAudio.Insert(ctx, audio); err != nil {
+	if err := s.writer.write(ctx, audio); err != nil {
 		logger.Errorf(ctx, "sLog Audio error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			audioLog.AudioReq.Input = err.Error()
+			s.Audio(ctx, audioLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog Audio retry: %d", len(retry))
-
-		s.Audio(ctx, audioLog, retry...)
 	}
 }
 
@@ -753,6 +749,9 @@ func (s *sLog) Video(ctx context.Context, videoLog model.LogVideo, retry ...int)
 		logger.Debugf(ctx, "sLog Video time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog Video")
+	defer span.End()
+
 	// 不记录此错误日志
 	if videoLog.VideoRes.Error != nil && checkError(videoLog.VideoRes.Error) {
 		return
@@ -861,6 +860,7 @@ func (s *sLog) Video(ctx context.Context, videoLog model.LogVideo, retry ...int)
 			IsRetry:    videoLog.RetryInfo.IsRetry,
 			RetryCount: videoLog.RetryInfo.RetryCount,
 			ErrMsg:     videoLog.RetryInfo.ErrMsg,
+			Fallback:   videoLog.RetryInfo.Fallback,
 		}
 
 		if video.IsRetry {
@@ -871,24 +871,14 @@ func (s *sLog) Video(ctx context.Context, videoLog model.LogVideo, retry ...int)
 
 	applyVideoPrivacy(&video, privacy(ctx))
 
-	if _, err := dao.LogVideo.Insert(ctx, video); err != nil {
+	if err := s.writer.write(ctx, video); err != nil {
 		logger.Errorf(ctx, "sLog Video error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			videoLog.VideoReq.RequestData = map[string]any{"error": err.Error()}
+			s.Video(ctx, videoLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog Video retry: %d", len(retry))
-
-		s.Video(ctx, videoLog, retry...)
 	}
 }
 
@@ -900,6 +890,9 @@ func (s *sLog) File(ctx context.Context, fileLog model.LogFile, retry ...int) {
 		logger.Debugf(ctx, "sLog File time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog File")
+	defer span.End()
+
 	// 不记录此错误日志
 	if fileLog.FileRes.Error != nil && checkError(fileLog.FileRes.Error) {
 		return
@@ -1008,6 +1001,7 @@ func (s *sLog) File(ctx context.Context, fileLog model.LogFile, retry ...int) {
 			IsRetry:    fileLog.RetryInfo.IsRetry,
 			RetryCount: fileLog.RetryInfo.RetryCount,
 			ErrMsg:     fileLog.RetryInfo.ErrMsg,
+			Fallback:   fileLog.RetryInfo.Fallback,
 		}
 
 		if file.IsRetry {
@@ -1018,24 +1012,14 @@ func (s *sLog) File(ctx context.Context, fileLog model.LogFile, retry ...int) {
 
 	applyFilePrivacy(&file, privacy(ctx))
 
-	if _, err := dao.LogFile.Insert(ctx, file); err != nil {
+	if err := s.writer.write(ctx, file); err != nil {
 		logger.Errorf(ctx, "sLog File error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			fileLog.FileReq.RequestData = map[string]any{"error": err.Error()}
+			s.File(ctx, fileLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog File retry: %d", len(retry))
-
-		s.File(ctx, fileLog, retry...)
 	}
 }
 
@@ -1047,6 +1031,9 @@ func (s *sLog) Batch(ctx context.Context, batchLog model.LogBatch, retry ...int)
 		logger.Debugf(ctx, "sLog Batch time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog Batch")
+	defer span.End()
+
 	// 不记录此错误日志
 	if batchLog.BatchRes.Error != nil && checkError(batchLog.BatchRes.Error) {
 		return
@@ -1155,6 +1142,7 @@ func (s *sLog) Batch(ctx context.Context, batchLog model.LogBatch, retry ...int)
 			IsRetry:    batchLog.RetryInfo.IsRetry,
 			RetryCount: batchLog.RetryInfo.RetryCount,
 			ErrMsg:     batchLog.RetryInfo.ErrMsg,
+			Fallback:   batchLog.RetryInfo.Fallback,
 		}
 
 		if batch.IsRetry {
@@ -1165,24 +1153,14 @@ func (s *sLog) Batch(ctx context.Context, batchLog model.LogBatch, retry ...int)
 
 	applyBatchPrivacy(&batch, privacy(ctx))
 
-	if _, err := dao.LogBatch.Insert(ctx, batch); err != nil {
+	if err := s.writer.write(ctx, batch); err != nil {
 		logger.Errorf(ctx, "sLog Batch error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			batchLog.BatchReq.RequestData = map[string]any{"error": err.Error()}
+			s.Batch(ctx, batchLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog Batch retry: %d", len(retry))
-
-		s.Batch(ctx, batchLog, retry...)
 	}
 }
 
@@ -1194,6 +1172,9 @@ func (s *sLog) General(ctx context.Context, generalLog model.LogGeneral, retry .
 		logger.Debugf(ctx, "sLog General time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sLog General")
+	defer span.End()
+
 	// 不记录此错误日志
 	if generalLog.GeneralRes.Error != nil && checkError(generalLog.GeneralRes.Error) {
 		return
@@ -1305,6 +1286,7 @@ func (s *sLog) General(ctx context.Context, generalLog model.LogGeneral, retry .
 			IsRetry:    generalLog.RetryInfo.IsRetry,
 			RetryCount: generalLog.RetryInfo.RetryCount,
 			ErrMsg:     generalLog.RetryInfo.ErrMsg,
+			Fallback:   generalLog.RetryInfo.Fallback,
 		}
 
 		if general.IsRetry {
@@ -1315,24 +1297,14 @@ func (s *sLog) General(ctx context.Context, generalLog model.LogGeneral, retry .
 
 	applyGeneralPrivacy(&general, privacy(ctx))
 
-	if _, err := dao.LogGeneral.Insert(ctx, general); err != nil {
+	if err := s.writer.write(ctx, general); err != nil {
 		logger.Errorf(ctx, "sLog General error: %v", err)
 
-		if isTooLarge(err) {
+		// 日志过大时替换请求和响应内容后重新写入
+		if isTooLarge(err) && len(retry) == 0 {
 			generalLog.GeneralReq.RequestData = map[string]any{"error": err.Error()}
+			s.General(ctx, generalLog, 1)
 		}
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sLog General retry: %d", len(retry))
-
-		s.General(ctx, generalLog, retry...)
 	}
 }
 
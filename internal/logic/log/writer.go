@@ -0,0 +1,216 @@
+package log
+
+import (
+	"context"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/db"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/spool"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MongoDB 单个文档最大 16MB, 预留写入命令的开销
+const maxDocumentSize = 16*1024*1024 - 16*1024
+
+var errDocumentTooLarge = errors.New("an inserted document is too large")
+
+// 缓冲的日志
+type record struct {
+	Collection string `bson:"collection"`
+	Document   bson.M `bson:"document"`
+}
+
+// 日志写入, 内存队列按集合批量写入数据库, 写入失败或队列已满时追加到本地缓冲文件, 数据库恢复后按顺序重放
+type writer struct {
+	queue *spool.Queue[*record]
+}
+
+func newWriter(ctx context.Context) *writer {
+
+	cfg := config.Cfg.LogWriter
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 200
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 1000
+	}
+
+	if cfg.SpoolPath == "" {
+		cfg.SpoolPath = "./spool/log.spool"
+	}
+
+	// 缓冲文件不可用时直接写入数据库, 写入失败的日志丢弃
+	recordSpool, err := spool.New(cfg.SpoolPath, spool.Codec[*record]{
+		Marshal: func(r *record) ([]byte, error) {
+			return bson.Marshal(r)
+		},
+		Unmarshal: func(data []byte) (*record, error) {
+			r := new(record)
+			return r, bson.Unmarshal(data, r)
+		},
+	})
+	if err != nil {
+		logger.Errorf(ctx, "sLog writer open spool: %s, error: %v, write to mongo directly", cfg.SpoolPath, err)
+	}
+
+	w := new(writer)
+
+	w.queue = spool.NewQueue(ctx, recordSpool, spool.Options{
+		Name:          "sLog writer",
+		QueueSize:     cfg.QueueSize,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: time.Duration(cfg.FlushInterval) * time.Millisecond,
+	}, spool.Handler[*record]{
+		Flush:   w.flush,
+		Replay:  w.replay,
+		Spooled: w.spooled,
+		Backlog: func(ctx context.Context, queued int, pending int64) {
+			service.Metrics().LogBacklog(ctx, queued, pending)
+		},
+	})
+
+	return w
+}
+
+// 写入日志, 队列已满或已关闭时直接写入本地缓冲文件, 缓冲文件不可用时直接写入数据库
+func (w *writer) write(ctx context.Context, document any) error {
+
+	collection, value, err := dao.Document(ctx, document)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes) > maxDocumentSize {
+		return errDocumentTooLarge
+	}
+
+	return w.queue.Put(ctx, &record{
+		Collection: collection,
+		Document:   value,
+	})
+}
+
+// 按集合批量写入, 返回写入失败的日志
+func (w *writer) flush(ctx context.Context, records []*record) (failed []*record) {
+
+	collections := make([]string, 0)
+	documents := make(map[string][]any)
+	recordMap := make(map[string][]*record)
+
+	for _, r := range records {
+
+		if _, ok := documents[r.Collection]; !ok {
+			collections = append(collections, r.Collection)
+		}
+
+		documents[r.Collection] = append(documents[r.Collection], r.Document)
+		recordMap[r.Collection] = append(recordMap[r.Collection], r)
+	}
+
+	for _, collection := range collections {
+
+		if err := w.insertMany(ctx, collection, documents[collection]); err != nil {
+			logger.Errorf(ctx, "sLog writer insert collection: %s, error: %v", collection, err)
+			failed = append(failed, recordMap[collection]...)
+			continue
+		}
+
+		service.Metrics().LogWrite(ctx, collection, "mongo", len(documents[collection]))
+	}
+
+	return failed
+}
+
+// 重放缓冲文件中的日志, 按顺序将相邻的同集合日志合并写入
+func (w *writer) replay(ctx context.Context, records []*record) error {
+
+	for start := 0; start < len(records); {
+
+		end := start + 1
+		for end < len(records) && records[end].Collection == records[start].Collection {
+			end++
+		}
+
+		documents := make([]any, 0, end-start)
+		for _, r := range records[start:end] {
+			documents = append(documents, r.Document)
+		}
+
+		if err := w.insertMany(ctx, records[start].Collection, documents); err != nil {
+			return errors.Newf("collection: %s, error: %v", records[start].Collection, err)
+		}
+
+		service.Metrics().LogWrite(ctx, records[start].Collection, "mongo", len(documents))
+
+		start = end
+	}
+
+	return nil
+}
+
+// 批量写入, 部分日志已写入(重放时)或单条日志过大时逐条写入, 跳过已写入的日志并丢弃过大的日志
+func (w *writer) insertMany(ctx context.Context, collection string, documents []any) error {
+
+	m := &db.MongoDB{
+		Database:   db.DefaultDatabase,
+		Collection: collection,
+	}
+
+	_, err := m.InsertMany(ctx, documents)
+	if err == nil || (!mongo.IsDuplicateKeyError(err) && !isTooLarge(err)) {
+		return err
+	}
+
+	for _, document := range documents {
+		if _, err = m.InsertOne(ctx, document); err != nil && !mongo.IsDuplicateKeyError(err) {
+
+			if !isTooLarge(err) {
+				return err
+			}
+
+			logger.Errorf(ctx, "sLog writer drop collection: %s, _id: %v, error: %v", collection, document.(bson.M)["_id"], err)
+			service.Metrics().LogWrite(ctx, collection, "dropped", 1)
+		}
+	}
+
+	return nil
+}
+
+func (w *writer) spooled(ctx context.Context, records []*record, err error) {
+
+	result := "spool"
+	if err != nil {
+		result = "dropped"
+	}
+
+	counts := make(map[string]int)
+	for _, r := range records {
+		counts[r.Collection]++
+	}
+
+	for collection, count := range counts {
+		service.Metrics().LogWrite(ctx, collection, result, count)
+	}
+}
+
+// 关闭写入, 队列中的日志写入数据库或本地缓冲文件
+func (w *writer) close(ctx context.Context) {
+	w.queue.Close(ctx)
+}
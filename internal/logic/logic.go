@@ -13,6 +13,7 @@ import (
 	_ "github.com/iimeta/fastapi/v2/internal/logic/batch"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/chat"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/common"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/completion"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/core"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/dashboard"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/embedding"
@@ -23,16 +24,21 @@ import (
 	_ "github.com/iimeta/fastapi/v2/internal/logic/image"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/key"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/log"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/metrics"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/model"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/model_agent"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/moderation"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/openai"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/prompt_template"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/provider"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/rate_limit"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/realtime"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/rerank"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/reseller"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/session"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/session_keep"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/sys_config"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/usage_export"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/user"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/video"
 	_ "github.com/iimeta/fastapi/v2/internal/logic/volcengine"
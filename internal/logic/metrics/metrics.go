@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/metrics"
+)
+
+var labelNames = []string{"model", "real_model", "provider", "model_agent", "endpoint", "status"}
+
+type sMetrics struct {
+	requests     *metrics.CounterVec
+	connTime     *metrics.HistogramVec
+	duration     *metrics.HistogramVec
+	totalTime    *metrics.HistogramVec
+	internalTime *metrics.HistogramVec
+	tokens       *metrics.CounterVec
+	spendTokens  *metrics.CounterVec
+	retries      *metrics.CounterVec
+	disabled     *metrics.CounterVec
+	sessionKeep  *metrics.CounterVec
+	logWrites    *metrics.CounterVec
+	logQueue     *metrics.GaugeVec
+	logSpool     *metrics.GaugeVec
+	usageExports *metrics.CounterVec
+	usageQueue   *metrics.GaugeVec
+	usageSpool   *metrics.GaugeVec
+}
+
+func init() {
+	service.RegisterMetrics(New())
+}
+
+func New() service.IMetrics {
+	return &sMetrics{
+		requests:     metrics.NewCounterVec("fastapi_requests_total", "Total number of requests.", labelNames...),
+		connTime:     metrics.NewHistogramVec("fastapi_request_conn_time_ms", "Upstream connection time in milliseconds.", metrics.DefBuckets, labelNames...),
+		duration:     metrics.NewHistogramVec("fastapi_request_duration_ms", "Upstream duration in milliseconds.", metrics.DefBuckets, labelNames...),
+		totalTime:    metrics.NewHistogramVec("fastapi_request_total_time_ms", "Total request time in milliseconds.", metrics.DefBuckets, labelNames...),
+		internalTime: metrics.NewHistogramVec("fastapi_request_internal_time_ms", "Internal processing time in milliseconds.", metrics.DefBuckets, labelNames...),
+		tokens:       metrics.NewCounterVec("fastapi_tokens_total", "Total number of tokens.", append(labelNames, "type")...),
+		spendTokens:  metrics.NewCounterVec("fastapi_spend_tokens_total", "Total number of spend tokens.", labelNames...),
+		retries:      metrics.NewCounterVec("fastapi_retries_total", "Total number of retries.", labelNames...),
+		disabled:     metrics.NewCounterVec("fastapi_auto_disabled_total", "Total number of auto disabled model agents and keys.", "type", "name"),
+		sessionKeep:  metrics.NewCounterVec("fastapi_session_keep_total", "Total number of session keep lookups.", "model", "result"),
+		logWrites:    metrics.NewCounterVec("fastapi_log_writes_total", "Total number of logs written.", "collection", "result"),
+		logQueue:     metrics.NewGaugeVec("fastapi_log_queue_length", "Number of logs waiting in the write queue."),
+		logSpool:     metrics.NewGaugeVec("fastapi_log_spool_bytes", "Bytes of logs waiting in the spool file."),
+		usageExports: metrics.NewCounterVec("fastapi_usage_exports_total", "Total number of usage events exported.", "sink", "result"),
+		usageQueue:   metrics.NewGaugeVec("fastapi_usage_export_queue_length", "Number of usage events waiting in the export queue.", "sink"),
+		usageSpool:   metrics.NewGaugeVec("fastapi_usage_export_spool_bytes", "Bytes of usage events waiting in the spool file.", "sink"),
+	}
+}
+
+// 记录请求指标
+func (s *sMetrics) Request(ctx context.Context, labels common.MetricsLabels, after *common.AfterHandler) {
+
+	values := labelValues(labels)
+
+	s.requests.Inc(values...)
+
+	if after.ConnTime > 0 {
+		s.connTime.Observe(float64(after.ConnTime), values...)
+	}
+
+	if after.Duration > 0 {
+		s.duration.Observe(float64(after.Duration), values...)
+	}
+
+	if after.TotalTime > 0 {
+		s.totalTime.Observe(float64(after.TotalTime), values...)
+	}
+
+	if after.InternalTime > 0 {
+		s.internalTime.Observe(float64(after.InternalTime), values...)
+	}
+
+	if after.Usage != nil {
+		s.tokens.Add(float64(after.Usage.PromptTokens), append(values, "prompt")...)
+		s.tokens.Add(float64(after.Usage.CompletionTokens), append(values, "completion")...)
+		s.tokens.Add(float64(after.Usage.PromptTokensDetails.CachedTokens), append(values, "cached")...)
+	}
+
+	if after.Spend.TotalSpendTokens > 0 {
+		s.spendTokens.Add(float64(after.Spend.TotalSpendTokens), values...)
+	}
+}
+
+// 记录重试
+func (s *sMetrics) Retry(ctx context.Context, labels common.MetricsLabels) {
+	s.retries.Inc(labelValues(labels)...)
+}
+
+// 记录自动禁用
+func (s *sMetrics) Disabled(ctx context.Context, typ string, name string) {
+	s.disabled.Inc(typ, name)
+}
+
+// 记录会话保持
+func (s *sMetrics) SessionKeep(ctx context.Context, model string, hit bool) {
+	if hit {
+		s.sessionKeep.Inc(model, "hit")
+	} else {
+		s.sessionKeep.Inc(model, "miss")
+	}
+}
+
+// 记录日志写入, result[mongo:写入数据库, spool:写入本地缓冲文件, dropped:丢弃]
+func (s *sMetrics) LogWrite(ctx context.Context, collection, result string, count int) {
+	s.logWrites.Add(float64(count), collection, result)
+}
+
+// 记录日志队列积压
+func (s *sMetrics) LogBacklog(ctx context.Context, queueLength int, spoolBytes int64) {
+	s.logQueue.Set(float64(queueLength))
+	s.logSpool.Set(float64(spoolBytes))
+}
+
+// 记录用量事件导出, result[sent:已导出, spool:写入本地积压文件, dropped:丢弃]
+func (s *sMetrics) UsageExport(ctx context.Context, sink, result string, count int) {
+	s.usageExports.Add(float64(count), sink, result)
+}
+
+// 记录用量事件导出积压
+func (s *sMetrics) UsageExportBacklog(ctx context.Context, sink string, queueLength int, spoolBytes int64) {
+	s.usageQueue.Set(float64(queueLength), sink)
+	s.usageSpool.Set(float64(spoolBytes), sink)
+}
+
+// Prometheus文本格式指标数据
+func (s *sMetrics) Expose(ctx context.Context) []byte {
+	return metrics.Expose()
+}
+
+func labelValues(labels common.MetricsLabels) []string {
+	return []string{labels.Model, labels.RealModel, labels.Provider, labels.ModelAgent, labels.Endpoint, labels.Status}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gregex"
 	"github.com/gogf/gf/v2/text/gstr"
@@ -76,11 +77,17 @@ func (s *sModel) GetModel(ctx context.Context, m string) (*model.Model, error) {
 		ResHeaderPassthroughList: result.ResHeaderPassthroughList,
 		IsPublic:                 result.IsPublic,
 		Endpoints:                result.Endpoints,
+		ContextWindow:            result.ContextWindow,
 		LbStrategy:               result.LbStrategy,
 		IsEnableForward:          result.IsEnableForward,
 		ForwardConfig:            result.ForwardConfig,
 		IsEnableFallback:         result.IsEnableFallback,
 		FallbackConfig:           result.FallbackConfig,
+		IsEnableHedge:            result.IsEnableHedge,
+		HedgeConfig:              result.HedgeConfig,
+		ResponseCache:            result.ResponseCache,
+		Network:                  result.Network,
+		StreamTimeout:            result.StreamTimeout,
 		Remark:                   result.Remark,
 		Status:                   result.Status,
 	}
@@ -129,11 +136,17 @@ func (s *sModel) GetModelById(ctx context.Context, id string) (*model.Model, err
 		ResHeaderPassthroughList: result.ResHeaderPassthroughList,
 		IsPublic:                 result.IsPublic,
 		Endpoints:                result.Endpoints,
+		ContextWindow:            result.ContextWindow,
 		LbStrategy:               result.LbStrategy,
 		IsEnableForward:          result.IsEnableForward,
 		ForwardConfig:            result.ForwardConfig,
 		IsEnableFallback:         result.IsEnableFallback,
 		FallbackConfig:           result.FallbackConfig,
+		IsEnableHedge:            result.IsEnableHedge,
+		HedgeConfig:              result.HedgeConfig,
+		ResponseCache:            result.ResponseCache,
+		Network:                  result.Network,
+		StreamTimeout:            result.StreamTimeout,
 		Remark:                   result.Remark,
 		Status:                   result.Status,
 	}
@@ -320,11 +333,17 @@ func (s *sModel) List(ctx context.Context, ids []string) ([]*model.Model, error)
 			ResHeaderPassthroughList: result.ResHeaderPassthroughList,
 			IsPublic:                 result.IsPublic,
 			Endpoints:                result.Endpoints,
+			ContextWindow:            result.ContextWindow,
 			LbStrategy:               result.LbStrategy,
 			IsEnableForward:          result.IsEnableForward,
 			ForwardConfig:            result.ForwardConfig,
 			IsEnableFallback:         result.IsEnableFallback,
 			FallbackConfig:           result.FallbackConfig,
+			IsEnableHedge:            result.IsEnableHedge,
+			HedgeConfig:              result.HedgeConfig,
+			ResponseCache:            result.ResponseCache,
+			Network:                  result.Network,
+			StreamTimeout:            result.StreamTimeout,
 			Remark:                   result.Remark,
 			Status:                   result.Status,
 			CreatedAt:                result.CreatedAt,
@@ -386,11 +405,17 @@ func (s *sModel) ListAll(ctx context.Context) ([]*model.Model, error) {
 			ResHeaderPassthroughList: result.ResHeaderPassthroughList,
 			IsPublic:                 result.IsPublic,
 			Endpoints:                result.Endpoints,
+			ContextWindow:            result.ContextWindow,
 			LbStrategy:               result.LbStrategy,
 			IsEnableForward:          result.IsEnableForward,
 			ForwardConfig:            result.ForwardConfig,
 			IsEnableFallback:         result.IsEnableFallback,
 			FallbackConfig:           result.FallbackConfig,
+			IsEnableHedge:            result.IsEnableHedge,
+			HedgeConfig:              result.HedgeConfig,
+			ResponseCache:            result.ResponseCache,
+			Network:                  result.Network,
+			StreamTimeout:            result.StreamTimeout,
 			Remark:                   result.Remark,
 			Status:                   result.Status,
 			CreatedAt:                result.CreatedAt,
@@ -555,11 +580,17 @@ func (s *sModel) UpdateCacheModel(ctx context.Context, oldData *entity.Model, ne
 		ResHeaderPassthroughList: newData.ResHeaderPassthroughList,
 		IsPublic:                 newData.IsPublic,
 		Endpoints:                newData.Endpoints,
+		ContextWindow:            newData.ContextWindow,
 		LbStrategy:               newData.LbStrategy,
 		IsEnableForward:          newData.IsEnableForward,
 		ForwardConfig:            newData.ForwardConfig,
 		IsEnableFallback:         newData.IsEnableFallback,
 		FallbackConfig:           newData.FallbackConfig,
+		IsEnableHedge:            newData.IsEnableHedge,
+		HedgeConfig:              newData.HedgeConfig,
+		ResponseCache:            newData.ResponseCache,
+		Network:                  newData.Network,
+		StreamTimeout:            newData.StreamTimeout,
 		Status:                   newData.Status,
 	}
 
@@ -597,6 +628,9 @@ func (s *sModel) GetTargetModel(ctx context.Context, model *model.Model, message
 		logger.Debugf(ctx, "sModel GetTargetModel time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sModel GetTargetModel")
+	defer span.End()
+
 	if !model.IsEnableForward {
 		return model, nil
 	}
@@ -770,6 +804,9 @@ func (s *sModel) GetGroupTargetModel(ctx context.Context, group *model.Group, mo
 		logger.Debugf(ctx, "sModel GetGroupTargetModel time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sModel GetGroupTargetModel")
+	defer span.End()
+
 	if !group.IsEnableForward {
 		return model, nil
 	}
@@ -949,15 +986,15 @@ func (s *sModel) GetGroupTargetModel(ctx context.Context, group *model.Group, mo
 }
 
 // 获取后备模型
-func (s *sModel) GetFallbackModel(ctx context.Context, model *model.Model) (fallbackModel *model.Model, err error) {
+func (s *sModel) GetFallbackModel(ctx context.Context, id string) (fallbackModel *model.Model, err error) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "sModel GetFallbackModel time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if fallbackModel, err = s.GetCacheModel(ctx, model.FallbackConfig.Model); err != nil || fallbackModel == nil {
-		if fallbackModel, err = s.GetModelAndSaveCache(ctx, model.FallbackConfig.Model); err != nil {
+	if fallbackModel, err = s.GetCacheModel(ctx, id); err != nil || fallbackModel == nil {
+		if fallbackModel, err = s.GetModelAndSaveCache(ctx, id); err != nil {
 			logger.Error(ctx, err)
 			return nil, err
 		}
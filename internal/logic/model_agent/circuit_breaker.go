@@ -0,0 +1,190 @@
+package model_agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+// 熔断状态
+const (
+	circuitClosed   = iota // 关闭
+	circuitOpen            // 打开
+	circuitHalfOpen        // 半开
+)
+
+// 半开状态时抢占探测, 同一时间只放行一个探测请求
+// KEYS: [熔断哈希]
+// ARGV: [ID, 当前时间, 探测超时毫秒数]
+const probeScript = `
+local openUntil = tonumber(redis.call('HGET', KEYS[1], ARGV[1] .. '.open_until')) or 0
+local now = tonumber(ARGV[2])
+
+if openUntil == 0 or now < openUntil then
+	return 0
+end
+
+local probeUntil = tonumber(redis.call('HGET', KEYS[1], ARGV[1] .. '.probe_until')) or 0
+if probeUntil > now then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1] .. '.probe_until', tostring(now + tonumber(ARGV[3])))
+
+return 1
+`
+
+// 过滤已熔断的模型代理, 半开状态的模型代理放行一个探测请求
+func (s *sModelAgent) filterCircuitBreakerModelAgents(ctx context.Context, modelAgents []*model.ModelAgent) ([]*model.ModelAgent, *model.ModelAgent) {
+
+	if !isCircuitBreakerOpen() {
+		return modelAgents, nil
+	}
+
+	ids := make([]string, 0, len(modelAgents))
+	for _, modelAgent := range modelAgents {
+		ids = append(ids, modelAgent.Id)
+	}
+
+	states := s.circuitBreakerStates(ctx, upstreamLimitTypeAgent, ids)
+	if len(states) == 0 {
+		return modelAgents, nil
+	}
+
+	var (
+		filterModelAgents = make([]*model.ModelAgent, 0)
+		probe             *model.ModelAgent
+	)
+
+	for _, modelAgent := range modelAgents {
+		switch states[modelAgent.Id] {
+		case circuitClosed:
+			filterModelAgents = append(filterModelAgents, modelAgent)
+		case circuitHalfOpen:
+			if probe == nil && s.tryProbe(ctx, upstreamLimitTypeAgent, modelAgent.Id) {
+				probe = modelAgent
+			}
+		}
+	}
+
+	return filterModelAgents, probe
+}
+
+// 过滤已熔断的模型代理密钥, 半开状态的密钥放行一个探测请求
+func (s *sModelAgent) filterCircuitBreakerKeys(ctx context.Context, keys []*model.Key) ([]*model.Key, *model.Key) {
+
+	if !isCircuitBreakerOpen() {
+		return keys, nil
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.Id)
+	}
+
+	states := s.circuitBreakerStates(ctx, upstreamLimitTypeKey, ids)
+	if len(states) == 0 {
+		return keys, nil
+	}
+
+	var (
+		filterKeys = make([]*model.Key, 0)
+		probe      *model.Key
+	)
+
+	for _, key := range keys {
+		switch states[key.Id] {
+		case circuitClosed:
+			filterKeys = append(filterKeys, key)
+		case circuitHalfOpen:
+			if probe == nil && s.tryProbe(ctx, upstreamLimitTypeKey, key.Id) {
+				probe = key
+			}
+		}
+	}
+
+	return filterKeys, probe
+}
+
+// 获取熔断状态, Redis异常时视为关闭
+func (s *sModelAgent) circuitBreakerStates(ctx context.Context, typ string, ids []string) map[string]int {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent circuitBreakerStates time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	fields := make([]string, 0, len(ids))
+	for _, id := range ids {
+		fields = append(fields, fmt.Sprintf(consts.CIRCUIT_BREAKER_OPEN_UNTIL_FIELD, id))
+	}
+
+	openUntils, err := redis.HMGet(ctx, fmt.Sprintf(consts.CIRCUIT_BREAKER_KEY, typ), fields...)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil
+	}
+
+	states := make(map[string]int)
+	for i, id := range ids {
+		if openUntil := openUntils[i].Int64(); openUntil > now {
+			states[id] = circuitOpen
+		} else if openUntil > 0 {
+			states[id] = circuitHalfOpen
+		}
+	}
+
+	return states
+}
+
+// 抢占半开探测
+func (s *sModelAgent) tryProbe(ctx context.Context, typ, id string) bool {
+
+	reply, err := redis.Eval(ctx, probeScript, []string{fmt.Sprintf(consts.CIRCUIT_BREAKER_KEY, typ)}, id, gtime.TimestampMilli(), probeTimeout())
+	if err != nil {
+		logger.Error(ctx, err)
+		return false
+	}
+
+	if reply.Int() == 1 {
+		logger.Infof(ctx, "sModelAgent tryProbe %s: %s half-open probe", typ, id)
+		return true
+	}
+
+	return false
+}
+
+func isCircuitBreakerOpen() bool {
+	return config.Cfg.CircuitBreaker != nil && config.Cfg.CircuitBreaker.Open && config.Cfg.CircuitBreaker.FailureThreshold > 0
+}
+
+// 熔断时长毫秒数
+func openDuration() int64 {
+
+	if config.Cfg.CircuitBreaker.OpenDuration > 0 {
+		return int64(config.Cfg.CircuitBreaker.OpenDuration * time.Second / time.Millisecond)
+	}
+
+	return 30000
+}
+
+// 半开探测超时毫秒数, 防止探测请求异常退出时无法再次探测
+func probeTimeout() int64 {
+
+	if config.Cfg.CircuitBreaker.ProbeTimeout > 0 {
+		return int64(config.Cfg.CircuitBreaker.ProbeTimeout * time.Second / time.Millisecond)
+	}
+
+	if config.Cfg.Base.LongTimeout > 0 {
+		return int64(config.Cfg.Base.LongTimeout * time.Second / time.Millisecond)
+	}
+
+	return 60000
+}
@@ -0,0 +1,350 @@
+package model_agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/utility/lb"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+// 负载策略
+const (
+	lbStrategyLeastLatency  = 3 // 最低延迟
+	lbStrategyLeastInflight = 4 // 最少并发
+	lbStrategyErrorRate     = 5 // 错误率加权
+)
+
+// 延迟和错误率的指数加权移动平均系数
+const ewmaAlpha = 0.3
+
+// 记录请求结果, 更新延迟、错误率和熔断状态
+// KEYS: [统计哈希, 熔断哈希, 并发有序集合]
+// ARGV: [ID, 并发成员, 延迟, 是否失败, 平滑系数, 连续失败阈值, 熔断毫秒数, 当前时间]
+// 返回: 0:无变化, 1:熔断打开, 2:熔断恢复
+const recordScript = `
+local id = ARGV[1]
+local latency = tonumber(ARGV[3])
+local failure = tonumber(ARGV[4])
+local alpha = tonumber(ARGV[5])
+local threshold = tonumber(ARGV[6])
+local now = tonumber(ARGV[8])
+
+if ARGV[2] ~= '' then
+	redis.call('ZREM', KEYS[3], ARGV[2])
+end
+
+if latency > 0 then
+	local old = tonumber(redis.call('HGET', KEYS[1], id .. '.latency'))
+	if old then
+		latency = alpha * latency + (1 - alpha) * old
+	end
+	redis.call('HSET', KEYS[1], id .. '.latency', tostring(latency))
+end
+
+local rate = tonumber(redis.call('HGET', KEYS[1], id .. '.error_rate')) or 0
+redis.call('HSET', KEYS[1], id .. '.error_rate', tostring(alpha * failure + (1 - alpha) * rate))
+redis.call('PEXPIRE', KEYS[1], 86400000)
+
+if threshold <= 0 then
+	return 0
+end
+
+local openUntil = tonumber(redis.call('HGET', KEYS[2], id .. '.open_until')) or 0
+
+if failure == 1 then
+	local failures = redis.call('HINCRBY', KEYS[2], id .. '.failures', 1)
+	if (openUntil > 0 and now >= openUntil) or (openUntil == 0 and failures >= threshold) then
+		redis.call('HSET', KEYS[2], id .. '.open_until', tostring(now + tonumber(ARGV[7])))
+		redis.call('HDEL', KEYS[2], id .. '.probe_until')
+		return 1
+	end
+	return 0
+end
+
+if openUntil == 0 then
+	redis.call('HDEL', KEYS[2], id .. '.failures')
+elseif now >= openUntil then
+	redis.call('HDEL', KEYS[2], id .. '.failures', id .. '.open_until', id .. '.probe_until')
+	return 2
+end
+
+return 0
+`
+
+// 记录并发
+// KEYS: [并发有序集合...]
+// ARGV: [并发成员, 过期时间, 过期毫秒数]
+const acquireScript = `
+for _, key in ipairs(KEYS) do
+	redis.call('ZADD', key, tonumber(ARGV[2]), ARGV[1])
+	redis.call('PEXPIRE', key, tonumber(ARGV[3]))
+end
+return 1
+`
+
+// 清理过期成员后统计并发数
+// KEYS: [并发有序集合...]
+// ARGV: [当前时间]
+const inflightScript = `
+local counts = {}
+for i, key in ipairs(KEYS) do
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[1])
+	counts[i] = redis.call('ZCARD', key)
+end
+return counts
+`
+
+// 记录模型代理和密钥进行中的请求, 返回并发成员
+func (s *sModelAgent) AcquireInflight(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key) string {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent AcquireInflight time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	keys := make([]string, 0)
+
+	if modelAgent != nil && agentLbStrategy(m, group) == lbStrategyLeastInflight {
+		keys = append(keys, fmt.Sprintf(consts.LB_INFLIGHT_KEY, upstreamLimitTypeAgent, modelAgent.Id))
+	}
+
+	if key != nil && key.Id != "" && modelAgent != nil && modelAgent.LbStrategy == lbStrategyLeastInflight {
+		keys = append(keys, fmt.Sprintf(consts.LB_INFLIGHT_KEY, upstreamLimitTypeKey, key.Id))
+	}
+
+	if len(keys) == 0 {
+		return ""
+	}
+
+	member := grand.S(32)
+	expire := inflightExpire()
+
+	// 模型代理和密钥的Key类型不同, 分开执行以兼容集群模式
+	for _, k := range keys {
+		if _, err := redis.Eval(ctx, acquireScript, []string{k}, member, now+expire, expire); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	return member
+}
+
+// 记录请求结果, 用于负载策略和熔断
+func (s *sModelAgent) RecordResult(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key, member string, latency int64, isFailure bool) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent RecordResult time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	isBreaker := isCircuitBreakerOpen()
+
+	if modelAgent != nil && (isBreaker || agentLbStrategy(m, group) >= lbStrategyLeastLatency) {
+		s.recordResult(ctx, upstreamLimitTypeAgent, modelAgent.Id, modelAgent.Name, member, latency, isFailure, isBreaker)
+	}
+
+	if key != nil && key.Id != "" && modelAgent != nil && (isBreaker || modelAgent.LbStrategy >= lbStrategyLeastLatency) {
+		s.recordResult(ctx, upstreamLimitTypeKey, key.Id, key.Id, member, latency, isFailure, isBreaker)
+	}
+}
+
+func (s *sModelAgent) recordResult(ctx context.Context, typ, id, name, member string, latency int64, isFailure, isBreaker bool) {
+
+	var (
+		failure   = 0
+		threshold = 0
+		duration  = int64(0)
+	)
+
+	if isFailure {
+		failure = 1
+		latency = 0
+	}
+
+	if isBreaker {
+		threshold = config.Cfg.CircuitBreaker.FailureThreshold
+		duration = openDuration()
+	}
+
+	keys := []string{
+		fmt.Sprintf(consts.LB_STATS_KEY, typ),
+		fmt.Sprintf(consts.CIRCUIT_BREAKER_KEY, typ),
+		fmt.Sprintf(consts.LB_INFLIGHT_KEY, typ, id),
+	}
+
+	reply, err := redis.Eval(ctx, recordScript, keys, id, member, latency, failure, ewmaAlpha, threshold, duration, gtime.TimestampMilli())
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	switch reply.Int() {
+	case 1:
+		logger.Infof(ctx, "sModelAgent recordResult %s: %s circuit breaker open for %dms", typ, name, duration)
+	case 2:
+		logger.Infof(ctx, "sModelAgent recordResult %s: %s circuit breaker closed", typ, name)
+	}
+}
+
+// 按统计类负载策略挑选模型代理, 非统计类策略或统计异常时返回nil
+func (s *sModelAgent) pickModelAgentByStats(ctx context.Context, lbStrategy int, modelAgents []*model.ModelAgent) *model.ModelAgent {
+
+	if lbStrategy < lbStrategyLeastLatency || len(modelAgents) == 0 {
+		return nil
+	}
+
+	if len(modelAgents) == 1 {
+		return modelAgents[0]
+	}
+
+	ids := make([]string, 0, len(modelAgents))
+	weights := make([]int, 0, len(modelAgents))
+	for _, modelAgent := range modelAgents {
+		ids = append(ids, modelAgent.Id)
+		weights = append(weights, modelAgent.Weight)
+	}
+
+	if index := s.pickIndexByStats(ctx, upstreamLimitTypeAgent, lbStrategy, ids, weights); index >= 0 {
+		return modelAgents[index]
+	}
+
+	return nil
+}
+
+// 按统计类负载策略挑选模型代理密钥, 非统计类策略或统计异常时返回nil
+func (s *sModelAgent) pickKeyByStats(ctx context.Context, lbStrategy int, keys []*model.Key) *model.Key {
+
+	if lbStrategy < lbStrategyLeastLatency || len(keys) == 0 {
+		return nil
+	}
+
+	if len(keys) == 1 {
+		return keys[0]
+	}
+
+	ids := make([]string, 0, len(keys))
+	weights := make([]int, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.Id)
+		weights = append(weights, key.Weight)
+	}
+
+	if index := s.pickIndexByStats(ctx, upstreamLimitTypeKey, lbStrategy, ids, weights); index >= 0 {
+		return keys[index]
+	}
+
+	return nil
+}
+
+func (s *sModelAgent) pickIndexByStats(ctx context.Context, typ string, lbStrategy int, ids []string, weights []int) int {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent pickIndexByStats time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	switch lbStrategy {
+	case lbStrategyLeastLatency:
+
+		fields := make([]string, 0, len(ids))
+		for _, id := range ids {
+			fields = append(fields, fmt.Sprintf(consts.LB_LATENCY_FIELD, id))
+		}
+
+		latencies, err := redis.HMGet(ctx, fmt.Sprintf(consts.LB_STATS_KEY, typ), fields...)
+		if err != nil {
+			logger.Error(ctx, err)
+			return -1
+		}
+
+		// 无统计数据的优先, 便于尽快采集
+		values := make([]float64, 0, len(ids))
+		for _, latency := range latencies {
+			values = append(values, latency.Float64())
+		}
+
+		return lb.MinIndex(values)
+
+	case lbStrategyLeastInflight:
+
+		keys := make([]string, 0, len(ids))
+		for _, id := range ids {
+			keys = append(keys, fmt.Sprintf(consts.LB_INFLIGHT_KEY, typ, id))
+		}
+
+		reply, err := redis.Eval(ctx, inflightScript, keys, now)
+		if err != nil {
+			logger.Error(ctx, err)
+			return -1
+		}
+
+		counts := reply.Float64s()
+		if len(counts) != len(ids) {
+			logger.Errorf(ctx, "sModelAgent pickIndexByStats unexpected reply: %s", reply.String())
+			return -1
+		}
+
+		return lb.MinIndex(counts)
+
+	case lbStrategyErrorRate:
+
+		fields := make([]string, 0, len(ids))
+		for _, id := range ids {
+			fields = append(fields, fmt.Sprintf(consts.LB_ERROR_RATE_FIELD, id))
+		}
+
+		errorRates, err := redis.HMGet(ctx, fmt.Sprintf(consts.LB_STATS_KEY, typ), fields...)
+		if err != nil {
+			logger.Error(ctx, err)
+			return -1
+		}
+
+		// 权重按成功率折算, 未设置权重的按1计算
+		values := make([]float64, 0, len(ids))
+		for i, errorRate := range errorRates {
+
+			weight := float64(weights[i])
+			if weight <= 0 {
+				weight = 1
+			}
+
+			values = append(values, weight*max(1-errorRate.Float64(), 0.01))
+		}
+
+		return lb.RandomWeightIndex(values)
+	}
+
+	return -1
+}
+
+// 模型代理负载策略, 启用分组模型代理时使用分组的策略
+func agentLbStrategy(m *model.Model, group *model.Group) int {
+
+	if group != nil && group.IsEnableModelAgent {
+		return group.LbStrategy
+	}
+
+	if m != nil {
+		return m.LbStrategy
+	}
+
+	return 0
+}
+
+// 并发成员过期毫秒数, 防止异常退出时并发无法释放
+func inflightExpire() int64 {
+
+	if config.Cfg.Base.LongTimeout > 0 {
+		return int64(config.Cfg.Base.LongTimeout*time.Second/time.Millisecond) + 60000
+	}
+
+	return 600000
+}
@@ -6,6 +6,7 @@ import (
 	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gtrace"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/iimeta/fastapi/v2/internal/config"
 	"github.com/iimeta/fastapi/v2/internal/consts"
@@ -88,6 +89,8 @@ func (s *sModelAgent) GetById(ctx context.Context, id string) (*model.ModelAgent
 		ResPassthroughParams:     modelAgent.ResPassthroughParams,
 		ResHeaderPassthroughMode: modelAgent.ResHeaderPassthroughMode,
 		ResHeaderPassthroughList: modelAgent.ResHeaderPassthroughList,
+		UpstreamLimit:            modelAgent.UpstreamLimit,
+		Network:                  modelAgent.Network,
 		Status:                   modelAgent.Status,
 	}, nil
 }
@@ -141,6 +144,8 @@ func (s *sModelAgent) List(ctx context.Context, ids []string) ([]*model.ModelAge
 			ResPassthroughParams:     result.ResPassthroughParams,
 			ResHeaderPassthroughMode: result.ResHeaderPassthroughMode,
 			ResHeaderPassthroughList: result.ResHeaderPassthroughList,
+			UpstreamLimit:            result.UpstreamLimit,
+			Network:                  result.Network,
 			Status:                   result.Status,
 		})
 	}
@@ -193,6 +198,8 @@ func (s *sModelAgent) ListAll(ctx context.Context) ([]*model.ModelAgent, error)
 			ResPassthroughParams:     result.ResPassthroughParams,
 			ResHeaderPassthroughMode: result.ResHeaderPassthroughMode,
 			ResHeaderPassthroughList: result.ResHeaderPassthroughList,
+			UpstreamLimit:            result.UpstreamLimit,
+			Network:                  result.Network,
 			Status:                   result.Status,
 		})
 	}
@@ -224,6 +231,7 @@ func (s *sModelAgent) GetKeys(ctx context.Context, id string) ([]*model.Key, err
 			ModelAgents:    result.ModelAgents,
 			IsNeverDisable: result.IsNeverDisable,
 			UsedQuota:      result.UsedQuota,
+			UpstreamLimit:  result.UpstreamLimit,
 			Status:         result.Status,
 		})
 	}
@@ -262,6 +270,7 @@ func (s *sModelAgent) GetModelAgentsAndKeys(ctx context.Context) ([]*model.Model
 			ModelAgents:    result.ModelAgents,
 			IsNeverDisable: result.IsNeverDisable,
 			UsedQuota:      result.UsedQuota,
+			UpstreamLimit:  result.UpstreamLimit,
 			Status:         result.Status,
 		}
 
@@ -281,6 +290,9 @@ func (s *sModelAgent) Pick(ctx context.Context, m *model.Model) (int, *model.Mod
 		logger.Debugf(ctx, "sModelAgent Pick time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sModelAgent Pick")
+	defer span.End()
+
 	var (
 		modelAgents []*model.ModelAgent
 		roundRobin  *lb.RoundRobin
@@ -433,6 +445,23 @@ func (s *sModelAgent) Pick(ctx context.Context, m *model.Model) (int, *model.Mod
 		}
 	}
 
+	// 过滤已达到上游限制的模型代理
+	if filterModelAgentList = s.filterUpstreamLimitedModelAgents(ctx, filterModelAgentList); len(filterModelAgentList) == 0 {
+		return 0, nil, errors.ERR_UPSTREAM_RATE_LIMITED
+	}
+
+	// 过滤已熔断的模型代理, 半开状态的优先探测
+	if availableList, probe := s.filterCircuitBreakerModelAgents(ctx, filterModelAgentList); probe != nil {
+		return len(availableList) + 1, probe, nil
+	} else if filterModelAgentList = availableList; len(filterModelAgentList) == 0 {
+		return 0, nil, errors.ERR_CIRCUIT_BREAKER_OPEN
+	}
+
+	// 负载策略-最低延迟/最少并发/错误率加权
+	if modelAgent := s.pickModelAgentByStats(ctx, m.LbStrategy, filterModelAgentList); modelAgent != nil {
+		return len(filterModelAgentList), modelAgent, nil
+	}
+
 	// 负载策略-权重
 	if m.LbStrategy == 2 {
 		return len(filterModelAgentList), lb.NewModelAgentWeight(filterModelAgentList).PickModelAgent(), nil
@@ -461,6 +490,9 @@ func (s *sModelAgent) PickGroup(ctx context.Context, m *model.Model, group *mode
 		logger.Debugf(ctx, "sModelAgent PickGroup time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sModelAgent PickGroup")
+	defer span.End()
+
 	var (
 		modelAgents []*model.ModelAgent
 		roundRobin  *lb.RoundRobin
@@ -628,6 +660,23 @@ func (s *sModelAgent) PickGroup(ctx context.Context, m *model.Model, group *mode
 		}
 	}
 
+	// 过滤已达到上游限制的模型代理
+	if filterModelAgentList = s.filterUpstreamLimitedModelAgents(ctx, filterModelAgentList); len(filterModelAgentList) == 0 {
+		return 0, nil, errors.ERR_UPSTREAM_RATE_LIMITED
+	}
+
+	// 过滤已熔断的模型代理, 半开状态的优先探测
+	if availableList, probe := s.filterCircuitBreakerModelAgents(ctx, filterModelAgentList); probe != nil {
+		return len(availableList) + 1, probe, nil
+	} else if filterModelAgentList = availableList; len(filterModelAgentList) == 0 {
+		return 0, nil, errors.ERR_CIRCUIT_BREAKER_OPEN
+	}
+
+	// 负载策略-最低延迟/最少并发/错误率加权
+	if modelAgent := s.pickModelAgentByStats(ctx, group.LbStrategy, filterModelAgentList); modelAgent != nil {
+		return len(filterModelAgentList), modelAgent, nil
+	}
+
 	// 负载策略-权重
 	if group.LbStrategy == 2 {
 		return len(filterModelAgentList), lb.NewModelAgentWeight(filterModelAgentList).PickModelAgent(), nil
@@ -695,7 +744,8 @@ func (s *sModelAgent) RecordError(ctx context.Context, m *model.Model, modelAgen
 		logger.Error(ctx, err)
 	}
 
-	if reply >= config.Cfg.Base.ModelAgentErrDisable {
+	// 启用熔断时由熔断处理临时故障, 不再按错误次数禁用
+	if reply >= config.Cfg.Base.ModelAgentErrDisable && !isCircuitBreakerOpen() {
 		s.Disabled(ctx, modelAgent, "Reached the maximum number of errors")
 	}
 }
@@ -718,6 +768,8 @@ func (s *sModelAgent) Disabled(ctx context.Context, modelAgent *model.ModelAgent
 		return
 	}
 
+	service.Metrics().Disabled(ctx, "model_agent", modelAgent.Name)
+
 	modelAgent.Status = 2
 	modelAgent.IsAutoDisabled = true
 	modelAgent.AutoDisabledReason = disabledReason
@@ -741,10 +793,12 @@ func (s *sModelAgent) PickKey(ctx context.Context, modelAgent *model.ModelAgent)
 		logger.Debugf(ctx, "sModelAgent PickKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	_, span := gtrace.NewSpan(ctx, "sModelAgent PickKey")
+	defer span.End()
+
 	var (
-		keys       []*model.Key
-		roundRobin *lb.RoundRobin
-		err        error
+		keys []*model.Key
+		err  error
 	)
 
 	if keysValue := s.modelAgentKeysCache.GetVal(ctx, modelAgent.Id); keysValue != nil {
@@ -803,32 +857,81 @@ func (s *sModelAgent) PickKey(ctx context.Context, modelAgent *model.ModelAgent)
 		return 0, nil, errors.ERR_ALL_MODEL_AGENT_KEY
 	}
 
+	// 过滤已达到上游限制的模型代理密钥
+	if filterKeyList = s.filterUpstreamLimitedKeys(ctx, modelAgent, filterKeyList); len(filterKeyList) == 0 {
+		return 0, nil, errors.ERR_UPSTREAM_RATE_LIMITED
+	}
+
+	// 过滤已熔断的模型代理密钥, 半开状态的优先探测
+	availableList, probe := s.filterCircuitBreakerKeys(ctx, filterKeyList)
+	if probe != nil && s.acquireUpstreamRequest(ctx, modelAgent, probe) {
+		return len(availableList) + 1, probe, nil
+	}
+
+	if filterKeyList = availableList; len(filterKeyList) == 0 {
+		if probe != nil {
+			return 0, nil, errors.ERR_UPSTREAM_RATE_LIMITED
+		}
+		return 0, nil, errors.ERR_CIRCUIT_BREAKER_OPEN
+	}
+
+	for {
+
+		key, err := s.selectKey(ctx, modelAgent, filterKeyList)
+		if err != nil {
+			logger.Error(ctx, err)
+			return 0, nil, err
+		}
+
+		// 并发请求可能同时通过上游限制过滤, 占用失败时换下一个密钥
+		if s.acquireUpstreamRequest(ctx, modelAgent, key) {
+			return len(filterKeyList), key, nil
+		}
+
+		filterKeyList = slices.DeleteFunc(slices.Clone(filterKeyList), func(k *model.Key) bool {
+			return k.Id == key.Id
+		})
+
+		if len(filterKeyList) == 0 {
+			return 0, nil, errors.ERR_UPSTREAM_RATE_LIMITED
+		}
+	}
+}
+
+// 按负载策略选择模型代理密钥
+func (s *sModelAgent) selectKey(ctx context.Context, modelAgent *model.ModelAgent, filterKeyList []*model.Key) (*model.Key, error) {
+
 	if preferredKeyId := service.Session().GetSessionKeepPreferredKey(ctx); preferredKeyId != "" {
 		for _, key := range filterKeyList {
 			if key.Id == preferredKeyId {
-				return len(filterKeyList), key, nil
+				return key, nil
 			}
 		}
 	}
 
+	// 负载策略-最低延迟/最少并发/错误率加权
+	if key := s.pickKeyByStats(ctx, modelAgent.LbStrategy, filterKeyList); key != nil {
+		return key, nil
+	}
+
 	// 负载策略-权重
 	if modelAgent.LbStrategy == 2 {
-		return len(filterKeyList), lb.NewKeyWeight(filterKeyList).PickKey(), nil
+		return lb.NewKeyWeight(filterKeyList).PickKey(), nil
 	}
 
+	var roundRobin *lb.RoundRobin
 	if roundRobinValue := s.modelAgentKeysRoundRobinCache.GetVal(ctx, modelAgent.Id); roundRobinValue != nil {
 		roundRobin = roundRobinValue.(*lb.RoundRobin)
 	}
 
 	if roundRobin == nil {
 		roundRobin = lb.NewRoundRobin()
-		if err = s.modelAgentKeysRoundRobinCache.Set(ctx, modelAgent.Id, roundRobin, 0); err != nil {
-			logger.Error(ctx, err)
-			return 0, nil, err
+		if err := s.modelAgentKeysRoundRobinCache.Set(ctx, modelAgent.Id, roundRobin, 0); err != nil {
+			return nil, err
 		}
 	}
 
-	return len(filterKeyList), filterKeyList[roundRobin.Index(len(filterKeyList))], nil
+	return filterKeyList[roundRobin.Index(len(filterKeyList))], nil
 }
 
 // 移除模型代理密钥
@@ -880,7 +983,8 @@ func (s *sModelAgent) RecordErrorKey(ctx context.Context, modelAgent *model.Mode
 		logger.Error(ctx, err)
 	}
 
-	if reply >= config.Cfg.Base.ModelAgentKeyErrDisable {
+	// 启用熔断时由熔断处理临时故障, 不再按错误次数禁用
+	if reply >= config.Cfg.Base.ModelAgentKeyErrDisable && !isCircuitBreakerOpen() {
 		s.DisabledKey(ctx, key, "Reached the maximum number of errors")
 	}
 }
@@ -903,6 +1007,8 @@ func (s *sModelAgent) DisabledKey(ctx context.Context, key *model.Key, disabledR
 		return
 	}
 
+	service.Metrics().Disabled(ctx, "key", key.Id)
+
 	s.UpdateCacheKey(ctx, nil, &entity.Key{
 		Id:                 key.Id,
 		ProviderId:         key.ProviderId,
@@ -911,6 +1017,7 @@ func (s *sModelAgent) DisabledKey(ctx context.Context, key *model.Key, disabledR
 		ModelAgents:        key.ModelAgents,
 		IsNeverDisable:     key.IsNeverDisable,
 		UsedQuota:          key.UsedQuota,
+		UpstreamLimit:      key.UpstreamLimit,
 		Status:             2,
 		IsAutoDisabled:     true,
 		AutoDisabledReason: disabledReason,
@@ -1303,6 +1410,7 @@ func (s *sModelAgent) CreateCacheKey(ctx context.Context, key *entity.Key) {
 		ModelAgents:    key.ModelAgents,
 		IsNeverDisable: key.IsNeverDisable,
 		UsedQuota:      key.UsedQuota,
+		UpstreamLimit:  key.UpstreamLimit,
 		Status:         key.Status,
 	}
 
@@ -1336,6 +1444,7 @@ func (s *sModelAgent) UpdateCacheKey(ctx context.Context, oldData *entity.Key, n
 		ModelAgents:        newData.ModelAgents,
 		IsNeverDisable:     newData.IsNeverDisable,
 		UsedQuota:          newData.UsedQuota,
+		UpstreamLimit:      newData.UpstreamLimit,
 		Status:             newData.Status,
 		IsAutoDisabled:     newData.IsAutoDisabled,
 		AutoDisabledReason: newData.AutoDisabledReason,
@@ -1509,15 +1618,15 @@ func (s *sModelAgent) SaveCache(ctx context.Context, modelAgent *model.ModelAgen
 }
 
 // 获取后备模型代理
-func (s *sModelAgent) GetFallback(ctx context.Context, model *model.Model) (fallbackModelAgent *model.ModelAgent, err error) {
+func (s *sModelAgent) GetFallback(ctx context.Context, id string) (fallbackModelAgent *model.ModelAgent, err error) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "sModelAgent GetFallback time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if fallbackModelAgent, err = s.GetCache(ctx, model.FallbackConfig.ModelAgent); err != nil || fallbackModelAgent == nil {
-		if fallbackModelAgent, err = s.GetAndSaveCache(ctx, model.FallbackConfig.ModelAgent); err != nil {
+	if fallbackModelAgent, err = s.GetCache(ctx, id); err != nil || fallbackModelAgent == nil {
+		if fallbackModelAgent, err = s.GetAndSaveCache(ctx, id); err != nil {
 			logger.Error(ctx, err)
 			return nil, err
 		}
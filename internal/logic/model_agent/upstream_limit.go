@@ -0,0 +1,419 @@
+package model_agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+const (
+	upstreamLimitTypeAgent = "agent"
+	upstreamLimitTypeKey   = "key"
+)
+
+// 检查上游分钟用量和每日额度, 全部通过后才会占用请求数
+// KEYS: [分钟用量, 每日额度]
+// ARGV: [RPM字段, TPM字段, ID, rpm, tpm, 每日额度]
+// 返回: 0:通过, 1:RPM, 2:TPM, 3:每日额度
+const upstreamAcquireScript = `
+local rpm = tonumber(ARGV[4])
+local tpm = tonumber(ARGV[5])
+local dailyQuota = tonumber(ARGV[6])
+
+if rpm > 0 and (tonumber(redis.call('HGET', KEYS[1], ARGV[1])) or 0) >= rpm then
+	return 1
+end
+
+if tpm > 0 and (tonumber(redis.call('HGET', KEYS[1], ARGV[2])) or 0) >= tpm then
+	return 2
+end
+
+if dailyQuota > 0 and (tonumber(redis.call('HGET', KEYS[2], ARGV[3])) or 0) >= dailyQuota then
+	return 3
+end
+
+if rpm > 0 then
+	redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+	redis.call('EXPIRE', KEYS[1], 120)
+end
+
+return 0
+`
+
+// 上游限流响应头[剩余, 重置]
+var upstreamRateLimitHeaders = [][2]string{
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+	{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+}
+
+// 过滤已达到上游限制的模型代理
+func (s *sModelAgent) filterUpstreamLimitedModelAgents(ctx context.Context, modelAgents []*model.ModelAgent) []*model.ModelAgent {
+
+	limits := make(map[string]*common.UpstreamLimit)
+	for _, modelAgent := range modelAgents {
+		if modelAgent.UpstreamLimit != nil {
+			limits[modelAgent.Id] = modelAgent.UpstreamLimit
+		}
+	}
+
+	if len(limits) == 0 {
+		return modelAgents
+	}
+
+	limited := s.upstreamLimited(ctx, upstreamLimitTypeAgent, limits)
+	if len(limited) == 0 {
+		return modelAgents
+	}
+
+	filterModelAgents := make([]*model.ModelAgent, 0)
+	for _, modelAgent := range modelAgents {
+		if !limited[modelAgent.Id] {
+			filterModelAgents = append(filterModelAgents, modelAgent)
+		}
+	}
+
+	return filterModelAgents
+}
+
+// 过滤已达到上游限制的模型代理密钥
+func (s *sModelAgent) filterUpstreamLimitedKeys(ctx context.Context, modelAgent *model.ModelAgent, keys []*model.Key) []*model.Key {
+
+	isAdaptive := modelAgent.UpstreamLimit != nil && modelAgent.UpstreamLimit.IsAdaptive
+
+	limits := make(map[string]*common.UpstreamLimit)
+	for _, key := range keys {
+		if key.UpstreamLimit != nil {
+			limits[key.Id] = key.UpstreamLimit
+		} else if isAdaptive {
+			// 仅根据上游响应头自适应
+			limits[key.Id] = &common.UpstreamLimit{IsAdaptive: true}
+		}
+	}
+
+	if len(limits) == 0 {
+		return keys
+	}
+
+	limited := s.upstreamLimited(ctx, upstreamLimitTypeKey, limits)
+	if len(limited) == 0 {
+		return keys
+	}
+
+	filterKeys := make([]*model.Key, 0)
+	for _, key := range keys {
+		if !limited[key.Id] {
+			filterKeys = append(filterKeys, key)
+		}
+	}
+
+	return filterKeys
+}
+
+// 获取已达到上游限制的ID, Redis异常时不限制
+func (s *sModelAgent) upstreamLimited(ctx context.Context, typ string, limits map[string]*common.UpstreamLimit) map[string]bool {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent upstreamLimited time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	var (
+		ids           = make([]string, 0, len(limits))
+		usageFields   = make([]string, 0, len(limits)*2)
+		hasUsage      bool
+		hasDailyQuota bool
+		hasAdaptive   bool
+	)
+
+	for id, limit := range limits {
+
+		ids = append(ids, id)
+		usageFields = append(usageFields, fmt.Sprintf(consts.UPSTREAM_LIMIT_RPM_FIELD, id), fmt.Sprintf(consts.UPSTREAM_LIMIT_TPM_FIELD, id))
+
+		if limit.Rpm > 0 || limit.Tpm > 0 {
+			hasUsage = true
+		}
+
+		if limit.DailyQuota > 0 {
+			hasDailyQuota = true
+		}
+
+		if limit.IsAdaptive {
+			hasAdaptive = true
+		}
+	}
+
+	limited := make(map[string]bool)
+
+	if hasUsage {
+
+		usages, err := redis.HMGet(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_USAGE_KEY, typ, now/60000), usageFields...)
+		if err != nil {
+			logger.Error(ctx, err)
+			return nil
+		}
+
+		for i, id := range ids {
+			if limit := limits[id]; (limit.Rpm > 0 && usages[i*2].Int() >= limit.Rpm) || (limit.Tpm > 0 && usages[i*2+1].Int() >= limit.Tpm) {
+				limited[id] = true
+			}
+		}
+	}
+
+	if hasDailyQuota {
+
+		spends, err := redis.HMGet(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_SPEND_KEY, typ, gtime.Now().Format("Ymd")), ids...)
+		if err != nil {
+			logger.Error(ctx, err)
+			return nil
+		}
+
+		for i, id := range ids {
+			if limit := limits[id]; limit.DailyQuota > 0 && spends[i].Int() >= limit.DailyQuota {
+				limited[id] = true
+			}
+		}
+	}
+
+	if hasAdaptive {
+
+		exhausteds, err := redis.HMGet(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_EXHAUSTED_KEY, typ), ids...)
+		if err != nil {
+			logger.Error(ctx, err)
+			return nil
+		}
+
+		expired := make([]string, 0)
+		for i, id := range ids {
+			if until := exhausteds[i].Int64(); until > now {
+				limited[id] = true
+			} else if until > 0 {
+				expired = append(expired, id)
+			}
+		}
+
+		// 清理已过重置时间的标记
+		if len(expired) > 0 {
+			if _, err = redis.HDel(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_EXHAUSTED_KEY, typ), expired...); err != nil {
+				logger.Error(ctx, err)
+			}
+		}
+	}
+
+	return limited
+}
+
+// 占用上游请求数, 模型代理和密钥均通过才会占用, Redis异常时不限制
+func (s *sModelAgent) acquireUpstreamRequest(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key) bool {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent acquireUpstreamRequest time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if !s.acquireUpstream(ctx, upstreamLimitTypeAgent, modelAgent.Id, modelAgent.UpstreamLimit) {
+		return false
+	}
+
+	if key != nil && !s.acquireUpstream(ctx, upstreamLimitTypeKey, key.Id, key.UpstreamLimit) {
+		// 回滚模型代理已占用的请求数
+		if modelAgent.UpstreamLimit != nil && modelAgent.UpstreamLimit.Rpm > 0 {
+			s.incrUpstreamUsage(ctx, upstreamLimitTypeAgent, consts.UPSTREAM_LIMIT_RPM_FIELD, modelAgent.Id, -1)
+		}
+		return false
+	}
+
+	return true
+}
+
+// 原子检查并占用单个对象的上游请求数
+func (s *sModelAgent) acquireUpstream(ctx context.Context, typ, id string, limit *common.UpstreamLimit) bool {
+
+	if limit == nil || (limit.Rpm <= 0 && limit.Tpm <= 0 && limit.DailyQuota <= 0) {
+		return true
+	}
+
+	keys := []string{
+		fmt.Sprintf(consts.UPSTREAM_LIMIT_USAGE_KEY, typ, gtime.TimestampMilli()/60000),
+		fmt.Sprintf(consts.UPSTREAM_LIMIT_SPEND_KEY, typ, gtime.Now().Format("Ymd")),
+	}
+
+	reply, err := redis.Eval(ctx, upstreamAcquireScript, keys, fmt.Sprintf(consts.UPSTREAM_LIMIT_RPM_FIELD, id), fmt.Sprintf(consts.UPSTREAM_LIMIT_TPM_FIELD, id), id, limit.Rpm, limit.Tpm, limit.DailyQuota)
+	if err != nil {
+		logger.Error(ctx, err)
+		return true
+	}
+
+	if result := reply.Int(); result != 0 {
+		logger.Infof(ctx, "sModelAgent acquireUpstream %s: %s limited, result: %d", typ, id, result)
+		return false
+	}
+
+	return true
+}
+
+// 记录上游用量
+func (s *sModelAgent) RecordUpstreamUsage(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, totalTokens, spendQuota int) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sModelAgent RecordUpstreamUsage time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if modelAgent != nil && modelAgent.UpstreamLimit != nil {
+
+		if modelAgent.UpstreamLimit.Tpm > 0 && totalTokens > 0 {
+			s.incrUpstreamUsage(ctx, upstreamLimitTypeAgent, consts.UPSTREAM_LIMIT_TPM_FIELD, modelAgent.Id, totalTokens)
+		}
+
+		if modelAgent.UpstreamLimit.DailyQuota > 0 && spendQuota > 0 {
+			s.incrUpstreamSpend(ctx, upstreamLimitTypeAgent, modelAgent.Id, spendQuota)
+		}
+	}
+
+	if key != nil && key.UpstreamLimit != nil {
+
+		if key.UpstreamLimit.Tpm > 0 && totalTokens > 0 {
+			s.incrUpstreamUsage(ctx, upstreamLimitTypeKey, consts.UPSTREAM_LIMIT_TPM_FIELD, key.Id, totalTokens)
+		}
+
+		if key.UpstreamLimit.DailyQuota > 0 && spendQuota > 0 {
+			s.incrUpstreamSpend(ctx, upstreamLimitTypeKey, key.Id, spendQuota)
+		}
+	}
+}
+
+// 根据上游响应头记录模型代理和密钥限流状态
+func (s *sModelAgent) RecordUpstreamHeaders(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, header http.Header) {
+
+	if len(header) == 0 || !isUpstreamAdaptive(modelAgent, key) {
+		return
+	}
+
+	if until := upstreamExhaustedUntil(header); until > 0 {
+		s.recordUpstreamExhausted(ctx, modelAgent, key, until)
+	}
+}
+
+// 根据上游限流错误(429)记录模型代理和密钥限流状态, 一分钟后重置
+func (s *sModelAgent) RecordUpstreamRateLimited(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key) {
+	if isUpstreamAdaptive(modelAgent, key) {
+		s.recordUpstreamExhausted(ctx, modelAgent, key, time.Now().Add(time.Minute).UnixMilli())
+	}
+}
+
+// 记录额度耗尽至何时(毫秒), 模型代理自适应时同时记录模型代理和其密钥, 密钥自适应时仅记录密钥
+func (s *sModelAgent) recordUpstreamExhausted(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, until int64) {
+
+	if modelAgent != nil && modelAgent.UpstreamLimit != nil && modelAgent.UpstreamLimit.IsAdaptive {
+
+		logger.Infof(ctx, "sModelAgent recordUpstreamExhausted modelAgent: %s exhausted until: %s", modelAgent.Id, gtime.NewFromTimeStamp(until).String())
+
+		if _, err := redis.HSetStrAny(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_EXHAUSTED_KEY, upstreamLimitTypeAgent), modelAgent.Id, until); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	if key != nil {
+
+		logger.Infof(ctx, "sModelAgent recordUpstreamExhausted key: %s exhausted until: %s", key.Id, gtime.NewFromTimeStamp(until).String())
+
+		if _, err := redis.HSetStrAny(ctx, fmt.Sprintf(consts.UPSTREAM_LIMIT_EXHAUSTED_KEY, upstreamLimitTypeKey), key.Id, until); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 累加上游分钟用量
+func (s *sModelAgent) incrUpstreamUsage(ctx context.Context, typ, field, id string, increment int) {
+
+	usageKey := fmt.Sprintf(consts.UPSTREAM_LIMIT_USAGE_KEY, typ, gtime.TimestampMilli()/60000)
+
+	reply, err := redis.HIncrBy(ctx, usageKey, fmt.Sprintf(field, id), int64(increment))
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	if reply == int64(increment) {
+		if _, err = redis.Expire(ctx, usageKey, 120); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 累加上游每日额度
+func (s *sModelAgent) incrUpstreamSpend(ctx context.Context, typ, id string, increment int) {
+
+	now := gtime.Now()
+	spendKey := fmt.Sprintf(consts.UPSTREAM_LIMIT_SPEND_KEY, typ, now.Format("Ymd"))
+
+	reply, err := redis.HIncrBy(ctx, spendKey, id, int64(increment))
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	if reply == int64(increment) {
+		if _, err = redis.ExpireAt(ctx, spendKey, now.EndOfDay().Add(time.Hour).Time); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 解析上游响应头, 返回额度耗尽至何时(毫秒), 未耗尽返回0
+func upstreamExhaustedUntil(header http.Header) int64 {
+
+	var (
+		now   = time.Now()
+		until int64
+	)
+
+	for _, h := range upstreamRateLimitHeaders {
+
+		remaining := header.Get(h[0])
+		if remaining == "" || gconv.Int(remaining) > 0 {
+			continue
+		}
+
+		// 默认一分钟后重置
+		resetAt := now.Add(time.Minute).UnixMilli()
+
+		if reset := header.Get(h[1]); reset != "" {
+			if d, err := time.ParseDuration(reset); err == nil {
+				resetAt = now.Add(d).UnixMilli()
+			} else if t, err := time.Parse(time.RFC3339, reset); err == nil {
+				resetAt = t.UnixMilli()
+			}
+		}
+
+		if resetAt > until {
+			until = resetAt
+		}
+	}
+
+	if until <= now.UnixMilli() {
+		return 0
+	}
+
+	return until
+}
+
+// 模型代理或密钥是否根据上游响应自适应限流
+func isUpstreamAdaptive(modelAgent *model.ModelAgent, key *model.Key) bool {
+	return (modelAgent != nil && modelAgent.UpstreamLimit != nil && modelAgent.UpstreamLimit.IsAdaptive) ||
+		(key != nil && key.UpstreamLimit != nil && key.UpstreamLimit.IsAdaptive)
+}
@@ -2,11 +2,7 @@ package moderation
 
 import (
 	"context"
-	"slices"
 
-	"github.com/gogf/gf/v2/frame/g"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
@@ -36,139 +32,47 @@ func (s *sModeration) Moderations(ctx context.Context, params smodel.ModerationR
 		logger.Debugf(ctx, "sModeration Moderations time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_MODERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				response.Model = mak.ReqModel.Model
-			}
-
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					ModerationReq: params,
-					Action:        consts.ACTION_MODERATIONS,
-					Usage:         response.Usage,
-					Error:         err,
-					RetryInfo:     retryInfo,
-					TotalTime:     response.TotalTime,
-					InternalTime:  internalTime,
-					EnterTime:     enterTime,
-				}
-
-				if response.Results != nil {
-					afterHandler.Completion = gconv.String(response.Results)
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.ModerationResponse]{
+		Name: "sModeration Moderations",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_MODERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (smodel.ModerationResponse, error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
+			mak := attempt.Mak
 
-	request := params
+			request := params
+			request.Model = attempt.ReplaceModel(ctx, request.Model)
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sModeration Moderations request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
-			}
-		}
-	}
-
-	response, err = common.NewModerationClient(ctx, mak.RealModel, mak.RealKey, mak.BaseUrl, mak.Path, mak.Passthrough).TextModerations(ctx, request)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			return common.NewModerationClient(ctx, mak).TextModerations(ctx, request)
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *smodel.ModerationResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
 			}
-		}
-
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Moderations(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Moderations(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.ModerationResponse, err error) *mcommon.AfterHandler {
+
+			after := &mcommon.AfterHandler{
+				ModerationReq: params,
+				Action:        consts.ACTION_MODERATIONS,
+				Usage:         res.Usage,
+				TotalTime:     res.TotalTime,
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			if res.Results != nil {
+				after.Completion = gconv.String(res.Results)
 			}
 
-			return s.Moderations(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+			return after
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
@@ -0,0 +1,390 @@
+package openai
+
+import (
+	"strings"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+)
+
+// 生成 Responses 对象ID, 如: resp_xxx, msg_xxx, fc_xxx
+func newId(prefix string) string {
+	return prefix + "_" + grand.S(48)
+}
+
+// 输入统一为输入项, 字符串输入转换为用户消息, 未带ID的输入项补全ID
+func inputItems(input any) []map[string]any {
+
+	if text, ok := input.(string); ok {
+		return []map[string]any{{
+			"id":      newId("msg"),
+			"type":    "message",
+			"role":    sconsts.ROLE_USER,
+			"content": []any{g.Map{"type": "input_text", "text": text}},
+		}}
+	}
+
+	items := gconv.Maps(input)
+
+	for _, item := range items {
+
+		if item["type"] == nil {
+			item["type"] = "message"
+		}
+
+		if item["id"] == nil {
+			switch item["type"] {
+			case "message":
+				item["id"] = newId("msg")
+			case "function_call":
+				item["id"] = newId("fc")
+			case "function_call_output":
+				item["id"] = newId("fco")
+			default:
+				item["id"] = newId("item")
+			}
+		}
+	}
+
+	return items
+}
+
+// 消息内容的文本部分按顺序拼接
+func contentText(content any) string {
+
+	if text, ok := content.(string); ok {
+		return text
+	}
+
+	texts := make([]string, 0)
+	for _, part := range gconv.Maps(content) {
+		switch part["type"] {
+		case "input_text", "output_text", "text":
+			texts = append(texts, gconv.String(part["text"]))
+		case "refusal":
+			texts = append(texts, gconv.String(part["refusal"]))
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// 用户消息内容转换为 Chat Completions 多模态内容
+func userContent(content any) any {
+
+	if text, ok := content.(string); ok {
+		return text
+	}
+
+	contents := make([]any, 0)
+	for _, part := range gconv.Maps(content) {
+		switch part["type"] {
+		case "input_text", "text":
+			contents = append(contents, g.Map{"type": "text", "text": part["text"]})
+		case "input_image":
+			if imageUrl := gconv.String(part["image_url"]); imageUrl != "" {
+				contents = append(contents, g.Map{"type": "image_url", "image_url": g.Map{"url": imageUrl, "detail": part["detail"]}})
+			}
+		}
+	}
+
+	return contents
+}
+
+// Responses 输入项转换为 Chat Completions 消息, 连续的助手消息和工具调用合并为一条助手消息
+func convInputToMessages(instructions string, items []map[string]any) []any {
+
+	var (
+		messages  = make([]any, 0)
+		assistant g.Map
+	)
+
+	if instructions != "" {
+		messages = append(messages, g.Map{"role": sconsts.ROLE_SYSTEM, "content": instructions})
+	}
+
+	for _, item := range items {
+		switch item["type"] {
+		case "message":
+
+			role := gconv.String(item["role"])
+
+			if role == sconsts.ROLE_ASSISTANT {
+
+				if assistant == nil {
+					assistant = g.Map{"role": sconsts.ROLE_ASSISTANT, "content": ""}
+					messages = append(messages, assistant)
+				}
+
+				assistant["content"] = gconv.String(assistant["content"]) + contentText(item["content"])
+
+				continue
+			}
+
+			assistant = nil
+
+			if role == "developer" {
+				role = sconsts.ROLE_SYSTEM
+			}
+
+			if role == sconsts.ROLE_SYSTEM {
+				messages = append(messages, g.Map{"role": role, "content": contentText(item["content"])})
+			} else {
+				messages = append(messages, g.Map{"role": role, "content": userContent(item["content"])})
+			}
+
+		case "function_call":
+
+			if assistant == nil {
+				assistant = g.Map{"role": sconsts.ROLE_ASSISTANT, "content": ""}
+				messages = append(messages, assistant)
+			}
+
+			toolCalls, _ := assistant["tool_calls"].([]any)
+			assistant["tool_calls"] = append(toolCalls, g.Map{
+				"id":   item["call_id"],
+				"type": "function",
+				"function": g.Map{
+					"name":      item["name"],
+					"arguments": item["arguments"],
+				},
+			})
+
+		case "function_call_output":
+
+			assistant = nil
+
+			output := item["output"]
+			if _, ok := output.(string); !ok {
+				output = contentText(output)
+			}
+
+			messages = append(messages, g.Map{
+				"role":         sconsts.ROLE_TOOL,
+				"tool_call_id": item["call_id"],
+				"content":      output,
+			})
+		}
+	}
+
+	return messages
+}
+
+// Responses 请求转换为 Chat Completions 请求, 输入项已含上一个响应的上下文
+func convResponsesToChatCompletions(request map[string]any, items []map[string]any) map[string]any {
+
+	chatCompletionRequest := g.Map{
+		"model":    request["model"],
+		"messages": convInputToMessages(gconv.String(request["instructions"]), items),
+	}
+
+	if request["max_output_tokens"] != nil {
+		chatCompletionRequest["max_tokens"] = request["max_output_tokens"]
+	}
+
+	for _, key := range []string{"temperature", "top_p", "user", "parallel_tool_calls", "service_tier"} {
+		if request[key] != nil {
+			chatCompletionRequest[key] = request[key]
+		}
+	}
+
+	if gconv.Bool(request["stream"]) {
+		chatCompletionRequest["stream"] = true
+		chatCompletionRequest["stream_options"] = g.Map{"include_usage": true}
+	}
+
+	if effort := gconv.String(gconv.Map(request["reasoning"])["effort"]); effort != "" {
+		chatCompletionRequest["reasoning_effort"] = effort
+	}
+
+	if format := gconv.Map(gconv.Map(request["text"])["format"]); format != nil {
+		switch format["type"] {
+		case "json_object":
+			chatCompletionRequest["response_format"] = g.Map{"type": "json_object"}
+		case "json_schema":
+			chatCompletionRequest["response_format"] = g.Map{
+				"type": "json_schema",
+				"json_schema": g.Map{
+					"name":        format["name"],
+					"description": format["description"],
+					"schema":      format["schema"],
+					"strict":      format["strict"],
+				},
+			}
+		}
+	}
+
+	// 仅转换函数工具, 内置工具(web_search、file_search 等)上游无法执行
+	tools := make([]any, 0)
+	for _, tool := range gconv.Maps(request["tools"]) {
+		if tool["type"] == "function" {
+			tools = append(tools, g.Map{
+				"type": "function",
+				"function": g.Map{
+					"name":        tool["name"],
+					"description": tool["description"],
+					"parameters":  tool["parameters"],
+					"strict":      tool["strict"],
+				},
+			})
+		}
+	}
+
+	if len(tools) > 0 {
+		chatCompletionRequest["tools"] = tools
+	}
+
+	switch toolChoice := request["tool_choice"].(type) {
+	case string:
+		chatCompletionRequest["tool_choice"] = toolChoice
+	case map[string]any:
+		if toolChoice["type"] == "function" {
+			chatCompletionRequest["tool_choice"] = g.Map{"type": "function", "function": g.Map{"name": toolChoice["name"]}}
+		}
+	}
+
+	return chatCompletionRequest
+}
+
+// Chat Completions 用量转换为 Responses 用量
+func convUsage(usage map[string]any) map[string]any {
+
+	if usage == nil {
+		return nil
+	}
+
+	cachedTokens := gconv.Int(gconv.Map(usage["prompt_tokens_details"])["cached_tokens"])
+	if cachedTokens == 0 {
+		// DeepSeek
+		cachedTokens = gconv.Int(usage["prompt_cache_hit_tokens"])
+	}
+
+	var (
+		inputTokens  = gconv.Int(usage["prompt_tokens"])
+		outputTokens = gconv.Int(usage["completion_tokens"])
+		totalTokens  = gconv.Int(usage["total_tokens"])
+	)
+
+	if totalTokens == 0 {
+		totalTokens = inputTokens + outputTokens
+	}
+
+	return g.Map{
+		"input_tokens":          inputTokens,
+		"input_tokens_details":  g.Map{"cached_tokens": cachedTokens},
+		"output_tokens":         outputTokens,
+		"output_tokens_details": g.Map{"reasoning_tokens": gconv.Int(gconv.Map(usage["completion_tokens_details"])["reasoning_tokens"])},
+		"total_tokens":          totalTokens,
+	}
+}
+
+// 结束原因转换为响应状态和未完成原因
+func responseStatus(finishReason string) (string, map[string]any) {
+	switch finishReason {
+	case "length":
+		return "incomplete", g.Map{"reason": "max_output_tokens"}
+	case "content_filter":
+		return "incomplete", g.Map{"reason": "content_filter"}
+	default:
+		return "completed", nil
+	}
+}
+
+// 构建 Responses 响应对象, 请求参数按原样回显
+func responseObject(request map[string]any, id string, createdAt int64, status string, incompleteDetails map[string]any, output []map[string]any, usage map[string]any, isStore bool) map[string]any {
+
+	response := g.Map{
+		"id":                   id,
+		"object":               "response",
+		"created_at":           createdAt,
+		"status":               status,
+		"error":                nil,
+		"incomplete_details":   incompleteDetails,
+		"instructions":         request["instructions"],
+		"max_output_tokens":    request["max_output_tokens"],
+		"model":                request["model"],
+		"output":               output,
+		"parallel_tool_calls":  gconv.Bool(g.NewVar(request["parallel_tool_calls"]).Default(true)),
+		"previous_response_id": request["previous_response_id"],
+		"reasoning":            g.Map{"effort": gconv.Map(request["reasoning"])["effort"], "summary": nil},
+		"service_tier":         request["service_tier"],
+		"store":                isStore,
+		"temperature":          request["temperature"],
+		"text":                 g.Map{"format": g.Map{"type": "text"}},
+		"tool_choice":          g.NewVar(request["tool_choice"]).Default("auto").Val(),
+		"tools":                g.NewVar(request["tools"]).Default([]any{}).Val(),
+		"top_p":                request["top_p"],
+		"truncation":           "disabled",
+		"usage":                usage,
+		"user":                 request["user"],
+		"metadata":             g.NewVar(request["metadata"]).Default(g.Map{}).Val(),
+	}
+
+	if text := gconv.Map(request["text"]); text["format"] != nil {
+		response["text"] = text
+	}
+
+	return response
+}
+
+// 推理内容转换为推理输出项
+func reasoningItem(id, text string) map[string]any {
+	return g.Map{"id": id, "type": "reasoning", "summary": []any{g.Map{"type": "summary_text", "text": text}}}
+}
+
+// 文本转换为消息输出项
+func messageItem(id, text string) map[string]any {
+	return g.Map{
+		"id":      id,
+		"type":    "message",
+		"status":  "completed",
+		"role":    sconsts.ROLE_ASSISTANT,
+		"content": []any{g.Map{"type": "output_text", "text": text, "annotations": []any{}}},
+	}
+}
+
+// 工具调用转换为函数调用输出项
+func functionCallItem(id, callId, name, arguments string) map[string]any {
+	return g.Map{"id": id, "type": "function_call", "status": "completed", "call_id": callId, "name": name, "arguments": arguments}
+}
+
+// Chat Completions 响应转换为 Responses 输出项和状态
+func convChatCompletionsToOutput(response map[string]any) (output []map[string]any, status string, incompleteDetails map[string]any) {
+
+	output = make([]map[string]any, 0)
+
+	choices := gconv.Maps(response["choices"])
+	if len(choices) == 0 {
+		return output, "completed", nil
+	}
+
+	message := gconv.Map(choices[0]["message"])
+
+	if reasoningContent := gconv.String(message["reasoning_content"]); reasoningContent != "" {
+		output = append(output, reasoningItem(newId("rs"), reasoningContent))
+	}
+
+	if content := gconv.String(message["content"]); content != "" {
+		output = append(output, messageItem(newId("msg"), content))
+	}
+
+	for _, toolCall := range gconv.Maps(message["tool_calls"]) {
+		function := gconv.Map(toolCall["function"])
+		output = append(output, functionCallItem(newId("fc"), gconv.String(toolCall["id"]), gconv.String(function["name"]), gconv.String(function["arguments"])))
+	}
+
+	status, incompleteDetails = responseStatus(gconv.String(choices[0]["finish_reason"]))
+
+	return output, status, incompleteDetails
+}
+
+// 请求体中的输入项
+func requestInput(body []byte) (map[string]any, []map[string]any) {
+
+	request := gjson.New(body).Map()
+
+	return request, inputItems(request["input"])
+}
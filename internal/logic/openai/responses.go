@@ -5,13 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"slices"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
-	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
-	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
@@ -43,391 +39,366 @@ func (s *sOpenAI) Responses(ctx context.Context, request *ghttp.Request, isChatC
 		logger.Debugf(ctx, "sOpenAI Responses time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		params = common.ConvResponsesToChatCompletionsRequest(request, isChatCompletions)
-		mak    = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           responsesEndpoint(isChatCompletions),
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
+	// 渲染提示词模板
+	data, err := service.PromptTemplate().RenderBody(ctx, request.GetBody(), !isChatCompletions)
+	if err != nil {
+		return response, err
+	}
+
+	pipeline := responsesPipeline(ctx, data, isChatCompletions, "sOpenAI Responses", responsesEndpoint(isChatCompletions), consts.ACTION_RESPONSES,
+		func(ctx context.Context, mak *common.MAK, body []byte) (smodel.OpenAIResponsesRes, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, mak, "Responses")
+			response, err := common.NewAdapterOpenAI(upstreamCtx, mak, false).Responses(upstreamCtx, body)
+			common.EndSpan(upstreamSpan, err)
+			return response, err
+		})
+
+	// 由 Chat Completions 转入时转换为 Chat Completions 响应
+	if response, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); isChatCompletions {
+		response.ResponseBytes = gjson.MustEncode(common.ConvResponsesToChatCompletionsResponse(ctx, response))
+	}
 
+	return response, err
+}
+
+// ResponsesStream
+func (s *sOpenAI) ResponsesStream(ctx context.Context, request *ghttp.Request, isChatCompletions bool, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (err error) {
+
+	now := gtime.TimestampMilli()
 	defer func() {
+		logger.Debugf(ctx, "sOpenAI ResponsesStream time: %d", gtime.TimestampMilli()-now)
+	}()
 
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-		chatCompletionResponse := common.ConvResponsesToChatCompletionsResponse(ctx, response)
+	// 渲染提示词模板
+	data, err := service.PromptTemplate().RenderBody(ctx, request.GetBody(), !isChatCompletions)
+	if err != nil {
+		return err
+	}
 
-		if isChatCompletions {
-			response.ResponseBytes = gjson.MustEncode(chatCompletionResponse)
-		}
+	var (
+		params      = common.ConvResponsesToChatCompletionsRequest(ctx, data, isChatCompletions)
+		reqModel    = params.Model
+		body        []byte
+		completion  string
+		serviceTier string
+		connTime    int64
+		duration    int64
+		totalTime   int64
+		usage       *smodel.Usage
+		cancel      context.CancelFunc
 
-		if mak.ReqModel != nil && mak.RealModel != nil {
+		native              *nativeResponses
+		chatCompletionsChan chan *smodel.ChatCompletionResponse
+	)
 
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				chatCompletionResponse.Model = mak.ReqModel.Model
+	pipeline := &common.Pipeline[chan *smodel.OpenAIResponsesStreamRes]{
+		Name:        "sOpenAI ResponsesStream",
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           responsesEndpoint(isChatCompletions),
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
 			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: chatCompletionResponse,
-					Action:            consts.ACTION_RESPONSES,
-					Usage:             chatCompletionResponse.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          chatCompletionResponse.ConnTime,
-					Duration:          chatCompletionResponse.Duration,
-					TotalTime:         chatCompletionResponse.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+			if body, err = attempt.TransformBody(ctx, data, reqModel, &params, nil); err != nil {
+				return err
 			}
-		}
-	}()
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
+			if isChatCompletions {
+				body = gjson.MustEncode(common.ConvChatCompletionsToResponsesRequest(ctx, body))
+				return nil
+			}
+
+			// 上游不支持 Responses API, 转换为 Chat Completions
+			if native = nil; isNativeResponses(ctx, attempt.Mak) {
 
-	body := request.GetBody()
+				if native, err = newNativeResponses(ctx, body); err != nil {
+					return err
+				}
 
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sOpenAI Responses params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
+				body = gjson.MustEncode(native.chatCompletionsBody(params.Model))
 
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
+				return nil
+			}
 
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
+			if isResponsesStore() {
+				body, err = expandPreviousResponse(ctx, body)
+			}
+
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (chan *smodel.OpenAIResponsesStreamRes, error) {
+
+			if native != nil {
+
+				chatCompletionRequest := smodel.ChatCompletionRequest{}
+				if err := json.Unmarshal(body, &chatCompletionRequest); err != nil {
 					logger.Error(ctx, err)
-					return response, err
+					return nil, err
 				}
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
+				upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletionsStream")
+				upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+				var err error
+				chatCompletionsChan, err = common.NewAdapter(upstreamCtx, attempt.Mak, true).ChatCompletionsStream(upstreamCtx, chatCompletionRequest)
+				attempt.EndStreamSpan(upstreamSpan, err)
+				if err != nil {
+					cancel()
 				}
+				return nil, err
+			}
 
-				body = gjson.MustEncode(data)
-
-				break
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ResponsesStream")
+			upstreamCtx, cancel = context.WithCancel(upstreamCtx)
+			responseChan, err := common.NewAdapterOpenAI(upstreamCtx, attempt.Mak, true).ResponsesStream(upstreamCtx, body)
+			attempt.EndStreamSpan(upstreamSpan, err)
+			if err != nil {
+				cancel()
 			}
-		}
-	}
+			return responseChan, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseChan chan *smodel.OpenAIResponsesStreamRes) (chan *smodel.OpenAIResponsesStreamRes, error) {
 
-	if isChatCompletions {
-		body = gjson.MustEncode(common.ConvChatCompletionsToResponsesRequest(ctx, body))
-	}
+			mak := attempt.Mak
 
-	response, err = common.NewAdapterOpenAI(ctx, mak, false).Responses(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
+			streamTimeout := common.NewStreamTimeout(mak)
+			guardrail := attempt.Guardrail.Stream()
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			// 转换为 Responses 事件, 结束后由网关存储响应
+			if native != nil {
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+				defer func() {
+					cancel()
+					close(chatCompletionsChan)
+				}()
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				responsesStream := newResponsesStream(native.request, native.responseId, native.createdAt, native.isStore)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				for {
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+					response, err := common.StreamRecv(ctx, streamTimeout, chatCompletionsChan)
+					if err != nil {
 
-		if isRetry {
+						// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+						if common.IsStreamStalled(err) {
+							common.StreamErrorEvent(ctx, err, "error")
+							return responseChan, err
+						}
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+						// 未向客户端输出, 转入重试
+						return responseChan, attempt.UpstreamError(err)
+					}
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+					connTime = response.ConnTime
+					duration = response.Duration
+					totalTime = response.TotalTime
 
-					if mak.RealModel.IsEnableFallback {
+					if response.Error != nil {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
+						if errors.Is(response.Error, io.EOF) {
+
+							// 补发护栏暂缓的内容
+							if flush := guardrail.Flush(ctx); flush != nil {
+								if err := responsesStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+									logger.Error(ctx, err)
+									return responseChan, err
 								}
-								return s.Responses(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel)
 							}
-						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Responses(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, nil, fallbackModel)
+							if err := responsesStream.stop(ctx); err != nil {
+								logger.Error(ctx, err)
+								return responseChan, err
 							}
+
+							native.save(ctx, responsesStream.response)
+
+							return responseChan, nil
 						}
+
+						return responseChan, attempt.UpstreamError(response.Error)
 					}
 
-					return response, err
-				}
-			}
+					if response.ResponseHeaders != nil {
+						service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, response.ResponseHeaders)
+					}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
+					if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
 
-			return s.Responses(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+						if response.Choices[0].Delta.ReasoningContent != nil {
+							completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+						}
 
-		return response, err
-	}
+						completion += response.Choices[0].Delta.Content
 
-	return response, nil
-}
+						if response.Choices[0].Delta.ToolCalls != nil {
+							completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+						}
+					}
 
-// ResponsesStream
-func (s *sOpenAI) ResponsesStream(ctx context.Context, request *ghttp.Request, isChatCompletions bool, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (err error) {
+					if response.ServiceTier != "" {
+						serviceTier = response.ServiceTier
+					}
 
-	now := gtime.TimestampMilli()
-	defer func() {
-		logger.Debugf(ctx, "sOpenAI ResponsesStream time: %d", gtime.TimestampMilli()-now)
-	}()
+					// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+					flush, err := guardrail.Chunk(ctx, response)
+					if err != nil {
+						common.StreamErrorEvent(ctx, err, "error")
+						return responseChan, err
+					}
 
-	var (
-		params = common.ConvResponsesToChatCompletionsRequest(request, isChatCompletions)
-		mak    = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           responsesEndpoint(isChatCompletions),
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		completion  string
-		serviceTier string
-		connTime    int64
-		duration    int64
-		totalTime   int64
-		usage       *smodel.Usage
-		retryInfo   *mcommon.Retry
-	)
+					if flush != nil {
+						if err := responsesStream.chatCompletionsChunk(ctx, gjson.MustEncode(flush)); err != nil {
+							logger.Error(ctx, err)
+							return responseChan, err
+						}
+					}
 
-	defer func() {
+					if response.Usage != nil {
+						logger.Infof(ctx, "sOpenAI ResponsesStream Usage: %s", gjson.MustEncodeString(response.Usage))
+						usage = common.MergeUsage(usage, response.Usage)
+					}
 
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					Completion:        completion,
-					ServiceTier:       serviceTier,
-					Action:            consts.ACTION_RESPONSES,
-					Usage:             usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-				panic(err)
+					if err := responsesStream.chatCompletionsChunk(ctx, gjson.MustEncode(response)); err != nil {
+						logger.Error(ctx, err)
+						return responseChan, err
+					}
+				}
 			}
-		}
-	}()
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			defer func() {
+				cancel()
+				close(responseChan)
+			}()
 
-	//request := *params
-	//
-	//if !gstr.Contains(mak.RealModel.Model, "*") {
-	//	request.Model = mak.RealModel.Model
-	//}
-	//
-	//// 预设配置
-	//if mak.RealModel.IsEnablePresetConfig {
-	//
-	//	// 替换预设提示词
-	//	if mak.RealModel.PresetConfig.IsSupportSystemRole && mak.RealModel.PresetConfig.SystemRolePrompt != "" {
-	//		if request.Messages[0].Role == consts.ROLE_SYSTEM {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages[1:]...)
-	//		} else {
-	//			request.Messages = append([]smodel.ChatCompletionMessage{{
-	//				Role:    consts.ROLE_SYSTEM,
-	//				Content: mak.RealModel.PresetConfig.SystemRolePrompt,
-	//			}}, request.Messages...)
-	//		}
-	//	}
-	//
-	//	// 检查MaxTokens取值范围
-	//	if request.MaxTokens != 0 {
-	//		if mak.RealModel.PresetConfig.MinTokens != 0 && request.MaxTokens < mak.RealModel.PresetConfig.MinTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MinTokens
-	//		} else if mak.RealModel.PresetConfig.MaxTokens != 0 && request.MaxTokens > mak.RealModel.PresetConfig.MaxTokens {
-	//			request.MaxTokens = mak.RealModel.PresetConfig.MaxTokens
-	//		}
-	//	}
-	//}
-	//
-	//if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-	//	for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-	//		if replaceModel == request.Model {
-	//			logger.Infof(ctx, "sOpenAI ResponsesStream request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-	//			request.Model = mak.ModelAgent.TargetModels[i]
-	//			mak.RealModel.Model = request.Model
-	//			break
-	//		}
-	//	}
-	//}
-
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sOpenAI ResponsesStream params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
-
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
-
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
-					logger.Error(ctx, err)
-					return err
-				}
+			// 输出事件, 由 Chat Completions 转入时输出 Chat Completions 数据块
+			send := func(response *smodel.ChatCompletionResponse) error {
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
+				data := response.ResponseBytes
+				if isChatCompletions {
+					data = gjson.MustEncode(response)
 				}
 
-				body = gjson.MustEncode(data)
+				if err := util.SSEServer(ctx, string(data), response.SSEEvent); err != nil {
+					logger.Error(ctx, err)
+					return err
+				}
 
-				break
+				return nil
 			}
-		}
-	}
 
-	if isChatCompletions {
-		body = gjson.MustEncode(common.ConvChatCompletionsToResponsesRequest(ctx, body))
-	}
+			for {
 
-	response, err := common.NewAdapterOpenAI(ctx, mak, true).ResponsesStream(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
+				res, err := common.StreamRecv(ctx, streamTimeout, responseChan)
+				if err != nil {
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+					// 已向客户端输出, 以错误事件结束流并按已输出内容计费
+					if common.IsStreamStalled(err) {
+						common.StreamErrorEvent(ctx, err, "error")
+						return responseChan, err
+					}
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+					// 未向客户端输出, 转入重试
+					return responseChan, attempt.UpstreamError(err)
+				}
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				response := common.ConvResponsesStreamToChatCompletionsResponse(ctx, *res)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				connTime = response.ConnTime
+				duration = response.Duration
+				totalTime = response.TotalTime
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-
-		if isRetry {
+				// 响应头透传
+				if res.ResponseHeaders != nil {
+					common.WritePassthroughHeaders(ctx, mak.Passthrough, res.ResponseHeaders)
+					service.ModelAgent().RecordUpstreamHeaders(ctx, mak.ModelAgent, mak.Key, res.ResponseHeaders)
+				}
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+				if response.Error != nil {
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+					if errors.Is(response.Error, io.EOF) {
 
-					if mak.RealModel.IsEnableFallback {
+						if response.Usage != nil {
+							logger.Infof(ctx, "sOpenAI ResponsesStream Usage: %s", response.ResponseBytes)
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
+							if usage == nil {
+								usage = response.Usage
+							} else {
+								if response.Usage.PromptTokens != 0 {
+									usage.PromptTokens = response.Usage.PromptTokens
+								}
+								if response.Usage.CompletionTokens != 0 {
+									usage.CompletionTokens = response.Usage.CompletionTokens
+								}
+								if response.Usage.TotalTokens != 0 {
+									usage.TotalTokens = response.Usage.TotalTokens
+								} else {
+									usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+								}
+								if response.Usage.CacheCreationInputTokens != 0 {
+									usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
+								}
+								if response.Usage.CacheReadInputTokens != 0 {
+									usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
 								}
-								return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel)
 							}
 						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, nil, fallbackModel)
+						// 补发护栏暂缓的内容
+						if flush := guardrail.Flush(ctx); flush != nil {
+							if err := send(flush); err != nil {
+								return responseChan, err
 							}
 						}
+
+						return responseChan, nil
 					}
 
-					return err
+					return responseChan, attempt.UpstreamError(response.Error)
 				}
-			}
-
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return err
-	}
-
-	defer close(response)
 
-	for {
-
-		res := <-response
-
-		response := common.ConvResponsesStreamToChatCompletionsResponse(ctx, *res)
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+					if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
+						completion += response.Choices[0].Delta.Audio.Transcript
+					} else {
+						if len(response.Choices) > 1 {
+							for i, choice := range response.Choices {
+								completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
+							}
+						} else {
+							if response.Choices[0].Delta.ReasoningContent != nil {
+								completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
+							}
+							completion += response.Choices[0].Delta.Content
+						}
+					}
+				}
 
-		connTime = response.ConnTime
-		duration = response.Duration
-		totalTime = response.TotalTime
+				if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
+					completion += gconv.String(response.Choices[0].Delta.ToolCalls)
+				}
 
-		// 响应头透传
-		if res.ResponseHeaders != nil {
-			common.WritePassthroughHeaders(ctx, mak.Passthrough, res.ResponseHeaders)
-		}
+				if response.ServiceTier != "" {
+					serviceTier = response.ServiceTier
+				}
 
-		if response.Error != nil {
+				// 护栏检查输出, 命中屏蔽规则时以错误事件结束流
+				flush, err := guardrail.Chunk(ctx, &response)
+				if err != nil {
+					common.StreamErrorEvent(ctx, err, "error")
+					return responseChan, err
+				}
 
-			if errors.Is(response.Error, io.EOF) {
+				if flush != nil {
+					if err := send(flush); err != nil {
+						return responseChan, err
+					}
+				}
 
 				if response.Usage != nil {
 					logger.Infof(ctx, "sOpenAI ResponsesStream Usage: %s", response.ResponseBytes)
@@ -455,137 +426,31 @@ func (s *sOpenAI) ResponsesStream(ctx context.Context, request *ghttp.Request, i
 					}
 				}
 
-				return nil
-			}
-
-			err = response.Error
-
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-			isRetry, isDisabled := common.IsNeedRetry(err)
-
-			if isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-				}, nil); err != nil {
-					logger.Error(ctx, err)
-				}
-			}
-
-			if isRetry {
-
-				if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-					if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-						service.Session().SaveModelAgentBillingMethod(ctx, 1)
-						retry = []int{}
-					} else {
-
-						if mak.RealModel.IsEnableFallback {
-
-							if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-								if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel)
-								}
-							}
-
-							if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-								if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, nil, fallbackModel)
-								}
-							}
-						}
-
-						return err
-					}
-				}
-
-				retryInfo = &mcommon.Retry{
-					IsRetry:    true,
-					RetryCount: len(retry),
-					ErrMsg:     err.Error(),
+				if err := send(&response); err != nil {
+					return responseChan, err
 				}
-
-				return s.ResponsesStream(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 			}
-
-			return err
-		}
-
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-			if mak.RealModel.Type == 102 && response.Choices[0].Delta.Audio != nil {
-				completion += response.Choices[0].Delta.Audio.Transcript
-			} else {
-				if len(response.Choices) > 1 {
-					for i, choice := range response.Choices {
-						completion += fmt.Sprintf("index: %d\ncontent: %s\n\n", i, choice.Delta.Content)
-					}
-				} else {
-					if response.Choices[0].Delta.ReasoningContent != nil {
-						completion += gconv.String(response.Choices[0].Delta.ReasoningContent)
-					}
-					completion += response.Choices[0].Delta.Content
-				}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.OpenAIResponsesStreamRes, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				Action:            consts.ACTION_RESPONSES,
+				Completion:        completion,
+				ServiceTier:       serviceTier,
+				Usage:             usage,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
 			}
-		}
-
-		if len(response.Choices) > 0 && response.Choices[0].Delta != nil && response.Choices[0].Delta.ToolCalls != nil {
-			completion += gconv.String(response.Choices[0].Delta.ToolCalls)
-		}
-
-		if response.ServiceTier != "" {
-			serviceTier = response.ServiceTier
-		}
-
-		if response.Usage != nil {
-			logger.Infof(ctx, "sOpenAI ResponsesStream Usage: %s", response.ResponseBytes)
-
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
-				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
-				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-				}
-				if response.Usage.CacheCreationInputTokens != 0 {
-					usage.CacheCreationInputTokens = response.Usage.CacheCreationInputTokens
-				}
-				if response.Usage.CacheReadInputTokens != 0 {
-					usage.CacheReadInputTokens = response.Usage.CacheReadInputTokens
-				}
-			}
-		}
-
-		data := res.ResponseBytes
-		if isChatCompletions {
-			data = gjson.MustEncode(response)
-		}
+		},
+	}
 
-		if err = util.SSEServer(ctx, string(data), response.SSEEvent); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+	// 流中断或输出被拦截已以错误事件结束流
+	if _, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); common.IsStreamStalled(err) || common.IsOutputBlocked(err) {
+		return nil
 	}
+
+	return err
 }
 
 // ResponsesCompact
@@ -596,166 +461,140 @@ func (s *sOpenAI) ResponsesCompact(ctx context.Context, request *ghttp.Request,
 		logger.Debugf(ctx, "sOpenAI ResponsesCompact time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		params = common.ConvResponsesToChatCompletionsRequest(request, isChatCompletions)
-		mak    = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_RESPONSES_COMPACT,
-			Messages:           params.Messages,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
+	pipeline := responsesPipeline(ctx, request.GetBody(), isChatCompletions, "sOpenAI ResponsesCompact", consts.ENDPOINT_RESPONSES_COMPACT, consts.ACTION_COMPACT,
+		func(ctx context.Context, mak *common.MAK, body []byte) (smodel.OpenAIResponsesRes, error) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, mak, "ResponsesCompact")
+			response, err := common.NewAdapterOpenAI(upstreamCtx, mak, false).ResponsesCompact(upstreamCtx, body)
+			common.EndSpan(upstreamSpan, err)
+			return response, err
+		})
+
+	// 由 Chat Completions 转入时转换为 Chat Completions 响应
+	if response, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...); isChatCompletions {
+		response.ResponseBytes = gjson.MustEncode(common.ConvResponsesToChatCompletionsResponse(ctx, response))
+	}
 
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-		chatCompletionResponse := common.ConvResponsesToChatCompletionsResponse(ctx, response)
+	return response, err
+}
 
-		if isChatCompletions {
-			response.ResponseBytes = gjson.MustEncode(chatCompletionResponse)
-		}
+// 非流式 Responses 请求管道, 按是否由 Chat Completions 转入转换请求和响应
+func responsesPipeline(ctx context.Context, data []byte, isChatCompletions bool, name, endpoint, action string, upstream func(ctx context.Context, mak *common.MAK, body []byte) (smodel.OpenAIResponsesRes, error)) *common.Pipeline[smodel.OpenAIResponsesRes] {
 
-		if mak.ReqModel != nil && mak.RealModel != nil {
+	var (
+		params   = common.ConvResponsesToChatCompletionsRequest(ctx, data, isChatCompletions)
+		reqModel = params.Model
+		body     []byte
+		native   *nativeResponses
+	)
 
-			// 替换成调用的模型
-			if mak.ReqModel.IsEnableForward {
-				chatCompletionResponse.Model = mak.ReqModel.Model
+	return &common.Pipeline[smodel.OpenAIResponsesRes]{
+		Name:        name,
+		IsGuardrail: true,
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           endpoint,
+				Messages:           params.Messages,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData:           &mcommon.BillingData{ChatCompletionRequest: params},
 			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: params,
-					ChatCompletionRes: chatCompletionResponse,
-					Action:            consts.ACTION_COMPACT,
-					Usage:             chatCompletionResponse.Usage,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          chatCompletionResponse.ConnTime,
-					Duration:          chatCompletionResponse.Duration,
-					TotalTime:         chatCompletionResponse.TotalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+			if body, err = attempt.TransformBody(ctx, data, reqModel, &params, nil); err != nil {
+				return err
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
 
-	body := request.GetBody()
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == params.Model {
-				logger.Infof(ctx, "sOpenAI ResponsesCompact params.Model: %s replaced %s", params.Model, mak.ModelAgent.TargetModels[i])
+			if isChatCompletions {
+				body = gjson.MustEncode(common.ConvChatCompletionsToResponsesRequest(ctx, body))
+				return nil
+			}
 
-				params.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = params.Model
+			if action != consts.ACTION_RESPONSES {
+				return nil
+			}
 
-				data := make(map[string]any)
-				if err = json.Unmarshal(body, &data); err != nil {
-					logger.Error(ctx, err)
-					return response, err
-				}
+			// 上游不支持 Responses API, 转换为 Chat Completions
+			if native = nil; isNativeResponses(ctx, attempt.Mak) {
 
-				if _, ok := data["model"]; ok {
-					data["model"] = mak.RealModel.Model
+				if native, err = newNativeResponses(ctx, body); err != nil {
+					return err
 				}
 
-				body = gjson.MustEncode(data)
+				body = gjson.MustEncode(native.chatCompletionsBody(params.Model))
 
-				break
+				return nil
 			}
-		}
-	}
-
-	if isChatCompletions {
-		body = gjson.MustEncode(common.ConvChatCompletionsToResponsesRequest(ctx, body))
-	}
-
-	response, err = common.NewAdapterOpenAI(ctx, mak, false).ResponsesCompact(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			if isResponsesStore() {
+				body, err = expandPreviousResponse(ctx, body)
+			}
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			return err
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.OpenAIResponsesRes, err error) {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			if native == nil {
+				return upstream(ctx, attempt.Mak, body)
+			}
 
-			}, nil); err != nil {
+			chatCompletionRequest := smodel.ChatCompletionRequest{}
+			if err = json.Unmarshal(body, &chatCompletionRequest); err != nil {
 				logger.Error(ctx, err)
+				return response, err
 			}
-		}
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "ChatCompletions")
+			res, err := common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, chatCompletionRequest)
+			common.EndSpan(upstreamSpan, err)
+			if err != nil {
+				return response, err
+			}
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+			return smodel.OpenAIResponsesRes{
+				ResponseBytes:   gjson.MustEncode(native.response(gjson.New(gjson.MustEncode(res)).Map())),
+				ResponseHeaders: res.ResponseHeaders,
+				ConnTime:        res.ConnTime,
+				Duration:        res.Duration,
+				TotalTime:       res.TotalTime,
+			}, nil
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response smodel.OpenAIResponsesRes) (smodel.OpenAIResponsesRes, error) {
+
+			// 护栏检查输出, 存储响应前检查
+			if err := attempt.GuardResponses(ctx, &response); err != nil {
+				return response, err
+			}
 
-					if mak.RealModel.IsEnableFallback {
+			if native != nil {
+				native.save(ctx, gjson.New(response.ResponseBytes).Map())
+			}
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.ResponsesCompact(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel)
-							}
-						}
+			return response, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.OpenAIResponsesRes, err error) *mcommon.AfterHandler {
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.ResponsesCompact(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, nil, fallbackModel)
-							}
-						}
-					}
+			chatCompletionResponse := common.ConvResponsesToChatCompletionsResponse(ctx, res)
 
-					return response, err
-				}
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				chatCompletionResponse.Model = attempt.Mak.ReqModel.Model
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: params,
+				ChatCompletionRes: chatCompletionResponse,
+				Action:            action,
+				Usage:             chatCompletionResponse.Usage,
+				ConnTime:          chatCompletionResponse.ConnTime,
+				Duration:          chatCompletionResponse.Duration,
+				TotalTime:         chatCompletionResponse.TotalTime,
 			}
-
-			return s.ResponsesCompact(g.RequestFromCtx(ctx).GetCtx(), request, isChatCompletions, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
-
-	return response, nil
 }
 
-// 请求端点[兼容以ChatCompletions格式请求Responses的情况]
 func responsesEndpoint(isChatCompletions bool) string {
 	if isChatCompletions {
 		return consts.ENDPOINT_CHAT_COMPLETIONS
@@ -0,0 +1,340 @@
+package openai
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/os/gtimer"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/api/openai/v1"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model/do"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func init() {
+	_ = gtimer.AddSingleton(gctx.New(), 30*time.Minute, func(ctx context.Context) {
+		cleanExpiredResponses(gctx.New())
+	})
+}
+
+// 是否开启响应存储
+func isResponsesStore() bool {
+	return config.Cfg.ResponsesStore != nil && config.Cfg.ResponsesStore.Open
+}
+
+// 是否网关 Responses, 开启响应存储且上游不支持 Responses API
+func isNativeResponses(ctx context.Context, mak *common.MAK) bool {
+
+	if !isResponsesStore() {
+		return false
+	}
+
+	return common.GetProviderCode(ctx, mak.Provider) != sconsts.PROVIDER_OPENAI
+}
+
+// 网关 Responses, 上游不支持 Responses API 时转换为 Chat Completions 并由网关存储响应
+type nativeResponses struct {
+	request    map[string]any   // 原始请求
+	input      []map[string]any // 输入项, 含上一个响应的上下文
+	responseId string
+	createdAt  int64
+	isStore    bool
+}
+
+// 解析请求并按 previous_response_id 合并已存储的上下文
+func newNativeResponses(ctx context.Context, body []byte) (*nativeResponses, error) {
+
+	request, input := requestInput(body)
+
+	if previousResponseId := gconv.String(request["previous_response_id"]); previousResponseId != "" {
+
+		previous, err := findResponse(ctx, previousResponseId)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, errors.NewError(400, "previous_response_not_found", "Previous response with id '"+previousResponseId+"' not found.", "invalid_request_error", "previous_response_id")
+			}
+			logger.Error(ctx, err)
+			return nil, err
+		}
+
+		input = append(append(append([]map[string]any{}, previous.Input...), previous.Output...), input...)
+	}
+
+	return &nativeResponses{
+		request:    request,
+		input:      input,
+		responseId: newId("resp"),
+		createdAt:  gtime.Timestamp(),
+		isStore:    gconv.Bool(g.NewVar(request["store"]).Default(true)),
+	}, nil
+}
+
+// 转换为 Chat Completions 请求
+func (n *nativeResponses) chatCompletionsBody(model string) map[string]any {
+
+	chatCompletionRequest := convResponsesToChatCompletions(n.request, n.input)
+	chatCompletionRequest["model"] = model
+
+	return chatCompletionRequest
+}
+
+// Chat Completions 响应转换为 Responses 响应
+func (n *nativeResponses) response(chatCompletionResponse map[string]any) map[string]any {
+
+	output, status, incompleteDetails := convChatCompletionsToOutput(chatCompletionResponse)
+
+	return responseObject(n.request, n.responseId, n.createdAt, status, incompleteDetails, output, convUsage(gconv.Map(chatCompletionResponse["usage"])), n.isStore)
+}
+
+// 存储响应, 按用户隐私设置(未设置时为系统默认)分别丢弃请求和响应内容, 均不记录时不存储
+func (n *nativeResponses) save(ctx context.Context, response map[string]any) {
+
+	if !n.isStore || response == nil {
+		return
+	}
+
+	userId := service.Session().GetUserId(ctx)
+
+	privacy := service.User().GetPrivacy(ctx, userId)
+	if !privacy.LogRequestContent && !privacy.LogResponseContent {
+		return
+	}
+
+	var (
+		input        = n.input
+		output       = gconv.Maps(response["output"])
+		responseData = response
+	)
+
+	if !privacy.LogRequestContent {
+		input = nil
+	}
+
+	if !privacy.LogResponseContent {
+		output = nil
+		responseData = nil
+	}
+
+	expiresAt := gtime.Now().Add(30 * 24 * time.Hour).TimestampMilli()
+	if config.Cfg.ResponsesStore.StorageExpiresAt > 0 {
+		expiresAt = gtime.Now().Add(config.Cfg.ResponsesStore.StorageExpiresAt * time.Minute).TimestampMilli()
+	}
+
+	if _, err := dao.TaskResponse.Insert(ctx, &do.TaskResponse{
+		TraceId:            gctx.CtxId(ctx),
+		UserId:             userId,
+		AppId:              service.Session().GetAppId(ctx),
+		Model:              gconv.String(n.request["model"]),
+		ResponseId:         n.responseId,
+		PreviousResponseId: gconv.String(n.request["previous_response_id"]),
+		Input:              input,
+		Output:             output,
+		ResponseData:       responseData,
+		Status:             gconv.String(response["status"]),
+		ExpiresAt:          expiresAt,
+		Privacy:            privacy,
+		Rid:                service.Session().GetRid(ctx),
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 上游支持 Responses API 时, previous_response_id 为网关存储的响应则合并上下文, 否则原样透传
+func expandPreviousResponse(ctx context.Context, body []byte) ([]byte, error) {
+
+	previousResponseId := gjson.New(body).Get("previous_response_id").String()
+	if previousResponseId == "" {
+		return body, nil
+	}
+
+	previous, err := findResponse(ctx, previousResponseId)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return body, nil
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	request, input := requestInput(body)
+
+	// 网关生成的推理项和输入项ID上游无法识别
+	items := make([]map[string]any, 0)
+	for _, item := range append(append(append([]map[string]any{}, previous.Input...), previous.Output...), input...) {
+		if item["type"] != "reasoning" {
+			expanded := make(map[string]any, len(item))
+			for k, v := range item {
+				if k != "id" {
+					expanded[k] = v
+				}
+			}
+			items = append(items, expanded)
+		}
+	}
+
+	request["input"] = items
+	delete(request, "previous_response_id")
+
+	return gjson.MustEncode(request), nil
+}
+
+// 查询当前用户应用已存储且未过期的响应
+func findResponse(ctx context.Context, responseId string) (*entity.TaskResponse, error) {
+	return dao.TaskResponse.FindOne(ctx, bson.M{
+		"response_id": responseId,
+		"user_id":     service.Session().GetUserId(ctx),
+		"app_id":      service.Session().GetAppId(ctx),
+		"status":      bson.M{"$ne": "deleted"},
+		"expires_at":  bson.M{"$gt": gtime.TimestampMilli()},
+	})
+}
+
+// 响应不存在错误
+func responseNotFound(responseId string) error {
+	return errors.NewError(404, "invalid_request_error", "Response with id '"+responseId+"' not found.", "invalid_request_error", "response_id")
+}
+
+// ResponsesRetrieve
+func (s *sOpenAI) ResponsesRetrieve(ctx context.Context, params *v1.ResponsesRetrieveReq) (response map[string]any, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sOpenAI ResponsesRetrieve time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	taskResponse, err := findResponse(ctx, params.ResponseId)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, responseNotFound(params.ResponseId)
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 按隐私设置未存储响应内容
+	if taskResponse.ResponseData == nil {
+		return g.Map{
+			"id":     taskResponse.ResponseId,
+			"object": "response",
+			"model":  taskResponse.Model,
+			"status": taskResponse.Status,
+		}, nil
+	}
+
+	return taskResponse.ResponseData, nil
+}
+
+// ResponsesDelete
+func (s *sOpenAI) ResponsesDelete(ctx context.Context, params *v1.ResponsesDeleteReq) (response map[string]any, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sOpenAI ResponsesDelete time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	taskResponse, err := findResponse(ctx, params.ResponseId)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, responseNotFound(params.ResponseId)
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 删除时清空已存储的内容
+	if err = dao.TaskResponse.UpdateById(ctx, taskResponse.Id, bson.M{
+		"$set":   bson.M{"status": "deleted"},
+		"$unset": bson.M{"input": 1, "output": 1, "response_data": 1},
+	}); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	return g.Map{"id": params.ResponseId, "object": "response", "deleted": true}, nil
+}
+
+// ResponsesInputItems
+func (s *sOpenAI) ResponsesInputItems(ctx context.Context, params *v1.ResponsesInputItemsReq) (response map[string]any, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sOpenAI ResponsesInputItems time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	taskResponse, err := findResponse(ctx, params.ResponseId)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, responseNotFound(params.ResponseId)
+		}
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	limit := params.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	items := make([]map[string]any, 0, len(taskResponse.Input))
+	if params.Order == "asc" {
+		items = append(items, taskResponse.Input...)
+	} else {
+		for i := len(taskResponse.Input) - 1; i >= 0; i-- {
+			items = append(items, taskResponse.Input[i])
+		}
+	}
+
+	if params.After != "" {
+		for i, item := range items {
+			if gconv.String(item["id"]) == params.After {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	response = g.Map{
+		"object":   "list",
+		"data":     items,
+		"first_id": nil,
+		"last_id":  nil,
+		"has_more": hasMore,
+	}
+
+	if len(items) > 0 {
+		response["first_id"] = items[0]["id"]
+		response["last_id"] = items[len(items)-1]["id"]
+	}
+
+	return response, nil
+}
+
+// 清理过期的响应
+func cleanExpiredResponses(ctx context.Context) {
+
+	if !isResponsesStore() {
+		return
+	}
+
+	if _, err := dao.TaskResponse.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": gtime.TimestampMilli()}}); err != nil {
+		logger.Error(ctx, err)
+	}
+}
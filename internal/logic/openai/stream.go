@@ -0,0 +1,271 @@
+package openai
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	"github.com/iimeta/fastapi/v2/utility/util"
+)
+
+// 流式响应转换, 将 Chat Completions 数据块转换为 Responses 事件
+type responsesStream struct {
+	request        map[string]any
+	id             string
+	createdAt      int64
+	isStore        bool
+	sequenceNumber int
+	output         []map[string]any // 已完成的输出项
+	item           map[string]any   // 当前输出项
+	itemType       string
+	text           string // 当前输出项的文本或参数
+	toolCallIndex  int
+	finishReason   string
+	usage          map[string]any
+	isStarted      bool
+	response       map[string]any // 最终响应
+}
+
+func newResponsesStream(request map[string]any, id string, createdAt int64, isStore bool) *responsesStream {
+	return &responsesStream{
+		request:       request,
+		id:            id,
+		createdAt:     createdAt,
+		isStore:       isStore,
+		output:        make([]map[string]any, 0),
+		toolCallIndex: -1,
+	}
+}
+
+func (s *responsesStream) send(ctx context.Context, event string, data g.Map) error {
+
+	data["type"] = event
+	data["sequence_number"] = s.sequenceNumber
+	s.sequenceNumber++
+
+	return util.SSEServer(ctx, gjson.MustEncodeString(data), event)
+}
+
+// 开始流, 收到首个数据块时输出 response.created 和 response.in_progress
+func (s *responsesStream) start(ctx context.Context) error {
+
+	if s.isStarted {
+		return nil
+	}
+
+	s.isStarted = true
+
+	response := responseObject(s.request, s.id, s.createdAt, "in_progress", nil, []map[string]any{}, nil, s.isStore)
+
+	if err := s.send(ctx, "response.created", g.Map{"response": response}); err != nil {
+		return err
+	}
+
+	return s.send(ctx, "response.in_progress", g.Map{"response": response})
+}
+
+// 开始输出项, 类型不同时先结束当前输出项
+func (s *responsesStream) open(ctx context.Context, itemType string, item map[string]any) error {
+
+	if err := s.close(ctx); err != nil {
+		return err
+	}
+
+	s.item = item
+	s.itemType = itemType
+	s.text = ""
+
+	if err := s.send(ctx, "response.output_item.added", g.Map{"output_index": len(s.output), "item": item}); err != nil {
+		return err
+	}
+
+	switch itemType {
+	case "reasoning":
+		return s.send(ctx, "response.reasoning_summary_part.added", g.Map{
+			"item_id":       item["id"],
+			"output_index":  len(s.output),
+			"summary_index": 0,
+			"part":          g.Map{"type": "summary_text", "text": ""},
+		})
+	case "message":
+		return s.send(ctx, "response.content_part.added", g.Map{
+			"item_id":       item["id"],
+			"output_index":  len(s.output),
+			"content_index": 0,
+			"part":          g.Map{"type": "output_text", "text": "", "annotations": []any{}},
+		})
+	}
+
+	return nil
+}
+
+// 结束当前输出项
+func (s *responsesStream) close(ctx context.Context) error {
+
+	if s.item == nil {
+		return nil
+	}
+
+	var (
+		id          = gconv.String(s.item["id"])
+		outputIndex = len(s.output)
+		item        map[string]any
+	)
+
+	switch s.itemType {
+	case "reasoning":
+
+		item = reasoningItem(id, s.text)
+
+		if err := s.send(ctx, "response.reasoning_summary_text.done", g.Map{"item_id": id, "output_index": outputIndex, "summary_index": 0, "text": s.text}); err != nil {
+			return err
+		}
+
+		if err := s.send(ctx, "response.reasoning_summary_part.done", g.Map{"item_id": id, "output_index": outputIndex, "summary_index": 0, "part": g.Map{"type": "summary_text", "text": s.text}}); err != nil {
+			return err
+		}
+
+	case "message":
+
+		item = messageItem(id, s.text)
+
+		if err := s.send(ctx, "response.output_text.done", g.Map{"item_id": id, "output_index": outputIndex, "content_index": 0, "text": s.text}); err != nil {
+			return err
+		}
+
+		if err := s.send(ctx, "response.content_part.done", g.Map{"item_id": id, "output_index": outputIndex, "content_index": 0, "part": g.Map{"type": "output_text", "text": s.text, "annotations": []any{}}}); err != nil {
+			return err
+		}
+
+	case "function_call":
+
+		item = functionCallItem(id, gconv.String(s.item["call_id"]), gconv.String(s.item["name"]), s.text)
+
+		if err := s.send(ctx, "response.function_call_arguments.done", g.Map{"item_id": id, "output_index": outputIndex, "arguments": s.text}); err != nil {
+			return err
+		}
+	}
+
+	if err := s.send(ctx, "response.output_item.done", g.Map{"output_index": outputIndex, "item": item}); err != nil {
+		return err
+	}
+
+	s.output = append(s.output, item)
+	s.item = nil
+	s.itemType = ""
+	s.text = ""
+
+	return nil
+}
+
+// Chat Completions 数据块
+func (s *responsesStream) chatCompletionsChunk(ctx context.Context, data []byte) error {
+
+	if err := s.start(ctx); err != nil {
+		return err
+	}
+
+	chunk := gjson.New(data).Map()
+
+	if usage := convUsage(gconv.Map(chunk["usage"])); usage != nil {
+		s.usage = usage
+	}
+
+	choices := gconv.Maps(chunk["choices"])
+	if len(choices) == 0 {
+		return nil
+	}
+
+	delta := gconv.Map(choices[0]["delta"])
+
+	if reasoningContent := gconv.String(delta["reasoning_content"]); reasoningContent != "" {
+
+		if s.itemType != "reasoning" {
+			if err := s.open(ctx, "reasoning", g.Map{"id": newId("rs"), "type": "reasoning", "summary": []any{}}); err != nil {
+				return err
+			}
+		}
+
+		s.text += reasoningContent
+
+		if err := s.send(ctx, "response.reasoning_summary_text.delta", g.Map{"item_id": s.item["id"], "output_index": len(s.output), "summary_index": 0, "delta": reasoningContent}); err != nil {
+			return err
+		}
+	}
+
+	if content := gconv.String(delta["content"]); content != "" {
+
+		if s.itemType != "message" {
+			if err := s.open(ctx, "message", g.Map{"id": newId("msg"), "type": "message", "status": "in_progress", "role": sconsts.ROLE_ASSISTANT, "content": []any{}}); err != nil {
+				return err
+			}
+		}
+
+		s.text += content
+
+		if err := s.send(ctx, "response.output_text.delta", g.Map{"item_id": s.item["id"], "output_index": len(s.output), "content_index": 0, "delta": content}); err != nil {
+			return err
+		}
+	}
+
+	for _, toolCall := range gconv.Maps(delta["tool_calls"]) {
+
+		function := gconv.Map(toolCall["function"])
+
+		// 新的工具调用
+		if index := gconv.Int(toolCall["index"]); s.itemType != "function_call" || index != s.toolCallIndex {
+
+			s.toolCallIndex = index
+
+			if err := s.open(ctx, "function_call", g.Map{
+				"id":        newId("fc"),
+				"type":      "function_call",
+				"status":    "in_progress",
+				"call_id":   toolCall["id"],
+				"name":      function["name"],
+				"arguments": "",
+			}); err != nil {
+				return err
+			}
+		}
+
+		if arguments := gconv.String(function["arguments"]); arguments != "" {
+
+			s.text += arguments
+
+			if err := s.send(ctx, "response.function_call_arguments.delta", g.Map{"item_id": s.item["id"], "output_index": len(s.output), "delta": arguments}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if finishReason := gconv.String(choices[0]["finish_reason"]); finishReason != "" {
+		s.finishReason = finishReason
+	}
+
+	return nil
+}
+
+// 结束流, 输出 response.completed 或 response.incomplete
+func (s *responsesStream) stop(ctx context.Context) error {
+
+	if s.response != nil {
+		return nil
+	}
+
+	if err := s.start(ctx); err != nil {
+		return err
+	}
+
+	if err := s.close(ctx); err != nil {
+		return err
+	}
+
+	status, incompleteDetails := responseStatus(s.finishReason)
+
+	s.response = responseObject(s.request, s.id, s.createdAt, status, incompleteDetails, s.output, s.usage, s.isStore)
+
+	return s.send(ctx, "response."+status, g.Map{"response": s.response})
+}
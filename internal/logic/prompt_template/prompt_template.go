@@ -0,0 +1,428 @@
+package prompt_template
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/dao"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/model/entity"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/cache"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// 模板变量, 如: {{name}}
+var variableRegexp = regexp.MustCompile(`\{\{\s*([\w.\-]+)\s*\}\}`)
+
+type sPromptTemplate struct {
+	promptTemplateCache *cache.Cache // [提示词模板ID]PromptTemplate
+}
+
+func init() {
+	service.RegisterPromptTemplate(New())
+}
+
+func New() service.IPromptTemplate {
+	return &sPromptTemplate{
+		promptTemplateCache: cache.New(),
+	}
+}
+
+// 根据提示词模板ID获取提示词模板信息
+func (s *sPromptTemplate) GetById(ctx context.Context, id string) (*model.PromptTemplate, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate GetById time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	promptTemplate, err := dao.PromptTemplate.FindById(ctx, id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	return &model.PromptTemplate{
+		Id:       promptTemplate.Id,
+		UserId:   promptTemplate.UserId,
+		Name:     promptTemplate.Name,
+		Version:  promptTemplate.Version,
+		Versions: promptTemplate.Versions,
+		Status:   promptTemplate.Status,
+	}, nil
+}
+
+// 提示词模板列表
+func (s *sPromptTemplate) List(ctx context.Context) ([]*model.PromptTemplate, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate List time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	filter := bson.M{}
+
+	results, err := dao.PromptTemplate.Find(ctx, filter)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	items := make([]*model.PromptTemplate, 0)
+	for _, result := range results {
+		items = append(items, &model.PromptTemplate{
+			Id:       result.Id,
+			UserId:   result.UserId,
+			Name:     result.Name,
+			Version:  result.Version,
+			Versions: result.Versions,
+			Status:   result.Status,
+		})
+	}
+
+	return items, nil
+}
+
+// 渲染提示词模板, 模板消息置于请求消息之前, 优先级: 请求 > 应用密钥 > 应用
+func (s *sPromptTemplate) Render(ctx context.Context, prompt *common.Prompt, messages []smodel.ChatCompletionMessage) ([]smodel.ChatCompletionMessage, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate Render time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if prompt == nil || prompt.Id == "" {
+		if appKey := service.Session().GetAppKey(ctx); appKey != nil && appKey.Prompt != nil && appKey.Prompt.Id != "" {
+			prompt = appKey.Prompt
+		} else if app := service.Session().GetApp(ctx); app != nil && app.Prompt != nil && app.Prompt.Id != "" {
+			prompt = app.Prompt
+		} else {
+			return messages, nil
+		}
+	}
+
+	promptTemplate, err := s.GetCache(ctx, prompt.Id)
+	if err != nil || promptTemplate.Status != 1 || (promptTemplate.UserId != 0 && promptTemplate.UserId != service.Session().GetUserId(ctx)) {
+		return nil, errors.ERR_PROMPT_TEMPLATE_NOT_FOUND
+	}
+
+	version := prompt.Version
+	if version == 0 {
+		version = promptTemplate.Version
+	}
+
+	var promptVersion *common.PromptVersion
+	for _, v := range promptTemplate.Versions {
+		if v.Version == version {
+			promptVersion = v
+			break
+		}
+	}
+
+	if promptVersion == nil {
+		return nil, errors.ERR_PROMPT_VERSION_NOT_FOUND
+	}
+
+	rendered := make([]smodel.ChatCompletionMessage, 0, len(promptVersion.Messages)+len(messages))
+	for _, message := range promptVersion.Messages {
+
+		content, err := renderContent(message.Content, promptVersion.Variables, prompt.Variables)
+		if err != nil {
+			return nil, err
+		}
+
+		rendered = append(rendered, smodel.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: content,
+		})
+	}
+
+	// 日志仅记录模板ID和版本
+	service.Session().SavePrompt(ctx, &common.Prompt{
+		Id:      promptTemplate.Id,
+		Version: version,
+	})
+
+	return append(rendered, messages...), nil
+}
+
+// 渲染请求体中的提示词模板, 模板消息置于请求消息之前, Responses 请求的 prompt 非提示词模板时原样转发
+func (s *sPromptTemplate) RenderBody(ctx context.Context, body []byte, isResponses bool) ([]byte, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate RenderBody time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	data := make(map[string]any)
+	if err := json.Unmarshal(body, &data); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	var prompt *common.Prompt
+	if value, ok := data["prompt"]; ok && value != nil {
+		if err := gjson.Unmarshal(gjson.MustEncode(value), &prompt); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+	}
+
+	// Responses 请求的 prompt 可能为上游存储的提示词
+	if isResponses && prompt != nil && prompt.Id != "" && !s.promptTemplateCache.ContainsKey(ctx, prompt.Id) {
+		return body, nil
+	}
+
+	rendered, err := s.Render(ctx, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rendered) == 0 {
+		return body, nil
+	}
+
+	key := "messages"
+	if isResponses {
+		key = "input"
+	}
+
+	messages := make([]any, 0, len(rendered)+1)
+	for _, message := range rendered {
+		messages = append(messages, g.Map{
+			"role":    message.Role,
+			"content": message.Content,
+		})
+	}
+
+	switch value := data[key].(type) {
+	case nil:
+	case []any:
+		messages = append(messages, value...)
+	default:
+		// Responses 请求的 input 为字符串时作为用户消息
+		messages = append(messages, g.Map{
+			"role":    sconsts.ROLE_USER,
+			"content": value,
+		})
+	}
+
+	data[key] = messages
+	delete(data, "prompt")
+
+	return gjson.Encode(data)
+}
+
+// 替换模板变量, 请求变量优先于模板默认值
+func renderContent(content string, defaults, variables map[string]string) (string, error) {
+
+	var missing string
+
+	rendered := variableRegexp.ReplaceAllStringFunc(content, func(match string) string {
+
+		name := strings.TrimSpace(match[2 : len(match)-2])
+
+		if value, ok := variables[name]; ok {
+			return value
+		}
+
+		if value, ok := defaults[name]; ok {
+			return value
+		}
+
+		if missing == "" {
+			missing = name
+		}
+
+		return match
+	})
+
+	if missing != "" {
+		return "", errors.NewErrorf(400, "missing_prompt_variable", "Missing prompt variable: '%s'.", "invalid_request_error", "prompt.variables", missing)
+	}
+
+	return rendered, nil
+}
+
+// 根据提示词模板ID获取提示词模板信息并保存到缓存
+func (s *sPromptTemplate) GetAndSaveCache(ctx context.Context, id string) (*model.PromptTemplate, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate GetAndSaveCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	promptTemplate, err := s.GetById(ctx, id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if promptTemplate != nil {
+		if err = s.SaveCache(ctx, promptTemplate); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+	}
+
+	return promptTemplate, nil
+}
+
+// 保存提示词模板到缓存
+func (s *sPromptTemplate) SaveCache(ctx context.Context, promptTemplate *model.PromptTemplate) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate SaveCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	return s.SaveCacheList(ctx, []*model.PromptTemplate{promptTemplate})
+}
+
+// 保存提示词模板列表到缓存
+func (s *sPromptTemplate) SaveCacheList(ctx context.Context, promptTemplates []*model.PromptTemplate) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate SaveCacheList time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	for _, promptTemplate := range promptTemplates {
+		if err := s.promptTemplateCache.Set(ctx, promptTemplate.Id, promptTemplate, 0); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 刷新缓存中的提示词模板, 移除已删除的提示词模板
+func (s *sPromptTemplate) RefreshCache(ctx context.Context, promptTemplates []*model.PromptTemplate) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate RefreshCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	ids := make(map[string]bool, len(promptTemplates))
+	for _, promptTemplate := range promptTemplates {
+		ids[promptTemplate.Id] = true
+	}
+
+	keys, err := s.promptTemplateCache.Keys(ctx)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	for _, key := range keys {
+		if id := gconv.String(key); !ids[id] {
+			s.RemoveCache(ctx, id)
+		}
+	}
+
+	return s.SaveCacheList(ctx, promptTemplates)
+}
+
+// 获取缓存中的提示词模板信息
+func (s *sPromptTemplate) GetCache(ctx context.Context, id string) (*model.PromptTemplate, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate GetCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if promptTemplateCacheValue := s.promptTemplateCache.GetVal(ctx, id); promptTemplateCacheValue != nil {
+		return promptTemplateCacheValue.(*model.PromptTemplate), nil
+	}
+
+	return nil, errors.New("promptTemplate is nil")
+}
+
+// 更新缓存中的提示词模板
+func (s *sPromptTemplate) UpdateCache(ctx context.Context, newData *entity.PromptTemplate) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate UpdateCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	promptTemplate := &model.PromptTemplate{
+		Id:       newData.Id,
+		UserId:   newData.UserId,
+		Name:     newData.Name,
+		Version:  newData.Version,
+		Versions: newData.Versions,
+		Status:   newData.Status,
+	}
+
+	if err := s.SaveCache(ctx, promptTemplate); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 移除缓存中的提示词模板
+func (s *sPromptTemplate) RemoveCache(ctx context.Context, id string) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate RemoveCache time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if _, err := s.promptTemplateCache.Remove(ctx, id); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 变更订阅
+func (s *sPromptTemplate) Subscribe(ctx context.Context, msg string) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sPromptTemplate Subscribe time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	message := new(model.SubMessage)
+	if err := gjson.Unmarshal([]byte(msg), &message); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+	logger.Infof(ctx, "sPromptTemplate Subscribe: %s", gjson.MustEncodeString(message))
+
+	var promptTemplate *entity.PromptTemplate
+	switch message.Action {
+	case consts.ACTION_CREATE, consts.ACTION_UPDATE, consts.ACTION_STATUS:
+
+		if err := gjson.Unmarshal(gjson.MustEncode(message.NewData), &promptTemplate); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		s.UpdateCache(ctx, promptTemplate)
+
+	case consts.ACTION_DELETE:
+
+		if err := gjson.Unmarshal(gjson.MustEncode(message.OldData), &promptTemplate); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		s.RemoveCache(ctx, promptTemplate.Id)
+	}
+
+	return nil
+}
@@ -0,0 +1,578 @@
+package rate_limit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/redis"
+)
+
+// 滑动窗口大小, 单位: 毫秒
+const window = 60000
+
+// 滑动窗口计数 + 并发有序集合, 检查全部通过后才会占用
+// KEYS: [当前RPM窗口, 上一RPM窗口, 当前TPM窗口, 上一TPM窗口, 并发]
+// ARGV: [rpm, tpm, concurrency, 上一窗口权重, 预扣Token数, 当前时间, 并发过期毫秒数, 并发成员, 窗口大小]
+// 返回: [结果(0:通过, 1:RPM, 2:TPM, 3:并发), 已用请求数, 已用Token数, 当前并发数]
+const checkScript = `
+local rpm = tonumber(ARGV[1])
+local tpm = tonumber(ARGV[2])
+local concurrency = tonumber(ARGV[3])
+local weight = tonumber(ARGV[4])
+local tokens = tonumber(ARGV[5])
+local now = tonumber(ARGV[6])
+local expire = tonumber(ARGV[7])
+local member = ARGV[8]
+local window = tonumber(ARGV[9])
+
+local requests = 0
+if rpm > 0 then
+	requests = math.floor((tonumber(redis.call('GET', KEYS[2])) or 0) * weight) + (tonumber(redis.call('GET', KEYS[1])) or 0)
+	if requests >= rpm then
+		return {1, requests, 0, 0}
+	end
+end
+
+local usedTokens = 0
+if tpm > 0 then
+	usedTokens = math.floor((tonumber(redis.call('GET', KEYS[4])) or 0) * weight) + (tonumber(redis.call('GET', KEYS[3])) or 0)
+	if usedTokens >= tpm or usedTokens + tokens > tpm then
+		return {2, requests, usedTokens, 0}
+	end
+end
+
+local inflight = 0
+if concurrency > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[5], '-inf', now)
+	inflight = redis.call('ZCARD', KEYS[5])
+	if inflight >= concurrency then
+		return {3, requests, usedTokens, inflight}
+	end
+end
+
+if rpm > 0 then
+	requests = requests + 1
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], window * 2)
+end
+
+if tpm > 0 and tokens > 0 then
+	usedTokens = usedTokens + tokens
+	redis.call('INCRBY', KEYS[3], tokens)
+	redis.call('PEXPIRE', KEYS[3], window * 2)
+end
+
+if concurrency > 0 then
+	inflight = inflight + 1
+	redis.call('ZADD', KEYS[5], now + expire, member)
+	redis.call('PEXPIRE', KEYS[5], expire)
+end
+
+return {0, requests, usedTokens, inflight}
+`
+
+// 回滚/修正/释放
+// KEYS: [RPM窗口, TPM窗口, 并发]
+// ARGV: [请求数增量, Token数增量, 并发成员, 窗口大小]
+const adjustScript = `
+local requests = tonumber(ARGV[1])
+local tokens = tonumber(ARGV[2])
+
+if requests ~= 0 and redis.call('EXISTS', KEYS[1]) == 1 then
+	if redis.call('INCRBY', KEYS[1], requests) < 0 then
+		redis.call('SET', KEYS[1], 0, 'KEEPTTL')
+	end
+end
+
+if tokens > 0 then
+	redis.call('INCRBY', KEYS[2], tokens)
+	redis.call('PEXPIRE', KEYS[2], tonumber(ARGV[4]) * 2)
+elseif tokens < 0 and redis.call('EXISTS', KEYS[2]) == 1 then
+	if redis.call('INCRBY', KEYS[2], tokens) < 0 then
+		redis.call('SET', KEYS[2], 0, 'KEEPTTL')
+	end
+end
+
+if ARGV[3] ~= '' then
+	redis.call('ZREM', KEYS[3], ARGV[3])
+end
+
+return 1
+`
+
+type sRateLimit struct{}
+
+// 限流对象
+type target struct {
+	name        string            // 限流对象标识, 如: key:sk-xxx
+	rateLimit   *common.RateLimit // 速率限制
+	rpmKey      string            // 占用请求数的窗口
+	tpmKey      string            // 预扣Token的窗口
+	isAcquired  bool              // 是否占用了并发
+	isRequested bool              // 是否占用了请求数
+}
+
+// 会话中的限流状态
+type state struct {
+	member      string    // 并发成员
+	targets     []*target // 已通过的限流对象
+	tokens      int       // 预扣Token数
+	isCharged   bool      // 是否已预扣Token
+	isCorrected bool      // 是否已修正
+}
+
+// 核验结果
+type result struct {
+	rpm        int
+	tpm        int
+	code       int
+	requests   int
+	usedTokens int
+	inflight   int
+}
+
+func init() {
+	service.RegisterRateLimit(New())
+}
+
+func New() service.IRateLimit {
+	return &sRateLimit{}
+}
+
+// 核验密钥、应用和用户的速率限制
+func (s *sRateLimit) Check(ctx context.Context, key *model.AppKey, app *model.App, user *model.User) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sRateLimit Check time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	st := &state{member: gtrace.GetTraceID(ctx)}
+	if st.member == "" {
+		st.member = grand.S(32)
+	}
+
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.SetCtxVar(consts.SESSION_RATE_LIMIT, st)
+	}
+
+	targets := make([]*target, 0)
+
+	if key != nil && isLimit(key.RateLimit) {
+		targets = append(targets, &target{name: "key:" + key.Key, rateLimit: key.RateLimit})
+	}
+
+	if app != nil && isLimit(app.RateLimit) {
+		targets = append(targets, &target{name: fmt.Sprintf("app:%d", app.AppId), rateLimit: app.RateLimit})
+	}
+
+	if user != nil && isLimit(user.RateLimit) {
+		targets = append(targets, &target{name: fmt.Sprintf("user:%d", user.UserId), rateLimit: user.RateLimit})
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	results := make([]*result, 0, len(targets))
+
+	for _, t := range targets {
+
+		res, err := s.check(ctx, st.member, t, t.rateLimit.Rpm, t.rateLimit.Tpm, t.rateLimit.Concurrency, 0)
+		if err != nil {
+			// 限流异常时放行, 不影响正常请求
+			logger.Error(ctx, err)
+			continue
+		}
+
+		if res.code != 0 {
+			setHeaders(ctx, append(results, res))
+			s.rollback(ctx, st)
+			return rateLimitError(ctx, t, res)
+		}
+
+		t.isRequested = t.rateLimit.Rpm > 0
+		t.isAcquired = t.rateLimit.Concurrency > 0
+		st.targets = append(st.targets, t)
+		results = append(results, res)
+	}
+
+	setHeaders(ctx, results)
+
+	return nil
+}
+
+// 是否需要预扣Token
+func (s *sRateLimit) IsLimitTokens(ctx context.Context, group *model.Group) bool {
+
+	st := getState(ctx)
+	if st == nil || st.isCharged {
+		return false
+	}
+
+	if group != nil && group.RateLimit != nil && group.RateLimit.Tpm > 0 {
+		return true
+	}
+
+	for _, t := range st.targets {
+		if t.rateLimit.Tpm > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 核验分组的速率限制并预扣Token
+func (s *sRateLimit) Charge(ctx context.Context, group *model.Group, tokens int) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sRateLimit Charge time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	st := getState(ctx)
+	if st == nil || st.isCharged {
+		return nil
+	}
+
+	st.isCharged = true
+	st.tokens = tokens
+
+	targets := make([]*target, 0)
+	results := make([]*result, 0)
+
+	// 已通过的对象仅需预扣Token
+	for _, t := range st.targets {
+
+		if t.rateLimit.Tpm <= 0 {
+			continue
+		}
+
+		res, err := s.check(ctx, st.member, t, 0, t.rateLimit.Tpm, 0, tokens)
+		if err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		if res.code != 0 {
+			s.refund(ctx, targets, tokens)
+			st.tokens = 0
+			setHeaders(ctx, append(results, res))
+			return rateLimitError(ctx, t, res)
+		}
+
+		targets = append(targets, t)
+		results = append(results, res)
+	}
+
+	if group != nil && isLimit(group.RateLimit) {
+
+		t := &target{name: "group:" + group.Id, rateLimit: group.RateLimit}
+
+		res, err := s.check(ctx, st.member, t, t.rateLimit.Rpm, t.rateLimit.Tpm, t.rateLimit.Concurrency, tokens)
+		if err != nil {
+			logger.Error(ctx, err)
+		} else if res.code != 0 {
+			s.refund(ctx, targets, tokens)
+			st.tokens = 0
+			setHeaders(ctx, append(results, res))
+			return rateLimitError(ctx, t, res)
+		} else {
+			t.isRequested = t.rateLimit.Rpm > 0
+			t.isAcquired = t.rateLimit.Concurrency > 0
+			st.targets = append(st.targets, t)
+			targets = append(targets, t)
+			results = append(results, res)
+		}
+	}
+
+	if len(results) > 0 {
+		setHeaders(ctx, results)
+	}
+
+	return nil
+}
+
+// 按实际用量修正预扣Token
+func (s *sRateLimit) Correct(ctx context.Context, totalTokens int) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sRateLimit Correct time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	st := getState(ctx)
+	if st == nil || st.isCorrected {
+		return
+	}
+
+	st.isCorrected = true
+
+	delta := totalTokens - st.tokens
+	if delta == 0 {
+		return
+	}
+
+	for _, t := range st.targets {
+
+		if t.rateLimit.Tpm <= 0 {
+			continue
+		}
+
+		// 多用的计入当前窗口, 少用的退回预扣时的窗口
+		tpmKey := t.tpmKey
+		if delta > 0 || tpmKey == "" {
+			tpmKey = fmt.Sprintf(consts.RATE_LIMIT_TPM_KEY, t.name, gtime.TimestampMilli()/window)
+		}
+
+		if _, err := redis.Eval(ctx, adjustScript, adjustKeys(t, tpmKey), 0, delta, "", window); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 释放并发
+func (s *sRateLimit) Release(ctx context.Context) {
+
+	st := getState(ctx)
+	if st == nil {
+		return
+	}
+
+	for _, t := range st.targets {
+		if t.isAcquired {
+			if _, err := redis.Eval(ctx, adjustScript, adjustKeys(t, t.tpmKey), 0, 0, st.member, window); err != nil {
+				logger.Error(ctx, err)
+			}
+			t.isAcquired = false
+		}
+	}
+}
+
+// 核验并占用
+func (s *sRateLimit) check(ctx context.Context, member string, t *target, rpm, tpm, concurrency, tokens int) (*result, error) {
+
+	now := gtime.TimestampMilli()
+	current := now / window
+	weight := float64(window-now%window) / float64(window)
+
+	rpmKey := fmt.Sprintf(consts.RATE_LIMIT_RPM_KEY, t.name, current)
+	tpmKey := fmt.Sprintf(consts.RATE_LIMIT_TPM_KEY, t.name, current)
+
+	keys := []string{
+		rpmKey,
+		fmt.Sprintf(consts.RATE_LIMIT_RPM_KEY, t.name, current-1),
+		tpmKey,
+		fmt.Sprintf(consts.RATE_LIMIT_TPM_KEY, t.name, current-1),
+		concurrencyKey(t),
+	}
+
+	reply, err := redis.Eval(ctx, checkScript, keys, rpm, tpm, concurrency, weight, tokens, now, concurrencyExpire(), member, window)
+	if err != nil {
+		return nil, err
+	}
+
+	values := reply.Ints()
+	if len(values) != 4 {
+		return nil, errors.Newf("sRateLimit check unexpected reply: %s", reply.String())
+	}
+
+	res := &result{
+		rpm:        rpm,
+		tpm:        tpm,
+		code:       values[0],
+		requests:   values[1],
+		usedTokens: values[2],
+		inflight:   values[3],
+	}
+
+	if res.code == 0 {
+		if rpm > 0 {
+			t.rpmKey = rpmKey
+		}
+		if tpm > 0 {
+			t.tpmKey = tpmKey
+		}
+	}
+
+	return res, nil
+}
+
+// 回滚已占用的请求数和并发
+func (s *sRateLimit) rollback(ctx context.Context, st *state) {
+
+	for _, t := range st.targets {
+
+		requests := 0
+		if t.isRequested {
+			requests = -1
+		}
+
+		member := ""
+		if t.isAcquired {
+			member = st.member
+		}
+
+		if _, err := redis.Eval(ctx, adjustScript, adjustKeys(t, t.tpmKey), requests, 0, member, window); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		t.isRequested = false
+		t.isAcquired = false
+	}
+
+	st.targets = nil
+}
+
+// 退回已预扣的Token
+func (s *sRateLimit) refund(ctx context.Context, targets []*target, tokens int) {
+
+	if tokens <= 0 {
+		return
+	}
+
+	for _, t := range targets {
+		if _, err := redis.Eval(ctx, adjustScript, adjustKeys(t, t.tpmKey), 0, -tokens, "", window); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+func getState(ctx context.Context) *state {
+
+	val := ctx.Value(consts.SESSION_RATE_LIMIT)
+	if val == nil {
+		return nil
+	}
+
+	if st, ok := val.(*state); ok {
+		return st
+	}
+
+	return nil
+}
+
+func isLimit(rateLimit *common.RateLimit) bool {
+	return rateLimit != nil && (rateLimit.Rpm > 0 || rateLimit.Tpm > 0 || rateLimit.Concurrency > 0)
+}
+
+// 同一对象的Key使用相同的哈希标签, 兼容集群模式
+func adjustKeys(t *target, tpmKey string) []string {
+
+	current := gtime.TimestampMilli() / window
+
+	rpmKey := t.rpmKey
+	if rpmKey == "" {
+		rpmKey = fmt.Sprintf(consts.RATE_LIMIT_RPM_KEY, t.name, current)
+	}
+
+	if tpmKey == "" {
+		tpmKey = fmt.Sprintf(consts.RATE_LIMIT_TPM_KEY, t.name, current)
+	}
+
+	return []string{rpmKey, tpmKey, concurrencyKey(t)}
+}
+
+func concurrencyKey(t *target) string {
+	return fmt.Sprintf(consts.RATE_LIMIT_CONCURRENCY_KEY, t.name)
+}
+
+// 并发成员过期毫秒数, 防止异常退出时并发无法释放
+func concurrencyExpire() int64 {
+
+	if config.Cfg.Base.LongTimeout > 0 {
+		return int64(config.Cfg.Base.LongTimeout*time.Second/time.Millisecond) + window
+	}
+
+	return 10 * window
+}
+
+// 距离当前窗口结束的时长
+func resetDuration() string {
+	return (time.Duration(window-gtime.TimestampMilli()%window) * time.Millisecond).Round(time.Second).String()
+}
+
+// 设置 x-ratelimit-* 响应头, 取剩余最少的
+func setHeaders(ctx context.Context, results []*result) {
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return
+	}
+
+	var (
+		limitRequests, remainingRequests = 0, -1
+		limitTokens, remainingTokens     = 0, -1
+	)
+
+	for _, res := range results {
+
+		if res.rpm > 0 {
+			if remaining := max(res.rpm-res.requests, 0); remainingRequests == -1 || remaining < remainingRequests {
+				limitRequests, remainingRequests = res.rpm, remaining
+			}
+		}
+
+		if res.tpm > 0 {
+			if remaining := max(res.tpm-res.usedTokens, 0); remainingTokens == -1 || remaining < remainingTokens {
+				limitTokens, remainingTokens = res.tpm, remaining
+			}
+		}
+	}
+
+	reset := resetDuration()
+
+	// 已设置过的取剩余更少的
+	if value := r.Response.Header().Get("x-ratelimit-remaining-requests"); value != "" && gconv.Int(value) < remainingRequests {
+		remainingRequests = -1
+	}
+
+	if value := r.Response.Header().Get("x-ratelimit-remaining-tokens"); value != "" && gconv.Int(value) < remainingTokens {
+		remainingTokens = -1
+	}
+
+	if remainingRequests != -1 {
+		r.Response.Header().Set("x-ratelimit-limit-requests", fmt.Sprint(limitRequests))
+		r.Response.Header().Set("x-ratelimit-remaining-requests", fmt.Sprint(remainingRequests))
+		r.Response.Header().Set("x-ratelimit-reset-requests", reset)
+	}
+
+	if remainingTokens != -1 {
+		r.Response.Header().Set("x-ratelimit-limit-tokens", fmt.Sprint(limitTokens))
+		r.Response.Header().Set("x-ratelimit-remaining-tokens", fmt.Sprint(remainingTokens))
+		r.Response.Header().Set("x-ratelimit-reset-tokens", reset)
+	}
+}
+
+func rateLimitError(ctx context.Context, t *target, res *result) error {
+
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.Response.Header().Set("retry-after", fmt.Sprint((window-gtime.TimestampMilli()%window)/1000+1))
+	}
+
+	switch res.code {
+	case 1:
+		logger.Errorf(ctx, "sRateLimit %s rpm limit: %d, used: %d", t.name, t.rateLimit.Rpm, res.requests)
+		return errors.ERR_RATE_LIMIT_REQUESTS
+	case 2:
+		logger.Errorf(ctx, "sRateLimit %s tpm limit: %d, used: %d", t.name, t.rateLimit.Tpm, res.usedTokens)
+		return errors.ERR_RATE_LIMIT_TOKENS
+	default:
+		logger.Errorf(ctx, "sRateLimit %s concurrency limit: %d, inflight: %d", t.name, t.rateLimit.Concurrency, res.inflight)
+		return errors.ERR_RATE_LIMIT_CONCURRENCY
+	}
+}
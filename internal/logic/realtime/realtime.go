@@ -4,7 +4,6 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"slices"
 	"time"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
@@ -78,320 +77,259 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 	}
 
 	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_REALTIME,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		connTime  int64
-		duration  int64
-		totalTime int64
-		retryInfo *mcommon.Retry
+		requestChan chan *smodel.RealtimeRequest
+		connTime    int64
+		duration    int64
+		totalTime   int64
 	)
 
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if err != nil && mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true},
-					Action:            consts.ACTION_REALTIME,
-					Error:             err,
-					RetryInfo:         retryInfo,
-					ConnTime:          connTime,
-					Duration:          duration,
-					TotalTime:         totalTime,
-					InternalTime:      internalTime,
-					EnterTime:         enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[chan *smodel.RealtimeResponse]{
+		Name: "sRealtime Realtime",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_REALTIME,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
-	}()
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (chan *smodel.RealtimeResponse, error) {
 
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
+			mak := attempt.Mak
 
-	requestChan := make(chan *smodel.RealtimeRequest)
+			requestChan = make(chan *smodel.RealtimeRequest)
 
-	response, err := common.NewRealtimeClient(ctx, mak.RealModel, mak.RealKey, mak.BaseUrl, mak.Path).Realtime(ctx, requestChan)
-	if err != nil {
-		logger.Error(ctx, err)
+			return common.NewRealtimeClient(ctx, mak).Realtime(ctx, requestChan)
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, response chan *smodel.RealtimeResponse) (chan *smodel.RealtimeResponse, error) {
 
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+			var (
+				mak       = attempt.Mak
+				retryInfo = attempt.RetryInfo
+				err       error
+			)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+			if err := grpool.AddWithRecover(ctx, func(ctx context.Context) {
 
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				defer close(response)
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+				responseMessage := ""
+				responseCompletion := ""
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+				for {
 
-		if isRetry {
+					response := <-response
 
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
+					if response == nil {
+						return
+					}
 
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
+					connTime = response.ConnTime
+					duration = response.Duration
+					totalTime = response.TotalTime
 
-					if mak.RealModel.IsEnableFallback {
+					if response.Error != nil {
 
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Realtime(g.RequestFromCtx(ctx).GetCtx(), r, params, fallbackModelAgent, fallbackModel)
+						if errors.Is(response.Error, io.EOF) {
+							if err := conn.Close(); err != nil {
+								logger.Error(ctx, err)
 							}
+							return
 						}
 
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Realtime(g.RequestFromCtx(ctx).GetCtx(), r, params, nil, fallbackModel)
-							}
-						}
-					}
+						// 记录错误次数和禁用
+						service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent, response.Error)
 
-					return err
-				}
-			}
+						if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
+							enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
+							internalTime := gtime.TimestampMilli() - enterTime - totalTime
 
-			return s.Realtime(g.RequestFromCtx(ctx).GetCtx(), r, params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
+							common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
+								ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true},
+								Action:            consts.ACTION_REALTIME,
+								Error:             response.Error,
+								RetryInfo:         retryInfo,
+								ConnTime:          connTime,
+								Duration:          duration,
+								TotalTime:         totalTime,
+								InternalTime:      internalTime,
+								EnterTime:         enterTime,
+							})
 
-		return err
-	}
-
-	if err := grpool.AddWithRecover(ctx, func(ctx context.Context) {
-
-		defer close(response)
-
-		responseMessage := ""
-		responseCompletion := ""
-
-		for {
-
-			response := <-response
+						}); err != nil {
+							logger.Error(ctx, err)
+						}
 
-			if response == nil {
-				return
-			}
+						if err := conn.Close(); err != nil {
+							logger.Error(ctx, err)
+						}
 
-			connTime = response.ConnTime
-			duration = response.Duration
-			totalTime = response.TotalTime
+						return
+					}
 
-			if response.Error != nil {
+					logger.Debugf(ctx, "sRealtime Response messageType: %d, message: %s", response.MessageType, response.Message)
 
-				if errors.Is(response.Error, io.EOF) {
-					if err := conn.Close(); err != nil {
-						logger.Error(ctx, err)
+					realtimeResponse := new(model.RealtimeResponse)
+					if err = gjson.Unmarshal(response.Message, &realtimeResponse); err != nil {
+						logger.Errorf(ctx, "sRealtime response.Message: %s, error: %v", response.Message, err)
+						return
 					}
-					return
-				}
 
-				// 记录错误次数和禁用
-				service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
+					switch realtimeResponse.Type {
+					case "conversation.item.input_audio_transcription.completed":
+						if realtimeResponse.Transcript != "" {
+							responseMessage = realtimeResponse.Transcript
+						}
+					case "response.audio_transcript.delta":
+						responseCompletion += realtimeResponse.Delta
+					case "response.text.done":
+						if realtimeResponse.Text != "" {
+							responseCompletion = realtimeResponse.Text
+						}
+					case "response.audio_transcript.done":
+						if realtimeResponse.Transcript != "" {
+							responseCompletion = realtimeResponse.Transcript
+						}
+					case "response.content_part.done":
+						if realtimeResponse.Part.Text != "" {
+							responseCompletion = realtimeResponse.Part.Text
+						}
+						if realtimeResponse.Part.Transcript != "" {
+							responseCompletion = realtimeResponse.Part.Transcript
+						}
+					case "response.output_item.done":
+						if len(realtimeResponse.Item.Content) > 0 {
+							if realtimeResponse.Item.Content[0].Text != "" {
+								responseCompletion = realtimeResponse.Item.Content[0].Text
+							}
+							if realtimeResponse.Item.Content[0].Transcript != "" {
+								responseCompletion = realtimeResponse.Item.Content[0].Transcript
+							}
+						} else if realtimeResponse.Item.Arguments != nil {
+							responseCompletion = gconv.String(realtimeResponse.Item.Arguments)
+						}
+					}
 
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+					if realtimeResponse.Response.Usage.TotalTokens != 0 {
+
+						usage := &smodel.Usage{
+							PromptTokens: realtimeResponse.Response.Usage.InputTokens,
+							PromptTokensDetails: smodel.PromptTokensDetails{
+								TextTokens:   realtimeResponse.Response.Usage.InputTokenDetails.TextTokens,
+								AudioTokens:  realtimeResponse.Response.Usage.InputTokenDetails.AudioTokens,
+								CachedTokens: realtimeResponse.Response.Usage.InputTokenDetails.CachedTokens,
+							},
+							CompletionTokens: realtimeResponse.Response.Usage.OutputTokens,
+							CompletionTokensDetails: smodel.CompletionTokensDetails{
+								TextTokens:  realtimeResponse.Response.Usage.OutputTokenDetails.TextTokens,
+								AudioTokens: realtimeResponse.Response.Usage.OutputTokenDetails.AudioTokens,
+							},
+							TotalTokens: realtimeResponse.Response.Usage.TotalTokens,
+						}
 
-					enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-					internalTime := gtime.TimestampMilli() - enterTime - totalTime
+						message := responseMessage
+						completion := responseCompletion
+
+						if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+							enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
+							internalTime := gtime.TimestampMilli() - enterTime - totalTime
+
+							common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
+								ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true, Messages: []smodel.ChatCompletionMessage{{Content: message}}},
+								Completion:        completion,
+								Action:            consts.ACTION_REALTIME,
+								Usage:             usage,
+								Error:             err,
+								RetryInfo:         retryInfo,
+								ConnTime:          response.ConnTime,
+								Duration:          response.Duration,
+								TotalTime:         response.TotalTime,
+								InternalTime:      internalTime,
+								EnterTime:         enterTime,
+							})
+
+						}); err != nil {
+							logger.Error(ctx, err)
+						}
 
-					common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-						ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true},
-						Action:            consts.ACTION_REALTIME,
-						Error:             response.Error,
-						RetryInfo:         retryInfo,
-						ConnTime:          connTime,
-						Duration:          duration,
-						TotalTime:         totalTime,
-						InternalTime:      internalTime,
-						EnterTime:         enterTime,
-					})
+						responseMessage = ""
+						responseCompletion = ""
+					}
 
-				}); err != nil {
-					logger.Error(ctx, err)
-				}
+					if len(response.Message) > 0 {
+						if err = conn.WriteMessage(response.MessageType, response.Message); err != nil {
+							logger.Error(ctx, err)
+							return
+						}
+					}
 
-				if err := conn.Close(); err != nil {
-					logger.Error(ctx, err)
+					if realtimeResponse.Error.Code != "" {
+						if realtimeResponse.Error.Code == "session_expired" {
+							if err := conn.Close(); err != nil {
+								logger.Error(ctx, err)
+							}
+							return
+						}
+						logger.Error(ctx, realtimeResponse.Error)
+					}
 				}
 
-				return
+			}, nil); err != nil {
+				logger.Error(ctx, err)
+				return response, err
 			}
 
-			logger.Debugf(ctx, "sRealtime Response messageType: %d, message: %s", response.MessageType, response.Message)
+			defer close(requestChan)
 
-			realtimeResponse := new(model.RealtimeResponse)
-			if err = gjson.Unmarshal(response.Message, &realtimeResponse); err != nil {
-				logger.Errorf(ctx, "sRealtime response.Message: %s, error: %v", response.Message, err)
-				return
-			}
+			for {
 
-			switch realtimeResponse.Type {
-			case "conversation.item.input_audio_transcription.completed":
-				if realtimeResponse.Transcript != "" {
-					responseMessage = realtimeResponse.Transcript
-				}
-			case "response.audio_transcript.delta":
-				responseCompletion += realtimeResponse.Delta
-			case "response.text.done":
-				if realtimeResponse.Text != "" {
-					responseCompletion = realtimeResponse.Text
-				}
-			case "response.audio_transcript.done":
-				if realtimeResponse.Transcript != "" {
-					responseCompletion = realtimeResponse.Transcript
-				}
-			case "response.content_part.done":
-				if realtimeResponse.Part.Text != "" {
-					responseCompletion = realtimeResponse.Part.Text
-				}
-				if realtimeResponse.Part.Transcript != "" {
-					responseCompletion = realtimeResponse.Part.Transcript
-				}
-			case "response.output_item.done":
-				if len(realtimeResponse.Item.Content) > 0 {
-					if realtimeResponse.Item.Content[0].Text != "" {
-						responseCompletion = realtimeResponse.Item.Content[0].Text
-					}
-					if realtimeResponse.Item.Content[0].Transcript != "" {
-						responseCompletion = realtimeResponse.Item.Content[0].Transcript
-					}
-				} else if realtimeResponse.Item.Arguments != nil {
-					responseCompletion = gconv.String(realtimeResponse.Item.Arguments)
-				}
-			}
+				messageType, message, err := conn.ReadMessage()
+				if err != nil {
 
-			if realtimeResponse.Response.Usage.TotalTokens != 0 {
-
-				usage := &smodel.Usage{
-					PromptTokens: realtimeResponse.Response.Usage.InputTokens,
-					PromptTokensDetails: smodel.PromptTokensDetails{
-						TextTokens:   realtimeResponse.Response.Usage.InputTokenDetails.TextTokens,
-						AudioTokens:  realtimeResponse.Response.Usage.InputTokenDetails.AudioTokens,
-						CachedTokens: realtimeResponse.Response.Usage.InputTokenDetails.CachedTokens,
-					},
-					CompletionTokens: realtimeResponse.Response.Usage.OutputTokens,
-					CompletionTokensDetails: smodel.CompletionTokensDetails{
-						TextTokens:  realtimeResponse.Response.Usage.OutputTokenDetails.TextTokens,
-						AudioTokens: realtimeResponse.Response.Usage.OutputTokenDetails.AudioTokens,
-					},
-					TotalTokens: realtimeResponse.Response.Usage.TotalTokens,
-				}
+					requestChan <- nil
+
+					if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+						return response, nil
+					}
 
-				message := responseMessage
-				completion := responseCompletion
-
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-					enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-					internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-					common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-						ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true, Messages: []smodel.ChatCompletionMessage{{Content: message}}},
-						Completion:        completion,
-						Action:            consts.ACTION_REALTIME,
-						Usage:             usage,
-						Error:             err,
-						RetryInfo:         retryInfo,
-						ConnTime:          response.ConnTime,
-						Duration:          response.Duration,
-						TotalTime:         response.TotalTime,
-						InternalTime:      internalTime,
-						EnterTime:         enterTime,
-					})
-
-				}); err != nil {
 					logger.Error(ctx, err)
+					return response, err
 				}
 
-				responseMessage = ""
-				responseCompletion = ""
-			}
+				logger.Debugf(ctx, "sRealtime Request messageType: %d, message: %s", messageType, message)
 
-			if len(response.Message) > 0 {
-				if err = conn.WriteMessage(response.MessageType, response.Message); err != nil {
+				if err := service.Auth().VerifySecretKey(ctx, service.Session().GetSecretKey(ctx)); err != nil {
 					logger.Error(ctx, err)
-					return
+					requestChan <- nil
+					return response, err
 				}
-			}
 
-			if realtimeResponse.Error.Code != "" {
-				if realtimeResponse.Error.Code == "session_expired" {
-					if err := conn.Close(); err != nil {
-						logger.Error(ctx, err)
-					}
-					return
+				requestChan <- &smodel.RealtimeRequest{
+					MessageType: messageType,
+					Message:     message,
 				}
-				logger.Error(ctx, realtimeResponse.Error)
 			}
-		}
-
-	}, nil); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
-
-	defer close(requestChan)
-
-	for {
-
-		messageType, message, err := conn.ReadMessage()
-		if err != nil {
-
-			requestChan <- nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, _ chan *smodel.RealtimeResponse, err error) *mcommon.AfterHandler {
 
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+			// 会话中的用量由响应处理逐轮记录, 此处仅记录错误
+			if err == nil {
 				return nil
 			}
 
-			logger.Error(ctx, err)
-			return err
-		}
-
-		logger.Debugf(ctx, "sRealtime Request messageType: %d, message: %s", messageType, message)
+			return &mcommon.AfterHandler{
+				ChatCompletionReq: smodel.ChatCompletionRequest{Stream: true},
+				Action:            consts.ACTION_REALTIME,
+				ConnTime:          connTime,
+				Duration:          duration,
+				TotalTime:         totalTime,
+			}
+		},
+	}
 
-		if err := service.Auth().VerifySecretKey(ctx, service.Session().GetSecretKey(ctx)); err != nil {
-			logger.Error(ctx, err)
-			requestChan <- nil
-			return err
-		}
+	_, err = pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 
-		requestChan <- &smodel.RealtimeRequest{
-			MessageType: messageType,
-			Message:     message,
-		}
-	}
+	return err
 }
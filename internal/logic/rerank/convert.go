@@ -0,0 +1,198 @@
+package rerank
+
+import (
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// 上游重排序格式转换
+type converter interface {
+	// 上游路径, 模型代理未配置路径时使用
+	Path() string
+	// 转换为上游请求
+	ConvRequest(request model.RerankRequest, documents []string) ([]byte, error)
+	// 转换为统一响应
+	ConvResponse(data []byte) (model.RerankResponse, error)
+}
+
+// 按提供商代码选择转换器, 未知提供商按Jina兼容格式
+func newConverter(providerCode string) converter {
+
+	if gstr.ContainsI(providerCode, "cohere") {
+		return &cohere{}
+	}
+
+	if gstr.ContainsI(providerCode, "voyage") {
+		return &voyage{}
+	}
+
+	return &jina{}
+}
+
+// 文档转换为文本, 兼容字符串和包含text的对象
+func documentTexts(documents []any) []string {
+
+	texts := make([]string, 0, len(documents))
+
+	for _, document := range documents {
+		if content, ok := document.(map[string]any); ok && content["text"] != nil {
+			texts = append(texts, gconv.String(content["text"]))
+		} else {
+			texts = append(texts, gconv.String(document))
+		}
+	}
+
+	return texts
+}
+
+// Cohere v2
+type cohere struct{}
+
+func (c *cohere) Path() string {
+	return "/v2/rerank"
+}
+
+func (c *cohere) ConvRequest(request model.RerankRequest, documents []string) ([]byte, error) {
+
+	data := g.Map{
+		"model":     request.Model,
+		"query":     request.Query,
+		"documents": documents,
+	}
+
+	if request.TopN > 0 {
+		data["top_n"] = request.TopN
+	}
+
+	return gjson.Encode(data)
+}
+
+func (c *cohere) ConvResponse(data []byte) (response model.RerankResponse, err error) {
+
+	res := struct {
+		Id      string               `json:"id"`
+		Results []model.RerankResult `json:"results"`
+		Meta    struct {
+			BilledUnits struct {
+				SearchUnits int `json:"search_units"`
+			} `json:"billed_units"`
+		} `json:"meta"`
+	}{}
+
+	if err = gjson.Unmarshal(data, &res); err != nil {
+		return response, err
+	}
+
+	response.Id = res.Id
+	response.Results = res.Results
+	response.Usage = &model.RerankUsage{
+		SearchUnits: res.Meta.BilledUnits.SearchUnits,
+	}
+
+	return response, nil
+}
+
+// Jina, 以及兼容Jina格式的上游
+type jina struct{}
+
+func (j *jina) Path() string {
+	return consts.ENDPOINT_RERANK
+}
+
+func (j *jina) ConvRequest(request model.RerankRequest, documents []string) ([]byte, error) {
+
+	data := g.Map{
+		"model":            request.Model,
+		"query":            request.Query,
+		"documents":        documents,
+		"return_documents": request.ReturnDocuments,
+	}
+
+	if request.TopN > 0 {
+		data["top_n"] = request.TopN
+	}
+
+	return gjson.Encode(data)
+}
+
+func (j *jina) ConvResponse(data []byte) (response model.RerankResponse, err error) {
+
+	res := struct {
+		Model   string               `json:"model"`
+		Results []model.RerankResult `json:"results"`
+		Usage   *model.RerankUsage   `json:"usage"`
+	}{}
+
+	if err = gjson.Unmarshal(data, &res); err != nil {
+		return response, err
+	}
+
+	response.Model = res.Model
+	response.Results = res.Results
+	response.Usage = res.Usage
+
+	return response, nil
+}
+
+// Voyage
+type voyage struct{}
+
+func (v *voyage) Path() string {
+	return consts.ENDPOINT_RERANK
+}
+
+func (v *voyage) ConvRequest(request model.RerankRequest, documents []string) ([]byte, error) {
+
+	data := g.Map{
+		"model":            request.Model,
+		"query":            request.Query,
+		"documents":        documents,
+		"return_documents": request.ReturnDocuments,
+	}
+
+	if request.TopN > 0 {
+		data["top_k"] = request.TopN
+	}
+
+	return gjson.Encode(data)
+}
+
+func (v *voyage) ConvResponse(data []byte) (response model.RerankResponse, err error) {
+
+	res := struct {
+		Model string `json:"model"`
+		Data  []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+			Document       string  `json:"document"`
+		} `json:"data"`
+		Usage *model.RerankUsage `json:"usage"`
+	}{}
+
+	if err = gjson.Unmarshal(data, &res); err != nil {
+		return response, err
+	}
+
+	response.Model = res.Model
+	response.Usage = res.Usage
+
+	for _, result := range res.Data {
+
+		rerankResult := model.RerankResult{
+			Index:          result.Index,
+			RelevanceScore: result.RelevanceScore,
+		}
+
+		if result.Document != "" {
+			rerankResult.Document = &model.RerankDocument{Text: result.Document}
+		}
+
+		response.Results = append(response.Results, rerankResult)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,156 @@
+package rerank
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gtime"
+	sconsts "github.com/iimeta/fastapi-sdk/v2/consts"
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/logic/common"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+type sRerank struct{}
+
+func init() {
+	service.RegisterRerank(New())
+}
+
+func New() service.IRerank {
+	return &sRerank{}
+}
+
+// Rerank
+func (s *sRerank) Rerank(ctx context.Context, params model.RerankRequest, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (response model.RerankResponse, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sRerank Rerank time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	documents := documentTexts(params.Documents)
+
+	var (
+		request model.RerankRequest
+		conv    converter
+		data    []byte
+		res     smodel.ChatCompletionResponse
+	)
+
+	pipeline := &common.Pipeline[model.RerankResponse]{
+		Name: "sRerank Rerank",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_RERANK,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Transform: func(ctx context.Context, attempt *common.Attempt) (err error) {
+
+			request = params
+			request.Model = attempt.UpstreamModel(ctx, request.Model)
+
+			conv = newConverter(common.GetProviderCode(ctx, attempt.Mak.Provider))
+
+			if data, err = conv.ConvRequest(request, documents); err != nil {
+				return err
+			}
+
+			attempt.Mak.SetUpstreamPath(conv.Path())
+
+			return nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response model.RerankResponse, err error) {
+
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "Rerank")
+			res, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).ChatCompletions(upstreamCtx, data)
+			common.EndSpan(upstreamSpan, err)
+
+			return response, err
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, _ model.RerankResponse) (model.RerankResponse, error) {
+
+			response, err := conv.ConvResponse(res.ResponseBytes)
+			if err != nil {
+				logger.Error(ctx, err)
+				return response, err
+			}
+
+			response.ResponseHeaders = res.ResponseHeaders
+			response.TotalTime = res.TotalTime
+
+			if response.Model == "" {
+				response.Model = request.Model
+			}
+
+			// 按请求参数返回文档, 上游未返回时使用请求中的文档
+			for i, result := range response.Results {
+				if !params.ReturnDocuments {
+					response.Results[i].Document = nil
+				} else if result.Document == nil && result.Index >= 0 && result.Index < len(documents) {
+					response.Results[i].Document = &model.RerankDocument{Text: documents[result.Index]}
+				}
+			}
+
+			return response, nil
+		},
+		Finish: func(ctx context.Context, attempt *common.Attempt, response *model.RerankResponse) {
+			// 替换成调用的模型
+			if attempt.Mak.ReqModel.IsEnableForward {
+				response.Model = attempt.Mak.ReqModel.Model
+			}
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res model.RerankResponse, err error) *mcommon.AfterHandler {
+
+			// 查询和文档作为消息, 用于计算令牌数和记录日志
+			messages := []smodel.ChatCompletionMessage{{
+				Role:    sconsts.ROLE_USER,
+				Content: params.Query,
+			}}
+
+			for _, document := range documents {
+				messages = append(messages, smodel.ChatCompletionMessage{
+					Role:    sconsts.ROLE_USER,
+					Content: document,
+				})
+			}
+
+			after := &mcommon.AfterHandler{
+				ChatCompletionReq: smodel.ChatCompletionRequest{
+					Model:    params.Model,
+					Messages: messages,
+				},
+				RerankDocuments: len(documents),
+				Action:          consts.ACTION_RERANK,
+				TotalTime:       res.TotalTime,
+			}
+
+			if len(res.Results) > 0 {
+				after.Completion = gjson.MustEncodeString(res.Results)
+			}
+
+			if res.Usage != nil {
+
+				after.RerankSearchUnits = res.Usage.SearchUnits
+
+				if res.Usage.TotalTokens > 0 {
+					after.Usage = &smodel.Usage{
+						PromptTokens: res.Usage.TotalTokens,
+						TotalTokens:  res.Usage.TotalTokens,
+					}
+				}
+			}
+
+			return after
+		},
+	}
+
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
+}
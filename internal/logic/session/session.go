@@ -349,3 +349,47 @@ func (s *sSession) GetSessionKey(ctx context.Context) *common.SessionKey {
 
 	return nil
 }
+
+// 保存后备链执行状态
+func (s *sSession) SaveFallbackState(ctx context.Context, state *common.FallbackState) {
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.SetCtxVar(consts.SESSION_FALLBACK_STATE, state)
+	}
+}
+
+// 获取后备链执行状态
+func (s *sSession) GetFallbackState(ctx context.Context) *common.FallbackState {
+
+	val := ctx.Value(consts.SESSION_FALLBACK_STATE)
+	if val == nil {
+		return nil
+	}
+
+	if state, ok := val.(*common.FallbackState); ok {
+		return state
+	}
+
+	return nil
+}
+
+// 保存提示词模板
+func (s *sSession) SavePrompt(ctx context.Context, prompt *common.Prompt) {
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.SetCtxVar(consts.SESSION_PROMPT, prompt)
+	}
+}
+
+// 获取提示词模板
+func (s *sSession) GetPrompt(ctx context.Context) *common.Prompt {
+
+	val := ctx.Value(consts.SESSION_PROMPT)
+	if val == nil {
+		return nil
+	}
+
+	if prompt, ok := val.(*common.Prompt); ok {
+		return prompt
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package usage_export
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+	"github.com/iimeta/fastapi/v2/utility/spool"
+)
+
+// 导出器, 每个导出目标独立的内存队列, 批量导出失败重试后仍失败或队列已满时追加到本地积压文件, 恢复后按顺序重放
+type exporter struct {
+	name          string
+	sink          sink
+	maxRetries    int
+	retryInterval time.Duration
+	queue         *spool.Queue[*model.UsageEvent]
+}
+
+func newExporter(ctx context.Context, name string, sink sink, options *options) (*exporter, error) {
+
+	eventSpool, err := spool.New(options.spoolPath(name), spool.Codec[*model.UsageEvent]{
+		Marshal: func(event *model.UsageEvent) ([]byte, error) {
+			return json.Marshal(event)
+		},
+		Unmarshal: func(data []byte) (*model.UsageEvent, error) {
+			event := new(model.UsageEvent)
+			return event, json.Unmarshal(data, event)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &exporter{
+		name:          name,
+		sink:          sink,
+		maxRetries:    options.maxRetries,
+		retryInterval: options.retryInterval,
+	}
+
+	e.queue = spool.NewQueue(ctx, eventSpool, spool.Options{
+		Name:          "sUsageExport sink: " + name,
+		QueueSize:     options.queueSize,
+		BatchSize:     options.batchSize,
+		FlushInterval: options.flushInterval,
+	}, spool.Handler[*model.UsageEvent]{
+		Flush:   e.flush,
+		Replay:  e.replay,
+		Spooled: e.spooled,
+		Backlog: func(ctx context.Context, queued int, pending int64) {
+			service.Metrics().UsageExportBacklog(ctx, e.name, queued, pending)
+		},
+	})
+
+	return e, nil
+}
+
+// 导出事件, 队列已满或已关闭时直接写入本地积压文件
+func (e *exporter) export(ctx context.Context, event *model.UsageEvent) {
+	if err := e.queue.Put(ctx, event); err != nil {
+		logger.Errorf(ctx, "sUsageExport sink: %s export event_id: %s, error: %v", e.name, event.EventId, err)
+	}
+}
+
+// 批量导出, 返回导出失败的事件
+func (e *exporter) flush(ctx context.Context, events []*model.UsageEvent) []*model.UsageEvent {
+
+	if err := e.send(ctx, events); err != nil {
+		logger.Errorf(ctx, "sUsageExport sink: %s export error: %v", e.name, err)
+		return events
+	}
+
+	service.Metrics().UsageExport(ctx, e.name, "sent", len(events))
+
+	return nil
+}
+
+// 导出失败按间隔翻倍重试, 关闭时不重试
+func (e *exporter) send(ctx context.Context, events []*model.UsageEvent) (err error) {
+
+	interval := e.retryInterval
+
+	for i := 0; ; i++ {
+
+		if err = e.sink.send(ctx, events); err == nil || i >= e.maxRetries {
+			return err
+		}
+
+		logger.Errorf(ctx, "sUsageExport sink: %s export error: %v, retry: %d after %s", e.name, err, i+1, interval)
+
+		select {
+		case <-time.After(interval):
+		case <-e.queue.Closed():
+			return err
+		}
+
+		interval *= 2
+	}
+}
+
+// 重放本地积压文件中的事件, 不重试
+func (e *exporter) replay(ctx context.Context, events []*model.UsageEvent) error {
+
+	if err := e.sink.send(ctx, events); err != nil {
+		return err
+	}
+
+	service.Metrics().UsageExport(ctx, e.name, "sent", len(events))
+
+	return nil
+}
+
+func (e *exporter) spooled(ctx context.Context, events []*model.UsageEvent, err error) {
+
+	result := "spool"
+	if err != nil {
+		result = "dropped"
+	}
+
+	service.Metrics().UsageExport(ctx, e.name, result, len(events))
+}
+
+// 关闭导出, 队列中的事件导出或写入本地积压文件
+func (e *exporter) close(ctx context.Context) {
+
+	e.queue.Close(ctx)
+
+	if err := e.sink.close(); err != nil {
+		logger.Errorf(ctx, "sUsageExport sink: %s close error: %v", e.name, err)
+	}
+}
@@ -0,0 +1,153 @@
+package usage_export
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// JSONL文件, 每行一个事件, 超过大小后轮转为 文件名-时间.扩展名
+type fileSink struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(cfg config.UsageExportSink) (sink, error) {
+
+	s := &fileSink{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSize) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+	}
+
+	if s.path == "" {
+		s.path = "./usage/usage.jsonl"
+	}
+
+	if s.maxSize <= 0 {
+		s.maxSize = 100 * 1024 * 1024
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileSink) send(ctx context.Context, events []*model.UsageEvent) error {
+
+	data := make([]byte, 0)
+	for _, event := range events {
+
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		data = append(append(data, bytes...), '\n')
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+func (s *fileSink) close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *fileSink) open() error {
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+// 轮转文件, 超过保留数量时删除最早的文件
+func (s *fileSink) rotate() error {
+
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(s.path)
+	prefix := strings.TrimSuffix(s.path, ext) + "-"
+
+	if err := os.Rename(s.path, prefix+time.Now().Format("20060102150405.000")+ext); err != nil {
+		// 轮转失败时继续写入原文件
+		if e := s.open(); e != nil {
+			return e
+		}
+		return err
+	}
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if s.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return err
+	}
+
+	slices.Sort(backups)
+
+	for len(backups) > s.maxBackups {
+		if err = os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
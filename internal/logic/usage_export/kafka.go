@@ -0,0 +1,441 @@
+package usage_export
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+const (
+	kafkaApiProduce         = 0
+	kafkaApiMetadata        = 3
+	kafkaProduceVersion     = 3 // Kafka 0.11+, 使用 v2 消息格式
+	kafkaMetadataVersion    = 4 // Kafka 1.0+
+	kafkaClientId           = "fastapi"
+	kafkaMaxResponseSize    = 64 * 1024 * 1024
+	kafkaRecordBatchMagic   = 2
+	kafkaAcksAll            = -1
+	kafkaNullLength         = -1
+	kafkaSchemaVersionField = "schema_version"
+)
+
+var kafkaCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka协议生产者, 兼容Kafka及Redpanda等, 消息Key为事件ID, 按Key哈希分区, acks=all, 暂不支持认证和压缩
+type kafkaSink struct {
+	brokers       []string
+	topic         string
+	timeout       time.Duration
+	correlationId atomic.Int32
+}
+
+func newKafkaSink(cfg config.UsageExportSink, timeout time.Duration) (sink, error) {
+
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka brokers is empty")
+	}
+
+	if cfg.Topic == "" {
+		return nil, errors.New("kafka topic is empty")
+	}
+
+	return &kafkaSink{
+		brokers: cfg.Brokers,
+		topic:   cfg.Topic,
+		timeout: timeout,
+	}, nil
+}
+
+func (s *kafkaSink) send(ctx context.Context, events []*model.UsageEvent) error {
+
+	leaders, err := s.metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	partitions := make([]int32, 0, len(leaders))
+	for partition := range leaders {
+		partitions = append(partitions, partition)
+	}
+
+	slices.Sort(partitions)
+
+	// 按首领节点分组, 每个节点一次请求
+	requests := make(map[string]map[int32][]*model.UsageEvent)
+	for _, event := range events {
+
+		partition := partitions[crc32.ChecksumIEEE([]byte(event.EventId))%uint32(len(partitions))]
+
+		addr := leaders[partition]
+		if requests[addr] == nil {
+			requests[addr] = make(map[int32][]*model.UsageEvent)
+		}
+
+		requests[addr][partition] = append(requests[addr][partition], event)
+	}
+
+	for addr, partitionEvents := range requests {
+		if err = s.produce(ctx, addr, partitionEvents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *kafkaSink) close() error {
+	return nil
+}
+
+// 获取主题各分区的首领节点地址
+func (s *kafkaSink) metadata(ctx context.Context) (map[int32]string, error) {
+
+	e := new(kafkaEncoder)
+	e.int32(1)
+	e.string(s.topic)
+	e.int8(0) // allow_auto_topic_creation
+
+	var (
+		response []byte
+		err      error
+	)
+
+	for _, broker := range s.brokers {
+		if response, err = s.request(ctx, broker, kafkaApiMetadata, kafkaMetadataVersion, e.buf); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d := &kafkaDecoder{buf: response}
+
+	d.int32() // throttle_time_ms
+
+	brokers := make(map[int32]string)
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+
+		nodeId := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+
+		brokers[nodeId] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	d.string() // cluster_id
+	d.int32()  // controller_id
+
+	leaders := make(map[int32]string)
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+
+		errorCode := d.int16()
+		topic := d.string()
+		d.int8() // is_internal
+
+		if errorCode != 0 && topic == s.topic {
+			return nil, fmt.Errorf("kafka topic: %s metadata error code: %d", topic, errorCode)
+		}
+
+		for j, m := 0, d.int32(); j < int(m) && d.err == nil; j++ {
+
+			d.int16() // error_code
+			partition := d.int32()
+			leader := d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // isr
+
+			if addr, ok := brokers[leader]; ok && topic == s.topic {
+				leaders[partition] = addr
+			}
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka topic: %s has no available partition leader", s.topic)
+	}
+
+	return leaders, nil
+}
+
+// 发送消息到首领节点
+func (s *kafkaSink) produce(ctx context.Context, addr string, partitionEvents map[int32][]*model.UsageEvent) error {
+
+	e := new(kafkaEncoder)
+	e.int16(kafkaNullLength) // transactional_id
+	e.int16(kafkaAcksAll)
+	e.int32(int32(s.timeout.Milliseconds()))
+	e.int32(1)
+	e.string(s.topic)
+	e.int32(int32(len(partitionEvents)))
+
+	for partition, events := range partitionEvents {
+
+		batch, err := kafkaRecordBatch(events)
+		if err != nil {
+			return err
+		}
+
+		e.int32(partition)
+		e.bytes(batch)
+	}
+
+	response, err := s.request(ctx, addr, kafkaApiProduce, kafkaProduceVersion, e.buf)
+	if err != nil {
+		return err
+	}
+
+	d := &kafkaDecoder{buf: response}
+
+	for i, n := 0, d.int32(); i < int(n) && d.err == nil; i++ {
+
+		topic := d.string()
+
+		for j, m := 0, d.int32(); j < int(m) && d.err == nil; j++ {
+
+			partition := d.int32()
+			errorCode := d.int16()
+			d.int64() // base_offset
+			d.int64() // log_append_time
+
+			if errorCode != 0 {
+				return fmt.Errorf("kafka topic: %s, partition: %d produce error code: %d", topic, partition, errorCode)
+			}
+		}
+	}
+
+	return d.err
+}
+
+// 发送请求并读取响应, 每次请求新建连接
+func (s *kafkaSink) request(ctx context.Context, addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err = conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return nil, err
+	}
+
+	correlationId := s.correlationId.Add(1)
+
+	e := new(kafkaEncoder)
+	e.int32(0) // 请求长度, 最后填充
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationId)
+	e.string(kafkaClientId)
+	e.buf = append(e.buf, body...)
+
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	if _, err = conn.Write(e.buf); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("kafka broker: %s invalid response size: %d", addr, size)
+	}
+
+	response := make([]byte, size)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	if id := int32(binary.BigEndian.Uint32(response)); id != correlationId {
+		return nil, fmt.Errorf("kafka broker: %s correlation id mismatch, expected: %d, got: %d", addr, correlationId, id)
+	}
+
+	return response[4:], nil
+}
+
+// v2 消息格式的批次, 不压缩
+func kafkaRecordBatch(events []*model.UsageEvent) ([]byte, error) {
+
+	timestamp := time.Now().UnixMilli()
+
+	records := make([]byte, 0)
+	for i, event := range events {
+
+		value, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		record := []byte{0}                            // attributes
+		record = binary.AppendVarint(record, 0)        // timestamp_delta
+		record = binary.AppendVarint(record, int64(i)) // offset_delta
+		record = kafkaAppendVarBytes(record, []byte(event.EventId))
+		record = kafkaAppendVarBytes(record, value)
+		record = binary.AppendVarint(record, 1) // headers
+		record = kafkaAppendVarBytes(record, []byte(kafkaSchemaVersionField))
+		record = kafkaAppendVarBytes(record, []byte(event.SchemaVersion))
+
+		records = binary.AppendVarint(records, int64(len(record)))
+		records = append(records, record...)
+	}
+
+	// 校验和覆盖 attributes 到批次结尾
+	body := new(kafkaEncoder)
+	body.int16(0)                      // attributes
+	body.int32(int32(len(events) - 1)) // last_offset_delta
+	body.int64(timestamp)              // base_timestamp
+	body.int64(timestamp)              // max_timestamp
+	body.int64(-1)                     // producer_id
+	body.int16(-1)                     // producer_epoch
+	body.int32(-1)                     // base_sequence
+	body.int32(int32(len(events)))
+	body.buf = append(body.buf, records...)
+
+	batch := new(kafkaEncoder)
+	batch.int64(0)                                // base_offset
+	batch.int32(int32(4 + 1 + 4 + len(body.buf))) // batch_length
+	batch.int32(-1)                               // partition_leader_epoch
+	batch.int8(kafkaRecordBatchMagic)
+	batch.int32(int32(crc32.Checksum(body.buf, kafkaCrcTable)))
+	batch.buf = append(batch.buf, body.buf...)
+
+	return batch.buf, nil
+}
+
+func kafkaAppendVarBytes(buf, value []byte) []byte {
+	return append(binary.AppendVarint(buf, int64(len(value))), value...)
+}
+
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(d.buf) < n {
+		d.err = errors.New("kafka response is truncated")
+		return nil
+	}
+
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if v := d.next(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if v := d.next(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+// 字符串, 长度为 -1 时为 null
+func (d *kafkaDecoder) string() string {
+
+	n := d.int16()
+	if n == kafkaNullLength {
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() []int32 {
+
+	n := d.int32()
+	if n <= 0 {
+		return nil
+	}
+
+	values := make([]int32, 0, min(int(n), len(d.buf)/4))
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		values = append(values, d.int32())
+	}
+
+	return values
+}
@@ -0,0 +1,35 @@
+package usage_export
+
+import (
+	"context"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+// 导出目标, 整批成功才返回 nil, 失败时整批重试, 下游按事件ID去重
+type sink interface {
+	send(ctx context.Context, events []*model.UsageEvent) error
+	close() error
+}
+
+func newSink(cfg config.UsageExportSink) (sink, error) {
+
+	timeout := 10 * time.Second
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	switch cfg.Type {
+	case "webhook":
+		return newWebhookSink(cfg, timeout)
+	case "file":
+		return newFileSink(cfg)
+	case "kafka":
+		return newKafkaSink(cfg, timeout)
+	}
+
+	return nil, errors.Newf("unsupported type: %s", cfg.Type)
+}
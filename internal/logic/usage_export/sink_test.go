@@ -0,0 +1,254 @@
+package usage_export
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	_ "github.com/iimeta/fastapi/v2/internal/logic/metrics"
+	"github.com/iimeta/fastapi/v2/internal/model"
+)
+
+const testSecret = "test-secret"
+
+// 本地接收服务, 与 usage-receiver 相同的签名校验和事件ID去重, 可指定前几次请求失败
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	requests int
+	seen     map[string]bool
+	events   []string
+}
+
+func newReceiver(t *testing.T, failures int) (*httptest.Server, *receiver) {
+
+	r := &receiver{
+		failures: failures,
+		seen:     make(map[string]bool),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(server.Close)
+
+	return server, r
+}
+
+func (r *receiver) serve(w http.ResponseWriter, req *http.Request) {
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !hmac.Equal([]byte(signature(testSecret, req.Header.Get(consts.USAGE_TIMESTAMP_HEADER), body)), []byte(req.Header.Get(consts.USAGE_SIGNATURE_HEADER))) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	payload := new(webhookBody)
+	if err = json.Unmarshal(body, payload); err != nil || payload.SchemaVersion != consts.USAGE_EVENT_SCHEMA_VERSION {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.requests++; r.requests <= r.failures {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	for _, event := range payload.Events {
+		if !r.seen[event.EventId] {
+			r.seen[event.EventId] = true
+			r.events = append(r.events, event.EventId)
+		}
+	}
+}
+
+func (r *receiver) received() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.events)
+}
+
+func testEvents(ids ...string) []*model.UsageEvent {
+
+	events := make([]*model.UsageEvent, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, &model.UsageEvent{
+			SchemaVersion: consts.USAGE_EVENT_SCHEMA_VERSION,
+			EventId:       id,
+			EventTime:     time.Now().UnixMilli(),
+		})
+	}
+
+	return events
+}
+
+func TestWebhookSink(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		failures int
+		secret   string
+		wantErr  bool
+		wantIds  []string
+	}{
+		{
+			name:    "sent",
+			secret:  testSecret,
+			wantIds: []string{"e1", "e2"},
+		},
+		{
+			name:     "server error",
+			failures: 1,
+			secret:   testSecret,
+			wantErr:  true,
+		},
+		{
+			name:    "invalid signature",
+			secret:  "other-secret",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			server, r := newReceiver(t, tt.failures)
+
+			sink, err := newSink(config.UsageExportSink{
+				Type:    "webhook",
+				Url:     server.URL,
+				Secret:  tt.secret,
+				Headers: map[string]string{"X-Test": "1"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() {
+				_ = sink.close()
+			}()
+
+			if err = sink.send(context.Background(), testEvents("e1", "e2")); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if ids := r.received(); !slices.Equal(ids, tt.wantIds) {
+				t.Fatalf("received = %v, want %v", ids, tt.wantIds)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "usage", "usage.jsonl")
+
+	s, err := newFileSink(config.UsageExportSink{
+		Type:       "file",
+		Path:       path,
+		MaxBackups: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = s.close()
+	}()
+
+	// 超过大小后轮转, 仅保留最新的轮转文件
+	fs := s.(*fileSink)
+	fs.maxSize = 200
+
+	for i := 0; i < 5; i++ {
+
+		if err = s.send(context.Background(), testEvents(fmt.Sprintf("e%d", i))); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	event := new(model.UsageEvent)
+	if err = json.Unmarshal([]byte(lines[len(lines)-1]), event); err != nil || event.EventId != "e4" {
+		t.Fatalf("last event = %s, err = %v, want e4", lines[len(lines)-1], err)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(filepath.Dir(path), "usage-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+}
+
+func TestExporterReplay(t *testing.T) {
+
+	// 前两次推送失败, 不重试时写入本地积压文件, 恢复后按顺序重放
+	server, r := newReceiver(t, 2)
+
+	sink, err := newSink(config.UsageExportSink{
+		Type:   "webhook",
+		Url:    server.URL,
+		Secret: testSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := newExporter(context.Background(), "webhook_test", sink, &options{
+		queueSize:     100,
+		batchSize:     2,
+		flushInterval: 20 * time.Millisecond,
+		retryInterval: time.Millisecond,
+		spoolDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer e.close(context.Background())
+
+	for _, event := range testEvents("e1", "e2", "e3", "e4", "e5") {
+		e.export(context.Background(), event)
+	}
+
+	want := []string{"e1", "e2", "e3", "e4", "e5"}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.received()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if ids := r.received(); !slices.Equal(ids, want) {
+		t.Fatalf("received = %v, want %v", ids, want)
+	}
+}
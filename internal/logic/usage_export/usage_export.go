@@ -0,0 +1,134 @@
+package usage_export
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/internal/service"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+type sUsageExport struct {
+	exporters []*exporter
+}
+
+type options struct {
+	queueSize     int
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryInterval time.Duration
+	spoolDir      string
+}
+
+// 本地积压文件路径, 每个导出目标一个
+func (o *options) spoolPath(name string) string {
+	return filepath.Join(o.spoolDir, fmt.Sprintf("usage_%s.spool", name))
+}
+
+func init() {
+	service.RegisterUsageExport(New())
+}
+
+func New() service.IUsageExport {
+
+	s := &sUsageExport{}
+
+	cfg := config.Cfg.UsageExport
+	if !cfg.Open {
+		return s
+	}
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 1000
+	}
+
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 500
+	}
+
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = "./spool"
+	}
+
+	options := &options{
+		queueSize:     cfg.QueueSize,
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushInterval) * time.Millisecond,
+		maxRetries:    cfg.MaxRetries,
+		retryInterval: time.Duration(cfg.RetryInterval) * time.Millisecond,
+		spoolDir:      cfg.SpoolDir,
+	}
+
+	ctx := gctx.New()
+	names := make(map[string]bool)
+
+	for i, sinkCfg := range cfg.Sinks {
+
+		name := sinkCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("%s_%d", sinkCfg.Type, i)
+		}
+
+		if names[name] {
+			panic(errors.Newf("usage export sink name: %s is duplicated", name))
+		}
+
+		names[name] = true
+
+		sink, err := newSink(sinkCfg)
+		if err != nil {
+			panic(errors.Newf("usage export sink: %s, error: %v", name, err))
+		}
+
+		e, err := newExporter(ctx, name, sink, options)
+		if err != nil {
+			panic(err)
+		}
+
+		s.exporters = append(s.exporters, e)
+
+		logger.Infof(ctx, "sUsageExport sink: %s, type: %s started", name, sinkCfg.Type)
+	}
+
+	return s
+}
+
+// 是否开启
+func (s *sUsageExport) IsOpen() bool {
+	return len(s.exporters) > 0
+}
+
+// 导出用量事件, 投递到所有导出目标
+func (s *sUsageExport) Export(ctx context.Context, event *model.UsageEvent) {
+	for _, e := range s.exporters {
+		e.export(ctx, event)
+	}
+}
+
+// 关闭导出, 队列中的事件导出或写入本地积压文件
+func (s *sUsageExport) Close(ctx context.Context) {
+	for _, e := range s.exporters {
+		e.close(ctx)
+	}
+}
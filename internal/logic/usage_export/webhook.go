@@ -0,0 +1,99 @@
+package usage_export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iimeta/fastapi/v2/internal/config"
+	"github.com/iimeta/fastapi/v2/internal/consts"
+	"github.com/iimeta/fastapi/v2/internal/errors"
+	"github.com/iimeta/fastapi/v2/internal/model"
+	"github.com/iimeta/fastapi/v2/utility/crypto"
+)
+
+// HTTP推送, 请求体为 {"schema_version":"1","events":[...]}, 配置了签名密钥时签名
+type webhookSink struct {
+	url     string
+	secret  string
+	headers map[string]string
+	client  *http.Client
+}
+
+// 推送请求体
+type webhookBody struct {
+	SchemaVersion string              `json:"schema_version"`
+	Events        []*model.UsageEvent `json:"events"`
+}
+
+func newWebhookSink(cfg config.UsageExportSink, timeout time.Duration) (sink, error) {
+
+	if cfg.Url == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+
+	return &webhookSink{
+		url:     cfg.Url,
+		secret:  cfg.Secret,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *webhookSink) send(ctx context.Context, events []*model.UsageEvent) error {
+
+	body, err := json.Marshal(webhookBody{
+		SchemaVersion: consts.USAGE_EVENT_SCHEMA_VERSION,
+		Events:        events,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		request.Header.Set(k, v)
+	}
+
+	if s.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(consts.USAGE_TIMESTAMP_HEADER, timestamp)
+		request.Header.Set(consts.USAGE_SIGNATURE_HEADER, signature(s.secret, timestamp, body))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("webhook export failed, status: %d, body: %s", response.StatusCode, data)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// 推送签名, 接收方使用相同的密钥计算后比对, 并校验时间戳防重放
+func signature(secret, timestamp string, body []byte) string {
+	return "sha256=" + crypto.HmacSHA256(secret, append([]byte(timestamp+"."), body...))
+}
@@ -61,6 +61,7 @@ func (s *sUser) GetByUserId(ctx context.Context, userId int) (*model.User, error
 		QuotaExpiresAt: user.QuotaExpiresAt,
 		Groups:         user.Groups,
 		Privacy:        user.Privacy,
+		RateLimit:      user.RateLimit,
 		Status:         user.Status,
 		Rid:            user.Rid,
 	}, nil
@@ -96,6 +97,7 @@ func (s *sUser) List(ctx context.Context) ([]*model.User, error) {
 			QuotaExpiresAt: result.QuotaExpiresAt,
 			Groups:         result.Groups,
 			Privacy:        result.Privacy,
+			RateLimit:      result.RateLimit,
 			Status:         result.Status,
 			Rid:            result.Rid,
 		})
@@ -193,6 +195,7 @@ func (s *sUser) UpdateCache(ctx context.Context, user *entity.User) {
 		QuotaExpiresAt: user.QuotaExpiresAt,
 		Groups:         user.Groups,
 		Privacy:        user.Privacy,
+		RateLimit:      user.RateLimit,
 		Status:         user.Status,
 		Rid:            user.Rid,
 	}); err != nil {
@@ -3,7 +3,6 @@ package video
 import (
 	"context"
 	"fmt"
-	"slices"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
@@ -50,141 +49,53 @@ func (s *sVideo) Create(ctx context.Context, params *v1.CreateReq, fallbackModel
 		logger.Debugf(ctx, "sVideo Create time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					Action:       consts.ACTION_CREATE,
-					VideoId:      response.Id,
-					Prompt:       params.Prompt,
-					Seconds:      gconv.Int(params.Seconds),
-					Size:         params.Size,
-					RequestData:  util.ConvToMap(params.VideoCreateRequest),
-					ResponseData: util.ConvToMap(response),
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				if params.InputReference != nil {
-					afterHandler.VideoMode = "has_video_input"
-				} else {
-					afterHandler.VideoMode = "no_video_input"
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	videoMode := "no_video_input"
+	if params.InputReference != nil {
+		videoMode = "has_video_input"
+	}
+
+	pipeline := &common.Pipeline[smodel.VideoJobResponse]{
+		Name: "sVideo Create",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+				HoldData: &mcommon.BillingData{
+					Seconds:   gconv.Int(params.Seconds),
+					Size:      params.Size,
+					VideoMode: videoMode,
+				},
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	request := *params
-
-	if mak.ModelAgent != nil && mak.ModelAgent.IsEnableModelReplace {
-		for i, replaceModel := range mak.ModelAgent.ReplaceModels {
-			if replaceModel == request.Model {
-				logger.Infof(ctx, "sVideo Create request.Model: %s replaced %s", request.Model, mak.ModelAgent.TargetModels[i])
-				request.Model = mak.ModelAgent.TargetModels[i]
-				mak.RealModel.Model = request.Model
-				break
-			}
-		}
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).VideoCreate(ctx, request.VideoCreateRequest)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.VideoJobResponse, err error) {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
+			request := *params
+			request.Model = attempt.ReplaceModel(ctx, request.Model)
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
-			}
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "VideoCreate")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).VideoCreate(upstreamCtx, request.VideoCreateRequest)
+			common.EndSpan(upstreamSpan, err)
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.VideoJobResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				Action:       consts.ACTION_CREATE,
+				VideoId:      res.Id,
+				Prompt:       params.Prompt,
+				Seconds:      gconv.Int(params.Seconds),
+				Size:         params.Size,
+				VideoMode:    videoMode,
+				RequestData:  util.ConvToMap(params.VideoCreateRequest),
+				ResponseData: util.ConvToMap(res),
+				TotalTime:    res.TotalTime,
 			}
-
-			return s.Create(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // Remix
@@ -195,43 +106,6 @@ func (s *sVideo) Remix(ctx context.Context, params *v1.RemixReq, fallbackModelAg
 		logger.Debugf(ctx, "sVideo Remix time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo *mcommon.Retry
-	)
-
-	defer func() {
-
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					Action:       consts.ACTION_REMIX,
-					VideoId:      response.Id,
-					RequestData:  util.ConvToMap(params.VideoRemixRequest),
-					ResponseData: util.ConvToMap(response),
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    response.TotalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
 	taskVideo, err := dao.TaskVideo.FindOne(ctx, bson.M{"video_id": params.VideoId, "creator": service.Session().GetSecretKey(ctx)})
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -241,83 +115,36 @@ func (s *sVideo) Remix(ctx context.Context, params *v1.RemixReq, fallbackModelAg
 		return response, err
 	}
 
-	mak.Model = taskVideo.Model
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return response, err
-	}
-
-	response, err = common.NewAdapter(ctx, mak, false).VideoRemix(ctx, params.VideoRemixRequest)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
-
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[smodel.VideoJobResponse]{
+		Name: "sVideo Remix",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              taskVideo.Model,
+				Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
 			}
-		}
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (response smodel.VideoJobResponse, err error) {
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Remix(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.Remix(g.RequestFromCtx(ctx).GetCtx(), params, nil, fallbackModel)
-							}
-						}
-					}
-
-					return response, err
-				}
-			}
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "VideoRemix")
+			response, err = common.NewAdapter(upstreamCtx, attempt.Mak, false).VideoRemix(upstreamCtx, params.VideoRemixRequest)
+			common.EndSpan(upstreamSpan, err)
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
+			return response, err
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, res smodel.VideoJobResponse, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				Action:       consts.ACTION_REMIX,
+				VideoId:      res.Id,
+				RequestData:  util.ConvToMap(params.VideoRemixRequest),
+				ResponseData: util.ConvToMap(res),
+				TotalTime:    res.TotalTime,
 			}
-
-			return s.Remix(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return response, err
+		},
 	}
 
-	return response, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // List
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"math"
 	"net/http"
-	"slices"
 	"time"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
@@ -49,146 +48,75 @@ func (s *sVolcEngine) VideoCreate(ctx context.Context, request *ghttp.Request, f
 	}()
 
 	var (
-		params = convCreateRequest(request)
-		mak    = &common.MAK{
-			Model:              params.Model,
-			Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo      *mcommon.Retry
-		totalTime      int64
+		params         = convCreateRequest(request)
 		responseHeader http.Header
+		totalTime      int64
 	)
 
-	defer func() {
-
-		totalTime = gtime.TimestampMilli() - now
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				afterHandler := &mcommon.AfterHandler{
-					Action:             consts.ACTION_CREATE,
-					VideoMode:          detectVideoMode(params),
-					IsVolcEngine:       true,
-					VolcVideoCreateReq: params,
-					RequestData:        util.ConvToMap(params),
-					ResponseData:       util.ConvToMap(responseBytes),
-					Error:              err,
-					RetryInfo:          retryInfo,
-					TotalTime:          totalTime,
-					InternalTime:       internalTime,
-					EnterTime:          enterTime,
-				}
-
-				if params.Frames != nil && *params.Frames > 0 {
-					afterHandler.Seconds = int(math.Ceil(float64(*params.Frames) / 24))
-				} else if params.Duration != nil && *params.Duration > 0 {
-					afterHandler.Seconds = *params.Duration
-				}
-
-				// 解析响应获取 VideoId
-				if responseBytes != nil {
-					var res smodel.VolcVideoTaskRes
-					if e := json.Unmarshal(responseBytes, &res); e == nil {
-						afterHandler.VideoId = res.Id
-						if res.Duration != nil && afterHandler.Seconds == 0 {
-							afterHandler.Seconds = *res.Duration
-						}
-					}
-				}
-
-				common.AfterHandler(ctx, mak, afterHandler)
-
-			}); err != nil {
-				logger.Error(ctx, err)
+	pipeline := &common.Pipeline[[]byte]{
+		Name: "sVolcEngine VideoCreate",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              params.Model,
+				Endpoint:           consts.ENDPOINT_VIDEO_GENERATIONS,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (responseBytes []byte, err error) {
+
+			start := gtime.TimestampMilli()
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "VideoCreateOfficial")
+			responseBytes, responseHeader, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, false).VideoCreateOfficial(upstreamCtx, request.GetBody())
+			totalTime = gtime.TimestampMilli() - start
+			common.EndSpan(upstreamSpan, err)
+			if err != nil {
+				return nil, err
 			}
-		}
-	}()
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return nil, err
-	}
-
-	body := request.GetBody()
-
-	responseBytes, responseHeader, err = common.NewAdapterOfficial(ctx, mak, false).VideoCreateOfficial(ctx, body)
-	if err != nil {
-		logger.Error(ctx, err)
-
-		// 记录错误次数和禁用
-		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-		isRetry, isDisabled := common.IsNeedRetry(err)
-
-		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 
-				service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			return responseBytes, nil
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseBytes []byte) ([]byte, error) {
+
+			// 响应头透传
+			common.WritePassthroughHeaders(ctx, attempt.Mak.Passthrough, responseHeader)
+
+			return responseBytes, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, responseBytes []byte, err error) *mcommon.AfterHandler {
+
+			after := &mcommon.AfterHandler{
+				Action:             consts.ACTION_CREATE,
+				VideoMode:          detectVideoMode(params),
+				IsVolcEngine:       true,
+				VolcVideoCreateReq: params,
+				RequestData:        util.ConvToMap(params),
+				ResponseData:       util.ConvToMap(responseBytes),
+				TotalTime:          totalTime,
+			}
 
-			}, nil); err != nil {
-				logger.Error(ctx, err)
+			if params.Frames != nil && *params.Frames > 0 {
+				after.Seconds = int(math.Ceil(float64(*params.Frames) / 24))
+			} else if params.Duration != nil && *params.Duration > 0 {
+				after.Seconds = *params.Duration
 			}
-		}
 
-		if isRetry {
-
-			if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-				if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-					service.Session().SaveModelAgentBillingMethod(ctx, 1)
-					retry = []int{}
-				} else {
-
-					if mak.RealModel.IsEnableFallback {
-
-						if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-							if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.VideoCreate(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel)
-							}
-						}
-
-						if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-							if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-								retryInfo = &mcommon.Retry{
-									IsRetry:    true,
-									RetryCount: len(retry),
-									ErrMsg:     err.Error(),
-								}
-								return s.VideoCreate(g.RequestFromCtx(ctx).GetCtx(), request, nil, fallbackModel)
-							}
-						}
+			// 解析响应获取 VideoId
+			if responseBytes != nil {
+				var res smodel.VolcVideoTaskRes
+				if e := json.Unmarshal(responseBytes, &res); e == nil {
+					after.VideoId = res.Id
+					if res.Duration != nil && after.Seconds == 0 {
+						after.Seconds = *res.Duration
 					}
-
-					return nil, err
 				}
 			}
 
-			retryInfo = &mcommon.Retry{
-				IsRetry:    true,
-				RetryCount: len(retry),
-				ErrMsg:     err.Error(),
-			}
-
-			return s.VideoCreate(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
-		}
-
-		return nil, err
+			return after
+		},
 	}
 
-	// 响应头透传
-	common.WritePassthroughHeaders(ctx, mak.Passthrough, responseHeader)
-
-	return responseBytes, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // VideoList
@@ -314,43 +242,6 @@ func (s *sVolcEngine) VideoRetrieve(ctx context.Context, request *ghttp.Request,
 		logger.Debugf(ctx, "sVolcEngine VideoRetrieve time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	var (
-		mak = &common.MAK{
-			FallbackModelAgent: fallbackModelAgent,
-			FallbackModel:      fallbackModel,
-		}
-		retryInfo      *mcommon.Retry
-		totalTime      int64
-		responseHeader http.Header
-	)
-
-	defer func() {
-
-		totalTime = gtime.TimestampMilli() - now
-		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
-		internalTime := gtime.TimestampMilli() - enterTime - totalTime
-
-		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-
-				common.AfterHandler(ctx, mak, &mcommon.AfterHandler{
-					Action:       consts.ACTION_RETRIEVE,
-					VideoId:      taskId,
-					RequestData:  map[string]any{"task_id": taskId},
-					ResponseData: util.ConvToMap(responseBytes),
-					Error:        err,
-					RetryInfo:    retryInfo,
-					TotalTime:    totalTime,
-					InternalTime: internalTime,
-					EnterTime:    enterTime,
-				})
-
-			}); err != nil {
-				logger.Error(ctx, err)
-			}
-		}
-	}()
-
 	taskVideo, err := dao.TaskVideo.FindOne(ctx, bson.M{"video_id": taskId, "creator": service.Session().GetSecretKey(ctx)})
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -360,102 +251,80 @@ func (s *sVolcEngine) VideoRetrieve(ctx context.Context, request *ghttp.Request,
 		return nil, err
 	}
 
-	mak.Model = taskVideo.Model
-
-	if err = mak.InitMAK(ctx); err != nil {
-		logger.Error(ctx, err)
-		return nil, err
-	}
-
-	volcRes := convTaskVideoToVolcRes(ctx, taskVideo)
-	if volcRes == nil {
-
-		responseBytes, responseHeader, err = common.NewAdapterOfficial(ctx, mak, false).VideoRetrieveOfficial(ctx, taskId)
-		if err != nil {
-			logger.Error(ctx, err)
-
-			// 记录错误次数和禁用
-			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
-
-			isRetry, isDisabled := common.IsNeedRetry(err)
+	var (
+		responseHeader http.Header
+		totalTime      int64
+	)
 
-			if isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+	pipeline := &common.Pipeline[[]byte]{
+		Name: "sVolcEngine VideoRetrieve",
+		NewMAK: func(fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model) *common.MAK {
+			return &common.MAK{
+				Model:              taskVideo.Model,
+				FallbackModelAgent: fallbackModelAgent,
+				FallbackModel:      fallbackModel,
+			}
+		},
+		Before: func(ctx context.Context, attempt *common.Attempt) ([]byte, bool, error) {
 
-					service.ModelAgent().DisabledKey(ctx, mak.Key, err.Error())
+			// 任务已有结果时无需请求上游
+			volcRes := convTaskVideoToVolcRes(ctx, taskVideo)
+			if volcRes == nil {
+				return nil, false, nil
+			}
 
-				}, nil); err != nil {
-					logger.Error(ctx, err)
-				}
+			responseBytes, err := json.Marshal(volcRes)
+			if err != nil {
+				logger.Error(ctx, err)
+				return nil, true, err
 			}
 
-			if isRetry {
-
-				if common.IsMaxRetry(mak.AgentTotal, len(retry)) {
-
-					if service.Session().GetModelAgentBillingMethod(ctx) == 2 && slices.Contains(mak.RealModel.Pricing.BillingMethods, 1) {
-						service.Session().SaveModelAgentBillingMethod(ctx, 1)
-						retry = []int{}
-					} else {
-
-						if mak.RealModel.IsEnableFallback {
-
-							if mak.RealModel.FallbackConfig.ModelAgent != "" && mak.RealModel.FallbackConfig.ModelAgent != mak.ModelAgent.Id && fallbackModelAgent == nil {
-								if fallbackModelAgent, _ = service.ModelAgent().GetFallback(ctx, mak.RealModel); fallbackModelAgent != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.VideoRetrieve(g.RequestFromCtx(ctx).GetCtx(), request, taskId, fallbackModelAgent, fallbackModel)
-								}
-							}
-
-							if mak.RealModel.FallbackConfig.Model != "" && fallbackModel == nil {
-								if fallbackModel, _ = service.Model().GetFallbackModel(ctx, mak.RealModel); fallbackModel != nil {
-									retryInfo = &mcommon.Retry{
-										IsRetry:    true,
-										RetryCount: len(retry),
-										ErrMsg:     err.Error(),
-									}
-									return s.VideoRetrieve(g.RequestFromCtx(ctx).GetCtx(), request, taskId, nil, fallbackModel)
-								}
-							}
-						}
-
-						return nil, err
-					}
-				}
+			return responseBytes, true, nil
+		},
+		Upstream: func(ctx context.Context, attempt *common.Attempt) (responseBytes []byte, err error) {
+
+			start := gtime.TimestampMilli()
+			upstreamCtx, upstreamSpan := common.StartUpstreamSpan(ctx, attempt.Mak, "VideoRetrieveOfficial")
+			responseBytes, responseHeader, err = common.NewAdapterOfficial(upstreamCtx, attempt.Mak, false).VideoRetrieveOfficial(upstreamCtx, taskId)
+			totalTime = gtime.TimestampMilli() - start
+			common.EndSpan(upstreamSpan, err)
+			if err != nil {
+				return nil, err
+			}
 
-				retryInfo = &mcommon.Retry{
-					IsRetry:    true,
-					RetryCount: len(retry),
-					ErrMsg:     err.Error(),
-				}
+			return responseBytes, nil
+		},
+		Response: func(ctx context.Context, attempt *common.Attempt, responseBytes []byte) ([]byte, error) {
 
-				return s.VideoRetrieve(g.RequestFromCtx(ctx).GetCtx(), request, taskId, fallbackModelAgent, fallbackModel, append(retry, 1)...)
+			volcRes := &smodel.VolcVideoTaskRes{}
+			if err := json.Unmarshal(responseBytes, &volcRes); err != nil {
+				logger.Error(ctx, err)
+				return nil, err
 			}
 
-			return nil, err
-		}
-
-		volcRes = &smodel.VolcVideoTaskRes{}
-		if err = json.Unmarshal(responseBytes, &volcRes); err != nil {
-			logger.Error(ctx, err)
-			return nil, err
-		}
-	}
+			responseBytes, err := json.Marshal(volcRes)
+			if err != nil {
+				logger.Error(ctx, err)
+				return nil, err
+			}
 
-	responseBytes, err = json.Marshal(volcRes)
-	if err != nil {
-		logger.Error(ctx, err)
-		return nil, err
+			// 响应头透传
+			common.WritePassthroughHeaders(ctx, attempt.Mak.Passthrough, responseHeader)
+
+			return responseBytes, nil
+		},
+		Accounting: func(ctx context.Context, attempt *common.Attempt, responseBytes []byte, err error) *mcommon.AfterHandler {
+			return &mcommon.AfterHandler{
+				Action:       consts.ACTION_RETRIEVE,
+				VideoId:      taskId,
+				RequestData:  map[string]any{"task_id": taskId},
+				ResponseData: util.ConvToMap(responseBytes),
+				TotalTime:    totalTime,
+			}
+		},
 	}
 
-	// 响应头透传
-	common.WritePassthroughHeaders(ctx, mak.Passthrough, responseHeader)
-
-	return responseBytes, nil
+	return pipeline.Execute(ctx, fallbackModelAgent, fallbackModel, retry...)
 }
 
 // VideoDelete
@@ -1,27 +1,81 @@
 package model
 
+import "github.com/iimeta/fastapi/v2/internal/model/common"
+
 type AppKey struct {
-	Id                  string   `json:"id,omitempty"`                 // ID
-	UserId              int      `json:"user_id,omitempty"`            // 用户ID
-	AppId               int      `json:"app_id,omitempty"`             // 应用ID
-	Key                 string   `json:"key,omitempty"`                // 密钥
-	BillingMethods      []int    `json:"billing_methods,omitempty"`    // 计费方式[1:按Tokens, 2:按次]
-	Models              []string `json:"models,omitempty"`             // 模型
-	IsLimitQuota        bool     `json:"is_limit_quota"`               // 是否限制额度
-	Quota               int      `json:"quota,omitempty"`              // 剩余额度
-	UsedQuota           int      `json:"used_quota,omitempty"`         // 已用额度
-	QuotaExpiresRule    int      `json:"quota_expires_rule,omitempty"` // 额度过期规则[1:固定, 2:时长]
-	QuotaExpiresAt      int64    `json:"quota_expires_at,omitempty"`   // 额度过期时间
-	QuotaExpiresMinutes int64    `json:"quota_expires_minutes"`        // 额度过期分钟数
-	IsBindGroup         bool     `json:"is_bind_group,omitempty"`      // 是否绑定分组
-	Group               string   `json:"group,omitempty"`              // 绑定分组
-	IpWhitelist         []string `json:"ip_whitelist,omitempty"`       // IP白名单
-	IpBlacklist         []string `json:"ip_blacklist,omitempty"`       // IP黑名单
-	Remark              string   `json:"remark,omitempty"`             // 备注
-	Status              int      `json:"status,omitempty"`             // 状态[1:正常, 2:禁用, -1:删除]
-	Rid                 int      `json:"rid,omitempty"`                // 代理商ID
-	Creator             string   `json:"creator,omitempty"`            // 创建人
-	Updater             string   `json:"updater,omitempty"`            // 更新人
-	CreatedAt           string   `json:"created_at,omitempty"`         // 创建时间
-	UpdatedAt           string   `json:"updated_at,omitempty"`         // 更新时间
+	Id                  string                `json:"id,omitempty"`                 // ID
+	UserId              int                   `json:"user_id,omitempty"`            // 用户ID
+	AppId               int                   `json:"app_id,omitempty"`             // 应用ID
+	Key                 string                `json:"key,omitempty"`                // 密钥
+	BillingMethods      []int                 `json:"billing_methods,omitempty"`    // 计费方式[1:按Tokens, 2:按次]
+	Models              []string              `json:"models,omitempty"`             // 模型
+	IsLimitQuota        bool                  `json:"is_limit_quota"`               // 是否限制额度
+	Quota               int                   `json:"quota,omitempty"`              // 剩余额度
+	UsedQuota           int                   `json:"used_quota,omitempty"`         // 已用额度
+	QuotaExpiresRule    int                   `json:"quota_expires_rule,omitempty"` // 额度过期规则[1:固定, 2:时长]
+	QuotaExpiresAt      int64                 `json:"quota_expires_at,omitempty"`   // 额度过期时间
+	QuotaExpiresMinutes int64                 `json:"quota_expires_minutes"`        // 额度过期分钟数
+	IsBindGroup         bool                  `json:"is_bind_group,omitempty"`      // 是否绑定分组
+	Group               string                `json:"group,omitempty"`              // 绑定分组
+	IpWhitelist         []string              `json:"ip_whitelist,omitempty"`       // IP白名单
+	IpBlacklist         []string              `json:"ip_blacklist,omitempty"`       // IP黑名单
+	RateLimit           *common.RateLimit     `json:"rate_limit,omitempty"`         // 速率限制
+	ResponseCache       *common.ResponseCache `json:"response_cache,omitempty"`     // 响应缓存
+	Guardrail           *common.Guardrail     `json:"guardrail,omitempty"`          // 护栏
+	Prompt              *common.Prompt        `json:"prompt,omitempty"`             // 提示词模板
+	ParentId            string                `json:"parent_id,omitempty"`          // 父密钥ID, 自助签发的子密钥
+	ExpiresAt           int64                 `json:"expires_at,omitempty"`         // 密钥过期时间, 0:永不过期
+	Metadata            map[string]string     `json:"metadata,omitempty"`           // 元数据
+	Remark              string                `json:"remark,omitempty"`             // 备注
+	Status              int                   `json:"status,omitempty"`             // 状态[1:正常, 2:禁用, -1:删除]
+	Rid                 int                   `json:"rid,omitempty"`                // 代理商ID
+	Creator             string                `json:"creator,omitempty"`            // 创建人
+	Updater             string                `json:"updater,omitempty"`            // 更新人
+	CreatedAt           string                `json:"created_at,omitempty"`         // 创建时间
+	UpdatedAt           string                `json:"updated_at,omitempty"`         // 更新时间
+}
+
+// 签发子密钥接口请求参数
+type SubKeyCreateReq struct {
+	Models      []string          `json:"models"`       // 模型, 须为父密钥可用模型的子集, 为空时继承父密钥
+	Quota       int               `json:"quota"`        // 额度, 从父密钥剩余额度中划出, 父密钥限制额度时必填, 0:不限制
+	ExpiresIn   int64             `json:"expires_in"`   // 有效期, 单位: 秒, 0:最长有效期
+	RateLimit   *common.RateLimit `json:"rate_limit"`   // 速率限制, 不可超过父密钥
+	IpWhitelist []string          `json:"ip_whitelist"` // IP白名单, 为空时继承父密钥
+	Metadata    map[string]string `json:"metadata"`     // 元数据
+	Remark      string            `json:"remark"`       // 备注
+}
+
+// 子密钥列表接口请求参数
+type SubKeyListReq struct {
+	After string `json:"after"` // 分页游标, 上一页最后一个子密钥ID
+	Limit int64  `json:"limit"` // 每页条数, 默认: 20, 最大: 100
+	Order string `json:"order"` // 排序[asc, desc], 默认: desc
+}
+
+// 子密钥列表接口响应参数
+type SubKeyListRes struct {
+	Object  string    `json:"object"`
+	Data    []*SubKey `json:"data"`
+	FirstId *string   `json:"first_id"`
+	LastId  *string   `json:"last_id"`
+	HasMore bool      `json:"has_more"`
+}
+
+type SubKey struct {
+	Object         string            `json:"object"`
+	Id             string            `json:"id"`
+	Key            string            `json:"key,omitempty"`  // 密钥, 仅签发时返回
+	MaskedKey      string            `json:"masked_key"`     // 脱敏密钥
+	Models         []string          `json:"models"`         // 模型
+	IsLimitQuota   bool              `json:"is_limit_quota"` // 是否限制额度
+	RemainingQuota int               `json:"remaining_quota"`
+	UsedQuota      int               `json:"used_quota"`
+	RateLimit      *common.RateLimit `json:"rate_limit,omitempty"`
+	IpWhitelist    []string          `json:"ip_whitelist,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Remark         string            `json:"remark,omitempty"`
+	Status         string            `json:"status"`     // 状态[active:生效, expired:已过期, revoked:已撤销]
+	ExpiresAt      int64             `json:"expires_at"` // 过期时间, 单位: 秒
+	CreatedAt      int64             `json:"created_at"` // 创建时间, 单位: 秒
 }
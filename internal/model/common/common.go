@@ -1,6 +1,8 @@
 package common
 
 import (
+	"time"
+
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
 )
 
@@ -25,10 +27,76 @@ type ForwardConfig struct {
 }
 
 type FallbackConfig struct {
-	ModelAgent     string `bson:"model_agent,omitempty"      json:"model_agent,omitempty"`      // 后备模型代理
-	ModelAgentName string `bson:"model_agent_name,omitempty" json:"model_agent_name,omitempty"` // 后备模型代理名称
-	Model          string `bson:"model,omitempty"            json:"model,omitempty"`            // 后备模型
-	ModelName      string `bson:"model_name,omitempty"       json:"model_name,omitempty"`       // 后备模型名称
+	ModelAgent     string          `bson:"model_agent,omitempty"      json:"model_agent,omitempty"`      // 后备模型代理
+	ModelAgentName string          `bson:"model_agent_name,omitempty" json:"model_agent_name,omitempty"` // 后备模型代理名称
+	Model          string          `bson:"model,omitempty"            json:"model,omitempty"`            // 后备模型
+	ModelName      string          `bson:"model_name,omitempty"       json:"model_name,omitempty"`       // 后备模型名称
+	Chain          []*FallbackStep `bson:"chain,omitempty"            json:"chain,omitempty"`            // 后备链, 按顺序执行, 配置后忽略上面的后备模型代理和后备模型
+}
+
+type FallbackStep struct {
+	Model          string   `bson:"model,omitempty"            json:"model,omitempty"`            // 后备模型
+	ModelName      string   `bson:"model_name,omitempty"       json:"model_name,omitempty"`       // 后备模型名称
+	ModelAgent     string   `bson:"model_agent,omitempty"      json:"model_agent,omitempty"`      // 后备模型代理
+	ModelAgentName string   `bson:"model_agent_name,omitempty" json:"model_agent_name,omitempty"` // 后备模型代理名称
+	Conditions     []string `bson:"conditions,omitempty"       json:"conditions,omitempty"`       // 触发条件[rate_limit:限流, timeout:超时, context_length_exceeded:上下文超长, content_filter:内容过滤, server_error:服务端错误, no_available:无可用模型代理], 为空时可重试的错误均触发
+}
+
+// 后备链执行状态, 首次后备时按请求的模型确定后备链, 后续跳转沿用
+type FallbackState struct {
+	Chain []*FallbackStep // 后备链
+	Next  int             // 下一个待匹配的步骤
+}
+
+type HedgeConfig struct {
+	Delay int `bson:"delay,omitempty" json:"delay,omitempty"` // 对冲延迟毫秒数, 首个请求超过该时间未响应时发起对冲请求
+}
+
+type ResponseCache struct {
+	Open  bool    `bson:"open"            json:"open"`            // 是否启用
+	Ttl   int     `bson:"ttl,omitempty"   json:"ttl,omitempty"`   // 缓存秒数, 0:默认1小时
+	Ratio float64 `bson:"ratio,omitempty" json:"ratio,omitempty"` // 命中缓存的计费倍率, 0:不计费
+}
+
+type Guardrail struct {
+	Open            bool     `bson:"open"                       json:"open"`                       // 是否启用
+	BlockedTerms    []string `bson:"blocked_terms,omitempty"    json:"blocked_terms,omitempty"`    // 屏蔽词, 不区分大小写
+	BlockedPatterns []string `bson:"blocked_patterns,omitempty" json:"blocked_patterns,omitempty"` // 屏蔽正则
+	PiiTypes        []string `bson:"pii_types,omitempty"        json:"pii_types,omitempty"`        // 个人信息类型[email:邮箱, phone:手机号, id_card:身份证号, bank_card:银行卡号]
+	PiiAction       int      `bson:"pii_action,omitempty"       json:"pii_action,omitempty"`       // 个人信息处理方式[1:脱敏, 2:拦截]
+	ModerationModel string   `bson:"moderation_model,omitempty" json:"moderation_model,omitempty"` // 审核模型, 为空时不预检
+	IsCheckOutput   bool     `bson:"is_check_output,omitempty"  json:"is_check_output,omitempty"`  // 是否检查输出
+}
+
+type GuardrailHit struct {
+	Stage  string `bson:"stage,omitempty"  json:"stage,omitempty"`  // 阶段[input:输入, output:输出]
+	Rule   string `bson:"rule,omitempty"   json:"rule,omitempty"`   // 规则[blocked_term:屏蔽词, blocked_pattern:屏蔽正则, pii:个人信息, moderation:审核模型]
+	Match  string `bson:"match,omitempty"  json:"match,omitempty"`  // 命中内容, 如: 屏蔽词、正则、个人信息类型、审核类别
+	Action string `bson:"action,omitempty" json:"action,omitempty"` // 处理方式[mask:脱敏, block:拦截]
+}
+
+type Prompt struct {
+	Id        string            `bson:"id,omitempty"        json:"id,omitempty"`        // 提示词模板ID
+	Version   int               `bson:"version,omitempty"   json:"version,omitempty"`   // 版本, 为空时使用模板当前版本
+	Variables map[string]string `bson:"variables,omitempty" json:"variables,omitempty"` // 变量
+}
+
+type PromptVersion struct {
+	Version   int               `bson:"version,omitempty"    json:"version,omitempty"`    // 版本
+	Messages  []*PromptMessage  `bson:"messages,omitempty"   json:"messages,omitempty"`   // 消息, 内容中的变量格式: {{变量名}}
+	Variables map[string]string `bson:"variables,omitempty"  json:"variables,omitempty"`  // 变量默认值
+	Remark    string            `bson:"remark,omitempty"     json:"remark,omitempty"`     // 备注
+	CreatedAt int64             `bson:"created_at,omitempty" json:"created_at,omitempty"` // 创建时间
+}
+
+type PromptMessage struct {
+	Role    string `bson:"role,omitempty"    json:"role,omitempty"`    // 角色
+	Content string `bson:"content,omitempty" json:"content,omitempty"` // 内容
+}
+
+type StreamTimeout struct {
+	FirstToken time.Duration `bson:"first_token,omitempty" json:"first_token,omitempty"` // 首个数据块超时时间, 单位: 秒, 0:不限制, 未向客户端输出时转入重试
+	Idle       time.Duration `bson:"idle,omitempty"        json:"idle,omitempty"`        // 数据块间隔超时时间, 单位: 秒, 0:不限制, 已向客户端输出时以错误事件结束
 }
 
 type Message struct {
@@ -43,9 +111,18 @@ type Message struct {
 }
 
 type Retry struct {
-	IsRetry    bool   `bson:"is_retry,omitempty"    json:"is_retry,omitempty"`    // 是否重试
-	RetryCount int    `bson:"retry_count,omitempty" json:"retry_count,omitempty"` // 重试次数
-	ErrMsg     string `bson:"err_msg,omitempty"     json:"err_msg,omitempty"`     // 错误信息
+	IsRetry    bool         `bson:"is_retry,omitempty"    json:"is_retry,omitempty"`    // 是否重试
+	RetryCount int          `bson:"retry_count,omitempty" json:"retry_count,omitempty"` // 重试次数
+	ErrMsg     string       `bson:"err_msg,omitempty"     json:"err_msg,omitempty"`     // 错误信息
+	IsHedge    bool         `bson:"is_hedge,omitempty"    json:"is_hedge,omitempty"`    // 是否对冲请求
+	Fallback   *FallbackHop `bson:"fallback,omitempty"    json:"fallback,omitempty"`    // 后备跳转
+}
+
+type FallbackHop struct {
+	Step           int    `bson:"step,omitempty"             json:"step,omitempty"`             // 后备链步骤, 从1开始
+	ErrClass       string `bson:"err_class,omitempty"        json:"err_class,omitempty"`        // 触发的错误类型
+	Model          string `bson:"model,omitempty"            json:"model,omitempty"`            // 后备模型
+	ModelAgentName string `bson:"model_agent_name,omitempty" json:"model_agent_name,omitempty"` // 后备模型代理名称
 }
 
 type ImageData struct {
@@ -86,3 +163,34 @@ type PrivacyLogFieldOption struct {
 	Key     string `bson:"key"     json:"key"`     // 字段标识
 	Enabled bool   `bson:"enabled" json:"enabled"` // 是否启用
 }
+
+type RateLimit struct {
+	Rpm         int `bson:"rpm,omitempty"         json:"rpm,omitempty"`         // 每分钟请求数, 0:不限制
+	Tpm         int `bson:"tpm,omitempty"         json:"tpm,omitempty"`         // 每分钟Token数, 0:不限制
+	Concurrency int `bson:"concurrency,omitempty" json:"concurrency,omitempty"` // 最大并发数, 0:不限制
+}
+
+type UpstreamNetwork struct {
+	IsDirect         bool          `bson:"is_direct,omitempty"          json:"is_direct,omitempty"`          // 是否直连, 不使用全局代理
+	ProxyUrl         string        `bson:"proxy_url,omitempty"          json:"proxy_url,omitempty"`          // 代理地址, 支持 http/https/socks5, 空:使用全局代理
+	ConnectTimeout   time.Duration `bson:"connect_timeout,omitempty"    json:"connect_timeout,omitempty"`    // 连接超时时间(含TLS握手), 单位: 秒, 0:不限制, 连接/首字节超时、空闲连接保持时间和TLS配置仅作用于网关自身发起的上游请求, 如: 获取令牌
+	FirstByteTimeout time.Duration `bson:"first_byte_timeout,omitempty" json:"first_byte_timeout,omitempty"` // 首字节(响应头)超时时间, 单位: 秒, 0:不限制
+	IdleConnTimeout  time.Duration `bson:"idle_conn_timeout,omitempty"  json:"idle_conn_timeout,omitempty"`  // 空闲连接保持时间, 单位: 秒, 0:默认90秒, 流式数据块间隔超时见模型流式超时配置
+	TotalTimeout     time.Duration `bson:"total_timeout,omitempty"      json:"total_timeout,omitempty"`      // 总超时时间, 单位: 秒, 0:使用全局长/短连接超时时间
+	Tls              *UpstreamTls  `bson:"tls,omitempty"                json:"tls,omitempty"`                // TLS配置
+}
+
+type UpstreamTls struct {
+	InsecureSkipVerify bool   `bson:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"` // 是否跳过证书校验
+	ServerName         string `bson:"server_name,omitempty"          json:"server_name,omitempty"`          // 证书校验的服务器名称, 空:使用请求地址
+	CaCert             string `bson:"ca_cert,omitempty"              json:"ca_cert,omitempty"`              // 私有CA证书, PEM格式
+	ClientCert         string `bson:"client_cert,omitempty"          json:"client_cert,omitempty"`          // 客户端证书(mTLS), PEM格式
+	ClientKey          string `bson:"client_key,omitempty"           json:"client_key,omitempty"`           // 客户端私钥(mTLS), PEM格式
+}
+
+type UpstreamLimit struct {
+	Rpm        int  `bson:"rpm,omitempty"         json:"rpm,omitempty"`         // 每分钟请求数, 0:不限制
+	Tpm        int  `bson:"tpm,omitempty"         json:"tpm,omitempty"`         // 每分钟Token数, 0:不限制
+	DailyQuota int  `bson:"daily_quota,omitempty" json:"daily_quota,omitempty"` // 每日花费额度, 0:不限制
+	IsAdaptive bool `bson:"is_adaptive,omitempty" json:"is_adaptive,omitempty"` // 是否根据上游 x-ratelimit-* 响应头自适应
+}
@@ -116,6 +116,9 @@ type Quota struct {
 	ExpiredNotice     bool          `bson:"expired_notice"      json:"expired_notice"`      // 额度过期通知开关
 	ExpiredClear      bool          `bson:"expired_clear"       json:"expired_clear"`       // 额度过期清零开关
 	ExpiredClearDefer time.Duration `bson:"expired_clear_defer" json:"expired_clear_defer"` // 额度过期清零延迟, 单位: 分钟
+	Hold              bool          `bson:"hold"                json:"hold"`                // 额度预授权开关
+	HoldMaxTokens     int           `bson:"hold_max_tokens"     json:"hold_max_tokens"`     // 额度预授权未指定max_tokens时预估的输出Token数
+	HoldExpire        time.Duration `bson:"hold_expire"         json:"hold_expire"`         // 额度预授权过期时间, 单位: 秒
 }
 
 type ImageTask struct {
@@ -171,6 +174,19 @@ type FileTask struct {
 	StorageExpiredDelete bool          `bson:"storage_expired_delete" json:"storage_expired_delete"`       // 存储过期删除开关
 }
 
+type BatchTask struct {
+	Open                   bool          `bson:"open"                     json:"open"`                     // 开关, 上游不支持批处理API时由网关执行批处理
+	Concurrency            int           `bson:"concurrency"              json:"concurrency"`              // 并发数, 0:默认10
+	LowPriorityConcurrency int           `bson:"low_priority_concurrency" json:"low_priority_concurrency"` // 低优先级并发数, 0:默认2
+	LockMinutes            time.Duration `bson:"lock_minutes"             json:"lock_minutes"`             // 锁定时长, 单位: 分钟, 0:默认10分钟
+	StorageDir             string        `bson:"storage_dir"              json:"storage_dir"`              // 存储目录, 空:默认./resource/batch/
+}
+
+type ResponsesStore struct {
+	Open             bool          `bson:"open"               json:"open"`               // 开关, 上游不支持 Responses API 时由网关存储响应并按 previous_response_id 重建上下文
+	StorageExpiresAt time.Duration `bson:"storage_expires_at" json:"storage_expires_at"` // 存储过期时间, 单位: 分钟, 0:默认30天
+}
+
 type ModelAgentHealthCheckTask struct {
 	Open              bool          `bson:"open"                json:"open"`                // 开关
 	Cron              string        `bson:"cron"                json:"cron"`                // CRON表达式
@@ -231,6 +247,18 @@ type GeneralApi struct {
 	IpWhitelist []string `bson:"ip_whitelist" json:"ip_whitelist"` // IP白名单
 }
 
+type CircuitBreaker struct {
+	Open             bool          `bson:"open"              json:"open"`              // 开关
+	FailureThreshold int           `bson:"failure_threshold" json:"failure_threshold"` // 连续失败次数阈值
+	OpenDuration     time.Duration `bson:"open_duration"     json:"open_duration"`     // 熔断时长, 单位: 秒
+	ProbeTimeout     time.Duration `bson:"probe_timeout"     json:"probe_timeout"`     // 半开探测超时时间, 单位: 秒
+}
+
+type Metrics struct {
+	Open        bool     `bson:"open"         json:"open"`         // 开关
+	IpWhitelist []string `bson:"ip_whitelist" json:"ip_whitelist"` // IP白名单
+}
+
 type Debug struct {
 	Open bool `bson:"open" json:"open"` // 开关
 }
@@ -17,6 +17,8 @@ type AfterHandler struct {
 	AudioText              string
 	EmbeddingReq           smodel.EmbeddingRequest
 	ModerationReq          smodel.ModerationRequest
+	RerankDocuments        int
+	RerankSearchUnits      int
 	ChatCompletionRes      smodel.ChatCompletionResponse
 	Completion             string
 	ServiceTier            string
@@ -35,6 +37,8 @@ type AfterHandler struct {
 	FileRes                smodel.FileResponse
 	IsBatch                bool
 	BatchId                string
+	IsNativeBatch          bool   // 是否网关批处理
+	FilePath               string // 文件存储路径
 	Prompt                 string
 	Seconds                int
 	Size                   string
@@ -45,6 +49,8 @@ type AfterHandler struct {
 	RetryInfo              *Retry
 	Spend                  Spend
 	IsSmartMatch           bool
+	Guardrails             []*GuardrailHit // 护栏命中记录
+	IsGuardrail            bool            // 是否护栏审核请求, 不修正所属请求的速率限制和会话保持
 	ConnTime               int64
 	Duration               int64
 	TotalTime              int64
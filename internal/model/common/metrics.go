@@ -0,0 +1,10 @@
+package common
+
+type MetricsLabels struct {
+	Model      string // 模型
+	RealModel  string // 真实模型
+	Provider   string // 提供商
+	ModelAgent string // 模型代理
+	Endpoint   string // 请求端点
+	Status     string // 状态[success:成功, error:失败, aborted:中断]
+}
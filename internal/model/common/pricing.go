@@ -8,7 +8,7 @@ type Pricing struct {
 	CurrencySymbol  string                    `bson:"currency_symbol,omitempty"   json:"currency_symbol,omitempty"`   // 货币符号
 	BillingRule     int                       `bson:"billing_rule,omitempty"      json:"billing_rule,omitempty"`      // 计费规则[1:按官方, 2:按系统]
 	BillingMethods  []int                     `bson:"billing_methods,omitempty"   json:"billing_methods,omitempty"`   // 计费方式[1:按Tokens, 2:按次]
-	BillingItems    []string                  `bson:"billing_items,omitempty"     json:"billing_items,omitempty"`     // 计费项[text:文本, text_cache:文本缓存, tiered_text:阶梯文本, tiered_text_cache:阶梯文本缓存, image:图像, image_generation:图像生成, image_cache:图像缓存, vision:识图, audio:音频, audio_cache:音频缓存, video:视频, video_generation:视频生成, video_cache:视频缓存, search:搜索, once:一次]
+	BillingItems    []string                  `bson:"billing_items,omitempty"     json:"billing_items,omitempty"`     // 计费项[text:文本, text_cache:文本缓存, tiered_text:阶梯文本, tiered_text_cache:阶梯文本缓存, image:图像, image_generation:图像生成, image_cache:图像缓存, vision:识图, audio:音频, audio_cache:音频缓存, video:视频, video_generation:视频生成, video_cache:视频缓存, search:搜索, rerank:重排序, once:一次]
 	Text            []*TextPricing            `bson:"text,omitempty"              json:"text,omitempty"`              // 文本
 	TextCache       []*CachePricing           `bson:"text_cache,omitempty"        json:"text_cache,omitempty"`        // 文本缓存
 	TieredText      []*TextPricing            `bson:"tiered_text,omitempty"       json:"tiered_text,omitempty"`       // 阶梯文本
@@ -23,7 +23,9 @@ type Pricing struct {
 	VideoGeneration []*VideoGenerationPricing `bson:"video_generation,omitempty"  json:"video_generation,omitempty"`  // 视频生成
 	VideoCache      *CachePricing             `bson:"video_cache,omitempty"       json:"video_cache,omitempty"`       // 视频缓存
 	Search          []*SearchPricing          `bson:"search,omitempty"            json:"search,omitempty"`            // 搜索
+	Rerank          *RerankPricing            `bson:"rerank,omitempty"            json:"rerank,omitempty"`            // 重排序
 	Once            *OncePricing              `bson:"once,omitempty"              json:"once,omitempty"`              // 一次
+	BatchDiscount   float64                   `bson:"batch_discount,omitempty"    json:"batch_discount,omitempty"`    // 网关批处理折扣, 如: 0.5, 0:不打折
 }
 
 type TimeRule struct {
@@ -102,6 +104,12 @@ type SearchPricing struct {
 	IsDefault   bool    `bson:"is_default,omitempty"   json:"is_default,omitempty"`   // 是否默认选项
 }
 
+type RerankPricing struct {
+	Mode      string  `bson:"mode,omitempty"       json:"mode,omitempty"`       // 模式[search_unit:按搜索单元, document:按文档]
+	OnceRatio float64 `bson:"once_ratio,omitempty" json:"once_ratio,omitempty"` // 一次倍率, 每搜索单元或每文档
+	Documents int     `bson:"documents,omitempty"  json:"documents,omitempty"`  // 每搜索单元文档数, 默认100
+}
+
 type OncePricing struct {
 	OnceRatio float64 `bson:"once_ratio,omitempty" json:"once_ratio,omitempty"` // 一次倍率
 }
@@ -125,6 +133,11 @@ type BillingData struct {
 	IsVolcEngine           bool
 	VolcVideoCreateReq     *smodel.VolcVideoCreateReq
 	IsAsync                bool
+	IsCacheHit             bool    // 是否命中响应缓存
+	CacheRatio             float64 // 命中响应缓存的计费倍率
+	IsBatch                bool    // 是否网关批处理请求
+	RerankDocuments        int     // 重排序文档数
+	RerankSearchUnits      int     // 重排序上游返回的搜索单元数
 }
 
 type Spend struct {
@@ -132,7 +145,7 @@ type Spend struct {
 	ModelTimeRule       *TimeRule             `bson:"model_time_rule,omitempty"       json:"model_time_rule,omitempty"`       // 模型时段规则
 	BillingRule         int                   `bson:"billing_rule,omitempty"          json:"billing_rule,omitempty"`          // 计费规则[1:按官方, 2:按系统]
 	BillingMethods      []int                 `bson:"billing_methods,omitempty"       json:"billing_methods,omitempty"`       // 计费方式[1:按Tokens, 2:按次]
-	BillingItems        []string              `bson:"billing_items,omitempty"         json:"billing_items,omitempty"`         // 计费项[text:文本, text_cache:文本缓存, tiered_text:阶梯文本, tiered_text_cache:阶梯文本缓存, image:图像, image_generation:图像生成, image_cache:图像缓存, vision:识图, audio:音频, audio_cache:音频缓存, video:视频, video_generation:视频生成, video_cache:视频缓存, search:搜索, once:一次]
+	BillingItems        []string              `bson:"billing_items,omitempty"         json:"billing_items,omitempty"`         // 计费项[text:文本, text_cache:文本缓存, tiered_text:阶梯文本, tiered_text_cache:阶梯文本缓存, image:图像, image_generation:图像生成, image_cache:图像缓存, vision:识图, audio:音频, audio_cache:音频缓存, video:视频, video_generation:视频生成, video_cache:视频缓存, search:搜索, rerank:重排序, once:一次]
 	Text                *TextSpend            `bson:"text,omitempty"                  json:"text,omitempty"`                  // 文本
 	TextCache           *CacheSpend           `bson:"text_cache,omitempty"            json:"text_cache,omitempty"`            // 文本缓存
 	TieredText          *TextSpend            `bson:"tiered_text,omitempty"           json:"tiered_text,omitempty"`           // 阶梯文本
@@ -147,11 +160,14 @@ type Spend struct {
 	VideoGeneration     *VideoGenerationSpend `bson:"video_generation,omitempty"      json:"video_generation,omitempty"`      // 视频生成
 	VideoCache          *CacheSpend           `bson:"video_cache,omitempty"           json:"video_cache,omitempty"`           // 视频缓存
 	Search              *SearchSpend          `bson:"search,omitempty"                json:"search,omitempty"`                // 搜索
+	Rerank              *RerankSpend          `bson:"rerank,omitempty"                json:"rerank,omitempty"`                // 重排序
 	Once                *OnceSpend            `bson:"once,omitempty"                  json:"once,omitempty"`                  // 一次
 	GroupId             string                `bson:"group_id,omitempty"              json:"group_id,omitempty"`              // 分组ID
 	GroupName           string                `bson:"group_name,omitempty"            json:"group_name,omitempty"`            // 分组名称
 	GroupTimeRule       *TimeRule             `bson:"group_time_rule,omitempty"       json:"group_time_rule,omitempty"`       // 分组时段规则
 	GroupBillingMethods []int                 `bson:"group_billing_methods,omitempty" json:"group_billing_methods,omitempty"` // 分组计费方式[1:按Tokens, 2:按次]
+	ResponseCacheRatio  *float64              `bson:"response_cache_ratio,omitempty"  json:"response_cache_ratio,omitempty"`  // 命中响应缓存的计费倍率
+	BatchDiscount       *float64              `bson:"batch_discount,omitempty"        json:"batch_discount,omitempty"`        // 网关批处理折扣
 	TotalSpendTokens    int                   `bson:"total_spend_tokens,omitempty"    json:"total_spend_tokens,omitempty"`    // 总花费Token数
 }
 
@@ -215,6 +231,13 @@ type SearchSpend struct {
 	SpendTokens int            `bson:"spend_tokens,omitempty" json:"spend_tokens,omitempty"` // 花费Token数
 }
 
+type RerankSpend struct {
+	Pricing     *RerankPricing `bson:"pricing,omitempty"      json:"pricing,omitempty"`      // 定价
+	Documents   int            `bson:"documents,omitempty"    json:"documents,omitempty"`    // 文档数
+	SearchUnits int            `bson:"search_units,omitempty" json:"search_units,omitempty"` // 搜索单元数
+	SpendTokens int            `bson:"spend_tokens,omitempty" json:"spend_tokens,omitempty"` // 花费Token数
+}
+
 type OnceSpend struct {
 	Pricing      *OncePricing `bson:"pricing,omitempty"       json:"pricing,omitempty"`       // 定价
 	SpendTokens  int          `bson:"spend_tokens,omitempty"  json:"spend_tokens,omitempty"`  // 花费Token数
@@ -0,0 +1,30 @@
+package model
+
+import (
+	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+)
+
+// 文本补全(旧版)请求
+type CompletionRequest struct {
+	Model  string `json:"model"`
+	Prompt any    `json:"prompt,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	Stream bool   `json:"stream,omitempty"`
+}
+
+// 文本补全(旧版)响应
+type CompletionResponse struct {
+	Id      string             `json:"id,omitempty"`
+	Object  string             `json:"object,omitempty"`
+	Created int64              `json:"created,omitempty"`
+	Model   string             `json:"model,omitempty"`
+	Choices []CompletionChoice `json:"choices,omitempty"`
+	Usage   *smodel.Usage      `json:"usage,omitempty"`
+}
+
+type CompletionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Logprobs     any    `json:"logprobs,omitempty"`
+}
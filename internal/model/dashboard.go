@@ -18,6 +18,66 @@ type DashboardUsageRes struct {
 	TotalUsage float64 `json:"total_usage"`
 }
 
+// Usage明细/Costs接口请求参数
+type DashboardReportReq struct {
+	StartTime   int64    `json:"start_time"`   // 开始时间(秒), 包含
+	EndTime     int64    `json:"end_time"`     // 结束时间(秒), 不包含, 默认: 当前时间
+	BucketWidth string   `json:"bucket_width"` // 时间桶宽度[1m, 1h, 1d], 默认: 1d
+	GroupBy     []string `json:"group_by"`     // 分组[model, app_id, api_key_id, endpoint, status], 支持逗号分隔
+	Models      []string `json:"models"`       // 模型
+	Limit       int      `json:"limit"`        // 每页时间桶数, 默认: 1m:60, 1h:24, 1d:7
+	Page        string   `json:"page"`         // 分页游标, 上一页返回的 next_page
+}
+
+// Usage明细/Costs接口响应参数
+type DashboardReportRes struct {
+	Object   string             `json:"object"`
+	Data     []*DashboardBucket `json:"data"`
+	HasMore  bool               `json:"has_more"`
+	NextPage *string            `json:"next_page"`
+}
+
+// 时间桶
+type DashboardBucket struct {
+	Object    string `json:"object"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	Results   []any  `json:"results"`
+}
+
+// 分组字段, 未参与分组的为 null
+type DashboardGroup struct {
+	Model    *string `json:"model"`
+	AppId    *int    `json:"app_id"`
+	ApiKeyId *string `json:"api_key_id"`
+	Endpoint *string `json:"endpoint"`
+	Status   *string `json:"status"`
+}
+
+// 用量
+type DashboardUsageResult struct {
+	Object            string `json:"object"`
+	InputTokens       int    `json:"input_tokens"`        // 输入Token数, 含缓存
+	OutputTokens      int    `json:"output_tokens"`       // 输出Token数
+	InputCachedTokens int    `json:"input_cached_tokens"` // 缓存读取Token数
+	NumModelRequests  int    `json:"num_model_requests"`  // 请求数
+	SpendTokens       int    `json:"spend_tokens"`        // 花费Token数
+	DashboardGroup
+}
+
+// 费用
+type DashboardCostsResult struct {
+	Object   string          `json:"object"`
+	Amount   DashboardAmount `json:"amount"`
+	LineItem *string         `json:"line_item"`
+	DashboardGroup
+}
+
+type DashboardAmount struct {
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency"`
+}
+
 // Models接口响应参数
 type DashboardModelsRes struct {
 	Object string                `json:"object"`
@@ -51,12 +111,37 @@ type Permission struct {
 }
 
 type FastAPI struct {
-	Provider string         `json:"provider,omitempty"` // 提供商名称
-	Code     string         `json:"code,omitempty"`     // 提供商代码
-	Model    string         `json:"model,omitempty"`    // 模型
-	Type     int            `json:"type,omitempty"`     // 模型类型
-	BaseUrl  string         `json:"base_url,omitempty"` // 模型地址
-	Path     string         `json:"path,omitempty"`     // 模型路径
-	Pricing  common.Pricing `json:"pricing,omitempty"`  // 定价
-	Remark   string         `json:"remark,omitempty"`   // 备注
+	Provider            string          `json:"provider,omitempty"`          // 提供商名称
+	Code                string          `json:"code,omitempty"`              // 提供商代码
+	Model               string          `json:"model,omitempty"`             // 模型
+	Type                int             `json:"type,omitempty"`              // 模型类型
+	BaseUrl             string          `json:"base_url,omitempty"`          // 模型地址
+	Path                string          `json:"path,omitempty"`              // 模型路径
+	Endpoints           []string        `json:"endpoints,omitempty"`         // 支持的端点, 空表示不限制
+	ContextWindow       int             `json:"context_window,omitempty"`    // 上下文窗口
+	MaxOutputTokens     int             `json:"max_output_tokens,omitempty"` // 最大输出Tokens
+	InputModalities     []string        `json:"input_modalities,omitempty"`  // 输入模态
+	OutputModalities    []string        `json:"output_modalities,omitempty"` // 输出模态
+	IsSupportStream     bool            `json:"is_support_stream"`           // 是否支持流式
+	IsSupportSystemRole bool            `json:"is_support_system_role"`      // 是否支持system角色
+	Pricing             common.Pricing  `json:"pricing,omitempty"`           // 定价
+	Group               string          `json:"group,omitempty"`             // 分组名称
+	Discount            float64         `json:"discount,omitempty"`          // 当前时段折扣
+	EffectivePricing    *common.Pricing `json:"effective_pricing,omitempty"` // 当前时段折扣后的定价
+	Remark              string          `json:"remark,omitempty"`            // 备注
+}
+
+// Anthropic Models接口响应参数
+type AnthropicModelsRes struct {
+	Data    []*AnthropicModel `json:"data"`
+	HasMore bool              `json:"has_more"`
+	FirstId *string           `json:"first_id"`
+	LastId  *string           `json:"last_id"`
+}
+
+type AnthropicModel struct {
+	Type        string `json:"type"`
+	Id          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
 }
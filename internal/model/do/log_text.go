@@ -25,6 +25,9 @@ type LogText struct {
 	IsEnableForward      bool                   `bson:"is_enable_forward,omitempty"`       // 是否启用模型转发
 	ForwardConfig        *common.ForwardConfig  `bson:"forward_config,omitempty"`          // 模型转发配置
 	IsSmartMatch         bool                   `bson:"is_smart_match,omitempty"`          // 是否智能匹配
+	IsCacheHit           bool                   `bson:"is_cache_hit,omitempty"`            // 是否命中响应缓存
+	Guardrails           []*common.GuardrailHit `bson:"guardrails,omitempty"`              // 护栏命中记录
+	PromptTemplate       *common.Prompt         `bson:"prompt_template,omitempty"`         // 提示词模板
 	IsEnableFallback     bool                   `bson:"is_enable_fallback,omitempty"`      // 是否启用后备
 	FallbackConfig       *common.FallbackConfig `bson:"fallback_config,omitempty"`         // 后备配置
 	RealModelId          string                 `bson:"real_model_id,omitempty"`           // 真实模型ID
@@ -48,7 +51,7 @@ type LogText struct {
 	ErrMsg               string                 `bson:"err_msg,omitempty"`                 // 错误信息
 	IsRetry              bool                   `bson:"is_retry,omitempty"`                // 是否重试
 	Retry                *common.Retry          `bson:"retry,omitempty"`                   // 重试
-	Status               int                    `bson:"status,omitempty"`                  // 状态[1:成功, 2:中止, 3:重试, -1:失败]
+	Status               int                    `bson:"status,omitempty"`                  // 状态[1:成功, 2:中止, 3:重试, 4:流中断, -1:失败]
 	Host                 string                 `bson:"host,omitempty"`                    // Host
 	Method               string                 `bson:"method,omitempty"`                  // Method
 	Path                 string                 `bson:"path,omitempty"`                    // Path
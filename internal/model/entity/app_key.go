@@ -1,27 +1,36 @@
 package entity
 
+import "github.com/iimeta/fastapi/v2/internal/model/common"
+
 type AppKey struct {
-	Id                  string   `bson:"_id,omitempty"`                // ID
-	UserId              int      `bson:"user_id,omitempty"`            // 用户ID
-	AppId               int      `bson:"app_id,omitempty"`             // 应用ID
-	Key                 string   `bson:"key,omitempty"`                // 密钥
-	BillingMethods      []int    `bson:"billing_methods,omitempty"`    // 计费方式[1:按Tokens, 2:按次]
-	Models              []string `bson:"models,omitempty"`             // 模型权限
-	IsLimitQuota        bool     `bson:"is_limit_quota,omitempty"`     // 是否限制额度
-	Quota               int      `bson:"quota,omitempty"`              // 剩余额度
-	UsedQuota           int      `bson:"used_quota,omitempty"`         // 已用额度
-	QuotaExpiresRule    int      `bson:"quota_expires_rule,omitempty"` // 额度过期规则[1:固定, 2:时长]
-	QuotaExpiresAt      int64    `bson:"quota_expires_at,omitempty"`   // 额度过期时间
-	QuotaExpiresMinutes int64    `bson:"quota_expires_minutes"`        // 额度过期分钟数
-	IsBindGroup         bool     `bson:"is_bind_group,omitempty"`      // 是否绑定分组
-	Group               string   `bson:"group,omitempty"`              // 绑定分组
-	IpWhitelist         []string `bson:"ip_whitelist,omitempty"`       // IP白名单
-	IpBlacklist         []string `bson:"ip_blacklist,omitempty"`       // IP黑名单
-	Remark              string   `bson:"remark,omitempty"`             // 备注
-	Status              int      `bson:"status,omitempty"`             // 状态[1:正常, 2:禁用, -1:删除]
-	Rid                 int      `bson:"rid,omitempty"`                // 代理商ID
-	Creator             string   `bson:"creator,omitempty"`            // 创建人
-	Updater             string   `bson:"updater,omitempty"`            // 更新人
-	CreatedAt           int64    `bson:"created_at,omitempty"`         // 创建时间
-	UpdatedAt           int64    `bson:"updated_at,omitempty"`         // 更新时间
+	Id                  string                `bson:"_id,omitempty"`                // ID
+	UserId              int                   `bson:"user_id,omitempty"`            // 用户ID
+	AppId               int                   `bson:"app_id,omitempty"`             // 应用ID
+	Key                 string                `bson:"key,omitempty"`                // 密钥
+	BillingMethods      []int                 `bson:"billing_methods,omitempty"`    // 计费方式[1:按Tokens, 2:按次]
+	Models              []string              `bson:"models,omitempty"`             // 模型权限
+	IsLimitQuota        bool                  `bson:"is_limit_quota,omitempty"`     // 是否限制额度
+	Quota               int                   `bson:"quota,omitempty"`              // 剩余额度
+	UsedQuota           int                   `bson:"used_quota,omitempty"`         // 已用额度
+	QuotaExpiresRule    int                   `bson:"quota_expires_rule,omitempty"` // 额度过期规则[1:固定, 2:时长]
+	QuotaExpiresAt      int64                 `bson:"quota_expires_at,omitempty"`   // 额度过期时间
+	QuotaExpiresMinutes int64                 `bson:"quota_expires_minutes"`        // 额度过期分钟数
+	IsBindGroup         bool                  `bson:"is_bind_group,omitempty"`      // 是否绑定分组
+	Group               string                `bson:"group,omitempty"`              // 绑定分组
+	IpWhitelist         []string              `bson:"ip_whitelist,omitempty"`       // IP白名单
+	IpBlacklist         []string              `bson:"ip_blacklist,omitempty"`       // IP黑名单
+	RateLimit           *common.RateLimit     `bson:"rate_limit,omitempty"`         // 速率限制
+	ResponseCache       *common.ResponseCache `bson:"response_cache,omitempty"`     // 响应缓存
+	Guardrail           *common.Guardrail     `bson:"guardrail,omitempty"`          // 护栏
+	Prompt              *common.Prompt        `bson:"prompt,omitempty"`             // 提示词模板
+	ParentId            string                `bson:"parent_id,omitempty"`          // 父密钥ID, 自助签发的子密钥
+	ExpiresAt           int64                 `bson:"expires_at,omitempty"`         // 密钥过期时间, 0:永不过期
+	Metadata            map[string]string     `bson:"metadata,omitempty"`           // 元数据
+	Remark              string                `bson:"remark,omitempty"`             // 备注
+	Status              int                   `bson:"status,omitempty"`             // 状态[1:正常, 2:禁用, -1:删除]
+	Rid                 int                   `bson:"rid,omitempty"`                // 代理商ID
+	Creator             string                `bson:"creator,omitempty"`            // 创建人
+	Updater             string                `bson:"updater,omitempty"`            // 更新人
+	CreatedAt           int64                 `bson:"created_at,omitempty"`         // 创建时间
+	UpdatedAt           int64                 `bson:"updated_at,omitempty"`         // 更新时间
 }
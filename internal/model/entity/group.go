@@ -5,27 +5,32 @@ import (
 )
 
 type Group struct {
-	Id                 string                `bson:"_id,omitempty"`                   // ID
-	TimeRules          []*common.TimeRule    `bson:"time_rules,omitempty"`            // 时段规则
-	BillingMethods     []int                 `bson:"billing_methods,omitempty"`       // 计费方式[1:按Tokens, 2:按次]
-	Name               string                `bson:"name,omitempty"`                  // 分组名称
-	Models             []string              `bson:"models,omitempty"`                // 模型权限
-	IsEnableModelAgent bool                  `bson:"is_enable_model_agent,omitempty"` // 是否启用模型代理
-	LbStrategy         int                   `bson:"lb_strategy,omitempty"`           // 代理负载均衡策略[1:轮询, 2:权重]
-	ModelAgents        []string              `bson:"model_agents,omitempty"`          // 模型代理
-	IsDefault          bool                  `bson:"is_default,omitempty"`            // 是否默认分组
-	IsLimitQuota       bool                  `bson:"is_limit_quota,omitempty"`        // 是否限制额度
-	Quota              int                   `bson:"quota,omitempty"`                 // 剩余额度
-	UsedQuota          int                   `bson:"used_quota,omitempty"`            // 已用额度
-	IsEnableForward    bool                  `bson:"is_enable_forward,omitempty"`     // 是否启用模型转发
-	ForwardConfig      *common.ForwardConfig `bson:"forward_config,omitempty"`        // 模型转发配置
-	IsPublic           bool                  `bson:"is_public,omitempty"`             // 是否公开
-	Weight             int                   `bson:"weight,omitempty"`                // 权重
-	ExpiresAt          int64                 `bson:"expires_at,omitempty"`            // 过期时间
-	Remark             string                `bson:"remark,omitempty"`                // 备注
-	Status             int                   `bson:"status,omitempty"`                // 状态[1:正常, 2:禁用, -1:删除]
-	Creator            string                `bson:"creator,omitempty"`               // 创建人
-	Updater            string                `bson:"updater,omitempty"`               // 更新人
-	CreatedAt          int64                 `bson:"created_at,omitempty"`            // 创建时间
-	UpdatedAt          int64                 `bson:"updated_at,omitempty"`            // 更新时间
+	Id                 string                 `bson:"_id,omitempty"`                   // ID
+	TimeRules          []*common.TimeRule     `bson:"time_rules,omitempty"`            // 时段规则
+	BillingMethods     []int                  `bson:"billing_methods,omitempty"`       // 计费方式[1:按Tokens, 2:按次]
+	Name               string                 `bson:"name,omitempty"`                  // 分组名称
+	Models             []string               `bson:"models,omitempty"`                // 模型权限
+	IsEnableModelAgent bool                   `bson:"is_enable_model_agent,omitempty"` // 是否启用模型代理
+	LbStrategy         int                    `bson:"lb_strategy,omitempty"`           // 代理负载均衡策略[1:轮询, 2:权重, 3:最低延迟, 4:最少并发, 5:错误率加权]
+	ModelAgents        []string               `bson:"model_agents,omitempty"`          // 模型代理
+	IsDefault          bool                   `bson:"is_default,omitempty"`            // 是否默认分组
+	IsLimitQuota       bool                   `bson:"is_limit_quota,omitempty"`        // 是否限制额度
+	Quota              int                    `bson:"quota,omitempty"`                 // 剩余额度
+	UsedQuota          int                    `bson:"used_quota,omitempty"`            // 已用额度
+	IsEnableForward    bool                   `bson:"is_enable_forward,omitempty"`     // 是否启用模型转发
+	ForwardConfig      *common.ForwardConfig  `bson:"forward_config,omitempty"`        // 模型转发配置
+	RateLimit          *common.RateLimit      `bson:"rate_limit,omitempty"`            // 速率限制
+	IsEnableFallback   bool                   `bson:"is_enable_fallback,omitempty"`    // 是否启用后备, 模型未配置后备时使用
+	FallbackConfig     *common.FallbackConfig `bson:"fallback_config,omitempty"`       // 后备配置
+	ResponseCache      *common.ResponseCache  `bson:"response_cache,omitempty"`        // 响应缓存
+	Guardrail          *common.Guardrail      `bson:"guardrail,omitempty"`             // 护栏
+	IsPublic           bool                   `bson:"is_public,omitempty"`             // 是否公开
+	Weight             int                    `bson:"weight,omitempty"`                // 权重
+	ExpiresAt          int64                  `bson:"expires_at,omitempty"`            // 过期时间
+	Remark             string                 `bson:"remark,omitempty"`                // 备注
+	Status             int                    `bson:"status,omitempty"`                // 状态[1:正常, 2:禁用, -1:删除]
+	Creator            string                 `bson:"creator,omitempty"`               // 创建人
+	Updater            string                 `bson:"updater,omitempty"`               // 更新人
+	CreatedAt          int64                  `bson:"created_at,omitempty"`            // 创建时间
+	UpdatedAt          int64                  `bson:"updated_at,omitempty"`            // 更新时间
 }
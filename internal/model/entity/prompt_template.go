@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+)
+
+type PromptTemplate struct {
+	Id        string                  `bson:"_id,omitempty"`        // ID
+	UserId    int                     `bson:"user_id,omitempty"`    // 用户ID, 为空时所有用户可用
+	Name      string                  `bson:"name,omitempty"`       // 名称
+	Version   int                     `bson:"version,omitempty"`    // 当前版本
+	Versions  []*common.PromptVersion `bson:"versions,omitempty"`   // 版本列表
+	Remark    string                  `bson:"remark,omitempty"`     // 备注
+	Status    int                     `bson:"status,omitempty"`     // 状态[1:正常, 2:禁用, -1:删除]
+	Creator   string                  `bson:"creator,omitempty"`    // 创建人
+	Updater   string                  `bson:"updater,omitempty"`    // 更新人
+	CreatedAt int64                   `bson:"created_at,omitempty"` // 创建时间
+	UpdatedAt int64                   `bson:"updated_at,omitempty"` // 更新时间
+}
@@ -19,10 +19,14 @@ type SysConfig struct {
 	ImageStorage              *common.ImageStorage              `bson:"image_storage,omitempty"`                 // 绘图转储
 	VideoTask                 *common.VideoTask                 `bson:"video_task,omitempty"`                    // 视频任务
 	FileTask                  *common.FileTask                  `bson:"file_task,omitempty"`                     // 文件任务
+	BatchTask                 *common.BatchTask                 `bson:"batch_task,omitempty"`                    // 批处理任务
+	ResponsesStore            *common.ResponsesStore            `bson:"responses_store,omitempty"`               // 响应存储
 	ModelAgentHealthCheckTask *common.ModelAgentHealthCheckTask `bson:"model_agent_health_check_task,omitempty"` // 模型代理健康检查任务
 	ModelAgentSessionKeep     *common.ModelAgentSessionKeep     `bson:"model_agent_session_keep,omitempty"`      // 会话保持
 	ServiceUnavailable        *common.ServiceUnavailable        `bson:"service_unavailable,omitempty"`           // 暂停服务
 	GeneralApi                *common.GeneralApi                `bson:"general_api,omitempty"`                   // 通用API
+	CircuitBreaker            *common.CircuitBreaker            `bson:"circuit_breaker,omitempty"`               // 熔断
+	Metrics                   *common.Metrics                   `bson:"metrics,omitempty"`                       // 监控指标
 	Debug                     *common.Debug                     `bson:"debug,omitempty"`                         // 调试
 	Creator                   string                            `bson:"creator,omitempty"`                       // 创建人
 	Updater                   string                            `bson:"updater,omitempty"`                       // 更新人
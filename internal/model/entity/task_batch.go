@@ -20,6 +20,15 @@ type TaskBatch struct {
 	FailedAt     int64          `bson:"failed_at,omitempty"`      // 失败时间
 	ResponseData map[string]any `bson:"response_data,omitempty"`  // 响应数据
 	Error        map[string]any `bson:"error,omitempty"`          // 错误信息
+	IsNative     bool           `bson:"is_native,omitempty"`      // 是否网关批处理
+	Endpoint     string         `bson:"endpoint,omitempty"`       // 请求端点
+	Priority     string         `bson:"priority,omitempty"`       // 优先级[normal:普通, low:低]
+	Total        int            `bson:"total,omitempty"`          // 请求总数
+	Completed    int            `bson:"completed,omitempty"`      // 成功请求数
+	Failed       int            `bson:"failed,omitempty"`         // 失败请求数
+	Offset       int            `bson:"offset,omitempty"`         // 已处理行数, 节点重启后从此处继续
+	OutputBytes  int64          `bson:"output_bytes,omitempty"`   // 输出文件已写入字节数
+	ErrorBytes   int64          `bson:"error_bytes,omitempty"`    // 错误文件已写入字节数
 	Rid          int            `bson:"rid,omitempty"`            // 代理商ID
 	Creator      string         `bson:"creator,omitempty"`        // 创建人
 	Updater      string         `bson:"updater,omitempty"`        // 更新人
@@ -21,6 +21,7 @@ type TaskFile struct {
 	ResponseData map[string]any    `bson:"response_data,omitempty"`  // 响应数据
 	Error        *serrors.ApiError `bson:"error,omitempty"`          // 错误信息
 	BatchTraceId string            `bson:"batch_trace_id,omitempty"` // 批处理日志ID
+	IsNative     bool              `bson:"is_native,omitempty"`      // 是否网关批处理文件
 	Rid          int               `bson:"rid,omitempty"`            // 代理商ID
 	Creator      string            `bson:"creator,omitempty"`        // 创建人
 	Updater      string            `bson:"updater,omitempty"`        // 更新人
@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"github.com/iimeta/fastapi/v2/internal/model/common"
+)
+
+type TaskResponse struct {
+	Id                 string              `bson:"_id,omitempty"`                  // ID
+	TraceId            string              `bson:"trace_id,omitempty"`             // 日志ID
+	UserId             int                 `bson:"user_id,omitempty"`              // 用户ID
+	AppId              int                 `bson:"app_id,omitempty"`               // 应用ID
+	Model              string              `bson:"model,omitempty"`                // 模型
+	ResponseId         string              `bson:"response_id,omitempty"`          // 响应ID
+	PreviousResponseId string              `bson:"previous_response_id,omitempty"` // 上一个响应ID
+	Input              []map[string]any    `bson:"input,omitempty"`                // 输入项, 含上一个响应的上下文
+	Output             []map[string]any    `bson:"output,omitempty"`               // 输出项
+	ResponseData       map[string]any      `bson:"response_data,omitempty"`        // 响应数据
+	Status             string              `bson:"status,omitempty"`               // 状态[completed:已完成, incomplete:未完成, failed:已失败, deleted:已删除]
+	ExpiresAt          int64               `bson:"expires_at,omitempty"`           // 过期时间
+	Privacy            *common.UserPrivacy `bson:"privacy,omitempty"`              // 隐私设置
+	Rid                int                 `bson:"rid,omitempty"`                  // 代理商ID
+	Creator            string              `bson:"creator,omitempty"`              // 创建人
+	Updater            string              `bson:"updater,omitempty"`              // 更新人
+	CreatedAt          int64               `bson:"created_at,omitempty"`           // 创建时间
+	UpdatedAt          int64               `bson:"updated_at,omitempty"`           // 更新时间
+}
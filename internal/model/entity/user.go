@@ -15,6 +15,7 @@ type User struct {
 	Groups         []string            `bson:"groups,omitempty"`           // 分组权限
 	Remark         string              `bson:"remark,omitempty"`           // 备注
 	Privacy        *common.UserPrivacy `bson:"privacy,omitempty"`          // 隐私设置
+	RateLimit      *common.RateLimit   `bson:"rate_limit,omitempty"`       // 速率限制
 	Status         int                 `bson:"status,omitempty"`           // 状态[1:正常, 2:禁用, -1:删除]
 	Rid            int                 `bson:"rid,omitempty"`              // 代理商ID
 	Creator        string              `bson:"creator,omitempty"`          // 创建人
@@ -3,27 +3,32 @@ package model
 import "github.com/iimeta/fastapi/v2/internal/model/common"
 
 type Group struct {
-	Id                 string                `json:"id,omitempty"`                    // ID
-	TimeRules          []*common.TimeRule    `json:"time_rules,omitempty"`            // 时段规则
-	BillingMethods     []int                 `json:"billing_methods,omitempty"`       // 计费方式[1:按Tokens, 2:按次]
-	Name               string                `json:"name,omitempty"`                  // 分组名称
-	Models             []string              `json:"models,omitempty"`                // 模型权限
-	IsEnableModelAgent bool                  `json:"is_enable_model_agent,omitempty"` // 是否启用模型代理
-	LbStrategy         int                   `json:"lb_strategy,omitempty"`           // 代理负载均衡策略[1:轮询, 2:权重]
-	ModelAgents        []string              `json:"model_agents,omitempty"`          // 模型代理
-	IsDefault          bool                  `json:"is_default,omitempty"`            // 是否默认分组
-	IsLimitQuota       bool                  `json:"is_limit_quota,omitempty"`        // 是否限制额度
-	Quota              int                   `json:"quota,omitempty"`                 // 剩余额度
-	UsedQuota          int                   `json:"used_quota,omitempty"`            // 已用额度
-	IsEnableForward    bool                  `json:"is_enable_forward,omitempty"`     // 是否启用模型转发
-	ForwardConfig      *common.ForwardConfig `json:"forward_config,omitempty"`        // 模型转发配置
-	IsPublic           bool                  `json:"is_public,omitempty"`             // 是否公开
-	Weight             int                   `json:"weight,omitempty"`                // 权重
-	ExpiresAt          int64                 `json:"expires_at,omitempty"`            // 过期时间
-	Remark             string                `json:"remark,omitempty"`                // 备注
-	Status             int                   `json:"status,omitempty"`                // 状态[1:正常, 2:禁用, -1:删除]
-	Creator            string                `json:"creator,omitempty"`               // 创建人
-	Updater            string                `json:"updater,omitempty"`               // 更新人
-	CreatedAt          string                `json:"created_at,omitempty"`            // 创建时间
-	UpdatedAt          string                `json:"updated_at,omitempty"`            // 更新时间
+	Id                 string                 `json:"id,omitempty"`                    // ID
+	TimeRules          []*common.TimeRule     `json:"time_rules,omitempty"`            // 时段规则
+	BillingMethods     []int                  `json:"billing_methods,omitempty"`       // 计费方式[1:按Tokens, 2:按次]
+	Name               string                 `json:"name,omitempty"`                  // 分组名称
+	Models             []string               `json:"models,omitempty"`                // 模型权限
+	IsEnableModelAgent bool                   `json:"is_enable_model_agent,omitempty"` // 是否启用模型代理
+	LbStrategy         int                    `json:"lb_strategy,omitempty"`           // 代理负载均衡策略[1:轮询, 2:权重, 3:最低延迟, 4:最少并发, 5:错误率加权]
+	ModelAgents        []string               `json:"model_agents,omitempty"`          // 模型代理
+	IsDefault          bool                   `json:"is_default,omitempty"`            // 是否默认分组
+	IsLimitQuota       bool                   `json:"is_limit_quota,omitempty"`        // 是否限制额度
+	Quota              int                    `json:"quota,omitempty"`                 // 剩余额度
+	UsedQuota          int                    `json:"used_quota,omitempty"`            // 已用额度
+	IsEnableForward    bool                   `json:"is_enable_forward,omitempty"`     // 是否启用模型转发
+	ForwardConfig      *common.ForwardConfig  `json:"forward_config,omitempty"`        // 模型转发配置
+	RateLimit          *common.RateLimit      `json:"rate_limit,omitempty"`            // 速率限制
+	IsEnableFallback   bool                   `json:"is_enable_fallback,omitempty"`    // 是否启用后备, 模型未配置后备时使用
+	FallbackConfig     *common.FallbackConfig `json:"fallback_config,omitempty"`       // 后备配置
+	ResponseCache      *common.ResponseCache  `json:"response_cache,omitempty"`        // 响应缓存
+	Guardrail          *common.Guardrail      `json:"guardrail,omitempty"`             // 护栏
+	IsPublic           bool                   `json:"is_public,omitempty"`             // 是否公开
+	Weight             int                    `json:"weight,omitempty"`                // 权重
+	ExpiresAt          int64                  `json:"expires_at,omitempty"`            // 过期时间
+	Remark             string                 `json:"remark,omitempty"`                // 备注
+	Status             int                    `json:"status,omitempty"`                // 状态[1:正常, 2:禁用, -1:删除]
+	Creator            string                 `json:"creator,omitempty"`               // 创建人
+	Updater            string                 `json:"updater,omitempty"`               // 更新人
+	CreatedAt          string                 `json:"created_at,omitempty"`            // 创建时间
+	UpdatedAt          string                 `json:"updated_at,omitempty"`            // 更新时间
 }
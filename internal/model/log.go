@@ -20,6 +20,9 @@ type LogText struct {
 	RetryInfo          *mcommon.Retry
 	Spend              mcommon.Spend
 	IsSmartMatch       bool
+	IsCacheHit         bool
+	Guardrails         []*mcommon.GuardrailHit
+	PromptTemplate     *mcommon.Prompt
 }
 
 type LogImage struct {
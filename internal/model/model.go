@@ -3,34 +3,40 @@ package model
 import "github.com/iimeta/fastapi/v2/internal/model/common"
 
 type Model struct {
-	Id                       string                 `json:"id,omitempty"`                          // ID
-	ProviderId               string                 `json:"provider_id,omitempty"`                 // 提供商ID
-	Name                     string                 `json:"name,omitempty"`                        // 模型名称
-	Model                    string                 `json:"model,omitempty"`                       // 模型
-	Type                     int                    `json:"type,omitempty"`                        // 模型类型[1:文生文, 2:文生图, 3:图生文, 4:图生图, 5:文生语音, 6:语音生文, 7:文本向量化, 8:视频生成, 100:多模态, 101:多模态实时, 102:多模态语音, 103:多模态向量化, 10000:通用]
-	IsEnablePresetConfig     bool                   `json:"is_enable_preset_config,omitempty"`     // 是否启用预设配置
-	PresetConfig             common.PresetConfig    `json:"preset_config,omitempty"`               // 预设配置
-	TimeRules                []*common.TimeRule     `json:"time_rules,omitempty"`                  // 时段规则
-	Pricing                  common.Pricing         `json:"pricing,omitempty"`                     // 定价
-	IsEnableDataPassthrough  bool                   `json:"is_enable_data_passthrough,omitempty"`  // 是否启用数据透传
-	ReqPassthroughParams     []string               `json:"req_passthrough_params,omitempty"`      // 请求透传参数
-	ReqHeaderPassthroughMode int                    `json:"req_header_passthrough_mode,omitempty"` // 请求头透传模式[1:全量, 2:指定]
-	ReqHeaderPassthroughList []string               `json:"req_header_passthrough_list,omitempty"` // 请求头透传白名单
-	ResPassthroughParams     []string               `json:"res_passthrough_params,omitempty"`      // 响应透传参数
-	ResHeaderPassthroughMode int                    `json:"res_header_passthrough_mode,omitempty"` // 响应头透传模式[1:全量, 2:指定]
-	ResHeaderPassthroughList []string               `json:"res_header_passthrough_list,omitempty"` // 响应头透传白名单
-	IsPublic                 bool                   `json:"is_public,omitempty"`                   // 是否公开
-	Endpoints                []string               `json:"endpoints,omitempty"`                   // 支持的端点, 空表示不限制
-	LbStrategy               int                    `json:"lb_strategy,omitempty"`                 // 代理负载均衡策略[1:轮询, 2:权重]
-	ModelAgents              []string               `json:"model_agents,omitempty"`                // 模型代理
-	IsEnableForward          bool                   `json:"is_enable_forward,omitempty"`           // 是否启用模型转发
-	ForwardConfig            *common.ForwardConfig  `json:"forward_config,omitempty"`              // 模型转发配置
-	IsEnableFallback         bool                   `json:"is_enable_fallback,omitempty"`          // 是否启用后备
-	FallbackConfig           *common.FallbackConfig `json:"fallback_config,omitempty"`             // 后备配置
-	Remark                   string                 `json:"remark,omitempty"`                      // 备注
-	Status                   int                    `json:"status,omitempty"`                      // 状态[1:正常, 2:禁用, -1:删除]
-	Creator                  string                 `json:"creator,omitempty"`                     // 创建人
-	Updater                  string                 `json:"updater,omitempty"`                     // 更新人
-	CreatedAt                int64                  `json:"created_at,omitempty"`                  // 创建时间
-	UpdatedAt                int64                  `json:"updated_at,omitempty"`                  // 更新时间
+	Id                       string                  `json:"id,omitempty"`                          // ID
+	ProviderId               string                  `json:"provider_id,omitempty"`                 // 提供商ID
+	Name                     string                  `json:"name,omitempty"`                        // 模型名称
+	Model                    string                  `json:"model,omitempty"`                       // 模型
+	Type                     int                     `json:"type,omitempty"`                        // 模型类型[1:文生文, 2:文生图, 3:图生文, 4:图生图, 5:文生语音, 6:语音生文, 7:文本向量化, 8:视频生成, 9:重排序, 100:多模态, 101:多模态实时, 102:多模态语音, 103:多模态向量化, 10000:通用]
+	IsEnablePresetConfig     bool                    `json:"is_enable_preset_config,omitempty"`     // 是否启用预设配置
+	PresetConfig             common.PresetConfig     `json:"preset_config,omitempty"`               // 预设配置
+	TimeRules                []*common.TimeRule      `json:"time_rules,omitempty"`                  // 时段规则
+	Pricing                  common.Pricing          `json:"pricing,omitempty"`                     // 定价
+	IsEnableDataPassthrough  bool                    `json:"is_enable_data_passthrough,omitempty"`  // 是否启用数据透传
+	ReqPassthroughParams     []string                `json:"req_passthrough_params,omitempty"`      // 请求透传参数
+	ReqHeaderPassthroughMode int                     `json:"req_header_passthrough_mode,omitempty"` // 请求头透传模式[1:全量, 2:指定]
+	ReqHeaderPassthroughList []string                `json:"req_header_passthrough_list,omitempty"` // 请求头透传白名单
+	ResPassthroughParams     []string                `json:"res_passthrough_params,omitempty"`      // 响应透传参数
+	ResHeaderPassthroughMode int                     `json:"res_header_passthrough_mode,omitempty"` // 响应头透传模式[1:全量, 2:指定]
+	ResHeaderPassthroughList []string                `json:"res_header_passthrough_list,omitempty"` // 响应头透传白名单
+	IsPublic                 bool                    `json:"is_public,omitempty"`                   // 是否公开
+	Endpoints                []string                `json:"endpoints,omitempty"`                   // 支持的端点, 空表示不限制
+	ContextWindow            int                     `json:"context_window,omitempty"`              // 上下文窗口, 单位: Token, 0:未知
+	LbStrategy               int                     `json:"lb_strategy,omitempty"`                 // 代理负载均衡策略[1:轮询, 2:权重, 3:最低延迟, 4:最少并发, 5:错误率加权]
+	ModelAgents              []string                `json:"model_agents,omitempty"`                // 模型代理
+	IsEnableForward          bool                    `json:"is_enable_forward,omitempty"`           // 是否启用模型转发
+	ForwardConfig            *common.ForwardConfig   `json:"forward_config,omitempty"`              // 模型转发配置
+	IsEnableFallback         bool                    `json:"is_enable_fallback,omitempty"`          // 是否启用后备
+	FallbackConfig           *common.FallbackConfig  `json:"fallback_config,omitempty"`             // 后备配置
+	IsEnableHedge            bool                    `json:"is_enable_hedge,omitempty"`             // 是否启用对冲请求
+	HedgeConfig              *common.HedgeConfig     `json:"hedge_config,omitempty"`                // 对冲请求配置
+	ResponseCache            *common.ResponseCache   `json:"response_cache,omitempty"`              // 响应缓存
+	Network                  *common.UpstreamNetwork `json:"network,omitempty"`                     // 网络配置, 代理、超时和TLS
+	StreamTimeout            *common.StreamTimeout   `json:"stream_timeout,omitempty"`              // 流式超时配置
+	Remark                   string                  `json:"remark,omitempty"`                      // 备注
+	Status                   int                     `json:"status,omitempty"`                      // 状态[1:正常, 2:禁用, -1:删除]
+	Creator                  string                  `json:"creator,omitempty"`                     // 创建人
+	Updater                  string                  `json:"updater,omitempty"`                     // 更新人
+	CreatedAt                int64                   `json:"created_at,omitempty"`                  // 创建时间
+	UpdatedAt                int64                   `json:"updated_at,omitempty"`                  // 更新时间
 }
@@ -22,7 +22,7 @@ type ModelAgent struct {
 	IsEnableSessionKeep      bool                          `json:"is_enable_session_keep,omitempty"`      // 是否启用会话保持
 	SessionKeepConfig        *common.ModelAgentSessionKeep `json:"session_keep_config,omitempty"`         // 会话保持配置
 	IsNeverDisable           bool                          `json:"is_never_disable,omitempty"`            // 是否永不禁用
-	LbStrategy               int                           `json:"lb_strategy,omitempty"`                 // 密钥负载均衡策略[1:轮询, 2:权重]
+	LbStrategy               int                           `json:"lb_strategy,omitempty"`                 // 密钥负载均衡策略[1:轮询, 2:权重, 3:最低延迟, 4:最少并发, 5:错误率加权]
 	IsEnableDataPassthrough  bool                          `json:"is_enable_data_passthrough,omitempty"`  // 是否启用数据透传
 	ReqPassthroughParams     []string                      `json:"req_passthrough_params,omitempty"`      // 请求透传参数
 	ReqHeaderPassthroughMode int                           `json:"req_header_passthrough_mode,omitempty"` // 请求头透传模式[1:全量, 2:指定]
@@ -30,6 +30,8 @@ type ModelAgent struct {
 	ResPassthroughParams     []string                      `json:"res_passthrough_params,omitempty"`      // 响应透传参数
 	ResHeaderPassthroughMode int                           `json:"res_header_passthrough_mode,omitempty"` // 响应头透传模式[1:全量, 2:指定]
 	ResHeaderPassthroughList []string                      `json:"res_header_passthrough_list,omitempty"` // 响应头透传白名单
+	UpstreamLimit            *common.UpstreamLimit         `json:"upstream_limit,omitempty"`              // 上游限制
+	Network                  *common.UpstreamNetwork       `json:"network,omitempty"`                     // 网络配置, 代理、超时和TLS
 	Remark                   string                        `json:"remark,omitempty"`                      // 备注
 	Status                   int                           `json:"status,omitempty"`                      // 状态[1:正常, 2:禁用, -1:删除]
 	IsAutoDisabled           bool                          `json:"is_auto_disabled,omitempty"`            // 是否自动禁用
@@ -0,0 +1,17 @@
+package model
+
+import "github.com/iimeta/fastapi/v2/internal/model/common"
+
+type PromptTemplate struct {
+	Id        string                  `json:"id,omitempty"`         // ID
+	UserId    int                     `json:"user_id,omitempty"`    // 用户ID, 为空时所有用户可用
+	Name      string                  `json:"name,omitempty"`       // 名称
+	Version   int                     `json:"version,omitempty"`    // 当前版本
+	Versions  []*common.PromptVersion `json:"versions,omitempty"`   // 版本列表
+	Remark    string                  `json:"remark,omitempty"`     // 备注
+	Status    int                     `json:"status,omitempty"`     // 状态[1:正常, 2:禁用, -1:删除]
+	Creator   string                  `json:"creator,omitempty"`    // 创建人
+	Updater   string                  `json:"updater,omitempty"`    // 更新人
+	CreatedAt string                  `json:"created_at,omitempty"` // 创建时间
+	UpdatedAt string                  `json:"updated_at,omitempty"` // 更新时间
+}
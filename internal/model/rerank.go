@@ -0,0 +1,39 @@
+package model
+
+import (
+	"net/http"
+)
+
+// 重排序请求
+type RerankRequest struct {
+	Model           string `json:"model"`
+	Query           string `json:"query"`
+	Documents       []any  `json:"documents"` // 文档, 字符串或包含text的对象
+	TopN            int    `json:"top_n,omitempty"`
+	ReturnDocuments bool   `json:"return_documents,omitempty"`
+}
+
+// 重排序响应
+type RerankResponse struct {
+	Id              string         `json:"id,omitempty"`
+	Model           string         `json:"model,omitempty"`
+	Results         []RerankResult `json:"results"`
+	Usage           *RerankUsage   `json:"usage,omitempty"`
+	ResponseHeaders http.Header    `json:"-"`
+	TotalTime       int64          `json:"-"`
+}
+
+type RerankResult struct {
+	Index          int             `json:"index"`
+	RelevanceScore float64         `json:"relevance_score"`
+	Document       *RerankDocument `json:"document,omitempty"`
+}
+
+type RerankDocument struct {
+	Text string `json:"text"`
+}
+
+type RerankUsage struct {
+	TotalTokens int `json:"total_tokens,omitempty"`
+	SearchUnits int `json:"search_units,omitempty"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	mcommon "github.com/iimeta/fastapi/v2/internal/model/common"
+)
+
+// 用量事件, 结构版本见 consts.USAGE_EVENT_SCHEMA_VERSION, 下游按 EventId 去重
+type UsageEvent struct {
+	SchemaVersion  string            `json:"schema_version"`             // 结构版本
+	EventId        string            `json:"event_id"`                   // 事件ID, 幂等键, 重复投递时不变
+	EventTime      int64             `json:"event_time"`                 // 事件时间(毫秒)
+	TraceId        string            `json:"trace_id"`                   // 日志ID
+	Rid            int               `json:"rid,omitempty"`              // 代理商ID
+	UserId         int               `json:"user_id"`                    // 用户ID
+	AppId          int               `json:"app_id"`                     // 应用ID
+	AppKeyId       string            `json:"app_key_id,omitempty"`       // 应用密钥ID
+	GroupId        string            `json:"group_id,omitempty"`         // 分组ID
+	GroupName      string            `json:"group_name,omitempty"`       // 分组名称
+	Endpoint       string            `json:"endpoint,omitempty"`         // 端点
+	Action         string            `json:"action,omitempty"`           // 接口
+	Model          string            `json:"model,omitempty"`            // 请求模型
+	RealModel      string            `json:"real_model,omitempty"`       // 实际模型
+	Provider       string            `json:"provider,omitempty"`         // 提供商
+	ModelAgentId   string            `json:"model_agent_id,omitempty"`   // 模型代理ID
+	ModelAgentName string            `json:"model_agent_name,omitempty"` // 模型代理名称
+	KeyId          string            `json:"key_id,omitempty"`           // 上游密钥ID
+	IsSmartMatch   bool              `json:"is_smart_match,omitempty"`   // 是否智能匹配
+	IsCacheHit     bool              `json:"is_cache_hit,omitempty"`     // 是否命中响应缓存
+	Tokens         UsageEventTokens  `json:"tokens"`                     // 用量
+	Spend          mcommon.Spend     `json:"spend"`                      // 花费
+	Status         string            `json:"status"`                     // 状态[success:成功, error:失败, aborted:中断]
+	ErrMsg         string            `json:"err_msg,omitempty"`          // 错误信息
+	Timings        UsageEventTimings `json:"timings"`                    // 耗时
+}
+
+// 用量事件Token数
+type UsageEventTokens struct {
+	Prompt     int `json:"prompt"`      // 输入
+	Completion int `json:"completion"`  // 输出
+	Total      int `json:"total"`       // 总数
+	Cached     int `json:"cached"`      // 缓存读取
+	CacheWrite int `json:"cache_write"` // 缓存写入
+	Reasoning  int `json:"reasoning"`   // 推理
+}
+
+// 用量事件耗时(毫秒)
+type UsageEventTimings struct {
+	ReqTime      int64 `json:"req_time"`      // 请求时间
+	ConnTime     int64 `json:"conn_time"`     // 连接时间
+	Duration     int64 `json:"duration"`      // 上游耗时
+	TotalTime    int64 `json:"total_time"`    // 总耗时
+	InternalTime int64 `json:"internal_time"` // 内耗时
+}
@@ -15,6 +15,7 @@ type User struct {
 	Groups         []string            `json:"groups,omitempty"`           // 分组权限
 	Remark         string              `json:"remark,omitempty"`           // 备注
 	Privacy        *common.UserPrivacy `json:"privacy,omitempty"`          // 隐私设置
+	RateLimit      *common.RateLimit   `json:"rate_limit,omitempty"`       // 速率限制
 	Status         int                 `json:"status,omitempty"`           // 状态[1:正常, 2:禁用, -1:删除]
 	Rid            int                 `json:"rid,omitempty"`              // 代理商ID
 	CreatedAt      string              `json:"created_at,omitempty"`       // 创建时间
@@ -19,6 +19,8 @@ type (
 		Completions(ctx context.Context, request *ghttp.Request, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (response smodel.ChatCompletionResponse, err error)
 		// CompletionsStream
 		CompletionsStream(ctx context.Context, request *ghttp.Request, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (err error)
+		// CountTokens
+		CountTokens(ctx context.Context, request *ghttp.Request) (inputTokens int, err error)
 	}
 )
 
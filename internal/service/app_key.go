@@ -38,6 +38,12 @@ type (
 		UpdateQuotaExpiresAt(ctx context.Context, key *model.AppKey) error
 		// 变更订阅
 		Subscribe(ctx context.Context, msg string) error
+		// 签发子密钥
+		CreateSubKey(ctx context.Context, params model.SubKeyCreateReq) (*model.SubKey, error)
+		// 子密钥列表
+		ListSubKey(ctx context.Context, params model.SubKeyListReq) (*model.SubKeyListRes, error)
+		// 撤销子密钥, 未用完的额度退回父密钥
+		RevokeSubKey(ctx context.Context, id string) (*model.SubKey, error)
 	}
 )
 
@@ -16,7 +16,7 @@ type (
 		// 解析密钥
 		ParseSecretKey(ctx context.Context, secretKey string) (int, int, error)
 		// 记录错误次数和禁用
-		RecordError(ctx context.Context, model *model.Model, key *model.Key, modelAgent *model.ModelAgent)
+		RecordError(ctx context.Context, model *model.Model, key *model.Key, modelAgent *model.ModelAgent, err error)
 	}
 )
 
@@ -17,6 +17,18 @@ type (
 		Subscription(ctx context.Context) (*model.DashboardSubscriptionRes, error)
 		// Usage
 		Usage(ctx context.Context) (*model.DashboardUsageRes, error)
+		// Usage明细
+		UsageDetail(ctx context.Context, params model.DashboardReportReq) (*model.DashboardReportRes, error)
+		// Costs
+		Costs(ctx context.Context, params model.DashboardReportReq) (*model.DashboardReportRes, error)
+		// Models
+		Models(ctx context.Context, isFastAPI bool) (*model.DashboardModelsRes, error)
+		// Model
+		Model(ctx context.Context, id string, isFastAPI bool) (*model.DashboardModelsData, error)
+		// Anthropic Models
+		AnthropicModels(ctx context.Context) (*model.AnthropicModelsRes, error)
+		// Anthropic Model
+		AnthropicModel(ctx context.Context, id string) (*model.AnthropicModel, error)
 	}
 )
 
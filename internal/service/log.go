@@ -27,6 +27,8 @@ type (
 		Batch(ctx context.Context, batchLog model.LogBatch, retry ...int)
 		// 通用日志
 		General(ctx context.Context, generalLog model.LogGeneral, retry ...int)
+		// 关闭日志写入, 队列中的日志写入数据库或本地缓冲文件
+		Close(ctx context.Context)
 	}
 )
 
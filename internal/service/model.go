@@ -46,7 +46,7 @@ type (
 		// 获取分组目标模型
 		GetGroupTargetModel(ctx context.Context, group *model.Group, model *model.Model, messages []smodel.ChatCompletionMessage) (targetModel *model.Model, err error)
 		// 获取后备模型
-		GetFallbackModel(ctx context.Context, model *model.Model) (fallbackModel *model.Model, err error)
+		GetFallbackModel(ctx context.Context, id string) (fallbackModel *model.Model, err error)
 		// 变更订阅
 		Subscribe(ctx context.Context, msg string) error
 	}
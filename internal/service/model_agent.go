@@ -7,6 +7,7 @@ package service
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/iimeta/fastapi/v2/internal/model"
 	"github.com/iimeta/fastapi/v2/internal/model/entity"
@@ -69,9 +70,19 @@ type (
 		// 保存模型代理到缓存
 		SaveCache(ctx context.Context, modelAgent *model.ModelAgent) error
 		// 获取后备模型代理
-		GetFallback(ctx context.Context, model *model.Model) (fallbackModelAgent *model.ModelAgent, err error)
+		GetFallback(ctx context.Context, id string) (fallbackModelAgent *model.ModelAgent, err error)
 		// 保存分组模型代理列表到缓存
 		SaveGroupCache(ctx context.Context, group *model.Group) error
+		// 记录上游用量
+		RecordUpstreamUsage(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, totalTokens, spendQuota int)
+		// 根据上游响应头记录模型代理和密钥限流状态
+		RecordUpstreamHeaders(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key, header http.Header)
+		// 根据上游限流错误(429)记录模型代理和密钥限流状态, 一分钟后重置
+		RecordUpstreamRateLimited(ctx context.Context, modelAgent *model.ModelAgent, key *model.Key)
+		// 记录模型代理和密钥进行中的请求, 返回并发成员
+		AcquireInflight(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key) string
+		// 记录请求结果, 用于负载策略和熔断
+		RecordResult(ctx context.Context, m *model.Model, group *model.Group, modelAgent *model.ModelAgent, key *model.Key, member string, latency int64, isFailure bool)
 		// 变更订阅
 		Subscribe(ctx context.Context, msg string) error
 	}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/gogf/gf/v2/net/ghttp"
 	smodel "github.com/iimeta/fastapi-sdk/v2/model"
+	v1 "github.com/iimeta/fastapi/v2/api/openai/v1"
 	"github.com/iimeta/fastapi/v2/internal/model"
 )
 
@@ -21,6 +22,12 @@ type (
 		ResponsesStream(ctx context.Context, request *ghttp.Request, isChatCompletions bool, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (err error)
 		// ResponsesCompact
 		ResponsesCompact(ctx context.Context, request *ghttp.Request, isChatCompletions bool, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, retry ...int) (response smodel.OpenAIResponsesRes, err error)
+		// ResponsesRetrieve
+		ResponsesRetrieve(ctx context.Context, params *v1.ResponsesRetrieveReq) (response map[string]any, err error)
+		// ResponsesDelete
+		ResponsesDelete(ctx context.Context, params *v1.ResponsesDeleteReq) (response map[string]any, err error)
+		// ResponsesInputItems
+		ResponsesInputItems(ctx context.Context, params *v1.ResponsesInputItemsReq) (response map[string]any, err error)
 	}
 )
 
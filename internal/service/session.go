@@ -80,6 +80,14 @@ type (
 		SaveSessionKey(ctx context.Context, sk *common.SessionKey)
 		// 获取会话保持SessionKey
 		GetSessionKey(ctx context.Context) *common.SessionKey
+		// 保存后备链执行状态
+		SaveFallbackState(ctx context.Context, state *common.FallbackState)
+		// 获取后备链执行状态
+		GetFallbackState(ctx context.Context) *common.FallbackState
+		// 保存提示词模板
+		SavePrompt(ctx context.Context, prompt *common.Prompt)
+		// 获取提示词模板
+		GetPrompt(ctx context.Context) *common.Prompt
 	}
 )
 
@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 
 	"github.com/tjfoc/gmsm/sm3"
@@ -19,3 +21,9 @@ func EncryptPassword(data string) string {
 func VerifyPassword(cipherPwd, plainPwd string) bool {
 	return cipherPwd == SM3(plainPwd)
 }
+
+func HmacSHA256(secret string, data []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package lb
+
+import "math/rand/v2"
+
+// 最小值下标, 相同时取靠前的
+func MinIndex(values []float64) (index int) {
+
+	for i, value := range values {
+		if value < values[index] {
+			index = i
+		}
+	}
+
+	return
+}
+
+// 按权重随机挑选下标, 权重均为0时随机挑选
+func RandomWeightIndex(weights []float64) int {
+
+	total := 0.0
+	for _, weight := range weights {
+		if weight > 0 {
+			total += weight
+		}
+	}
+
+	if total <= 0 {
+		return rand.IntN(len(weights))
+	}
+
+	var (
+		r     = rand.Float64() * total
+		index int
+	)
+
+	for i, weight := range weights {
+
+		if weight <= 0 {
+			continue
+		}
+
+		if index = i; r < weight {
+			break
+		}
+
+		r -= weight
+	}
+
+	return index
+}
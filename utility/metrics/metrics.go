@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 默认耗时分桶(毫秒)
+var DefBuckets = []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000}
+
+type collector interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	collectors []collector
+	mutex      sync.RWMutex
+)
+
+func register(c collector) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	collectors = append(collectors, c)
+}
+
+// Prometheus文本格式指标数据
+func Expose() []byte {
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	buf := new(bytes.Buffer)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+
+	return buf.Bytes()
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	count       uint64
+}
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	series map[string]*series
+	mutex  sync.Mutex
+}
+
+func (v *vec) get(labelValues []string, buckets int) *series {
+
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics %s expected %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if buckets > 0 {
+			s.buckets = make([]uint64, buckets)
+		}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+func (v *vec) sortedSeries() []*series {
+
+	list := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		list = append(list, s)
+	}
+
+	slices.SortFunc(list, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+
+	return list
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec{name: name, help: help, labels: labels, series: make(map[string]*series)}}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+
+	if value < 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.get(labelValues, 0).value += value
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	for _, s := range c.sortedSeries() {
+		fmt.Fprintf(buf, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec{name: name, help: help, labels: labels, series: make(map[string]*series)}}
+	register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.get(labelValues, 0).value = value
+}
+
+func (g *GaugeVec) write(buf *bytes.Buffer) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+
+	for _, s := range g.sortedSeries() {
+		fmt.Fprintf(buf, "%s%s %s\n", g.name, formatLabels(g.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: vec{name: name, help: help, labels: labels, series: make(map[string]*series)}, buckets: buckets}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := h.get(labelValues, len(h.buckets))
+	s.value += value
+	s.count++
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	labels := append(slices.Clone(h.labels), "le")
+
+	for _, s := range h.sortedSeries() {
+
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(slices.Clone(s.labelValues), formatFloat(upperBound))), s.buckets[i])
+		}
+
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(slices.Clone(s.labelValues), "+Inf")), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues), formatFloat(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), s.count)
+	}
+}
+
+func formatLabels(labels, labelValues []string) string {
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	builder.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(label)
+		builder.WriteString(`="`)
+		builder.WriteString(escape(labelValues[i]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -307,3 +307,7 @@ func ZRange(ctx context.Context, key string, start, stop int64) ([]string, error
 func ZCard(ctx context.Context, key string) (int64, error) {
 	return slave.ZCard(ctx, key)
 }
+
+func Eval(ctx context.Context, script string, keys []string, args ...any) (*gvar.Var, error) {
+	return master.Eval(ctx, script, int64(len(keys)), keys, args)
+}
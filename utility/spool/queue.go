@@ -0,0 +1,236 @@
+package spool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/iimeta/fastapi/v2/utility/logger"
+)
+
+// 每次刷新最多重放的批次数, 避免阻塞队列
+const maxReplayBatches = 10
+
+type Options struct {
+	Name          string // 名称, 用于日志, 如: sLog writer
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// 批量处理, Flush 和 Replay 必须设置, 缓冲文件不可用时 Flush 可能与队列的协程并发调用
+type Handler[T any] struct {
+	Flush   func(ctx context.Context, items []T) (failed []T)    // 批量处理, 返回处理失败需写入缓冲文件的数据
+	Replay  func(ctx context.Context, items []T) error           // 重放缓冲文件中的数据, 失败时等待下次重放
+	Spooled func(ctx context.Context, items []T, err error)      // 写入缓冲文件后, 写入失败时数据已丢弃
+	Backlog func(ctx context.Context, queued int, pending int64) // 每次刷新后, 用于记录积压
+}
+
+// 内存队列, 批量处理失败或队列已满时追加到本地缓冲文件, 恢复后按顺序重放, 缓冲文件不可用时直接处理
+type Queue[T any] struct {
+	name          string
+	queue         chan T
+	batchSize     int
+	flushInterval time.Duration
+	spool         *Spool[T]
+	handler       Handler[T]
+	isClosed      atomic.Bool
+	closed        chan struct{}
+	done          chan struct{}
+}
+
+func NewQueue[T any](ctx context.Context, spool *Spool[T], options Options, handler Handler[T]) *Queue[T] {
+
+	if pending := spool.Pending(); pending > 0 {
+		logger.Infof(ctx, "%s spool pending: %d bytes, replay after start", options.Name, pending)
+	}
+
+	q := &Queue[T]{
+		name:          options.Name,
+		queue:         make(chan T, options.QueueSize),
+		batchSize:     options.BatchSize,
+		flushInterval: options.FlushInterval,
+		spool:         spool,
+		handler:       handler,
+		closed:        make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go q.run(gctx.NeverDone(ctx))
+
+	return q
+}
+
+// 放入队列, 队列已满或已关闭时直接写入本地缓冲文件, 缓冲文件不可用时直接处理
+func (q *Queue[T]) Put(ctx context.Context, item T) error {
+
+	if !q.isClosed.Load() {
+		select {
+		case q.queue <- item:
+			return nil
+		default:
+			logger.Errorf(ctx, "%s queue is full, write to spool", q.name)
+		}
+	}
+
+	if q.spool == nil {
+
+		if failed := q.handler.Flush(ctx, []T{item}); len(failed) > 0 {
+			q.spooled(ctx, failed, ErrUnavailable)
+			return ErrUnavailable
+		}
+
+		return nil
+	}
+
+	return q.toSpool(ctx, []T{item})
+}
+
+// 关闭信号, 关闭后处理中的重试应尽快结束
+func (q *Queue[T]) Closed() <-chan struct{} {
+	return q.closed
+}
+
+func (q *Queue[T]) run(ctx context.Context) {
+
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	items := make([]T, 0, q.batchSize)
+
+	for {
+		select {
+		case item := <-q.queue:
+
+			if items = append(items, item); len(items) >= q.batchSize {
+				q.flush(ctx, items)
+				items = make([]T, 0, q.batchSize)
+			}
+
+		case <-ticker.C:
+
+			if len(items) > 0 {
+				q.flush(ctx, items)
+				items = make([]T, 0, q.batchSize)
+			}
+
+			q.replay(ctx)
+
+			if q.handler.Backlog != nil {
+				q.handler.Backlog(ctx, len(q.queue), q.spool.Pending())
+			}
+
+		case <-q.closed:
+
+			for len(q.queue) > 0 {
+				items = append(items, <-q.queue)
+			}
+
+			if len(items) > 0 {
+				q.flush(ctx, items)
+			}
+
+			return
+		}
+	}
+}
+
+// 批量处理, 缓冲文件有待重放的数据时追加到缓冲文件以保证顺序
+func (q *Queue[T]) flush(ctx context.Context, items []T) {
+
+	if q.spool.Pending() > 0 {
+		_ = q.toSpool(ctx, items)
+		return
+	}
+
+	if failed := q.handler.Flush(ctx, items); len(failed) > 0 {
+
+		if q.spool == nil {
+			q.spooled(ctx, failed, ErrUnavailable)
+			return
+		}
+
+		_ = q.toSpool(ctx, failed)
+	}
+}
+
+// 重放缓冲文件中的数据, 仍处理失败时等待下次重放
+func (q *Queue[T]) replay(ctx context.Context) {
+
+	for i := 0; i < maxReplayBatches && q.spool.Pending() > 0; i++ {
+
+		items, offset, err := q.spool.Read(q.batchSize)
+		if err != nil {
+			logger.Errorf(ctx, "%s replay read error: %v", q.name, err)
+			return
+		}
+
+		if len(items) > 0 {
+			if err = q.handler.Replay(ctx, items); err != nil {
+				logger.Errorf(ctx, "%s replay error: %v", q.name, err)
+				return
+			}
+		}
+
+		if err = q.spool.Commit(offset); err != nil {
+			logger.Errorf(ctx, "%s replay commit error: %v", q.name, err)
+			return
+		}
+
+		logger.Infof(ctx, "%s replay: %d, pending: %d bytes", q.name, len(items), q.spool.Pending())
+	}
+}
+
+func (q *Queue[T]) toSpool(ctx context.Context, items []T) error {
+
+	err := q.spool.Append(items)
+	if err != nil {
+		logger.Errorf(ctx, "%s spool error: %v", q.name, err)
+	}
+
+	q.spooled(ctx, items, err)
+
+	return err
+}
+
+func (q *Queue[T]) spooled(ctx context.Context, items []T, err error) {
+	if q.handler.Spooled != nil {
+		q.handler.Spooled(ctx, items, err)
+	}
+}
+
+// 关闭队列, 队列中的数据处理或写入本地缓冲文件
+func (q *Queue[T]) Close(ctx context.Context) {
+
+	if !q.isClosed.CompareAndSwap(false, true) {
+		return
+	}
+
+	close(q.closed)
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		logger.Errorf(ctx, "%s close error: %v", q.name, ctx.Err())
+		return
+	}
+
+	// 关闭期间进入队列的数据
+	items := make([]T, 0)
+	for len(q.queue) > 0 {
+		items = append(items, <-q.queue)
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	if q.spool == nil {
+		q.flush(ctx, items)
+	} else {
+		_ = q.toSpool(ctx, items)
+	}
+}
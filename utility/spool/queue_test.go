@@ -0,0 +1,198 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+// 模拟下游, 不可用时处理失败, 记录处理成功的数据
+type fakeHandler struct {
+	mutex   sync.Mutex
+	isDown  bool
+	items   []string
+	spooled []string
+	dropped []string
+}
+
+func (f *fakeHandler) setDown(isDown bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.isDown = isDown
+}
+
+func (f *fakeHandler) flush(ctx context.Context, items []string) []string {
+
+	if err := f.replay(ctx, items); err != nil {
+		return items
+	}
+
+	return nil
+}
+
+func (f *fakeHandler) replay(ctx context.Context, items []string) error {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.isDown {
+		return errDown
+	}
+
+	f.items = append(f.items, items...)
+
+	return nil
+}
+
+func (f *fakeHandler) onSpooled(ctx context.Context, items []string, err error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err != nil {
+		f.dropped = append(f.dropped, items...)
+	} else {
+		f.spooled = append(f.spooled, items...)
+	}
+}
+
+func (f *fakeHandler) handler() Handler[string] {
+	return Handler[string]{
+		Flush:   f.flush,
+		Replay:  f.replay,
+		Spooled: f.onSpooled,
+	}
+}
+
+func (f *fakeHandler) received() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Clone(f.items)
+}
+
+// 等待下游收到指定数量的数据
+func waitReceived(t *testing.T, f *fakeHandler, count int) []string {
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if items := f.received(); len(items) >= count {
+			return items
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("received = %v, want %d items", f.received(), count)
+
+	return nil
+}
+
+func testOptions(queueSize int) Options {
+	return Options{
+		Name:          "test queue",
+		QueueSize:     queueSize,
+		BatchSize:     2,
+		FlushInterval: 20 * time.Millisecond,
+	}
+}
+
+func TestQueueSpoolAndReplay(t *testing.T) {
+
+	s, _ := newTestSpool(t)
+	f := &fakeHandler{isDown: true}
+
+	q := NewQueue(context.Background(), s, testOptions(100), f.handler())
+	defer q.Close(context.Background())
+
+	for _, item := range []string{"a", "b", "c"} {
+		if err := q.Put(context.Background(), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 下游不可用时写入缓冲文件
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Pending() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if s.Pending() == 0 {
+		t.Fatal("pending = 0, want spooled items")
+	}
+
+	// 恢复后按顺序重放, 之后的数据排在重放数据之后
+	f.setDown(false)
+
+	if err := q.Put(context.Background(), "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	if items := waitReceived(t, f, 4); !slices.Equal(items, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("received = %v, want [a b c d]", items)
+	}
+}
+
+func TestQueueUnavailableSpool(t *testing.T) {
+
+	f := &fakeHandler{}
+
+	q := NewQueue[string](context.Background(), nil, testOptions(0), f.handler())
+	defer q.Close(context.Background())
+
+	// 队列已满且缓冲文件不可用时直接处理
+	if err := q.Put(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if items := f.received(); !slices.Equal(items, []string{"a"}) {
+		t.Fatalf("received = %v, want [a]", items)
+	}
+
+	f.setDown(true)
+
+	if err := q.Put(context.Background(), "b"); err != ErrUnavailable {
+		t.Fatalf("err = %v, want %v", err, ErrUnavailable)
+	}
+
+	if !slices.Equal(f.dropped, []string{"b"}) {
+		t.Fatalf("dropped = %v, want [b]", f.dropped)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+
+	s, _ := newTestSpool(t)
+	f := &fakeHandler{}
+
+	options := testOptions(100)
+	options.BatchSize = 100
+	options.FlushInterval = time.Hour
+
+	q := NewQueue(context.Background(), s, options, f.handler())
+
+	for _, item := range []string{"a", "b"} {
+		if err := q.Put(context.Background(), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 关闭时处理队列中的数据, 关闭后写入缓冲文件
+	q.Close(context.Background())
+
+	if items := f.received(); !slices.Equal(items, []string{"a", "b"}) {
+		t.Fatalf("received = %v, want [a b]", items)
+	}
+
+	if err := q.Put(context.Background(), "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(f.spooled, []string{"c"}) {
+		t.Fatalf("spooled = %v, want [c]", f.spooled)
+	}
+}
@@ -0,0 +1,177 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 每条数据的长度头
+const headerSize = 4
+
+var ErrUnavailable = errors.New("spool is unavailable")
+
+// 编解码, 如: BSON、JSON
+type Codec[T any] struct {
+	Marshal   func(item T) ([]byte, error)
+	Unmarshal func(data []byte) (T, error)
+}
+
+// 本地缓冲文件, 按顺序追加写入带长度头的数据, 重放完成后清空, 为nil时不可用
+type Spool[T any] struct {
+	mutex  sync.Mutex
+	codec  Codec[T]
+	file   *os.File
+	offset int64 // 已重放位置
+	size   int64 // 文件大小
+}
+
+func New[T any](path string, codec Codec[T]) (*Spool[T], error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &Spool[T]{
+		codec: codec,
+		file:  file,
+		size:  info.Size(),
+	}, nil
+}
+
+// 追加数据, 不可用时返回错误
+func (s *Spool[T]) Append(items []T) error {
+
+	if s == nil {
+		return ErrUnavailable
+	}
+
+	data := make([]byte, 0)
+	for _, item := range items {
+
+		bytes, err := s.codec.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(bytes)))
+		data = append(data, bytes...)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// 待重放的字节数, 不可用时为0
+func (s *Spool[T]) Pending() int64 {
+
+	if s == nil {
+		return 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.size - s.offset
+}
+
+// 从已重放位置读取数据, 返回读取后的位置, 无法解析的数据跳过, 末尾不完整的数据(如进程异常退出时)丢弃
+func (s *Spool[T]) Read(limit int) ([]T, int64, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var (
+		items  = make([]T, 0, limit)
+		offset = s.offset
+		header = make([]byte, headerSize)
+	)
+
+	for len(items) < limit && offset < s.size {
+
+		if s.size-offset < headerSize {
+			return items, s.size, nil
+		}
+
+		if _, err := s.file.ReadAt(header, offset); err != nil {
+			return nil, offset, err
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header))
+		if offset+headerSize+length > s.size {
+			return items, s.size, nil
+		}
+
+		bytes := make([]byte, length)
+		if _, err := s.file.ReadAt(bytes, offset+headerSize); err != nil {
+			return nil, offset, err
+		}
+
+		offset += headerSize + length
+
+		item, err := s.codec.Unmarshal(bytes)
+		if err != nil {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, offset, nil
+}
+
+// 更新已重放位置, 全部重放完成后清空文件
+func (s *Spool[T]) Commit(offset int64) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.offset = offset
+
+	if s.offset < s.size {
+		return nil
+	}
+
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+
+	s.offset = 0
+	s.size = 0
+
+	return nil
+}
+
+// 关闭文件
+func (s *Spool[T]) Close() error {
+
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
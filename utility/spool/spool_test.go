@@ -0,0 +1,156 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// 字符串编解码, "bad" 无法解析
+var testCodec = Codec[string]{
+	Marshal: func(item string) ([]byte, error) {
+		return []byte(item), nil
+	},
+	Unmarshal: func(data []byte) (string, error) {
+		if string(data) == "bad" {
+			return "", strconv.ErrSyntax
+		}
+		return string(data), nil
+	},
+}
+
+func newTestSpool(t *testing.T) (*Spool[string], string) {
+
+	path := filepath.Join(t.TempDir(), "spool", "test.spool")
+
+	s, err := New(path, testCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s, path
+}
+
+func TestSpoolReplay(t *testing.T) {
+
+	s, _ := newTestSpool(t)
+
+	if err := s.Append([]string{"a", "b", "bad", "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Append([]string{"d"}); err != nil {
+		t.Fatal(err)
+	}
+
+	items, offset, err := s.Read(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0] != "a" || items[1] != "b" {
+		t.Fatalf("items = %v, want [a b]", items)
+	}
+
+	if err = s.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无法解析的数据跳过
+	items, offset, err = s.Read(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0] != "c" || items[1] != "d" {
+		t.Fatalf("items = %v, want [c d]", items)
+	}
+
+	if err = s.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := s.Pending(); pending != 0 {
+		t.Fatalf("pending = %d, want 0", pending)
+	}
+
+	// 清空后继续追加
+	if err = s.Append([]string{"e"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if items, _, err = s.Read(10); err != nil || len(items) != 1 || items[0] != "e" {
+		t.Fatalf("items = %v, err = %v, want [e]", items, err)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+
+	s, path := newTestSpool(t)
+
+	if err := s.Append([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = s.Close()
+
+	// 模拟进程异常退出时末尾不完整的数据
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = file.Write([]byte{10, 0, 0, 0, 'x'}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = file.Close()
+
+	reopened, err := New(path, testCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = reopened.Close()
+	}()
+
+	items, offset, err := reopened.Read(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0] != "a" || items[1] != "b" {
+		t.Fatalf("items = %v, want [a b]", items)
+	}
+
+	if err = reopened.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := reopened.Pending(); pending != 0 {
+		t.Fatalf("pending = %d, want 0", pending)
+	}
+}
+
+func TestSpoolUnavailable(t *testing.T) {
+
+	var s *Spool[string]
+
+	if err := s.Append([]string{"a"}); err != ErrUnavailable {
+		t.Fatalf("err = %v, want %v", err, ErrUnavailable)
+	}
+
+	if pending := s.Pending(); pending != 0 {
+		t.Fatalf("pending = %d, want 0", pending)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
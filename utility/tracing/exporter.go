@@ -0,0 +1,255 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLP/HTTP JSON 链路导出器
+type Exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+}
+
+func NewExporter(endpoint string, headers map[string]string, serviceName string, timeout time.Duration) *Exporter {
+	return &Exporter{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.convert(spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("otlp export failed, status: %d, body: %s", response.StatusCode, data)
+	}
+
+	return nil
+}
+
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type span struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Events            []event    `json:"events,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+func (e *Exporter) convert(spans []sdktrace.ReadOnlySpan) exportRequest {
+
+	scopes := make(map[string]*scopeSpans)
+	names := make([]string, 0)
+
+	for _, s := range spans {
+
+		key := s.InstrumentationScope().Name + "@" + s.InstrumentationScope().Version
+
+		ss, ok := scopes[key]
+		if !ok {
+			ss = &scopeSpans{Scope: scope{Name: s.InstrumentationScope().Name, Version: s.InstrumentationScope().Version}}
+			scopes[key] = ss
+			names = append(names, key)
+		}
+
+		otlpSpan := span{
+			TraceId:           s.SpanContext().TraceID().String(),
+			SpanId:            s.SpanContext().SpanID().String(),
+			Name:              s.Name(),
+			Kind:              int(s.SpanKind()),
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime().UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+			Attributes:        keyValues(s.Attributes()),
+		}
+
+		if s.Parent().HasSpanID() {
+			otlpSpan.ParentSpanId = s.Parent().SpanID().String()
+		}
+
+		for _, ev := range s.Events() {
+			otlpSpan.Events = append(otlpSpan.Events, event{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   keyValues(ev.Attributes),
+			})
+		}
+
+		switch s.Status().Code {
+		case codes.Ok:
+			otlpSpan.Status = status{Code: 1}
+		case codes.Error:
+			otlpSpan.Status = status{Code: 2, Message: s.Status().Description}
+		}
+
+		ss.Spans = append(ss.Spans, otlpSpan)
+	}
+
+	rs := resourceSpans{
+		Resource: resource{Attributes: []keyValue{{Key: "service.name", Value: toAnyValue(attribute.StringValue(e.serviceName))}}},
+	}
+
+	for _, name := range names {
+		rs.ScopeSpans = append(rs.ScopeSpans, *scopes[name])
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{rs}}
+}
+
+func keyValues(attrs []attribute.KeyValue) []keyValue {
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	kvs := make([]keyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		kvs = append(kvs, keyValue{Key: string(attr.Key), Value: toAnyValue(attr.Value)})
+	}
+
+	return kvs
+}
+
+func toAnyValue(value attribute.Value) anyValue {
+
+	switch value.Type() {
+	case attribute.BOOL:
+		v := value.AsBool()
+		return anyValue{BoolValue: &v}
+	case attribute.INT64:
+		v := strconv.FormatInt(value.AsInt64(), 10)
+		return anyValue{IntValue: &v}
+	case attribute.FLOAT64:
+		v := value.AsFloat64()
+		return anyValue{DoubleValue: &v}
+	case attribute.BOOLSLICE:
+		values := make([]anyValue, 0)
+		for _, v := range value.AsBoolSlice() {
+			values = append(values, toAnyValue(attribute.BoolValue(v)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.INT64SLICE:
+		values := make([]anyValue, 0)
+		for _, v := range value.AsInt64Slice() {
+			values = append(values, toAnyValue(attribute.Int64Value(v)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.FLOAT64SLICE:
+		values := make([]anyValue, 0)
+		for _, v := range value.AsFloat64Slice() {
+			values = append(values, toAnyValue(attribute.Float64Value(v)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case attribute.STRINGSLICE:
+		values := make([]anyValue, 0)
+		for _, v := range value.AsStringSlice() {
+			values = append(values, toAnyValue(attribute.StringValue(v)))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	default:
+		v := value.Emit()
+		return anyValue{StringValue: &v}
+	}
+}
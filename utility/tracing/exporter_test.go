@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 本地OTLP/HTTP收集器, 记录收到的导出请求
+func newCollector(t *testing.T) (*httptest.Server, chan exportRequest) {
+
+	received := make(chan exportRequest, 16)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received <- req
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server, received
+}
+
+func TestExportSpans(t *testing.T) {
+
+	server, received := newCollector(t)
+
+	shutdown, err := Init(Config{
+		Open:        true,
+		Endpoint:    server.URL + "/v1/traces",
+		Headers:     map[string]string{"Authorization": "Bearer test"},
+		ServiceName: "fastapi-test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := otel.Tracer("fastapi")
+
+	ctx, parent := tracer.Start(context.Background(), "sChat Completions")
+
+	header := Inject(ctx, nil)
+	if traceparent := header["traceparent"]; !strings.Contains(traceparent, parent.SpanContext().TraceID().String()) {
+		t.Fatalf("traceparent: %q, want trace id %s", traceparent, parent.SpanContext().TraceID())
+	}
+
+	_, child := tracer.Start(ctx, "upstream ChatCompletions", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("fastapi.model", "gpt-4o"),
+		attribute.Int("fastapi.agent_total", 2),
+	))
+	child.SetStatus(codes.Error, "upstream error")
+	child.End()
+	parent.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	close(received)
+
+	spans := make(map[string]span)
+	for req := range received {
+
+		if len(req.ResourceSpans) != 1 {
+			t.Fatalf("resourceSpans: %d, want 1", len(req.ResourceSpans))
+		}
+
+		if attrs := req.ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "fastapi-test" {
+			t.Fatalf("resource attributes: %+v", attrs)
+		}
+
+		for _, ss := range req.ResourceSpans[0].ScopeSpans {
+			for _, s := range ss.Spans {
+				spans[s.Name] = s
+			}
+		}
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("spans: %d, want 2", len(spans))
+	}
+
+	p, c := spans["sChat Completions"], spans["upstream ChatCompletions"]
+
+	if c.TraceId != p.TraceId || c.ParentSpanId != p.SpanId {
+		t.Fatalf("child trace: %s/%s, want %s/%s", c.TraceId, c.ParentSpanId, p.TraceId, p.SpanId)
+	}
+
+	if c.Kind != int(trace.SpanKindClient) {
+		t.Fatalf("child kind: %d, want %d", c.Kind, trace.SpanKindClient)
+	}
+
+	if c.Status.Code != 2 || c.Status.Message != "upstream error" {
+		t.Fatalf("child status: %+v", c.Status)
+	}
+
+	attrs := make(map[string]anyValue)
+	for _, kv := range c.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+
+	if v := attrs["fastapi.model"].StringValue; v == nil || *v != "gpt-4o" {
+		t.Fatalf("fastapi.model: %v", v)
+	}
+
+	if v := attrs["fastapi.agent_total"].IntValue; v == nil || *v != "2" {
+		t.Fatalf("fastapi.agent_total: %v", v)
+	}
+}
+
+func TestExportSpansError(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("unavailable"))
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, nil, "fastapi-test", time.Second)
+
+	if err := exporter.ExportSpans(context.Background(), nil); err != nil {
+		t.Fatalf("empty spans: %v", err)
+	}
+
+	err := exporter.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{tracetest.SpanStub{Name: "span"}.Snapshot()})
+	if err == nil || !strings.Contains(err.Error(), "status: 503") {
+		t.Fatalf("error: %v, want status 503", err)
+	}
+}
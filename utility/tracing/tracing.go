@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type Config struct {
+	Open         bool              `json:"open"`          // 开关
+	Endpoint     string            `json:"endpoint"`      // OTLP/HTTP 地址, 如: http://127.0.0.1:4318/v1/traces
+	Headers      map[string]string `json:"headers"`       // 请求头
+	ServiceName  string            `json:"service_name"`  // 服务名称
+	SamplerRatio *float64          `json:"sampler_ratio"` // 采样率[0-1], 未配置时默认1, 0为不采样
+	Timeout      int               `json:"timeout"`       // 导出超时时间(秒), 默认10
+}
+
+// 初始化OTLP链路追踪, 返回关闭函数, 采样率超出范围时返回错误
+func Init(config Config) (func(ctx context.Context) error, error) {
+
+	if config.ServiceName == "" {
+		config.ServiceName = "fastapi"
+	}
+
+	samplerRatio := 1.0
+	if config.SamplerRatio != nil {
+		if samplerRatio = *config.SamplerRatio; samplerRatio < 0 || samplerRatio > 1 {
+			return nil, fmt.Errorf("tracing sampler_ratio %v must be between 0 and 1", samplerRatio)
+		}
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 10
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(NewExporter(config.Endpoint, config.Headers, config.ServiceName, time.Duration(config.Timeout)*time.Second)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplerRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// 将当前链路上下文注入到请求头中
+func Inject(ctx context.Context, header map[string]string) map[string]string {
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	if len(carrier) == 0 {
+		return header
+	}
+
+	if header == nil {
+		header = make(map[string]string)
+	}
+
+	for k, v := range carrier {
+		header[k] = v
+	}
+
+	return header
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInitSamplerRatio(t *testing.T) {
+
+	ratio := func(v float64) *float64 {
+		return &v
+	}
+
+	tests := []struct {
+		name         string
+		samplerRatio *float64
+		wantErr      bool
+	}{
+		{name: "unset"},
+		{name: "zero", samplerRatio: ratio(0)},
+		{name: "half", samplerRatio: ratio(0.5)},
+		{name: "one", samplerRatio: ratio(1)},
+		{name: "negative", samplerRatio: ratio(-0.1), wantErr: true},
+		{name: "above one", samplerRatio: ratio(1.5), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			shutdown, err := Init(Config{
+				Endpoint:     "http://127.0.0.1:0/v1/traces",
+				SamplerRatio: tt.samplerRatio,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if shutdown != nil {
+				if err = shutdown(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
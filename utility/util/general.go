@@ -8,3 +8,13 @@ func Round(f float64, n int) float64 {
 	n10 := math.Pow10(n)
 	return math.Trunc((f+0.5/n10)*n10) / n10
 }
+
+// 密钥脱敏, 保留前6位和后4位
+func MaskKey(key string) string {
+
+	if len(key) <= 10 {
+		return "***"
+	}
+
+	return key[:6] + "..." + key[len(key)-4:]
+}
@@ -17,7 +17,8 @@ import (
 	"github.com/iimeta/fastapi/v2/utility/logger"
 )
 
-func HttpPost(ctx context.Context, url string, header map[string]string, data, result any, proxyURL string) error {
+// 传输层不为空时使用该传输层的代理、超时和TLS配置, 忽略代理地址
+func HttpPost(ctx context.Context, url string, header map[string]string, data, result any, proxyURL string, transport http.RoundTripper) error {
 
 	logger.Infof(ctx, "HttpPost url: %s, header: %+v, data: %s, proxyURL: %s", url, header, gjson.MustEncodeString(data), proxyURL)
 
@@ -27,7 +28,9 @@ func HttpPost(ctx context.Context, url string, header map[string]string, data, r
 		client.SetHeaderMap(header)
 	}
 
-	if proxyURL != "" {
+	if transport != nil {
+		client.Transport = transport
+	} else if proxyURL != "" {
 		client.SetProxy(proxyURL)
 	}
 